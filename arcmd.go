@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/BlackNovaTech/gojasm/ijvmasm"
+	"github.com/sirupsen/logrus"
+	flag "github.com/spf13/pflag"
+)
+
+var (
+	flagArOutput string
+	flagArList   bool
+)
+
+// Archives relocatable objects into a library, or lists the members of one
+func arCommand(args []string) {
+	fs := flag.NewFlagSet("ar", flag.ExitOnError)
+	commonFlags(fs)
+	fs.StringVarP(&flagArOutput, "output", "o", "lib.ija", "specify output file.")
+	fs.BoolVarP(&flagArList, "list", "t", false, "list the members of the given library archives")
+
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s ar [flags] objects or inputfiles...\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s ar --list libraries...\n", os.Args[0])
+		fs.PrintDefaults()
+		os.Exit(0)
+	}
+
+	parseFlags(fs, args)
+	if fs.NArg() == 0 {
+		logrus.Fatal("Please specify the objects to archive")
+	}
+
+	if flagArList {
+		for i, lib := range readArchives(fs.Args()) {
+			fmt.Printf("%s:\n", fs.Arg(i))
+			for j := 0; j < lib.Len(); j++ {
+				obj, err := lib.Member(j)
+				if err != nil {
+					logrus.WithError(err).Fatal("Could not read library member")
+				}
+				fmt.Printf("  %s\n", obj.File)
+				for _, m := range obj.Methods {
+					fmt.Printf("    %s\n", m.Name)
+				}
+			}
+		}
+		return
+	}
+
+	var objs []*ijvmasm.Object
+	for _, input := range fs.Args() {
+		objs = append(objs, loadObject(input))
+	}
+	lib, err := ijvmasm.NewArchive(objs)
+	if err != nil {
+		logrus.WithError(err).Fatal("Archiving failed")
+	}
+
+	file, err := os.Create(flagArOutput)
+	if err != nil {
+		logrus.WithError(err).Fatal("Could not open output file")
+	}
+	defer file.Close()
+
+	if err := ijvmasm.WriteArchive(lib, file); err != nil {
+		logrus.WithError(err).Error("Error writing library")
+	}
+}
@@ -1,6 +1,6 @@
 module github.com/BlackNovaTech/gojasm
 
-go 1.12
+go 1.16
 
 require (
 	github.com/sirupsen/logrus v1.8.1
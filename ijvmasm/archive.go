@@ -0,0 +1,185 @@
+package ijvmasm
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+)
+
+const (
+	// ArchiveMagic is the magic header of library archives
+	ArchiveMagic = uint32(0x1DEADA4C)
+	// ArchiveVersion is the version of the archive format written by WriteArchive
+	ArchiveVersion = uint32(1)
+)
+
+// Archive is a library of relocatable objects, its members. The symbol index
+// maps every method defined by a member onto the member, so members are only
+// decoded when they are needed.
+type Archive struct {
+	index   map[string]int
+	members [][]byte
+	objects []*Object
+}
+
+var errMemberIndex = errors.New("archive: symbol index does not match the members")
+
+// NewArchive returns an archive of the given objects, which may not define
+// main, nor define the same method more than once.
+func NewArchive(objs []*Object) (*Archive, error) {
+	a := &Archive{
+		index:   make(map[string]int),
+		objects: objs,
+	}
+	for i, obj := range objs {
+		for _, m := range obj.Methods {
+			if m.Main {
+				return nil, fmt.Errorf("archive: %s:%d: main cannot be archived", obj.File, m.N)
+			}
+			if prev, ok := a.index[m.Name]; ok {
+				return nil, fmt.Errorf("archive: %s:%d: duplicate method `%s`, also defined in %s",
+					obj.File, m.N, m.Name, objs[prev].File)
+			}
+			a.index[m.Name] = i
+		}
+
+		buf := new(bytes.Buffer)
+		if err := WriteObject(obj, buf); err != nil {
+			return nil, err
+		}
+		a.members = append(a.members, buf.Bytes())
+	}
+	return a, nil
+}
+
+// Len returns the amount of members of the archive.
+func (a *Archive) Len() int {
+	return len(a.members)
+}
+
+// Member returns the i-th member of the archive.
+func (a *Archive) Member(i int) (*Object, error) {
+	if a.objects[i] == nil {
+		obj, err := ReadObject(bytes.NewReader(a.members[i]))
+		if err != nil {
+			return nil, fmt.Errorf("archive: member %d: %s", i, err)
+		}
+		a.objects[i] = obj
+	}
+	return a.objects[i], nil
+}
+
+// Lookup returns the index of the member defining the given method, if any.
+func (a *Archive) Lookup(method string) (int, bool) {
+	i, ok := a.index[method]
+	return i, ok
+}
+
+// WriteArchive writes the archive to w.
+func WriteArchive(a *Archive, w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	ow := &objectWriter{w: bw}
+
+	ow.u32(ArchiveMagic)
+	ow.u32(ArchiveVersion)
+	names := make([]string, 0, len(a.index))
+	for name := range a.index {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	ow.u32(uint32(len(names)))
+	for _, name := range names {
+		ow.str(name)
+		ow.u32(uint32(a.index[name]))
+	}
+	ow.u32(uint32(len(a.members)))
+	for _, member := range a.members {
+		ow.u32(uint32(len(member)))
+		ow.raw(member)
+	}
+
+	if ow.err != nil {
+		return ow.err
+	}
+	return bw.Flush()
+}
+
+// ReadArchive reads an archive written by WriteArchive from r.
+func ReadArchive(r io.Reader) (*Archive, error) {
+	or := &objectReader{r: bufio.NewReader(r)}
+
+	if magic := or.u32(); or.err == nil && magic != ArchiveMagic {
+		return nil, fmt.Errorf("archive: invalid magic header 0x%08X", magic)
+	}
+	if version := or.u32(); or.err == nil && version != ArchiveVersion {
+		return nil, fmt.Errorf("archive: unsupported version %d, expected %d", version, ArchiveVersion)
+	}
+
+	a := &Archive{index: make(map[string]int)}
+	for n := or.u32(); n > 0 && or.err == nil; n-- {
+		a.index[or.str()] = int(or.u32())
+	}
+	for n := or.u32(); n > 0 && or.err == nil; n-- {
+		a.members = append(a.members, or.bytes())
+	}
+	if or.err != nil {
+		return nil, or.err
+	}
+
+	a.objects = make([]*Object, len(a.members))
+	for name, i := range a.index {
+		if i >= len(a.members) {
+			return nil, fmt.Errorf("archive: method `%s` indexed in missing member %d", name, i)
+		}
+	}
+	return a, nil
+}
+
+// Returns the archive members defining the given referenced methods, and
+// those referenced by the members in turn, in the order they are needed.
+// Methods defined by the program are skipped, the other references are left
+// unresolved when no archive defines them. Earlier archives take precedence.
+func resolveMembers(libs []*Archive, refs []string, defined map[string]bool) ([]*Object, error) {
+	var members []*Object
+	pulled := make(map[*Object]bool)
+	for len(refs) > 0 {
+		name := refs[0]
+		refs = refs[1:]
+		if defined[name] {
+			continue
+		}
+
+		for _, lib := range libs {
+			i, ok := lib.Lookup(name)
+			if !ok {
+				continue
+			}
+			obj, err := lib.Member(i)
+			if err != nil {
+				return nil, err
+			}
+			if pulled[obj] {
+				return nil, errMemberIndex
+			}
+			pulled[obj] = true
+			members = append(members, obj)
+
+			for _, m := range obj.Methods {
+				defined[m.Name] = true
+				for _, r := range m.Relocs {
+					if r.Method {
+						refs = append(refs, r.Symbol)
+					}
+				}
+			}
+			if !defined[name] {
+				return nil, errMemberIndex
+			}
+			break
+		}
+	}
+	return members, nil
+}
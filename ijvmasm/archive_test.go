@@ -0,0 +1,206 @@
+package ijvmasm_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/BlackNovaTech/gojasm/ijvmasm"
+	"github.com/BlackNovaTech/gojasm/internal/testprog"
+	"github.com/BlackNovaTech/gojasm/opconf"
+)
+
+// Members of the test library: foo invokes bar, nothing invokes baz
+var librarySources = []struct {
+	name string
+	src  string
+}{
+	{"foo.jas", `
+.method foo(x)
+BIPUSH 0
+ILOAD x
+INVOKEVIRTUAL bar
+BIPUSH 1
+IADD
+IRETURN
+.end-method
+`},
+	{"bar.jas", `
+.constant
+k 1000
+.end-constant
+.method bar(x)
+ILOAD x
+LDC_W k
+IADD
+LDC_W k
+ISUB
+BIPUSH 1
+IADD
+IRETURN
+.end-method
+`},
+	{"baz.jas", `
+.method baz()
+BIPUSH 0
+IRETURN
+.end-method
+`},
+}
+
+// Returns an archive of the test library
+func library(t *testing.T) *ijvmasm.Archive {
+	var objs []*ijvmasm.Object
+	for _, m := range librarySources {
+		objs = append(objs, object(t, m.name, m.src))
+	}
+	lib, err := ijvmasm.NewArchive(objs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return lib
+}
+
+// Invokes foo on 'A', printing 'C'
+const libraryMain = `
+.main
+BIPUSH 0
+BIPUSH 'A'
+INVOKEVIRTUAL foo
+OUT
+HALT
+.end-main
+`
+
+func TestArchiveRoundTrip(t *testing.T) {
+	lib := library(t)
+	var buf bytes.Buffer
+	if err := ijvmasm.WriteArchive(lib, &buf); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+
+	read, err := ijvmasm.ReadArchive(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if read.Len() != len(librarySources) {
+		t.Fatalf("read %d members, want %d", read.Len(), len(librarySources))
+	}
+	for i, m := range librarySources {
+		name := strings.TrimSuffix(m.name, ".jas")
+		if idx, ok := read.Lookup(name); !ok || idx != i {
+			t.Errorf("%s is indexed in member %d, want %d", name, idx, i)
+		}
+		got, err := read.Member(i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want, _ := lib.Member(i)
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("member %d is %+v, want %+v", i, got, want)
+		}
+	}
+	if _, ok := read.Lookup("main"); ok {
+		t.Errorf("main is indexed")
+	}
+
+	for _, c := range []struct {
+		data []byte
+		err  string
+	}{
+		{append([]byte{0, 0, 0, 0}, data[4:]...), "archive: invalid magic header 0x00000000"},
+		{append(append([]byte{}, data[:4]...), append([]byte{0, 0, 0, 2}, data[8:]...)...),
+			"archive: unsupported version 2, expected 1"},
+		{data[:len(data)-1], "object: truncated"},
+	} {
+		if _, err := ijvmasm.ReadArchive(bytes.NewReader(c.data)); err == nil || err.Error() != c.err {
+			t.Errorf("expected error %q, got %v", c.err, err)
+		}
+	}
+}
+
+func TestArchiveErrors(t *testing.T) {
+	main := object(t, "main.jas", libraryMain)
+	foo := object(t, "foo.jas", librarySources[0].src)
+	foo2 := object(t, "foo2.jas", librarySources[0].src)
+
+	for _, c := range []struct {
+		objs []*ijvmasm.Object
+		err  string
+	}{
+		{[]*ijvmasm.Object{foo, main}, "archive: main.jas:2: main cannot be archived"},
+		{[]*ijvmasm.Object{foo, foo2}, "archive: foo2.jas:2: duplicate method `foo`, also defined in foo.jas"},
+	} {
+		if _, err := ijvmasm.NewArchive(c.objs); err == nil || err.Error() != c.err {
+			t.Errorf("expected error %q, got %v", c.err, err)
+		}
+	}
+}
+
+// Only the members reachable from main are pulled in, when assembling and
+// when linking objects
+func TestLinkLibraries(t *testing.T) {
+	lib := library(t)
+	prog := testprog.Assemble(t, nil, "main.jas", libraryMain, func(asm *ijvmasm.Assembler) {
+		asm.Libraries = []*ijvmasm.Archive{lib}
+	})
+	for _, name := range []string{"foo", "bar"} {
+		if prog.MethodByName(name) == nil {
+			t.Errorf("%s was not pulled in", name)
+		}
+	}
+	if prog.MethodByName("baz") != nil {
+		t.Errorf("baz was pulled in without being invoked")
+	}
+	if out, _, err := testprog.Run(prog, ""); err != nil || out != "C" {
+		t.Errorf("printed %q and failed with %v, want %q", out, err, "C")
+	}
+
+	// Linked binaries have no symbols, but only hold main, foo and bar
+	main := object(t, "main.jas", libraryMain)
+	linked, logged := link(t, []*ijvmasm.Object{main}, []*ijvmasm.Archive{lib})
+	if linked == nil {
+		t.Fatalf("linking failed: %q", logged)
+	}
+	foo, _ := lib.Member(0)
+	bar, _ := lib.Member(1)
+	size := main.Methods[0].Size() + foo.Methods[0].Size() + bar.Methods[0].Size()
+	if len(linked.Text) != int(size) || len(linked.Constants) != 3 {
+		t.Errorf("linked %d bytes and %d constants, want %d bytes and 3 constants",
+			len(linked.Text), len(linked.Constants), size)
+	}
+	if out, _, err := testprog.Run(linked, ""); err != nil || out != "C" {
+		t.Errorf("linked program printed %q and failed with %v, want %q", out, err, "C")
+	}
+}
+
+// Constants of pulled members must agree with those of the program
+func TestLinkLibrariesConflict(t *testing.T) {
+	lib := library(t)
+	src := ".constant\nk 999\n.end-constant\n" + libraryMain
+
+	path := filepath.Join(t.TempDir(), "main.jas")
+	if err := ioutil.WriteFile(path, []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var ok bool
+	logged := testprog.Logged(func() {
+		asm := ijvmasm.NewAssembler(path, opconf.NewDefaultOpConfig())
+		asm.Libraries = []*ijvmasm.Archive{lib}
+		ok, _ = asm.Parse()
+	})
+	want := "main.jas:11 > linker: Constant `k` = 1000 of bar.jas conflicts with the definition on line 2 (= 999)"
+	if ok || len(logged) != 1 || logged[0] != want {
+		t.Errorf("assembling logged %q, want %q", logged, want)
+	}
+
+	prog, logged := link(t, []*ijvmasm.Object{object(t, "main.jas", src)}, []*ijvmasm.Archive{lib})
+	want = "bar.jas:3 > linker: Constant `k` = 1000 conflicts with the definition on main.jas:2 (= 999)"
+	if prog != nil || len(logged) != 1 || logged[0] != want {
+		t.Errorf("linking logged %q, want %q", logged, want)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"bufio"
 	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path"
 	"regexp"
@@ -25,8 +26,39 @@ type Assembler struct {
 
 	// AutoWide flags the assembler to insert WIDE instructions whenever required
 	AutoWide bool
+	// TailCalls flags the assembler to rewrite INVOKEVIRTUAL directly followed by IRETURN
+	// into TAILCALL, if the operation configuration provides it
+	TailCalls bool
+	// Relocatable flags the assembler to leave references to methods and constants
+	// it does not define unresolved, to be linked with other objects using Link.
+	// Main is optional, and may be defined by another object.
+	Relocatable bool
+	// InlineSize is the largest size in bytes of the code of methods inlined at
+	// their call sites, 0 disables inlining. InlineDepth limits how many levels
+	// of invocations within inlined methods are inlined in turn.
+	InlineSize  int
+	InlineDepth int
+	// FoldConstants flags the assembler to evaluate arithmetic and conditional
+	// branches on constants, and to remove the code left unreachable
+	FoldConstants bool
+	// AllocateLocals flags the assembler to share the slots of local variables
+	// that are never live at the same time, see allocateLocals
+	AllocateLocals bool
+	// DeadMethods flags the assembler to remove the methods that cannot be
+	// reached from main over invocations, along with their method constants
+	DeadMethods bool
+	// ReorderMethods flags the assembler to place methods in the order they
+	// are first invoked, depth-first from main, instead of in source order
+	ReorderMethods bool
+	// CheckRanges flags the assembler to warn about suspicious values found by
+	// computing the range of every value, see checkRanges
+	CheckRanges bool
+	// Libraries are searched for the methods invoked, but not defined, by the
+	// program. Only the archive members required are appended to the program.
+	Libraries []*Archive
 
 	fileName string
+	filePath string
 	scanner  *bufio.Scanner
 	line     uint32
 
@@ -38,6 +70,9 @@ type Assembler struct {
 	parsedMain  bool
 	parsedConst bool
 
+	// Operation tail calls are rewritten into, nil if they are not rewritten
+	tailcall *opconf.Operation
+
 	failed bool
 }
 
@@ -49,17 +84,29 @@ func NewAssembler(filepath string, ops *opconf.OpConfig) *Assembler {
 		logrus.WithError(err).Fatal("Could not open file")
 	}
 
-	scanner := bufio.NewScanner(file)
+	return NewAssemblerFromReader(file, filepath, ops)
+}
+
+// NewAssemblerFromReader returns a new Assembler object reading the input
+// program from the given source, named after the given filepath.
+func NewAssemblerFromReader(read io.Reader, filepath string, ops *opconf.OpConfig) *Assembler {
+	scanner := bufio.NewScanner(read)
 
 	return &Assembler{
 		opconf:    ops,
 		fileName:  path.Base(filepath),
+		filePath:  filepath,
 		scanner:   scanner,
 		constants: make([]*Constant, 0),
 		methods:   make([]*Method, 0),
 	}
 }
 
+// OpConfig returns the operation configuration the Assembler was created with.
+func (asm *Assembler) OpConfig() *opconf.OpConfig {
+	return asm.opconf
+}
+
 func splitLink(s, sep string) (string, string) {
 	x := strings.SplitN(s, sep, 2)
 	return x[0], x[1]
@@ -95,6 +142,9 @@ func (asm *Assembler) Parse() (ok bool, err error) {
 		}
 		ok = !asm.failed
 	}()
+	if asm.TailCalls {
+		asm.tailcall = asm.tailCallOperation()
+	}
 	for token := asm.next(); token != nil; token = asm.next() {
 		logrus.Debug(asm.Sprintf(token.Text))
 
@@ -105,10 +155,13 @@ func (asm *Assembler) Parse() (ok bool, err error) {
 		case JASMainStart:
 			asm.mainBlock()
 			continue
+		case JASTestStart:
+			asm.testBlock()
+			continue
 		}
 
 		if strings.HasPrefix(token.Text, JASMethodPrefix) {
-			if !asm.parsedMain {
+			if !asm.parsedMain && !asm.Relocatable {
 				asm.Errorf("Main must be declared before other methods")
 				asm.skipUntil(".end-method")
 				continue
@@ -116,7 +169,9 @@ func (asm *Assembler) Parse() (ok bool, err error) {
 			asm.methodBlock(strings.TrimPrefix(token.Text, JASMethodPrefix))
 		}
 	}
-	asm.linkMethods()
+	if !asm.Relocatable {
+		asm.linkMethods()
+	}
 	ok = !asm.failed
 	return
 }
@@ -163,6 +218,12 @@ func (asm *Assembler) mainBlock() {
 		asm.skipUntil(JASMainEnd)
 		return
 	}
+	if len(asm.methods) > 0 {
+		// Only possible for relocatable objects, which may lack main
+		asm.Errorf("Main must be declared before other methods")
+		asm.skipUntil(JASMainEnd)
+		return
+	}
 	asm.methodBlock("main")
 	asm.parsedMain = true
 }
@@ -180,9 +241,7 @@ func (asm *Assembler) methodBlock(name string) {
 	for token := asm.next(); token != nil; token = asm.next() {
 		switch token.Text {
 		case method.end:
-			method.LinkLabels()
-			asm.methods = append(asm.methods, method)
-			logrus.Infof("Registered method: (%d) %s", len(asm.methods)-1, name)
+			asm.endMethod(method)
 			return
 		case "":
 			continue
@@ -226,6 +285,26 @@ func (asm *Assembler) methodBlock(name string) {
 	asm.Panicf("Unexpected end of file\n")
 }
 
+// Finishes a fully parsed method, and registers it
+func (asm *Assembler) endMethod(method *Method) {
+	if asm.tailcall != nil && method.name != "main" {
+		asm.rewriteTailCalls(method)
+	}
+	method.LinkLabels()
+	asm.methods = append(asm.methods, method)
+	logrus.Infof("Registered method: (%d) %s", len(asm.methods)-1, method.name)
+}
+
+// Skips a test block, which only holds annotations for `gojasm test`
+func (asm *Assembler) testBlock() {
+	for token := asm.next(); token != nil; token = asm.next() {
+		if token.Text == JASTestEnd {
+			return
+		}
+	}
+	asm.Panicf("Unexpected end of file\n")
+}
+
 // Parses a var block
 func (asm *Assembler) parseVars(method *Method) {
 	for token := asm.next(); token != nil; token = asm.next() {
@@ -345,14 +424,31 @@ func (asm *Assembler) linkMethods() (ok bool) {
 		asm.Errorf("linker: No main found")
 		return false
 	}
+	if !asm.linkLibraries() || !asm.checkMethodNames() {
+		return false
+	}
+	if asm.CheckRanges {
+		asm.checkRanges()
+	}
+	if asm.InlineSize > 0 && asm.InlineDepth > 0 {
+		asm.inlineMethods()
+	}
+	if asm.FoldConstants {
+		asm.foldConstants()
+	}
+	if asm.DeadMethods {
+		asm.removeDeadMethods()
+	}
+	if asm.ReorderMethods {
+		asm.reorderMethods()
+	}
+	if asm.AllocateLocals {
+		for _, m := range asm.methods {
+			asm.allocateLocals(m)
+		}
+	}
 	asm.bytes = asm.methods[0].bytes
 	for i, method := range asm.methods[1:] {
-
-		if exists, _, constant := asm.findConstant(method.name); exists {
-			asm.Errorf("linker: Method constant name conflict. `%s` already defined on line %d", method.name, constant.N)
-			return
-		}
-
 		mconst := &Constant{
 			N:     method.N,
 			Name:  method.name,
@@ -370,3 +466,69 @@ func (asm *Assembler) linkMethods() (ok bool) {
 	}
 	return
 }
+
+// Reports the methods whose name is already taken by a constant or another
+// method. Runs before unreachable methods are removed, so redefinitions are
+// reported even if they are never invoked.
+func (asm *Assembler) checkMethodNames() bool {
+	defined := make(map[string]uint32)
+	for _, c := range asm.constants {
+		defined[c.Name] = c.N
+	}
+	for _, method := range asm.methods[1:] {
+		if n, exists := defined[method.name]; exists {
+			asm.Errorf("linker: Method constant name conflict. `%s` already defined on line %d", method.name, n)
+			return false
+		}
+		defined[method.name] = method.N
+	}
+	return true
+}
+
+// Appends the methods and constants of the library archive members defining
+// methods invoked by the program, directly or through other members
+func (asm *Assembler) linkLibraries() (ok bool) {
+	if len(asm.Libraries) == 0 {
+		return true
+	}
+
+	defined := make(map[string]bool)
+	for _, c := range asm.constants {
+		defined[c.Name] = true
+	}
+	var refs []string
+	for _, m := range asm.methods {
+		defined[m.name] = true
+		for _, inst := range m.instructions {
+			if inst.linkMethod {
+				refs = append(refs, inst.label)
+			}
+		}
+	}
+
+	members, err := resolveMembers(asm.Libraries, refs, defined)
+	if err != nil {
+		asm.Errorf("linker: %s", err)
+		return false
+	}
+
+	ok = true
+	for _, obj := range members {
+		for _, c := range obj.Constants {
+			if exists, _, prev := asm.findConstant(c.Name); exists {
+				if prev.Value != c.Value {
+					asm.Errorf("linker: Constant `%s` = %d of %s conflicts with the definition on line %d (= %d)",
+						c.Name, c.Value, obj.File, prev.N, prev.Value)
+					ok = false
+				}
+				continue
+			}
+			asm.constants = append(asm.constants, &Constant{Name: c.Name, Value: c.Value, N: c.N})
+		}
+		for _, om := range obj.Methods {
+			logrus.Infof("Method %s pulled from %s", om.Name, obj.File)
+			asm.methods = append(asm.methods, libraryMethod(om, obj.File))
+		}
+	}
+	return
+}
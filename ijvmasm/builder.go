@@ -0,0 +1,109 @@
+package ijvmasm
+
+import (
+	"path"
+	"strings"
+
+	"github.com/BlackNovaTech/gojasm/opconf"
+	"github.com/sirupsen/logrus"
+)
+
+// NewBuilder returns a new Assembler without input program, for frontends
+// building a program from another source than JAS, e.g. a Java class file.
+// The program is built using AddConstant, AddMethod, AddLabel, AddInstruction
+// and EndMethod, followed by Link. The filepath is reported in errors and the
+// debug information.
+func NewBuilder(filepath string, ops *opconf.OpConfig) *Assembler {
+	asm := &Assembler{
+		opconf:    ops,
+		constants: make([]*Constant, 0),
+		methods:   make([]*Method, 0),
+	}
+	asm.SetFile(filepath)
+	return asm
+}
+
+// SetFile sets the path of the source file reported in errors and the debug information.
+func (asm *Assembler) SetFile(filepath string) {
+	asm.fileName = path.Base(filepath)
+	asm.filePath = filepath
+}
+
+// AddConstant registers a constant declared on the given source line.
+func (asm *Assembler) AddConstant(name string, value int32, N uint32) {
+	asm.line = N
+	if exists, _, constant := asm.findConstant(name); exists {
+		asm.Errorf("constant: Redefinition of constant `%s` from line %d", name, constant.N)
+		return
+	}
+	asm.constants = append(asm.constants, &Constant{Name: name, Value: value, N: N})
+	logrus.Debugf("Constant registered: %s = %d", name, value)
+}
+
+// AddMethod starts a new method with the given declaration, e.g. "main" or
+// "add(a, b)", and local variables, declared on the given source line. Main
+// must be added first, unless the assembler is relocatable. Returns nil if
+// the declaration is invalid.
+func (asm *Assembler) AddMethod(decl string, vars []string, N uint32) *Method {
+	asm.line = N
+	if decl == "main" && asm.parsedMain || decl != "main" && !asm.parsedMain && !asm.Relocatable {
+		asm.Errorf("Main must be declared once, before other methods")
+		return nil
+	}
+
+	method, err := NewMethod(decl, N)
+	if err != nil {
+		asm.Errorf("%s", err)
+		return nil
+	}
+	for _, v := range vars {
+		if !regexVariableName.MatchString(v) {
+			asm.Errorf("Invalid variable name `%s`", v)
+			continue
+		}
+		method.vars = append(method.vars, v)
+	}
+	asm.parsedMain = true
+	return method
+}
+
+// AddLabel adds a label declared on the given source line to the method,
+// pointing at the next added instruction.
+func (asm *Assembler) AddLabel(method *Method, name string, N uint32) {
+	method.labels = append(method.labels, &Label{name, N, method.bytes})
+	logrus.Debugf("[.%s] Registered label: %s@%d", method.name, name, method.bytes)
+}
+
+// AddInstruction appends an instruction in JAS notation, e.g. "ILOAD x",
+// assembled from the given source line to the method.
+func (asm *Assembler) AddInstruction(method *Method, instr string, N uint32) {
+	asm.line = N
+	if strings.TrimSpace(instr) == "" {
+		asm.Errorf("Empty instruction")
+		return
+	}
+	asm.parseInstruction(method, instr)
+}
+
+// EndMethod finishes a method started by AddMethod, linking its labels.
+func (asm *Assembler) EndMethod(method *Method) {
+	for _, inst := range method.instructions {
+		if inst.linkLabel {
+			if found, _, _ := method.findLabel(inst.label); !found {
+				asm.line = inst.N
+				asm.Errorf("Undefined label `%s`", inst.label)
+				return
+			}
+		}
+	}
+	asm.endMethod(method)
+}
+
+// Link links the invocations of the built methods, unless the assembler is relocatable.
+// Returns ok iff building the program was successful.
+func (asm *Assembler) Link() (ok bool) {
+	if !asm.Relocatable {
+		asm.linkMethods()
+	}
+	return !asm.failed
+}
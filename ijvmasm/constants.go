@@ -10,6 +10,11 @@ const (
 	JASConstantEnd   = ".end-constant"
 	JASMethodPrefix  = ".method "
 	JASMethodEnd     = ".end-method"
+	JASTestStart     = ".test"
+	JASTestEnd       = ".end-test"
 
-	OperationWide = "WIDE"
+	OperationWide     = "WIDE"
+	OperationInvoke   = "INVOKEVIRTUAL"
+	OperationReturn   = "IRETURN"
+	OperationTailCall = "TAILCALL"
 )
@@ -0,0 +1,71 @@
+package ijvmasm
+
+// DebugInfo maps the generated IJVM binary back onto the JAS source it was assembled from.
+type DebugInfo struct {
+	// File is the path of the assembled source file
+	File string
+	// Methods lists every method in the order it was placed in the binary
+	Methods []*MethodInfo
+}
+
+// MethodInfo describes a single method as it was placed in the binary.
+type MethodInfo struct {
+	Name string
+	// N is the line the method was declared on
+	N uint32
+	// B is the absolute byte offset of the method, including its header
+	B uint32
+	// Size is the amount of bytes the method occupies, including its header
+	Size uint32
+	// NumParams is the amount of parameters, including the object reference
+	NumParams int
+	// Vars holds the name of every local variable slot, parameters first
+	Vars []string
+	// Labels holds every label of the method, with absolute byte offsets
+	Labels []*Label
+	// Lines maps every instruction of the method onto its source line
+	Lines []*LineInfo
+}
+
+// LineInfo maps a single instruction onto the source line it was assembled from.
+type LineInfo struct {
+	// B is the absolute byte offset of the instruction
+	B uint32
+	// N is the source line of the instruction
+	N uint32
+}
+
+// DebugInfo returns the debug information of the assembled program.
+// Only valid after a successful Parse.
+func (asm *Assembler) DebugInfo() *DebugInfo {
+	info := &DebugInfo{
+		File:    asm.filePath,
+		Methods: make([]*MethodInfo, len(asm.methods)),
+	}
+
+	for i, m := range asm.methods {
+		base := m.B
+		mi := &MethodInfo{
+			Name:      m.name,
+			N:         m.N,
+			B:         base,
+			Size:      m.bytes,
+			NumParams: m.numparam,
+			Vars:      append([]string(nil), m.vars...),
+			Labels:    make([]*Label, len(m.labels)),
+			Lines:     make([]*LineInfo, len(m.instructions)),
+		}
+
+		for j, l := range m.labels {
+			mi.Labels[j] = &Label{Name: l.Name, N: l.N, B: base + l.B}
+		}
+
+		for j, inst := range m.instructions {
+			mi.Lines[j] = &LineInfo{B: base + inst.B, N: inst.N}
+		}
+
+		info.Methods[i] = mi
+	}
+
+	return info
+}
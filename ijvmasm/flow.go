@@ -0,0 +1,227 @@
+package ijvmasm
+
+import (
+	"fmt"
+
+	"github.com/BlackNovaTech/gojasm/opconf"
+)
+
+// Operations never continuing with the next instruction, shared by every
+// package following the control flow of IJVM code
+var terminators = map[string]bool{
+	"GOTO":            true,
+	"HALT":            true,
+	"ERR":             true,
+	OperationReturn:   true,
+	OperationTailCall: true,
+}
+
+// Amount of operand stack words popped and pushed by each operation, except
+// for invocations and IRETURN
+var stackEffects = map[string][2]int{
+	"NOP":        {0, 0},
+	"BIPUSH":     {0, 1},
+	"LDC_W":      {0, 1},
+	"DUP":        {1, 2},
+	"POP":        {1, 0},
+	"SWAP":       {2, 2},
+	"IADD":       {2, 1},
+	"ISUB":       {2, 1},
+	"IAND":       {2, 1},
+	"IOR":        {2, 1},
+	"GOTO":       {0, 0},
+	"IFEQ":       {1, 0},
+	"IFLT":       {1, 0},
+	"IF_ICMPEQ":  {2, 0},
+	"ILOAD":      {0, 1},
+	"ISTORE":     {1, 0},
+	"IINC":       {0, 0},
+	"WIDE":       {0, 0},
+	"IN":         {0, 1},
+	"OUT":        {1, 0},
+	"HALT":       {0, 0},
+	"ERR":        {0, 0},
+	"NEWARRAY":   {1, 1},
+	"ANEWARRAY":  {1, 1},
+	"IALOAD":     {2, 1},
+	"AIALOAD":    {2, 1},
+	"IASTORE":    {3, 0},
+	"AIASTORE":   {3, 0},
+	"GC":         {0, 0},
+	"NETBIND":    {1, 1},
+	"NETCONNECT": {2, 1},
+	"NETIN":      {1, 1},
+	"NETOUT":     {2, 0},
+	"NETCLOSE":   {1, 0},
+}
+
+// Terminates reports whether the operation never continues with the next
+// instruction
+func Terminates(op string) bool {
+	return terminators[op]
+}
+
+// StackEffect returns the amount of operand stack words popped and pushed by
+// the operation. Invocations and IRETURN depend on the methods involved, and
+// are not known.
+func StackEffect(op string) (pop, push int, ok bool) {
+	effect, ok := stackEffects[op]
+	return effect[0], effect[1], ok
+}
+
+// Returns the amount of bytes the instruction occupies
+func (inst *Instruction) size() uint32 {
+	size := uint32(1)
+	for _, arg := range inst.op.Args {
+		switch arg {
+		case opconf.ArgByte:
+			size++
+		case opconf.ArgVar:
+			size++
+			if inst.wide {
+				size++
+			}
+		default:
+			size += 2
+		}
+	}
+	return size
+}
+
+// Returns a copy of the instruction
+func (inst *Instruction) clone() *Instruction {
+	c := *inst
+	c.params = append([]int(nil), inst.params...)
+	return &c
+}
+
+// Returns the index of the instruction every label points at, or the amount
+// of instructions for labels at the end of the method
+func (m *Method) labelTargets() map[*Label]int {
+	targets := make(map[*Label]int, len(m.labels))
+	for _, l := range m.labels {
+		targets[l] = len(m.instructions)
+		for i, inst := range m.instructions {
+			if inst.B >= l.B {
+				targets[l] = i
+				break
+			}
+		}
+	}
+	return targets
+}
+
+// Returns the index of the instruction the label with the given name points at
+func (m *Method) labelTarget(targets map[*Label]int, name string) (int, bool) {
+	found, _, l := m.findLabel(name)
+	if !found {
+		return 0, false
+	}
+	return targets[l], true
+}
+
+// Returns the indices of the instructions that may execute after the given one
+func (m *Method) successors(targets map[*Label]int, i int) []int {
+	inst := m.instructions[i]
+	var next []int
+	if inst.linkLabel {
+		if t, ok := m.labelTarget(targets, inst.label); ok {
+			next = append(next, t)
+		}
+	}
+	if !terminators[inst.op.Name] && i+1 < len(m.instructions) {
+		next = append(next, i+1)
+	}
+	return next
+}
+
+// Returns the amount of operand stack words popped and pushed by the
+// instruction, using the given amount of parameters of invoked methods
+func (asm *Assembler) stackEffect(m *Method, inst *Instruction, params func(name string) (int, bool)) (int, int, error) {
+	switch inst.op.Name {
+	case OperationInvoke, OperationTailCall:
+		n, ok := params(inst.label)
+		if !ok {
+			return 0, 0, fmt.Errorf("undefined method `%s`", inst.label)
+		}
+		return n, 1, nil
+	case OperationReturn:
+		if m.end == JASMainEnd {
+			return 0, 0, nil
+		}
+		return 1, 0, nil
+	}
+	if effect, ok := stackEffects[inst.op.Name]; ok {
+		return effect[0], effect[1], nil
+	}
+	return 0, 0, fmt.Errorf("unknown stack effect of %s", inst.op.Name)
+}
+
+// Computes the operand stack depth before every instruction reachable from the
+// start of the method. Fails if the depth differs between paths, or the stack
+// underflows.
+func (asm *Assembler) stackDepths(m *Method, params func(name string) (int, bool)) (map[int]int, error) {
+	targets := m.labelTargets()
+	depths := make(map[int]int)
+	var work []int
+	visit := func(i, depth int) error {
+		if old, ok := depths[i]; ok {
+			if old != depth {
+				return fmt.Errorf("line %d: operand stack depth differs between paths (%d and %d)",
+					m.instructions[i].N, old, depth)
+			}
+			return nil
+		}
+		depths[i] = depth
+		work = append(work, i)
+		return nil
+	}
+
+	if len(m.instructions) == 0 {
+		return depths, nil
+	}
+	visit(0, 0)
+	for len(work) > 0 {
+		i := work[len(work)-1]
+		work = work[:len(work)-1]
+		inst := m.instructions[i]
+
+		pops, pushes, err := asm.stackEffect(m, inst, params)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", inst.N, err)
+		}
+		depth := depths[i]
+		if depth < pops {
+			return nil, fmt.Errorf("line %d: %s underflows the operand stack", inst.N, inst.op.Name)
+		}
+		for _, next := range m.successors(targets, i) {
+			if err := visit(next, depth+pushes-pops); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return depths, nil
+}
+
+// Recomputes the byte offsets of the instructions and labels, after the
+// instruction stream changed, given the index of the instruction every label
+// points at. Relinks the labels.
+func (m *Method) relayout(targets map[*Label]int) {
+	offsets := make([]uint32, len(m.instructions)+1)
+	B := uint32(0)
+	if m.end != JASMainEnd {
+		B = 4
+	}
+	for i, inst := range m.instructions {
+		offsets[i] = B
+		inst.B = B
+		B += inst.size()
+	}
+	offsets[len(m.instructions)] = B
+	m.bytes = B
+
+	for _, l := range m.labels {
+		l.B = offsets[targets[l]]
+	}
+	m.LinkLabels()
+}
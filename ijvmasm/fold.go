@@ -0,0 +1,437 @@
+package ijvmasm
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/BlackNovaTech/gojasm/opconf"
+	"github.com/sirupsen/logrus"
+)
+
+// Arithmetic operations evaluated at assembly time, given their operands in push order
+var constOperations = map[string]func(a, b int32) int32{
+	"IADD": func(a, b int32) int32 { return a + b },
+	"ISUB": func(a, b int32) int32 { return a - b },
+	"IAND": func(a, b int32) int32 { return a & b },
+	"IOR":  func(a, b int32) int32 { return a | b },
+}
+
+// Conditional branches resolved at assembly time, given their operands in push order
+var constBranches = map[string]func(ops []int32) bool{
+	"IFEQ":      func(ops []int32) bool { return ops[0] == 0 },
+	"IFLT":      func(ops []int32) bool { return ops[0] < 0 },
+	"IF_ICMPEQ": func(ops []int32) bool { return ops[0] == ops[1] },
+}
+
+// Value of an operand stack word or local variable, if known at assembly time
+type constValue struct {
+	known bool
+	value int32
+	// Index of the instruction pushing the word, if it pushes nothing but the
+	// constant, or -1
+	push int
+}
+
+var unknownValue = constValue{push: -1}
+
+// Known values before an instruction
+type constState struct {
+	stack  []constValue
+	locals []constValue
+}
+
+func (s *constState) copy() *constState {
+	return &constState{
+		stack:  append([]constValue(nil), s.stack...),
+		locals: append([]constValue(nil), s.locals...),
+	}
+}
+
+// Keeps the values s has in common with o, reporting whether s changed
+func (s *constState) merge(o *constState) (bool, error) {
+	if len(s.stack) != len(o.stack) {
+		return false, fmt.Errorf("operand stack depth differs between paths (%d and %d)", len(s.stack), len(o.stack))
+	}
+	changed := false
+	meet := func(a *constValue, b constValue) {
+		if a.known && (!b.known || a.value != b.value) {
+			*a = unknownValue
+			changed = true
+		}
+		if a.push != b.push && a.push >= 0 {
+			a.push = -1
+			changed = true
+		}
+	}
+	for i := range s.stack {
+		meet(&s.stack[i], o.stack[i])
+	}
+	for i := range s.locals {
+		meet(&s.locals[i], o.locals[i])
+	}
+	return changed, nil
+}
+
+// Propagates constants through a single method
+type folder struct {
+	asm    *Assembler
+	m      *Method
+	params func(name string) (int, bool)
+
+	targets map[*Label]int
+	// Known values before every instruction, nil for unreachable instructions
+	in []*constState
+}
+
+// Folds constant arithmetic and resolves conditional branches on constants
+// in every method, removing the code no longer reachable. Values are tracked
+// through the operand stack and local variables. Local variables are unknown
+// until stored to, as only the emulator starts them out zero.
+func (asm *Assembler) foldConstants() {
+	byName := make(map[string]*Method)
+	for _, m := range asm.methods {
+		if _, ok := byName[m.name]; !ok && m.end != JASMainEnd {
+			byName[m.name] = m
+		}
+	}
+	params := func(name string) (int, bool) {
+		m, ok := byName[name]
+		if !ok {
+			return 0, false
+		}
+		return m.numparam, true
+	}
+
+	for _, m := range asm.methods {
+		if m.code != nil || len(m.instructions) == 0 {
+			continue
+		}
+		f := &folder{asm: asm, m: m, params: params}
+		var folded, resolved int
+		bytes := m.bytes
+		for {
+			if err := f.analyze(); err != nil {
+				logrus.Debugf("[.%s] Not folding constants: %s", m.name, err)
+				break
+			}
+			fo, re, ok := f.apply()
+			if !ok {
+				break
+			}
+			folded += fo
+			resolved += re
+		}
+		if bytes != m.bytes || folded+resolved > 0 {
+			logrus.Infof("[.%s] Folded %d constant expressions and resolved %d branches, from %d to %d bytes",
+				m.name, folded, resolved, bytes, m.bytes)
+		}
+	}
+}
+
+// Computes the known values before every instruction reachable from the start
+// of the method, only following the branches taken on known operands
+func (f *folder) analyze() error {
+	m := f.m
+	f.targets = m.labelTargets()
+	f.in = make([]*constState, len(m.instructions))
+
+	entry := &constState{locals: make([]constValue, len(m.vars))}
+	for v := range entry.locals {
+		entry.locals[v] = unknownValue
+	}
+	f.in[0] = entry
+	work := []int{0}
+	for len(work) > 0 {
+		i := work[len(work)-1]
+		work = work[:len(work)-1]
+		out, next, _, err := f.step(i)
+		if err != nil {
+			return fmt.Errorf("line %d: %s", m.instructions[i].N, err)
+		}
+		for _, s := range next {
+			if f.in[s] == nil {
+				f.in[s] = out.copy()
+				work = append(work, s)
+				continue
+			}
+			changed, err := f.in[s].merge(out)
+			if err != nil {
+				return fmt.Errorf("line %d: %s", m.instructions[s].N, err)
+			}
+			if changed {
+				work = append(work, s)
+			}
+		}
+	}
+	return nil
+}
+
+// Executes the instruction on the values known before it. Returns the values
+// known after it, the instructions that may execute next, and the operands it
+// pops in push order.
+func (f *folder) step(i int) (*constState, []int, []constValue, error) {
+	inst := f.m.instructions[i]
+	out := f.in[i].copy()
+	pops, pushes, err := f.asm.stackEffect(f.m, inst, f.params)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if len(out.stack) < pops {
+		return nil, nil, nil, fmt.Errorf("%s underflows the operand stack", inst.op.Name)
+	}
+	ops := append([]constValue(nil), out.stack[len(out.stack)-pops:]...)
+	out.stack = out.stack[:len(out.stack)-pops]
+	push := func(v constValue) {
+		out.stack = append(out.stack, v)
+	}
+
+	switch name := inst.op.Name; name {
+	case "BIPUSH":
+		push(constValue{true, int32(inst.params[0]), i})
+	case "LDC_W":
+		if inst.linkConst {
+			push(unknownValue)
+		} else {
+			push(constValue{true, f.asm.constants[inst.params[0]].Value, i})
+		}
+	case "ILOAD":
+		v := out.locals[inst.params[0]]
+		if v.known {
+			v.push = i
+		}
+		push(v)
+	case "ISTORE":
+		out.locals[inst.params[0]] = constValue{ops[0].known, ops[0].value, -1}
+	case "IINC":
+		v := &out.locals[inst.params[0]]
+		v.value += int32(inst.params[1])
+	case "DUP":
+		push(constValue{ops[0].known, ops[0].value, -1})
+		push(constValue{ops[0].known, ops[0].value, -1})
+	case "SWAP":
+		push(constValue{ops[1].known, ops[1].value, -1})
+		push(constValue{ops[0].known, ops[0].value, -1})
+	default:
+		if op, ok := constOperations[name]; ok && ops[0].known && ops[1].known {
+			push(constValue{true, op(ops[0].value, ops[1].value), -1})
+			break
+		}
+		for j := 0; j < pushes; j++ {
+			push(unknownValue)
+		}
+		if v, _, store := varAccess(inst); store {
+			out.locals[v] = unknownValue
+		}
+	}
+
+	if taken, known := f.branch(inst, ops); known {
+		var next []int
+		if !taken && i+1 < len(f.m.instructions) {
+			next = append(next, i+1)
+		} else if t, ok := f.m.labelTarget(f.targets, inst.label); taken && ok {
+			next = append(next, t)
+		}
+		return out, next, ops, nil
+	}
+	return out, f.m.successors(f.targets, i), ops, nil
+}
+
+// Reports whether the conditional branch is taken, if its operands are known
+func (f *folder) branch(inst *Instruction, ops []constValue) (taken, known bool) {
+	cond, ok := constBranches[inst.op.Name]
+	if !ok {
+		return false, false
+	}
+	values := make([]int32, len(ops))
+	for i, v := range ops {
+		if !v.known {
+			return false, false
+		}
+		values[i] = v.value
+	}
+	return cond(values), true
+}
+
+// Rewrites the method after analyze, folding constant arithmetic, resolving
+// branches and removing unreachable instructions. Returns the amount of
+// expressions folded and branches resolved, and whether anything changed.
+func (f *folder) apply() (folded, resolved int, changed bool) {
+	m := f.m
+	asm := f.asm
+	n := len(m.instructions)
+
+	// Constants pushed only to be consumed by a single instruction may be
+	// removed along with it. Constants carried over to another path, or left
+	// on the stack when the method ends, are never consumed at all.
+	consumers := make(map[int][]int)
+	escapes := make(map[int]bool)
+	operands := make([][]constValue, n)
+	for i, in := range f.in {
+		if in == nil {
+			continue
+		}
+		out, next, ops, _ := f.step(i)
+		operands[i] = ops
+		for _, v := range ops {
+			if v.push >= 0 {
+				consumers[v.push] = append(consumers[v.push], i)
+			}
+		}
+		if name := m.instructions[i].op.Name; terminators[name] && name != "GOTO" {
+			for _, v := range out.stack {
+				escapes[v.push] = true
+			}
+		}
+		for _, s := range next {
+			for k, v := range out.stack {
+				if f.in[s].stack[k].push != v.push {
+					escapes[v.push] = true
+				}
+			}
+		}
+	}
+	removable := func(v constValue, i int) bool {
+		c := consumers[v.push]
+		return v.push >= 0 && !escapes[v.push] && len(c) == 1 && c[0] == i
+	}
+
+	remove := make(map[int]bool)
+	replace := make(map[int][]*Instruction)
+	removePush := func(p int) {
+		remove[p] = true
+		if m.instructions[p].wide && p > 0 && m.instructions[p-1].op.Name == OperationWide {
+			remove[p-1] = true
+		}
+	}
+	pop := asm.opconf.GetOp("POP")
+	jump := asm.opconf.GetOp("GOTO")
+	for i, inst := range m.instructions {
+		ops := operands[i]
+		switch {
+		case f.in[i] == nil:
+			remove[i] = true
+		case constOperations[inst.op.Name] != nil:
+			if !ops[0].known || !ops[1].known || !removable(ops[0], i) || !removable(ops[1], i) {
+				continue
+			}
+			value := constOperations[inst.op.Name](ops[0].value, ops[1].value)
+			c := asm.pushConstant(value, inst.N)
+			if c == nil {
+				continue
+			}
+			logrus.Debugf("[.%s] Folded %s at line %d to %d", m.name, inst.op.Name, inst.N, value)
+			removePush(ops[0].push)
+			removePush(ops[1].push)
+			replace[i] = []*Instruction{c}
+			folded++
+		case constBranches[inst.op.Name] != nil:
+			taken, known := f.branch(inst, ops)
+			if !known || pop == nil || jump == nil {
+				continue
+			}
+			logrus.Debugf("[.%s] Resolved %s at line %d, taken: %v", m.name, inst.op.Name, inst.N, taken)
+			var with []*Instruction
+			for _, v := range ops {
+				if removable(v, i) {
+					removePush(v.push)
+				} else {
+					with = append(with, NewInstruction(pop, inst.N, 0))
+				}
+			}
+			if taken {
+				g := NewInstruction(jump, inst.N, 0)
+				g.label = inst.label
+				g.linkLabel = true
+				with = append(with, g)
+			}
+			replace[i] = with
+			resolved++
+		}
+	}
+
+	// Jumps to the next instruction left are removed
+	for i, inst := range m.instructions {
+		if _, replaced := replace[i]; inst.op.Name != "GOTO" || remove[i] || replaced {
+			continue
+		}
+		t, ok := m.labelTarget(f.targets, inst.label)
+		if !ok || t <= i {
+			continue
+		}
+		next := true
+		for j := i + 1; j < t; j++ {
+			with, replaced := replace[j]
+			next = next && (remove[j] || replaced && len(with) == 0)
+		}
+		if next {
+			remove[i] = true
+		}
+	}
+
+	if len(remove) == 0 && len(replace) == 0 {
+		return 0, 0, false
+	}
+
+	out := &rewrite{
+		method:  m,
+		targets: make(map[*Label]int),
+		wide:    asm.opconf.GetOp(OperationWide),
+	}
+	byIndex := make(map[int][]*Label)
+	for _, l := range m.labels {
+		byIndex[f.targets[l]] = append(byIndex[f.targets[l]], l)
+	}
+	for i := 0; i <= n; i++ {
+		for _, l := range byIndex[i] {
+			out.place(l)
+		}
+		if i == n || remove[i] {
+			continue
+		}
+		if with, replaced := replace[i]; replaced {
+			for _, inst := range with {
+				out.add(inst)
+			}
+			continue
+		}
+		out.add(m.instructions[i])
+	}
+	m.instructions = out.instructions
+	m.labels = out.labels
+	m.relayout(out.targets)
+	return folded, resolved, true
+}
+
+// Returns an instruction pushing the value, using BIPUSH if it fits a byte,
+// or LDC_W with a constant of the value, added to the pool if none exists yet.
+// Returns nil if the operation configuration provides neither.
+func (asm *Assembler) pushConstant(value int32, N uint32) *Instruction {
+	if op := asm.opconf.GetOp("BIPUSH"); op != nil && value >= math.MinInt8 && value <= math.MaxInt8 {
+		inst := NewInstruction(op, N, 0)
+		inst.params[0] = int(value)
+		return inst
+	}
+	op := asm.opconf.GetOp("LDC_W")
+	if op == nil || len(op.Args) != 1 || op.Args[0] != opconf.ArgConst {
+		return nil
+	}
+	index := -1
+	for i, c := range asm.constants {
+		if c.Value == value {
+			index = i
+			break
+		}
+	}
+	if index < 0 {
+		name := fmt.Sprintf("fold.%d", value)
+		if exists, _, _ := asm.findConstant(name); exists {
+			return nil
+		}
+		index = len(asm.constants)
+		asm.constants = append(asm.constants, &Constant{Name: name, Value: value, N: N})
+		logrus.Debugf("Constant registered: %s = %d", name, value)
+	}
+	inst := NewInstruction(op, N, 0)
+	inst.params[0] = index
+	return inst
+}
@@ -0,0 +1,325 @@
+package ijvmasm_test
+
+import (
+	"testing"
+
+	"github.com/BlackNovaTech/gojasm/ijvmasm"
+	"github.com/BlackNovaTech/gojasm/internal/testprog"
+)
+
+var foldPrograms = []struct {
+	name   string
+	input  string
+	source string
+	// Set if folding must save executed instructions
+	folds bool
+}{
+	{"arithmetic", "", `
+.main
+BIPUSH 3
+BIPUSH 4
+IADD
+BIPUSH '0'
+IADD
+OUT
+BIPUSH 0x7f
+BIPUSH 0x70
+IAND
+BIPUSH 0x0f
+IOR
+BIPUSH 0x3f
+ISUB
+OUT
+HALT
+.end-main`, true},
+
+	{"pooled", "", `
+.constant
+big 100000
+max 0x7fffffff
+min -0x80000000
+.end-constant
+.main
+BIPUSH 100
+BIPUSH 100
+IADD
+LDC_W big
+IADD
+LDC_W big
+ISUB
+BIPUSH 127
+ISUB
+OUT
+LDC_W max
+BIPUSH 1
+IADD
+LDC_W min
+IF_ICMPEQ wrapped
+ERR
+wrapped:
+BIPUSH 'w'
+OUT
+HALT
+.end-main`, true},
+
+	{"locals", "", `
+.main
+.var
+a
+b
+zero
+.end-var
+BIPUSH 0
+ISTORE zero
+BIPUSH 40
+ISTORE a
+IINC a 25
+ILOAD a
+ILOAD zero
+IADD
+ISTORE b
+ILOAD b
+OUT
+ILOAD b
+ILOAD a
+IF_ICMPEQ same
+ERR
+same:
+HALT
+.end-main`, true},
+
+	{"branches", "", `
+.main
+BIPUSH 0
+IFEQ eq
+BIPUSH 'x'
+OUT
+eq:
+BIPUSH 1
+IFEQ ne
+BIPUSH 'a'
+OUT
+ne:
+BIPUSH -1
+IFLT lt
+BIPUSH 'x'
+OUT
+lt:
+BIPUSH 1
+IFLT ge
+BIPUSH 'b'
+OUT
+ge:
+BIPUSH 3
+BIPUSH 4
+IF_ICMPEQ never
+BIPUSH 'c'
+OUT
+GOTO end
+never:
+ERR
+end:
+HALT
+.end-main`, true},
+
+	{"loop", "", `
+.main
+.var
+i
+.end-var
+BIPUSH 5
+ISTORE i
+loop:
+ILOAD i
+IFEQ done
+ILOAD i
+BIPUSH '0'
+IADD
+OUT
+IINC i -1
+GOTO loop
+done:
+BIPUSH 2
+BIPUSH 3
+IADD
+BIPUSH '0'
+IADD
+OUT
+HALT
+.end-main`, true},
+
+	{"input", "a\x00", `
+.main
+IN
+DUP
+IFEQ zero
+OUT
+GOTO next
+zero:
+POP
+next:
+IN
+BIPUSH 0
+IADD
+IFEQ end
+BIPUSH 'n'
+OUT
+end:
+HALT
+.end-main`, false},
+
+	{"merged", "", `
+.main
+IN
+IFEQ left
+BIPUSH 4
+GOTO join
+left:
+BIPUSH 4
+join:
+BIPUSH '0'
+IADD
+DUP
+OUT
+BIPUSH 52
+IF_ICMPEQ equal
+ERR
+equal:
+IN
+IFEQ l2
+BIPUSH 0
+GOTO j2
+l2:
+BIPUSH 0
+j2:
+IFEQ ok
+ERR
+ok:
+HALT
+.end-main`, true},
+
+	{"escaping", "\x01\x00", `
+.main
+IN
+IFEQ other
+BIPUSH '5'
+IN
+IFEQ join
+BIPUSH 1
+IADD
+OUT
+HALT
+other:
+BIPUSH '6'
+join:
+OUT
+HALT
+.end-main`, false},
+
+	{"backwards", "", `
+.main
+GOTO push
+add:
+IADD
+OUT
+HALT
+push:
+BIPUSH '!'
+BIPUSH 1
+GOTO add
+.end-main`, true},
+
+	{"methods", "", `
+.constant
+objref 0xCAFE
+.end-constant
+.main
+LDC_W objref
+BIPUSH 7
+INVOKEVIRTUAL f
+OUT
+LDC_W objref
+BIPUSH -7
+INVOKEVIRTUAL f
+OUT
+HALT
+.end-main
+
+.method f(x)
+.var
+y
+.end-var
+ILOAD y
+IFEQ zeroed
+ERR
+zeroed:
+ILOAD x
+IFLT negative
+BIPUSH 'p'
+IRETURN
+negative:
+BIPUSH 'n'
+BIPUSH 0
+BIPUSH 0
+IADD
+IADD
+IRETURN
+.end-method`, true},
+}
+
+// Branches on local variables never stored to are kept, as only the emulator
+// starts them out zero
+const uninitializedSource = `
+.main
+.var
+x
+.end-var
+ILOAD x
+IFEQ zero
+BIPUSH 'n'
+OUT
+zero:
+HALT
+.end-main`
+
+// Enables or disables folding constants
+func folding(fold bool) func(*ijvmasm.Assembler) {
+	return func(asm *ijvmasm.Assembler) {
+		asm.FoldConstants = fold
+	}
+}
+
+// Folded programs must behave exactly like the programs as written
+func checkFolded(t *testing.T, name, input, src string, folds bool) {
+	want, wantSteps, wantErr := testprog.Run(testprog.Assemble(t, nil, name, src, folding(false)), input)
+	got, gotSteps, gotErr := testprog.Run(testprog.Assemble(t, nil, name, src, folding(true)), input)
+	if got != want {
+		t.Errorf("%s: output %q, want %q", name, got, want)
+	}
+	if (gotErr == nil) != (wantErr == nil) {
+		t.Errorf("%s: error %v, want %v", name, gotErr, wantErr)
+	}
+	if gotSteps > wantSteps || folds && gotSteps == wantSteps {
+		t.Errorf("%s: %d steps folded, %d as written", name, gotSteps, wantSteps)
+	}
+}
+
+func TestFoldConstants(t *testing.T) {
+	for _, p := range foldPrograms {
+		checkFolded(t, p.name+".jas", p.input, p.source, p.folds)
+	}
+}
+
+func TestFoldConstantsPrograms(t *testing.T) {
+	for _, p := range testprog.Examples(t) {
+		checkFolded(t, p.Name, testprog.ExampleInput, p.Source, false)
+	}
+}
+
+func TestFoldUninitializedLocals(t *testing.T) {
+	plain := testprog.Assemble(t, nil, "uninitialized.jas", uninitializedSource, folding(false))
+	folded := testprog.Assemble(t, nil, "uninitialized.jas", uninitializedSource, folding(true))
+	if len(folded.Text) != len(plain.Text) {
+		t.Errorf("folded %d bytes of code into %d", len(plain.Text), len(folded.Text))
+	}
+}
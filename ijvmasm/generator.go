@@ -33,23 +33,11 @@ func (asm *Assembler) Generate(out io.Writer) (err error) {
 		}
 	}()
 
-	// Write magic
-	mustWrite(out, Magic)
-	// Write constant pool offset
-	mustWrite(out, ConstPoolOffset)
-	// Write constant block size
-	mustWrite(out, uint32(len(asm.constants)*4))
-
-	// Write constants
-	for _, c := range asm.constants {
-		mustWrite(out, c.Value)
+	values := make([]int32, len(asm.constants))
+	for i, c := range asm.constants {
+		values[i] = c.Value
 	}
-
-	// Write zero (data block memory location)
-	mustWrite(out, uint32(0))
-
-	// Write total byte count
-	mustWrite(out, uint32(asm.bytes))
+	writeHeader(out, values, asm.bytes)
 
 	// Generate main
 	asm.methods[0].Generate(out)
@@ -83,42 +71,62 @@ func (asm *Assembler) GenerateDebugSymbols(out io.Writer) (err error) {
 		}
 	}()
 
-	buf := new(bytes.Buffer)
-	writer := bufio.NewWriter(buf)
-
-	// Write method headers
+	var methods, labels []symbolEntry
 	for _, m := range asm.methods {
-		mustWrite(writer, m.B)
-		mustWrite(writer, []byte(m.name))
-		mustWrite(writer, uint8(0))
+		methods = append(methods, symbolEntry{m.B, m.name})
+		for _, l := range m.labels {
+			labels = append(labels, symbolEntry{m.B + l.B, fmt.Sprintf("%s#%s", m.name, l.Name)})
+		}
 	}
+	writeSymbols(out, methods, labels)
+	return
+}
 
-	writer.Flush()
-	outbytes := buf.Bytes()
+// Writes the header of an IJVM binary: the constant pool, followed by the
+// header of the text block of the given size
+func writeHeader(out io.Writer, constants []int32, bytes uint32) {
+	// Write magic
+	mustWrite(out, Magic)
+	// Write constant pool offset
+	mustWrite(out, ConstPoolOffset)
+	// Write constant block size
+	mustWrite(out, uint32(len(constants)*4))
 
-	mustWrite(out, uint32(0xEEEEEEEE))
-	mustWrite(out, uint32(len(outbytes)))
-	mustWrite(out, outbytes)
+	// Write constants
+	for _, c := range constants {
+		mustWrite(out, c)
+	}
 
-	buf = new(bytes.Buffer)
-	writer = bufio.NewWriter(buf)
+	// Write zero (data block memory location)
+	mustWrite(out, uint32(0))
 
-	// Write labels
-	for _, m := range asm.methods {
-		for _, l := range m.labels {
-			mustWrite(writer, m.B+l.B)
-			mustWrite(writer, []byte(fmt.Sprintf("%s#%s", m.name, l.Name)))
+	// Write total byte count
+	mustWrite(out, bytes)
+}
+
+// An entry of a symbol block
+type symbolEntry struct {
+	B    uint32
+	name string
+}
+
+// Writes the method and label symbol blocks
+func writeSymbols(out io.Writer, methods, labels []symbolEntry) {
+	for i, block := range [][]symbolEntry{methods, labels} {
+		buf := new(bytes.Buffer)
+		writer := bufio.NewWriter(buf)
+		for _, s := range block {
+			mustWrite(writer, s.B)
+			mustWrite(writer, []byte(s.name))
 			mustWrite(writer, uint8(0))
 		}
-	}
-
-	writer.Flush()
-	outbytes = buf.Bytes()
+		writer.Flush()
+		outbytes := buf.Bytes()
 
-	mustWrite(out, uint32(0xFFFFFFFF))
-	mustWrite(out, uint32(len(outbytes)))
-	mustWrite(out, outbytes)
-	return
+		mustWrite(out, []uint32{0xEEEEEEEE, 0xFFFFFFFF}[i])
+		mustWrite(out, uint32(len(outbytes)))
+		mustWrite(out, outbytes)
+	}
 }
 
 func mustWrite(out io.Writer, data interface{}) {
@@ -0,0 +1,313 @@
+package ijvmasm
+
+import (
+	"fmt"
+
+	"github.com/BlackNovaTech/gojasm/opconf"
+	"github.com/sirupsen/logrus"
+)
+
+// Inlines small methods at their call sites
+type inliner struct {
+	asm    *Assembler
+	byName map[string]*Method
+	// The instructions and labels of every method before inlining, as methods
+	// are rewritten one by one
+	bodies    map[*Method]*methodBody
+	inlinable map[*Method]bool
+	// Methods currently being inlined
+	active map[*Method]bool
+	// Amount of call sites inlined, numbering the renamed locals and labels
+	sites int
+}
+
+type methodBody struct {
+	instructions []*Instruction
+	// Labels pointing at every instruction index
+	labels map[int][]*Label
+}
+
+// Instructions and labels of a method being rewritten
+type rewrite struct {
+	method       *Method
+	instructions []*Instruction
+	labels       []*Label
+	targets      map[*Label]int
+	wide         *opconf.Operation
+}
+
+// Appends an instruction, prefixed by WIDE if it refers to a variable beyond 255
+func (r *rewrite) add(inst *Instruction) {
+	for i, arg := range inst.op.Args {
+		if arg == opconf.ArgVar && inst.params[i] > 0xFF && !inst.wide {
+			r.instructions = append(r.instructions, NewInstruction(r.wide, inst.N, 0))
+			inst.wide = true
+		}
+	}
+	r.instructions = append(r.instructions, inst)
+}
+
+// Appends an instruction with a single variable or label argument
+func (r *rewrite) addOp(op *opconf.Operation, N uint32, param int, label string) {
+	inst := NewInstruction(op, N, 0)
+	if len(op.Args) > 0 {
+		inst.params[0] = param
+	}
+	if label != "" {
+		inst.label = label
+		inst.linkLabel = true
+	}
+	r.add(inst)
+}
+
+// Places the label at the next instruction
+func (r *rewrite) place(l *Label) {
+	r.labels = append(r.labels, l)
+	r.targets[l] = len(r.instructions)
+}
+
+// Inlines the methods of at most InlineSize bytes of code at their call
+// sites, up to InlineDepth levels deep. Recursive methods, and methods whose
+// operand stack usage cannot be determined, are never inlined.
+func (asm *Assembler) inlineMethods() {
+	in := &inliner{
+		asm:       asm,
+		byName:    make(map[string]*Method),
+		bodies:    make(map[*Method]*methodBody),
+		inlinable: make(map[*Method]bool),
+		active:    make(map[*Method]bool),
+	}
+	for _, m := range asm.methods {
+		if _, ok := in.byName[m.name]; !ok && m.end != JASMainEnd {
+			in.byName[m.name] = m
+		}
+		if m.code != nil {
+			continue
+		}
+		b := &methodBody{
+			instructions: m.instructions,
+			labels:       make(map[int][]*Label),
+		}
+		targets := m.labelTargets()
+		for _, l := range m.labels {
+			b.labels[targets[l]] = append(b.labels[targets[l]], l)
+		}
+		in.bodies[m] = b
+	}
+	for _, m := range asm.methods {
+		in.inlinable[m] = in.canInline(m)
+	}
+
+	for _, m := range asm.methods {
+		if m.code != nil {
+			continue
+		}
+		sites := in.sites
+		out := &rewrite{
+			method:  m,
+			targets: make(map[*Label]int),
+			wide:    asm.opconf.GetOp(OperationWide),
+		}
+		in.expand(out, m, nil, "", 0, nil)
+		if in.sites == sites {
+			continue
+		}
+		m.instructions = out.instructions
+		m.labels = out.labels
+		m.relayout(out.targets)
+	}
+	if in.sites > 0 {
+		logrus.Infof("Inlined %d call sites", in.sites)
+	}
+}
+
+// Reports whether the method may be inlined
+func (in *inliner) canInline(m *Method) bool {
+	if m.code != nil || m.end == JASMainEnd || len(m.instructions) == 0 {
+		return false
+	}
+	if m.bytes-4 > uint32(in.asm.InlineSize) {
+		return false
+	}
+	for _, name := range []string{"GOTO", "POP", "BIPUSH", "ISTORE"} {
+		if in.asm.opconf.GetOp(name) == nil {
+			return false
+		}
+	}
+	if in.recursive(m) {
+		logrus.Debugf("[.%s] Not inlining recursive method", m.name)
+		return false
+	}
+
+	depths, err := in.asm.stackDepths(m, in.params)
+	if err != nil {
+		logrus.Debugf("[.%s] Not inlining: %s", m.name, err)
+		return false
+	}
+	for i, inst := range m.instructions {
+		depth, reachable := depths[i]
+		switch {
+		case !reachable:
+		case inst.op.Name == OperationTailCall:
+			logrus.Debugf("[.%s] Not inlining method with tail calls", m.name)
+			return false
+		case inst.op.Name == OperationReturn && depth != 1:
+			logrus.Debugf("[.%s] Not inlining: IRETURN at line %d leaves %d words on the operand stack", m.name, inst.N, depth-1)
+			return false
+		}
+	}
+	return true
+}
+
+// Returns the amount of parameters of the named method, including the object reference
+func (in *inliner) params(name string) (int, bool) {
+	m, ok := in.byName[name]
+	if !ok {
+		return 0, false
+	}
+	return m.numparam, true
+}
+
+// Reports whether the method can invoke itself, directly or through other methods
+func (in *inliner) recursive(m *Method) bool {
+	seen := make(map[*Method]bool)
+	work := []*Method{m}
+	for len(work) > 0 {
+		caller := work[len(work)-1]
+		work = work[:len(work)-1]
+		for _, name := range caller.invoked() {
+			callee, ok := in.byName[name]
+			if !ok || seen[callee] {
+				continue
+			}
+			if callee == m {
+				return true
+			}
+			seen[callee] = true
+			work = append(work, callee)
+		}
+	}
+	return false
+}
+
+// Appends the original instructions of src to the rewritten method, inlining
+// the invocations of inlinable methods. The variables and labels of inlined
+// methods are mapped onto the given caller variables and prefixed label names,
+// and their IRETURN jumps to the exit label. Reports whether the exit label is used.
+func (in *inliner) expand(out *rewrite, src *Method, vars []int, prefix string, depth int, exit *Label) (exits bool) {
+	body := in.bodies[src]
+	for i := 0; i <= len(body.instructions); i++ {
+		for _, l := range body.labels[i] {
+			if vars == nil {
+				out.place(l)
+			} else {
+				out.place(&Label{Name: prefix + l.Name, N: l.N})
+			}
+		}
+		if i == len(body.instructions) {
+			break
+		}
+
+		inst := body.instructions[i]
+		if inst.op.Name == OperationInvoke {
+			if callee, ok := in.byName[inst.label]; ok && in.inlinable[callee] && !in.active[callee] &&
+				callee != out.method && depth < in.asm.InlineDepth && in.inline(out, inst, callee, depth+1) {
+				continue
+			}
+		}
+
+		c := inst.clone()
+		if vars != nil {
+			if c.op.Name == OperationReturn {
+				// The last IRETURN falls through to the exit
+				if i+1 < len(body.instructions) {
+					out.addOp(in.asm.opconf.GetOp("GOTO"), c.N, 0, exit.Name)
+					exits = true
+				}
+				continue
+			}
+			for j, arg := range c.op.Args {
+				if arg == opconf.ArgVar {
+					c.params[j] = vars[c.params[j]]
+				}
+			}
+			if c.linkLabel {
+				c.label = prefix + c.label
+			}
+		}
+		out.add(c)
+	}
+	return
+}
+
+// Inlines the callee at the invocation, allocating fresh locals of the caller
+// for the variables of the callee. Returns false if the caller has no room for them.
+func (in *inliner) inline(out *rewrite, site *Instruction, callee *Method, depth int) bool {
+	m := out.method
+	if len(m.vars)+len(callee.vars)-1 > 0x100 && (!in.asm.AutoWide || out.wide == nil) {
+		logrus.Infof("[.%s] Not inlining %s at line %d, exceeding 256 local variables", m.name, callee.name, site.N)
+		return false
+	}
+
+	in.sites++
+	name := fmt.Sprintf("%s.%d", callee.name, in.sites)
+	vars := make([]int, len(callee.vars))
+	for i, v := range callee.vars[1:] {
+		vars[i+1] = len(m.vars)
+		m.vars = append(m.vars, name+"."+v)
+	}
+
+	// Pop the arguments into their variables, and the object reference
+	istore := in.asm.opconf.GetOp("ISTORE")
+	for i := callee.numparam - 1; i > 0; i-- {
+		out.addOp(istore, site.N, vars[i], "")
+	}
+	out.addOp(in.asm.opconf.GetOp("POP"), site.N, 0, "")
+
+	// Locals start out zero, unless always stored before being read
+	for i := callee.numparam; i < len(callee.vars); i++ {
+		if in.readBeforeStored(callee, i) {
+			out.addOp(in.asm.opconf.GetOp("BIPUSH"), site.N, 0, "")
+			out.addOp(istore, site.N, vars[i], "")
+		}
+	}
+
+	logrus.Infof("[.%s] Inlined %s at line %d", m.name, callee.name, site.N)
+	exit := &Label{Name: name + ".return", N: site.N}
+	in.active[callee] = true
+	exits := in.expand(out, callee, vars, name+".", depth, exit)
+	delete(in.active, callee)
+	if exits {
+		out.place(exit)
+	}
+	return true
+}
+
+// Reports whether the variable may be read before it is stored. Only the
+// instructions before the first jump are considered, which always execute first.
+func (in *inliner) readBeforeStored(m *Method, v int) bool {
+	for _, inst := range in.bodies[m].instructions {
+		for i, arg := range inst.op.Args {
+			if arg != opconf.ArgVar || inst.params[i] != v {
+				continue
+			}
+			return inst.op.Name != "ISTORE"
+		}
+		if inst.linkLabel || terminators[inst.op.Name] {
+			return in.references(m, v)
+		}
+	}
+	return false
+}
+
+// Reports whether any instruction of the method refers to the variable
+func (in *inliner) references(m *Method, v int) bool {
+	for _, inst := range in.bodies[m].instructions {
+		for i, arg := range inst.op.Args {
+			if arg == opconf.ArgVar && inst.params[i] == v {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,242 @@
+package ijvmasm_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/BlackNovaTech/gojasm/ijvmasm"
+	"github.com/BlackNovaTech/gojasm/ijvmemu"
+	"github.com/BlackNovaTech/gojasm/internal/testprog"
+)
+
+var inlinePrograms = []struct {
+	name   string
+	output string
+	source string
+}{
+	// IRETURN before the end of the callee jumps to the continuation
+	{"early return", "pppnn", `
+.main
+.var
+i
+.end-var
+BIPUSH 5
+ISTORE i
+loop:
+BIPUSH 0
+ILOAD i
+BIPUSH 3
+ISUB
+INVOKEVIRTUAL sign
+OUT
+IINC i -1
+ILOAD i
+IFEQ done
+GOTO loop
+done:
+HALT
+.end-main
+
+.method sign(x)
+ILOAD x
+IFLT negative
+BIPUSH 'p'
+IRETURN
+negative:
+BIPUSH 'n'
+IRETURN
+.end-method`},
+
+	// The locals of the callee start out zero at every call, and its labels
+	// do not clash with those of the caller or other call sites
+	{"locals and labels", "3332", `
+.main
+.var
+i
+.end-var
+BIPUSH 3
+ISTORE i
+loop:
+BIPUSH 0
+BIPUSH 3
+INVOKEVIRTUAL count
+OUT
+IINC i -1
+ILOAD i
+IFEQ done
+GOTO loop
+done:
+BIPUSH 0
+BIPUSH 2
+INVOKEVIRTUAL count
+OUT
+HALT
+.end-main
+
+.method count(n)
+.var
+c
+.end-var
+loop:
+IINC c 1
+IINC n -1
+ILOAD n
+IFEQ done
+GOTO loop
+done:
+ILOAD c
+BIPUSH '0'
+IADD
+IRETURN
+.end-method`},
+
+	{"nested", "d", `
+.main
+BIPUSH 0
+BIPUSH 'a'
+INVOKEVIRTUAL one
+OUT
+HALT
+.end-main
+
+.method one(x)
+BIPUSH 0
+ILOAD x
+INVOKEVIRTUAL two
+BIPUSH 1
+IADD
+IRETURN
+.end-method
+
+.method two(x)
+BIPUSH 0
+ILOAD x
+INVOKEVIRTUAL three
+BIPUSH 1
+IADD
+IRETURN
+.end-method
+
+.method three(x)
+ILOAD x
+BIPUSH 1
+IADD
+IRETURN
+.end-method`},
+}
+
+// Assembles the source, inlining methods up to the given depth, or not at all
+// if 0, and removing the methods no longer invoked
+func assembleInlined(t *testing.T, name, src string, depth int) *ijvmemu.Program {
+	return testprog.Assemble(t, nil, name+".jas", src, func(asm *ijvmasm.Assembler) {
+		asm.DeadMethods = true
+		if depth > 0 {
+			asm.InlineSize = 64
+			asm.InlineDepth = depth
+		}
+	})
+}
+
+// Returns the amount of invocations in the code of the method
+func invocations(t *testing.T, prog *ijvmemu.Program, name string) int {
+	code, _ := prog.Code(prog.MethodByName(name))
+	n := 0
+	for _, inst := range code {
+		if inst.Op.Name == ijvmasm.OperationInvoke {
+			n++
+		}
+	}
+	return n
+}
+
+// Inlining never changes the output, and removes the invocations of main
+func TestInline(t *testing.T) {
+	for _, p := range inlinePrograms {
+		plain := assembleInlined(t, p.name, p.source, 0)
+		inlined := assembleInlined(t, p.name, p.source, 3)
+
+		if out, _, err := testprog.Run(plain, ""); err != nil || out != p.output {
+			t.Fatalf("%s: printed %q and failed with %v, want %q", p.name, out, err, p.output)
+		}
+		if out, _, err := testprog.Run(inlined, ""); err != nil || out != p.output {
+			t.Errorf("%s: inlined program printed %q and failed with %v, want %q", p.name, out, err, p.output)
+		}
+		if n := invocations(t, inlined, "main"); n != 0 {
+			t.Errorf("%s: main still holds %d invocations", p.name, n)
+		}
+	}
+}
+
+// Every call site gets its own locals, named after the callee and the site
+func TestInlineLocals(t *testing.T) {
+	p := inlinePrograms[1]
+	prog := assembleInlined(t, p.name, p.source, 1)
+	want := []string{"i", "count.1.n", "count.1.c", "count.2.n", "count.2.c"}
+	if vars := prog.MethodByName("main").Vars; !reflect.DeepEqual(vars, want) {
+		t.Errorf("main has locals %q, want %q", vars, want)
+	}
+}
+
+// Invocations within inlined methods are only inlined up to the depth, while
+// the methods invoked beyond it have their own invocations inlined
+func TestInlineDepth(t *testing.T) {
+	p := inlinePrograms[2]
+	for _, c := range []struct {
+		depth   int
+		invokes int
+		methods []string
+	}{
+		{0, 1, []string{"main", "one", "two", "three"}},
+		{1, 1, []string{"main", "two"}},
+		{2, 1, []string{"main", "three"}},
+		{3, 0, []string{"main"}},
+	} {
+		prog := assembleInlined(t, p.name, p.source, c.depth)
+		if n := invocations(t, prog, "main"); n != c.invokes {
+			t.Errorf("depth %d: main holds %d invocations, want %d", c.depth, n, c.invokes)
+		}
+		var methods []string
+		for _, m := range prog.Methods() {
+			methods = append(methods, m.Name)
+		}
+		if !reflect.DeepEqual(methods, c.methods) {
+			t.Errorf("depth %d: methods %q, want %q", c.depth, methods, c.methods)
+		}
+		if out, _, err := testprog.Run(prog, ""); err != nil || out != p.output {
+			t.Errorf("depth %d: printed %q and failed with %v, want %q", c.depth, out, err, p.output)
+		}
+	}
+}
+
+// Recursive methods are left alone
+func TestInlineRecursive(t *testing.T) {
+	src := `
+.main
+BIPUSH 0
+BIPUSH 3
+INVOKEVIRTUAL down
+OUT
+HALT
+.end-main
+
+.method down(n)
+ILOAD n
+IFEQ zero
+BIPUSH 0
+ILOAD n
+BIPUSH 1
+ISUB
+INVOKEVIRTUAL down
+IRETURN
+zero:
+BIPUSH 'z'
+IRETURN
+.end-method`
+	prog := assembleInlined(t, "recursive", src, 3)
+	if n := invocations(t, prog, "main"); n != 1 {
+		t.Errorf("main holds %d invocations, want 1", n)
+	}
+	if out, _, err := testprog.Run(prog, ""); err != nil || out != "z" {
+		t.Errorf("printed %q and failed with %v, want %q", out, err, "z")
+	}
+}
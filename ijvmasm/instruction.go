@@ -20,6 +20,8 @@ type Instruction struct {
 	wide       bool
 	linkLabel  bool
 	linkMethod bool
+	// Set for constants left unresolved by relocatable assemblers
+	linkConst bool
 }
 
 // Parses a single instruction string for the given method
@@ -76,7 +78,8 @@ func (asm *Assembler) parseInstruction(method *Method, instr string) {
 			} else if idx > 0xFF {
 				if asm.AutoWide {
 					method.AppendInst(NewInstruction(asm.opconf.GetOp(OperationWide), asm.line, method.bytes))
-					bytes++
+					// WIDE, and the second byte of the index
+					bytes += 2
 					instruction.B++
 					instruction.wide = true
 					logrus.Debugf("[.%s] Auto widened instruction", method.name)
@@ -91,11 +94,16 @@ func (asm *Assembler) parseInstruction(method *Method, instr string) {
 			bytes += 2
 		case opconf.ArgConst:
 			ok, idx, _ := asm.findConstant(token)
-			if !ok {
+			switch {
+			case ok:
+				instruction.params[i] = idx
+			case asm.Relocatable:
+				instruction.label = token
+				instruction.linkConst = true
+			default:
 				asm.Errorf("argument: Constant not found: `%s`", token)
 				return
 			}
-			instruction.params[i] = idx
 			bytes += 2
 		case opconf.ArgMethod:
 			instruction.label = token
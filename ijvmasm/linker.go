@@ -0,0 +1,226 @@
+package ijvmasm
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Linked is a program linked from relocatable objects by Link.
+type Linked struct {
+	constants []int32
+	methods   []*linkedMethod
+	text      []byte
+}
+
+// A method placed in a linked program
+type linkedMethod struct {
+	*ObjectMethod
+	file string
+	// B is the absolute byte offset of the method, including its header
+	B uint32
+}
+
+// A method or constant defined by an object
+type linkSymbol struct {
+	index  int
+	value  int32
+	method bool
+	file   string
+	N      uint32
+}
+
+type linker struct {
+	symbols map[string]*linkSymbol
+	// Pool indices of the constants, by value
+	values map[int32]int
+	failed bool
+}
+
+func (lk *linker) errorf(file string, N uint32, format string, args ...interface{}) {
+	lk.failed = true
+	logrus.Errorf("%s:%d > linker: "+format, append([]interface{}{file, N}, args...)...)
+}
+
+// Link links the objects into a single program. Main is placed first, followed
+// by the other methods in the order of the objects. Constants defined by more
+// than one object are merged if their values agree, and constants of equal
+// values share a constant pool entry. Every reference to a method or constant
+// is patched with its constant pool index. Duplicate and undefined symbols are
+// logged with the files and lines they originate from.
+// The library archives are searched for methods invoked but not defined by the
+// objects, appending only the members required after the objects.
+func Link(objs []*Object, libs []*Archive) (*Linked, error) {
+	lk := &linker{
+		symbols: make(map[string]*linkSymbol),
+		values:  make(map[int32]int),
+	}
+	l := &Linked{}
+
+	if len(libs) > 0 {
+		defined := make(map[string]bool)
+		var refs []string
+		for _, obj := range objs {
+			for _, c := range obj.Constants {
+				defined[c.Name] = true
+			}
+			for _, m := range obj.Methods {
+				defined[m.Name] = true
+				for _, r := range m.Relocs {
+					if r.Method {
+						refs = append(refs, r.Symbol)
+					}
+				}
+			}
+		}
+		members, err := resolveMembers(libs, refs, defined)
+		if err != nil {
+			logrus.Errorf("linker: %s", err)
+			return nil, errors.New("linking failed")
+		}
+		for _, obj := range members {
+			logrus.Infof("Member %s pulled from library", obj.File)
+		}
+		objs = append(append([]*Object(nil), objs...), members...)
+	}
+
+	for _, obj := range objs {
+		for _, c := range obj.Constants {
+			if prev, ok := lk.symbols[c.Name]; ok {
+				if prev.value != c.Value {
+					lk.errorf(obj.File, c.N, "Constant `%s` = %d conflicts with the definition on %s:%d (= %d)",
+						c.Name, c.Value, prev.file, prev.N, prev.value)
+				}
+				continue
+			}
+			idx, ok := lk.values[c.Value]
+			if !ok {
+				idx = len(l.constants)
+				l.constants = append(l.constants, c.Value)
+				lk.values[c.Value] = idx
+			}
+			lk.symbols[c.Name] = &linkSymbol{index: idx, value: c.Value, file: obj.File, N: c.N}
+		}
+	}
+
+	var main *linkedMethod
+	for _, obj := range objs {
+		for _, m := range obj.Methods {
+			lm := &linkedMethod{ObjectMethod: m, file: obj.File}
+			if !m.Main {
+				l.methods = append(l.methods, lm)
+				continue
+			}
+			if main != nil {
+				lk.errorf(obj.File, m.N, "Duplicate main, also defined on %s:%d", main.file, main.N)
+				continue
+			}
+			main = lm
+		}
+	}
+	if main == nil {
+		logrus.Errorf("linker: No main found")
+		return nil, errors.New("linking failed")
+	}
+	l.methods = append([]*linkedMethod{main}, l.methods...)
+
+	var bytes uint32
+	for _, m := range l.methods {
+		m.B = bytes
+		bytes += m.Size()
+		if m.Main {
+			continue
+		}
+		if prev, ok := lk.symbols[m.Name]; ok {
+			if prev.method {
+				lk.errorf(m.file, m.N, "Duplicate method `%s`, also defined on %s:%d", m.Name, prev.file, prev.N)
+			} else {
+				lk.errorf(m.file, m.N, "Method constant name conflict. `%s` already defined on %s:%d", m.Name, prev.file, prev.N)
+			}
+			continue
+		}
+		lk.symbols[m.Name] = &linkSymbol{index: len(l.constants), value: int32(m.B), method: true, file: m.file, N: m.N}
+		l.constants = append(l.constants, int32(m.B))
+		logrus.Infof("Method %s from %s placed at %d", m.Name, m.file, m.B)
+	}
+
+	l.text = make([]byte, bytes)
+	for _, m := range l.methods {
+		if !m.Main {
+			binary.BigEndian.PutUint16(l.text[m.B:], uint16(m.NumParams))
+			binary.BigEndian.PutUint16(l.text[m.B+2:], uint16(len(m.Vars)-m.NumParams))
+		}
+		copy(l.text[m.B+m.header():], m.Code)
+
+		for _, r := range m.Relocs {
+			sym, ok := lk.symbols[r.Symbol]
+			switch {
+			case !ok && r.Method:
+				lk.errorf(m.file, r.N, "Undefined method `%s`", r.Symbol)
+			case !ok:
+				lk.errorf(m.file, r.N, "Undefined constant `%s`", r.Symbol)
+			case sym.index > 0xFFFF:
+				lk.errorf(m.file, r.N, "Constant pool index of `%s` out of range (%d > %d)", r.Symbol, sym.index, 0xFFFF)
+			default:
+				binary.BigEndian.PutUint16(l.text[m.B+r.Offset:], uint16(sym.index))
+			}
+		}
+	}
+
+	if lk.failed {
+		return nil, errors.New("linking failed")
+	}
+	return l, nil
+}
+
+// Generate writes the IJVM binary of the linked program.
+// Returns error if any write fails.
+func (l *Linked) Generate(out io.Writer) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			switch x := r.(type) {
+			case string:
+				err = errors.New(x)
+			case error:
+				err = x
+			default:
+				err = errors.New("Unknown generation failure")
+			}
+		}
+	}()
+
+	writeHeader(out, l.constants, uint32(len(l.text)))
+	mustWrite(out, l.text)
+	return
+}
+
+// GenerateDebugSymbols generates the symbol blocks of the linked program,
+// like Assembler#GenerateDebugSymbols.
+// Returns error if any write fails
+func (l *Linked) GenerateDebugSymbols(out io.Writer) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			switch x := r.(type) {
+			case string:
+				err = errors.New(x)
+			case error:
+				err = x
+			default:
+				err = errors.New("Unknown generation failure")
+			}
+		}
+	}()
+
+	var methods, labels []symbolEntry
+	for _, m := range l.methods {
+		methods = append(methods, symbolEntry{m.B, m.Name})
+		for _, lbl := range m.Labels {
+			labels = append(labels, symbolEntry{m.B + lbl.B, fmt.Sprintf("%s#%s", m.Name, lbl.Name)})
+		}
+	}
+	writeSymbols(out, methods, labels)
+	return
+}
@@ -0,0 +1,255 @@
+package ijvmasm_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/BlackNovaTech/gojasm/ijvmasm"
+	"github.com/BlackNovaTech/gojasm/ijvmemu"
+	"github.com/BlackNovaTech/gojasm/internal/testprog"
+	"github.com/BlackNovaTech/gojasm/opconf"
+)
+
+// Assembles the source into a relocatable object
+func object(t *testing.T, name, src string) *ijvmasm.Object {
+	path := filepath.Join(t.TempDir(), name)
+	if err := ioutil.WriteFile(path, []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+	asm := ijvmasm.NewAssembler(path, opconf.NewDefaultOpConfig())
+	asm.Relocatable = true
+	if ok, err := asm.Parse(); !ok || err != nil {
+		t.Fatalf("%s: assembly failed: %v", name, err)
+	}
+	obj, err := asm.Object()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Keep the messages of the linker independent of the temporary directory
+	obj.File = name
+	return obj
+}
+
+// Links the objects and libraries, and loads the resulting binary. Returns
+// the messages logged by the linker if linking fails.
+func link(t *testing.T, objs []*ijvmasm.Object, libs []*ijvmasm.Archive) (*ijvmemu.Program, []string) {
+	var linked *ijvmasm.Linked
+	var err error
+	logged := testprog.Logged(func() {
+		linked, err = ijvmasm.Link(objs, libs)
+	})
+	if err != nil {
+		return nil, logged
+	}
+
+	var bin bytes.Buffer
+	if err := linked.Generate(&bin); err != nil {
+		t.Fatal(err)
+	}
+	prog, err := ijvmemu.LoadBinary(&bin, opconf.NewDefaultOpConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return prog, nil
+}
+
+const linkMain = `
+.constant
+shared 60
+a 1000
+.end-constant
+
+.main
+LDC_W shared
+LDC_W a
+IADD
+LDC_W a
+ISUB
+OUT
+BIPUSH 0
+BIPUSH 33
+INVOKEVIRTUAL twice
+OUT
+HALT
+.end-main
+`
+
+const linkLib = `
+.constant
+shared 60
+b 1000
+.end-constant
+
+.method twice(x)
+.var
+y
+.end-var
+ILOAD x
+ILOAD x
+IADD
+LDC_W b
+IADD
+LDC_W b
+ISUB
+ISTORE y
+loop:
+ILOAD y
+IRETURN
+.end-method
+`
+
+func TestObjectRoundTrip(t *testing.T) {
+	for _, src := range []string{linkMain, linkLib} {
+		obj := object(t, "obj.jas", src)
+		var buf bytes.Buffer
+		if err := ijvmasm.WriteObject(obj, &buf); err != nil {
+			t.Fatal(err)
+		}
+		data := buf.Bytes()
+
+		read, err := ijvmasm.ReadObject(bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(read, obj) {
+			t.Errorf("read object %+v, want %+v", read, obj)
+		}
+
+		for _, c := range []struct {
+			data []byte
+			err  string
+		}{
+			{append([]byte{0, 0, 0, 0}, data[4:]...), "object: invalid magic header 0x00000000"},
+			{append(append([]byte{}, data[:4]...), append([]byte{0, 0, 0, 9}, data[8:]...)...),
+				"object: unsupported version 9, expected 1"},
+			{data[:len(data)-3], "object: truncated"},
+		} {
+			if _, err := ijvmasm.ReadObject(bytes.NewReader(c.data)); err == nil || err.Error() != c.err {
+				t.Errorf("expected error %q, got %v", c.err, err)
+			}
+		}
+	}
+
+	// Relocations must patch the code of their method
+	obj := object(t, "main.jas", linkMain)
+	obj.Methods[0].Relocs[0].Offset = uint32(len(obj.Methods[0].Code)) - 1
+	var buf bytes.Buffer
+	if err := ijvmasm.WriteObject(obj, &buf); err != nil {
+		t.Fatal(err)
+	}
+	want := "object: relocation of `shared` in method main out of bounds"
+	if _, err := ijvmasm.ReadObject(&buf); err == nil || err.Error() != want {
+		t.Errorf("expected error %q, got %v", want, err)
+	}
+}
+
+func TestObjectRelocs(t *testing.T) {
+	obj := object(t, "main.jas", linkMain)
+	main := obj.Methods[0]
+	var relocs []string
+	for _, r := range main.Relocs {
+		// Constants of the object are referenced by their index in the object
+		idx := 0
+		for i, c := range obj.Constants {
+			if c.Name == r.Symbol {
+				idx = i
+			}
+		}
+		if got := int(main.Code[r.Offset])<<8 | int(main.Code[r.Offset+1]); got != idx {
+			t.Errorf("reference to %s holds %d, want %d", r.Symbol, got, idx)
+		}
+		relocs = append(relocs, r.Symbol)
+		if r.Method != (r.Symbol == "twice") {
+			t.Errorf("reference to %s is a method reference: %v", r.Symbol, r.Method)
+		}
+	}
+	if want := []string{"shared", "a", "a", "twice"}; !reflect.DeepEqual(relocs, want) {
+		t.Errorf("relocations of %v, want %v", relocs, want)
+	}
+
+	// Offsets include the header of methods
+	twice := object(t, "lib.jas", linkLib).Methods[0]
+	if twice.Size() != uint32(len(twice.Code))+4 || twice.Relocs[0].Offset != 10 {
+		t.Errorf("method of %d bytes with its first relocation at %d", twice.Size(), twice.Relocs[0].Offset)
+	}
+	if twice.Labels[0].Name != "loop" || twice.Labels[0].B != 19 {
+		t.Errorf("label %s at %d, want loop at 19", twice.Labels[0].Name, twice.Labels[0].B)
+	}
+}
+
+// Equal constants share an entry, and every reference is patched with the
+// index of its entry
+func TestLink(t *testing.T) {
+	main, lib := object(t, "main.jas", linkMain), object(t, "lib.jas", linkLib)
+	prog, logged := link(t, []*ijvmasm.Object{main, lib}, nil)
+	if prog == nil {
+		t.Fatalf("linking failed: %q", logged)
+	}
+
+	// Main is placed first, followed by twice
+	addr := int32(main.Methods[0].Size())
+	if want := []int32{60, 1000, addr}; !reflect.DeepEqual(prog.Constants, want) {
+		t.Errorf("constants %v, want %v", prog.Constants, want)
+	}
+	out, _, err := testprog.Run(prog, "")
+	if err != nil || out != "<B" {
+		t.Errorf("linked program printed %q and failed with %v, want %q", out, err, "<B")
+	}
+
+	// Main is placed first whatever the order of the objects
+	if prog, _ = link(t, []*ijvmasm.Object{lib, main}, nil); prog == nil {
+		t.Fatal("linking lib first failed")
+	}
+	if want := []int32{60, 1000, addr}; !reflect.DeepEqual(prog.Constants, want) {
+		t.Errorf("linking lib first gives constants %v, want %v", prog.Constants, want)
+	}
+}
+
+func TestLinkErrors(t *testing.T) {
+	main := object(t, "main.jas", linkMain)
+	lib := object(t, "lib.jas", linkLib)
+	other := object(t, "other.jas", `
+.constant
+a 1001
+.end-constant
+.method twice(x)
+ILOAD x
+LDC_W c
+IRETURN
+.end-method
+.method twice2()
+BIPUSH 0
+INVOKEVIRTUAL missing
+IRETURN
+.end-method
+`)
+	main2 := object(t, "main2.jas", ".main\nHALT\n.end-main\n")
+
+	for _, c := range []struct {
+		objs []*ijvmasm.Object
+		errs []string
+	}{
+		{[]*ijvmasm.Object{main}, []string{
+			"main.jas:16 > linker: Undefined method `twice`",
+		}},
+		{[]*ijvmasm.Object{main, lib, other}, []string{
+			"other.jas:3 > linker: Constant `a` = 1001 conflicts with the definition on main.jas:4 (= 1000)",
+			"other.jas:5 > linker: Duplicate method `twice`, also defined on lib.jas:7",
+			"other.jas:7 > linker: Undefined constant `c`",
+			"other.jas:12 > linker: Undefined method `missing`",
+		}},
+		{[]*ijvmasm.Object{main, lib, main2}, []string{
+			"main2.jas:1 > linker: Duplicate main, also defined on main.jas:7",
+		}},
+		{[]*ijvmasm.Object{lib}, []string{
+			"linker: No main found",
+		}},
+	} {
+		if prog, logged := link(t, c.objs, nil); prog != nil || !reflect.DeepEqual(logged, c.errs) {
+			t.Errorf("linking logged %q, want %q", logged, c.errs)
+		}
+	}
+}
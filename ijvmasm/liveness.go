@@ -0,0 +1,240 @@
+package ijvmasm
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/BlackNovaTech/gojasm/opconf"
+	"github.com/sirupsen/logrus"
+)
+
+// Set of local variables, by index
+type varSet []uint64
+
+func newVarSet(n int) varSet {
+	return make(varSet, (n+63)/64)
+}
+
+func (s varSet) has(v int) bool {
+	return s[v/64]&(1<<uint(v%64)) != 0
+}
+
+func (s varSet) add(v int) {
+	s[v/64] |= 1 << uint(v%64)
+}
+
+// Adds every variable of o except the given one, reporting whether s changed
+func (s varSet) union(o varSet, except int) bool {
+	changed := false
+	for i := range s {
+		w := o[i]
+		if except/64 == i {
+			w &^= 1 << uint(except%64)
+		}
+		if s[i]|w != s[i] {
+			s[i] |= w
+			changed = true
+		}
+	}
+	return changed
+}
+
+// Calls f for every variable in the set
+func (s varSet) each(f func(v int)) {
+	for i, w := range s {
+		for b := 0; w != 0; b++ {
+			if w&1 != 0 {
+				f(i*64 + b)
+			}
+			w >>= 1
+		}
+	}
+}
+
+// Returns the variable the instruction refers to, if any, and whether it
+// reads and stores the variable
+func varAccess(inst *Instruction) (v int, read, store bool) {
+	for i, arg := range inst.op.Args {
+		if arg != opconf.ArgVar {
+			continue
+		}
+		switch inst.op.Name {
+		case "ILOAD":
+			return inst.params[i], true, false
+		case "ISTORE":
+			return inst.params[i], false, true
+		default:
+			return inst.params[i], true, true
+		}
+	}
+	return -1, false, false
+}
+
+// Computes the variables live before every instruction of the method
+func (m *Method) liveness() []varSet {
+	n := len(m.instructions)
+	targets := m.labelTargets()
+	succ := make([][]int, n)
+	for i := range m.instructions {
+		succ[i] = m.successors(targets, i)
+	}
+
+	live := make([]varSet, n)
+	for i := range live {
+		live[i] = newVarSet(len(m.vars))
+	}
+	for changed := true; changed; {
+		changed = false
+		for i := n - 1; i >= 0; i-- {
+			v, read, store := varAccess(m.instructions[i])
+			except := -1
+			if store {
+				except = v
+			}
+			for _, s := range succ[i] {
+				if live[i].union(live[s], except) {
+					changed = true
+				}
+			}
+			if read && !live[i].has(v) {
+				live[i].add(v)
+				changed = true
+			}
+		}
+	}
+	return live
+}
+
+// Assigns the local variables of the method, except its parameters, to shared
+// slots, such that variables sharing a slot are never live at the same time.
+// Variables referred to most get the lowest slots, and variables that are
+// never referred to are dropped. A slot shared by several variables is named
+// after all of them, separated by slashes.
+func (asm *Assembler) allocateLocals(m *Method) {
+	if m.code != nil || len(m.vars) <= m.numparam {
+		return
+	}
+	n := len(m.vars)
+
+	uses := make([]int, n)
+	for _, inst := range m.instructions {
+		if v, _, _ := varAccess(inst); v >= 0 {
+			uses[v]++
+		}
+	}
+
+	// Variables interfere if one is stored while the other is live. Locals
+	// live at the start of the method rely on being zero, and interfere with
+	// each other.
+	live := m.liveness()
+	interferes := make([]varSet, n)
+	for v := range interferes {
+		interferes[v] = newVarSet(n)
+	}
+	interfere := func(a, b int) {
+		if a != b && a >= m.numparam && b >= m.numparam {
+			interferes[a].add(b)
+			interferes[b].add(a)
+		}
+	}
+	targets := m.labelTargets()
+	for i, inst := range m.instructions {
+		v, _, store := varAccess(inst)
+		if !store {
+			continue
+		}
+		for _, s := range m.successors(targets, i) {
+			live[s].each(func(w int) { interfere(v, w) })
+		}
+	}
+	if len(live) > 0 {
+		live[0].each(func(a int) {
+			live[0].each(func(b int) { interfere(a, b) })
+		})
+	}
+
+	// Greedily assign the most used variables first, to the lowest slot free of interference
+	var locals []int
+	for v := m.numparam; v < n; v++ {
+		if uses[v] > 0 {
+			locals = append(locals, v)
+		}
+	}
+	sort.SliceStable(locals, func(i, j int) bool { return uses[locals[i]] > uses[locals[j]] })
+
+	slotOf := make([]int, n)
+	for v := 0; v < m.numparam; v++ {
+		slotOf[v] = v
+	}
+	var slots [][]int
+	for _, v := range locals {
+		slot := 0
+		for ; slot < len(slots); slot++ {
+			free := true
+			for _, w := range slots[slot] {
+				free = free && !interferes[v].has(w)
+			}
+			if free {
+				break
+			}
+		}
+		if slot == len(slots) {
+			slots = append(slots, nil)
+		}
+		slots[slot] = append(slots[slot], v)
+		slotOf[v] = m.numparam + slot
+	}
+
+	wide := asm.opconf.GetOp(OperationWide)
+	if m.numparam+len(slots) > 0x100 && wide == nil {
+		return
+	}
+
+	vars := append([]string(nil), m.vars[:m.numparam]...)
+	for _, slot := range slots {
+		var names []string
+		for _, v := range slot {
+			names = append(names, m.vars[v])
+		}
+		vars = append(vars, strings.Join(names, "/"))
+	}
+	logrus.Infof("[.%s] Allocated %d local variables to %d slots", m.name, n-m.numparam, len(slots))
+
+	// Renumber the variables, dropping WIDE prefixes no longer required, or
+	// adding those now required
+	out := &rewrite{
+		method:  m,
+		targets: make(map[*Label]int),
+		wide:    wide,
+	}
+	byIndex := make(map[int][]*Label)
+	for _, l := range m.labels {
+		byIndex[targets[l]] = append(byIndex[targets[l]], l)
+	}
+	for i := 0; i <= len(m.instructions); i++ {
+		for _, l := range byIndex[i] {
+			out.place(l)
+		}
+		if i == len(m.instructions) {
+			break
+		}
+
+		inst := m.instructions[i]
+		if inst.op.Name == OperationWide && i+1 < len(m.instructions) {
+			if v, _, _ := varAccess(m.instructions[i+1]); v >= 0 && slotOf[v] <= 0xFF {
+				m.instructions[i+1].wide = false
+				continue
+			}
+		}
+		for j, arg := range inst.op.Args {
+			if arg == opconf.ArgVar {
+				inst.params[j] = slotOf[inst.params[j]]
+			}
+		}
+		out.add(inst)
+	}
+	m.vars = vars
+	m.instructions = out.instructions
+	m.labels = out.labels
+	m.relayout(out.targets)
+}
@@ -0,0 +1,151 @@
+package ijvmasm_test
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/BlackNovaTech/gojasm/ijvmasm"
+	"github.com/BlackNovaTech/gojasm/ijvmemu"
+	"github.com/BlackNovaTech/gojasm/internal/testprog"
+)
+
+// Assembles the source, optionally allocating the local variables
+func assembleLocals(t *testing.T, src string, allocate bool) *ijvmemu.Program {
+	return testprog.Assemble(t, nil, "locals.jas", src, func(asm *ijvmasm.Assembler) {
+		asm.AllocateLocals = allocate
+	})
+}
+
+// Variables never live at the same time share a slot, while the parameters
+// of methods keep theirs
+const localsSource = `
+.main
+.var
+i
+j
+total
+unused
+.end-var
+BIPUSH 3
+ISTORE i
+first:
+IINC total 1
+IINC i -1
+ILOAD i
+IFEQ second
+GOTO first
+second:
+BIPUSH 4
+ISTORE j
+again:
+IINC total 1
+IINC j -1
+ILOAD j
+IFEQ done
+GOTO again
+done:
+BIPUSH 0
+ILOAD total
+BIPUSH '0'
+INVOKEVIRTUAL add
+OUT
+HALT
+.end-main
+
+.method add(a, b)
+.var
+x
+y
+.end-var
+ILOAD a
+ISTORE x
+ILOAD x
+ILOAD b
+IADD
+ISTORE y
+ILOAD y
+ILOAD y
+IADD
+ILOAD y
+ISUB
+IRETURN
+.end-method
+`
+
+func TestAllocateLocals(t *testing.T) {
+	plain := assembleLocals(t, localsSource, false)
+	prog := assembleLocals(t, localsSource, true)
+
+	for _, c := range []struct {
+		method string
+		vars   []string
+	}{
+		{"main", []string{"i/j", "total"}},
+		// y is referred to most, so it is named first
+		{"add", []string{"LINK PTR", "a", "b", "y/x"}},
+	} {
+		vars := prog.MethodByName(c.method).Vars
+		if !reflect.DeepEqual(vars, c.vars) {
+			t.Errorf("%s has locals %q, want %q", c.method, vars, c.vars)
+		}
+	}
+
+	want, _, err := testprog.Run(plain, "")
+	if err != nil || want != "7" {
+		t.Fatalf("printed %q and failed with %v, want %q", want, err, "7")
+	}
+	if out, _, err := testprog.Run(prog, ""); err != nil || out != want {
+		t.Errorf("printed %q and failed with %v after allocating, want %q", out, err, want)
+	}
+}
+
+// WIDE prefixes are dropped from variables moving below 256, and added to
+// those moving beyond it
+func TestAllocateLocalsWide(t *testing.T) {
+	// Every variable is live at the start, and v0 is read the least, so it
+	// moves to the last slot while the others move down by one
+	const n = 300
+	src := new(strings.Builder)
+	src.WriteString(".main\n.var\n")
+	for v := 0; v < n; v++ {
+		fmt.Fprintf(src, "v%d\n", v)
+	}
+	src.WriteString(".end-var\n")
+	load := func(v int) {
+		if v > 0xFF {
+			src.WriteString("WIDE\n")
+		}
+		fmt.Fprintf(src, "ILOAD v%d\nPOP\n", v)
+	}
+	for v := 0; v < n; v++ {
+		load(v)
+		if v > 0 {
+			load(v)
+		}
+	}
+	src.WriteString("HALT\n.end-main\n")
+
+	prog := assembleLocals(t, src.String(), true)
+	main := prog.MethodByName("main")
+	if main.Vars[0] != "v1" || main.Vars[n-1] != "v0" {
+		t.Errorf("slots 0 and %d hold %s and %s, want v1 and v0", n-1, main.Vars[0], main.Vars[n-1])
+	}
+
+	code, _ := prog.Code(main)
+	slots := make(map[int32]bool)
+	for _, inst := range code {
+		if inst.Op.Name != "ILOAD" {
+			continue
+		}
+		slot := inst.Operands[0]
+		slots[slot] = true
+		if inst.Wide != (slot > 0xFF) {
+			t.Errorf("pc %d: ILOAD of slot %d is wide: %v", inst.PC, slot, inst.Wide)
+		}
+	}
+	if len(slots) != n {
+		t.Errorf("%d slots loaded, want %d", len(slots), n)
+	}
+}
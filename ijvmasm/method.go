@@ -1,6 +1,7 @@
 package ijvmasm
 
 import (
+	"encoding/binary"
 	"errors"
 	"github.com/sirupsen/logrus"
 	"io"
@@ -24,6 +25,11 @@ type Method struct {
 	B uint32
 
 	wide bool
+
+	// Assembled code and its references, for methods of library archive members
+	code   []byte
+	relocs []*Reloc
+	file   string
 }
 
 // Label represents a single label in JAS.
@@ -140,6 +146,21 @@ func (m *Method) LinkLabels() (ok bool) {
 // constant index.
 func (m *Method) LinkMethods(asm *Assembler) (ok bool) {
 	ok = true
+	if m.code != nil {
+		code := append([]byte(nil), m.code...)
+		for _, r := range m.relocs {
+			found, idx, _ := asm.findConstant(r.Symbol)
+			if !found {
+				logrus.Errorf("[.%s] Undefined method or constant `%s` at %s:%d", m.name, r.Symbol, m.file, r.N)
+				asm.failed = true
+				ok = false
+				continue
+			}
+			binary.BigEndian.PutUint16(code[r.Offset-4:], uint16(idx))
+		}
+		m.code = code
+		return
+	}
 	for _, inst := range m.instructions {
 		if !inst.linkMethod {
 			continue
@@ -149,7 +170,9 @@ func (m *Method) LinkMethods(asm *Assembler) (ok bool) {
 				found, idx, mtd := asm.findConstant(inst.label)
 				if !found {
 					logrus.Errorf("[.%s] Undefined method `%s` at line %d", m.name, inst.label, inst.N)
+					asm.failed = true
 					ok = false
+					continue
 				}
 				inst.params[j] = idx
 				logrus.Debugf("[.%s] Linking method, line %d: %s -> %d",
@@ -160,8 +183,60 @@ func (m *Method) LinkMethods(asm *Assembler) (ok bool) {
 	return
 }
 
+// Returns a method of a library archive member, generated from its assembled code
+func libraryMethod(om *ObjectMethod, file string) *Method {
+	m := &Method{
+		name:     om.Name,
+		vars:     append([]string(nil), om.Vars...),
+		numparam: om.NumParams,
+		labels:   make([]*Label, len(om.Labels)),
+		end:      JASMethodEnd,
+		N:        om.N,
+		bytes:    om.Size(),
+		B:        om.header(),
+		code:     om.Code,
+		relocs:   om.Relocs,
+		file:     file,
+	}
+	for i, l := range om.Labels {
+		m.labels[i] = &Label{Name: l.Name, N: l.N, B: l.B}
+	}
+	return m
+}
+
+// Returns the TAILCALL operation of the configuration, or nil with a warning
+// if tail calls cannot be rewritten
+func (asm *Assembler) tailCallOperation() *opconf.Operation {
+	tailcall := asm.opconf.GetOp(OperationTailCall)
+	if tailcall == nil {
+		logrus.Warnf("Operation %s is not available, not rewriting tail calls", OperationTailCall)
+		return nil
+	}
+	if len(tailcall.Args) != 1 || tailcall.Args[0] != opconf.ArgMethod {
+		logrus.Warnf("Operation %s does not take a single method, not rewriting tail calls", OperationTailCall)
+		return nil
+	}
+	return tailcall
+}
+
+// Rewrites every INVOKEVIRTUAL directly followed by IRETURN into TAILCALL.
+// The IRETURN is kept, as it may still be the target of a label.
+func (asm *Assembler) rewriteTailCalls(m *Method) {
+	for i := 0; i+1 < len(m.instructions); i++ {
+		inst := m.instructions[i]
+		if inst.op.Name == OperationInvoke && m.instructions[i+1].op.Name == OperationReturn {
+			inst.op = asm.tailcall
+			logrus.Infof("[.%s] Rewrote tail call to %s at line %d", m.name, inst.label, inst.N)
+		}
+	}
+}
+
 // Generate the Method's corresponding IJVM binary code
 func (m *Method) Generate(out io.Writer) {
+	if m.code != nil {
+		mustWrite(out, m.code)
+		return
+	}
 	for _, inst := range m.instructions {
 		mustWrite(out, inst.op.Opcode)
 		for i, arg := range inst.op.Args {
@@ -0,0 +1,316 @@
+package ijvmasm
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/BlackNovaTech/gojasm/opconf"
+)
+
+const (
+	// ObjectMagic is the magic header of relocatable object files
+	ObjectMagic = uint32(0x1DEAD0B1)
+	// ObjectVersion is the version of the object format written by WriteObject
+	ObjectVersion = uint32(1)
+)
+
+// Object is a relocatable object: the methods and constants assembled from a
+// single source file, with every reference to a method or constant left to
+// be resolved by Link.
+type Object struct {
+	// File is the path of the source file the object was assembled from
+	File      string
+	Constants []*Constant
+	Methods   []*ObjectMethod
+}
+
+// ObjectMethod is an assembled method of a relocatable object.
+type ObjectMethod struct {
+	Name string
+	// Main is set for the main method, which has no header
+	Main bool
+	// N is the line the method was declared on
+	N uint32
+	// NumParams is the amount of parameters, including the object reference
+	NumParams int
+	// Vars holds the name of every local variable slot, parameters first
+	Vars []string
+	// Code holds the instructions of the method, without header. References
+	// to constants of the object hold their index in Constants, references to
+	// methods and other constants are zero until linked.
+	Code []byte
+	// Relocs lists the references to methods and constants in the code
+	Relocs []*Reloc
+	// Labels and Lines hold byte offsets relative to the start of the method, including its header
+	Labels []*Label
+	Lines  []*LineInfo
+}
+
+// Reloc is a reference to a method or constant, patched by the linker.
+type Reloc struct {
+	// Offset is the offset of the 16-bit constant index, relative to the start of the method including its header
+	Offset uint32
+	Symbol string
+	// Method is set for method references, e.g. of INVOKEVIRTUAL
+	Method bool
+	// N is the source line of the reference
+	N uint32
+}
+
+// Size returns the amount of bytes the method occupies, including its header.
+func (m *ObjectMethod) Size() uint32 {
+	return m.header() + uint32(len(m.Code))
+}
+
+// Returns the size of the method header
+func (m *ObjectMethod) header() uint32 {
+	if m.Main {
+		return 0
+	}
+	return 4
+}
+
+// Object returns the relocatable object of the assembled program, which must
+// have been assembled using a relocatable assembler.
+func (asm *Assembler) Object() (*Object, error) {
+	if asm.failed {
+		return nil, errors.New("object: assembly failed")
+	}
+	if !asm.Relocatable {
+		return nil, errors.New("object: assembler is not relocatable")
+	}
+
+	obj := &Object{File: asm.filePath}
+	for _, c := range asm.constants {
+		obj.Constants = append(obj.Constants, &Constant{Name: c.Name, Value: c.Value, N: c.N})
+	}
+	for _, m := range asm.methods {
+		om := &ObjectMethod{
+			Name:      m.name,
+			Main:      m.end == JASMainEnd,
+			N:         m.N,
+			NumParams: m.numparam,
+			Vars:      append([]string(nil), m.vars...),
+		}
+		code := new(bytes.Buffer)
+		m.Generate(code)
+		om.Code = code.Bytes()
+
+		for _, inst := range m.instructions {
+			om.Lines = append(om.Lines, &LineInfo{B: inst.B, N: inst.N})
+			offset := inst.B + 1
+			for i, arg := range inst.op.Args {
+				switch arg {
+				case opconf.ArgConst, opconf.ArgMethod:
+					reloc := &Reloc{Offset: offset, Symbol: inst.label, Method: arg == opconf.ArgMethod, N: inst.N}
+					if arg == opconf.ArgConst && !inst.linkConst {
+						reloc.Symbol = asm.constants[inst.params[i]].Name
+					}
+					om.Relocs = append(om.Relocs, reloc)
+					offset += 2
+				case opconf.ArgLabel:
+					offset += 2
+				case opconf.ArgVar:
+					offset++
+					if inst.wide {
+						offset++
+					}
+				default:
+					offset++
+				}
+			}
+		}
+		for _, l := range m.labels {
+			om.Labels = append(om.Labels, &Label{Name: l.Name, N: l.N, B: l.B})
+		}
+		obj.Methods = append(obj.Methods, om)
+	}
+	return obj, nil
+}
+
+// WriteObject writes the object to w.
+func WriteObject(obj *Object, w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	ow := &objectWriter{w: bw}
+
+	ow.u32(ObjectMagic)
+	ow.u32(ObjectVersion)
+	ow.str(obj.File)
+	ow.u32(uint32(len(obj.Constants)))
+	for _, c := range obj.Constants {
+		ow.str(c.Name)
+		ow.u32(uint32(c.Value))
+		ow.u32(c.N)
+	}
+
+	ow.u32(uint32(len(obj.Methods)))
+	for _, m := range obj.Methods {
+		ow.str(m.Name)
+		ow.bool(m.Main)
+		ow.u32(m.N)
+		ow.u32(uint32(m.NumParams))
+		ow.u32(uint32(len(m.Vars)))
+		for _, v := range m.Vars {
+			ow.str(v)
+		}
+		ow.u32(uint32(len(m.Code)))
+		ow.raw(m.Code)
+		ow.u32(uint32(len(m.Relocs)))
+		for _, r := range m.Relocs {
+			ow.u32(r.Offset)
+			ow.str(r.Symbol)
+			ow.bool(r.Method)
+			ow.u32(r.N)
+		}
+		ow.u32(uint32(len(m.Labels)))
+		for _, l := range m.Labels {
+			ow.str(l.Name)
+			ow.u32(l.N)
+			ow.u32(l.B)
+		}
+		ow.u32(uint32(len(m.Lines)))
+		for _, l := range m.Lines {
+			ow.u32(l.B)
+			ow.u32(l.N)
+		}
+	}
+
+	if ow.err != nil {
+		return ow.err
+	}
+	return bw.Flush()
+}
+
+// ReadObject reads an object written by WriteObject from r.
+func ReadObject(r io.Reader) (*Object, error) {
+	or := &objectReader{r: bufio.NewReader(r)}
+
+	if magic := or.u32(); or.err == nil && magic != ObjectMagic {
+		return nil, fmt.Errorf("object: invalid magic header 0x%08X", magic)
+	}
+	if version := or.u32(); or.err == nil && version != ObjectVersion {
+		return nil, fmt.Errorf("object: unsupported version %d, expected %d", version, ObjectVersion)
+	}
+
+	obj := &Object{File: or.str()}
+	for n := or.u32(); n > 0 && or.err == nil; n-- {
+		obj.Constants = append(obj.Constants, &Constant{Name: or.str(), Value: int32(or.u32()), N: or.u32()})
+	}
+
+	for n := or.u32(); n > 0 && or.err == nil; n-- {
+		m := &ObjectMethod{
+			Name:      or.str(),
+			Main:      or.bool(),
+			N:         or.u32(),
+			NumParams: int(or.u32()),
+		}
+		for i := or.u32(); i > 0 && or.err == nil; i-- {
+			m.Vars = append(m.Vars, or.str())
+		}
+		m.Code = or.bytes()
+		for i := or.u32(); i > 0 && or.err == nil; i-- {
+			m.Relocs = append(m.Relocs, &Reloc{Offset: or.u32(), Symbol: or.str(), Method: or.bool(), N: or.u32()})
+		}
+		for i := or.u32(); i > 0 && or.err == nil; i-- {
+			m.Labels = append(m.Labels, &Label{Name: or.str(), N: or.u32(), B: or.u32()})
+		}
+		for i := or.u32(); i > 0 && or.err == nil; i-- {
+			m.Lines = append(m.Lines, &LineInfo{B: or.u32(), N: or.u32()})
+		}
+		obj.Methods = append(obj.Methods, m)
+	}
+
+	if or.err != nil {
+		return nil, or.err
+	}
+	for _, m := range obj.Methods {
+		for _, r := range m.Relocs {
+			if r.Offset < m.header() || r.Offset+2 > m.Size() {
+				return nil, fmt.Errorf("object: relocation of `%s` in method %s out of bounds", r.Symbol, m.Name)
+			}
+		}
+	}
+	return obj, nil
+}
+
+// Writes the big-endian encoding of objects, remembering the first error
+type objectWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (w *objectWriter) raw(data []byte) {
+	if w.err == nil {
+		_, w.err = w.w.Write(data)
+	}
+}
+
+func (w *objectWriter) u32(x uint32) {
+	var buf [4]byte
+	binary.BigEndian.PutUint32(buf[:], x)
+	w.raw(buf[:])
+}
+
+func (w *objectWriter) bool(b bool) {
+	if b {
+		w.raw([]byte{1})
+	} else {
+		w.raw([]byte{0})
+	}
+}
+
+func (w *objectWriter) str(s string) {
+	w.u32(uint32(len(s)))
+	w.raw([]byte(s))
+}
+
+// Reads the big-endian encoding of objects, remembering the first error
+type objectReader struct {
+	r   io.Reader
+	err error
+}
+
+// Longest string or code accepted, guarding against allocating for corrupt lengths
+const maxObjectBytes = 1 << 24
+
+func (r *objectReader) raw(data []byte) {
+	if r.err != nil {
+		return
+	}
+	if _, err := io.ReadFull(r.r, data); err != nil {
+		r.err = errors.New("object: truncated")
+	}
+}
+
+func (r *objectReader) u32() uint32 {
+	var buf [4]byte
+	r.raw(buf[:])
+	return binary.BigEndian.Uint32(buf[:])
+}
+
+func (r *objectReader) bool() bool {
+	var buf [1]byte
+	r.raw(buf[:])
+	return buf[0] != 0
+}
+
+func (r *objectReader) bytes() []byte {
+	n := r.u32()
+	if n > maxObjectBytes {
+		if r.err == nil {
+			r.err = errors.New("object: corrupt length")
+		}
+		return nil
+	}
+	data := make([]byte, n)
+	r.raw(data)
+	return data
+}
+
+func (r *objectReader) str() string {
+	return string(r.bytes())
+}
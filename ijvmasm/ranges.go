@@ -0,0 +1,569 @@
+package ijvmasm
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Range of values of an operand stack word or local variable. Ranges are
+// kept in 64 bits, so results leaving the 32-bit range can be detected.
+type interval struct {
+	lo, hi int64
+}
+
+var fullRange = interval{math.MinInt32, math.MaxInt32}
+
+func point(v int64) interval {
+	return interval{v, v}
+}
+
+func (a interval) empty() bool {
+	return a.lo > a.hi
+}
+
+func (a interval) full() bool {
+	return a == fullRange
+}
+
+func (a interval) join(b interval) interval {
+	if a.empty() {
+		return b
+	}
+	if b.empty() {
+		return a
+	}
+	return interval{min64(a.lo, b.lo), max64(a.hi, b.hi)}
+}
+
+func (a interval) meet(b interval) interval {
+	return interval{max64(a.lo, b.lo), min64(a.hi, b.hi)}
+}
+
+// Returns the range without the given value, if it is one of its bounds
+func (a interval) exclude(v int64) interval {
+	switch {
+	case a.lo == v:
+		a.lo++
+	case a.hi == v:
+		a.hi--
+	}
+	return a
+}
+
+// Returns the range of a 32-bit result, which wraps around if it may leave the 32-bit range
+func (a interval) wrap() interval {
+	if a.lo < math.MinInt32 || a.hi > math.MaxInt32 {
+		return fullRange
+	}
+	return a
+}
+
+func (a interval) String() string {
+	if a.lo == a.hi {
+		return fmt.Sprint(a.lo)
+	}
+	return fmt.Sprintf("%d..%d", a.lo, a.hi)
+}
+
+func min64(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func max64(a, b int64) int64 {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// Returns the smallest number of the form 2^n-1 of at least v, for v >= 0
+func ones(v int64) int64 {
+	m := int64(0)
+	for m < v {
+		m = m<<1 | 1
+	}
+	return m
+}
+
+// Ranges of the results of the arithmetic operations, given their operands in push order
+var rangeOperations = map[string]func(a, b interval) interval{
+	"IADD": func(a, b interval) interval {
+		return interval{a.lo + b.lo, a.hi + b.hi}.wrap()
+	},
+	"ISUB": func(a, b interval) interval {
+		return interval{a.lo - b.hi, a.hi - b.lo}.wrap()
+	},
+	"IAND": func(a, b interval) interval {
+		switch {
+		case a.lo == a.hi && b.lo == b.hi:
+			return point(int64(int32(a.lo) & int32(b.lo)))
+		case a.lo >= 0 && b.lo >= 0:
+			return interval{0, min64(a.hi, b.hi)}
+		case a.lo >= 0:
+			return interval{0, a.hi}
+		case b.lo >= 0:
+			return interval{0, b.hi}
+		}
+		return fullRange
+	},
+	"IOR": func(a, b interval) interval {
+		switch {
+		case a.lo == a.hi && b.lo == b.hi:
+			return point(int64(int32(a.lo) | int32(b.lo)))
+		case a.lo >= 0 && b.lo >= 0:
+			return interval{max64(a.lo, b.lo), ones(max64(a.hi, b.hi))}
+		case a.hi < 0 || b.hi < 0:
+			return interval{math.MinInt32, -1}
+		}
+		return fullRange
+	},
+}
+
+// Value of an operand stack word
+type rangeValue struct {
+	interval
+	// Local variable the word equals plus offset, as long as the variable is
+	// not assigned, or -1. Branches on the word narrow the range of the variable.
+	local  int
+	offset int64
+}
+
+func unrelated(r interval) rangeValue {
+	return rangeValue{r, -1, 0}
+}
+
+// Ranges of the values before an instruction
+type rangeState struct {
+	stack  []rangeValue
+	locals []interval
+}
+
+func (s *rangeState) copy() *rangeState {
+	return &rangeState{
+		stack:  append([]rangeValue(nil), s.stack...),
+		locals: append([]interval(nil), s.locals...),
+	}
+}
+
+// Forgets the words related to the local variable, as it is assigned
+func (s *rangeState) assign(v int) {
+	for i := range s.stack {
+		if s.stack[i].local == v {
+			s.stack[i].local = -1
+		}
+	}
+}
+
+// Narrows the range of the word, and of the variable it is related to.
+// Returns false if no value is left.
+func (s *rangeState) narrow(w *rangeValue, r interval) bool {
+	w.interval = w.meet(r)
+	if w.empty() {
+		return false
+	}
+	if w.local >= 0 {
+		v := &s.locals[w.local]
+		*v = v.meet(interval{w.lo - w.offset, w.hi - w.offset})
+		return !v.empty()
+	}
+	return true
+}
+
+// Joins o into s, widening the ranges that grew to the given thresholds
+// if widen is set. Reports whether s changed.
+func (s *rangeState) merge(o *rangeState, widen bool, thresholds []int64) (bool, error) {
+	if len(s.stack) != len(o.stack) {
+		return false, fmt.Errorf("operand stack depth differs between paths (%d and %d)", len(s.stack), len(o.stack))
+	}
+	changed := false
+	join := func(a *interval, b interval) {
+		j := a.join(b)
+		if widen && j.lo < a.lo {
+			i := sort.Search(len(thresholds), func(i int) bool { return thresholds[i] > j.lo })
+			j.lo = thresholds[i-1]
+		}
+		if widen && j.hi > a.hi {
+			i := sort.Search(len(thresholds), func(i int) bool { return thresholds[i] >= j.hi })
+			j.hi = thresholds[i]
+		}
+		if j != *a {
+			*a = j
+			changed = true
+		}
+	}
+	for i := range s.stack {
+		w, o := &s.stack[i], o.stack[i]
+		join(&w.interval, o.interval)
+		if w.local >= 0 && (w.local != o.local || w.offset != o.offset) {
+			w.local = -1
+			changed = true
+		}
+	}
+	for i := range s.locals {
+		join(&s.locals[i], o.locals[i])
+	}
+	return changed, nil
+}
+
+// Amount of times the ranges before an instruction may grow, before they are widened
+const widenAfter = 2
+
+// Computes the ranges of values through a single method
+type ranger struct {
+	asm    *Assembler
+	m      *Method
+	params func(name string) (int, bool)
+
+	targets map[*Label]int
+	// Bounds ranges are widened to: the constants of the method and their
+	// neighbours, -1, 0 and 1, and the bounds of bytes and 32-bit integers
+	thresholds []int64
+	// Ranges before every instruction, nil for unreachable instructions
+	in []*rangeState
+	// Ranges of the variables incremented by IINC found to overflow
+	overflows map[int]interval
+}
+
+// Checks the ranges of the values used by every method, warning about values
+// written by OUT outside of 0..255, IINC in loops that may overflow, negative
+// array indices and branches that are always or never taken. Values nothing
+// is known about, such as the results of invocations, are not warned about.
+func (asm *Assembler) checkRanges() {
+	byName := make(map[string]*Method)
+	for _, m := range asm.methods {
+		if _, ok := byName[m.name]; !ok && m.end != JASMainEnd {
+			byName[m.name] = m
+		}
+	}
+	params := func(name string) (int, bool) {
+		m, ok := byName[name]
+		if !ok {
+			return 0, false
+		}
+		return m.numparam, true
+	}
+
+	for _, m := range asm.methods {
+		if m.code != nil || len(m.instructions) == 0 {
+			continue
+		}
+		r := &ranger{asm: asm, m: m, params: params}
+		if err := r.analyze(); err != nil {
+			logrus.Debugf("[.%s] Not checking value ranges: %s", m.name, err)
+			continue
+		}
+		r.check()
+	}
+}
+
+// Collects the thresholds of the method
+func (r *ranger) collectThresholds() {
+	set := map[int64]bool{math.MinInt32: true, math.MaxInt32: true, -1: true, 0: true, 1: true, 255: true, 256: true}
+	for _, inst := range r.m.instructions {
+		var c int64
+		switch inst.op.Name {
+		case "BIPUSH":
+			c = int64(inst.params[0])
+		case "IINC":
+			c = int64(inst.params[1])
+		case "LDC_W":
+			if inst.linkConst {
+				continue
+			}
+			c = int64(r.asm.constants[inst.params[0]].Value)
+		default:
+			continue
+		}
+		for _, t := range []int64{c - 1, c, c + 1} {
+			if t >= math.MinInt32 && t <= math.MaxInt32 {
+				set[t] = true
+			}
+		}
+	}
+	r.thresholds = r.thresholds[:0]
+	for t := range set {
+		r.thresholds = append(r.thresholds, t)
+	}
+	sort.Slice(r.thresholds, func(i, j int) bool { return r.thresholds[i] < r.thresholds[j] })
+}
+
+// Computes the ranges before every instruction reachable from the start of
+// the method, only following the branch directions possible on the ranges
+func (r *ranger) analyze() error {
+	m := r.m
+	r.targets = m.labelTargets()
+	r.collectThresholds()
+	r.in = make([]*rangeState, len(m.instructions))
+	r.overflows = make(map[int]interval)
+
+	entry := &rangeState{locals: make([]interval, len(m.vars))}
+	for v := range entry.locals {
+		entry.locals[v] = point(0)
+		if v < m.numparam {
+			entry.locals[v] = fullRange
+		}
+	}
+	r.in[0] = entry
+	grown := make([]int, len(m.instructions))
+	work := []int{0}
+	for len(work) > 0 {
+		i := work[len(work)-1]
+		work = work[:len(work)-1]
+		outs, err := r.step(i)
+		if err != nil {
+			return fmt.Errorf("line %d: %s", m.instructions[i].N, err)
+		}
+		for s, out := range outs {
+			if r.in[s] == nil {
+				r.in[s] = out
+				work = append(work, s)
+				continue
+			}
+			changed, err := r.in[s].merge(out, grown[s] >= widenAfter, r.thresholds)
+			if err != nil {
+				return fmt.Errorf("line %d: %s", m.instructions[s].N, err)
+			}
+			if changed {
+				grown[s]++
+				work = append(work, s)
+			}
+		}
+	}
+	return nil
+}
+
+// Executes the instruction on the ranges before it. Returns the ranges after
+// it for every instruction that may execute next.
+func (r *ranger) step(i int) (map[int]*rangeState, error) {
+	inst := r.m.instructions[i]
+	out := r.in[i].copy()
+	pops, pushes, err := r.asm.stackEffect(r.m, inst, r.params)
+	if err != nil {
+		return nil, err
+	}
+	if len(out.stack) < pops {
+		return nil, fmt.Errorf("%s underflows the operand stack", inst.op.Name)
+	}
+	ops := append([]rangeValue(nil), out.stack[len(out.stack)-pops:]...)
+	out.stack = out.stack[:len(out.stack)-pops]
+	push := func(v rangeValue) {
+		out.stack = append(out.stack, v)
+	}
+	if v, _, store := varAccess(inst); v >= len(out.locals) {
+		return nil, fmt.Errorf("local variable %d out of range", v)
+	} else if store {
+		out.assign(v)
+	}
+
+	switch name := inst.op.Name; name {
+	case "BIPUSH":
+		push(unrelated(point(int64(inst.params[0]))))
+	case "LDC_W":
+		if inst.linkConst {
+			push(unrelated(fullRange))
+		} else {
+			push(unrelated(point(int64(r.asm.constants[inst.params[0]].Value))))
+		}
+	case "IN":
+		push(unrelated(interval{0, 255}))
+	case "ILOAD":
+		v := inst.params[0]
+		push(rangeValue{out.locals[v], v, 0})
+	case "ISTORE":
+		out.locals[inst.params[0]] = ops[0].interval
+	case "IINC":
+		v := &out.locals[inst.params[0]]
+		after := interval{v.lo + int64(inst.params[1]), v.hi + int64(inst.params[1])}
+		if _, ok := r.overflows[i]; !ok && !v.full() && after.wrap().full() {
+			// Once wrapped around the range of the variable is unknown, so
+			// the overflow is recorded on the first range found to overflow
+			r.overflows[i] = *v
+		}
+		*v = after.wrap()
+	case "DUP":
+		push(ops[0])
+		push(ops[0])
+	case "SWAP":
+		push(ops[1])
+		push(ops[0])
+	case "IADD", "ISUB":
+		res := unrelated(rangeOperations[name](ops[0].interval, ops[1].interval))
+		// Adding a constant keeps the relation to a variable, unless it wraps around
+		a, b := ops[0], ops[1]
+		if name == "IADD" && a.lo == a.hi {
+			a, b = b, a
+		}
+		if b.lo == b.hi && a.local >= 0 && !res.full() {
+			res.local, res.offset = a.local, a.offset+b.lo
+			if name == "ISUB" {
+				res.offset = a.offset - b.lo
+			}
+		}
+		push(res)
+	default:
+		if op, ok := rangeOperations[name]; ok {
+			push(unrelated(op(ops[0].interval, ops[1].interval)))
+			break
+		}
+		for j := 0; j < pushes; j++ {
+			push(unrelated(fullRange))
+		}
+		if v, _, store := varAccess(inst); store {
+			out.locals[v] = fullRange
+		}
+	}
+
+	outs := make(map[int]*rangeState)
+	taken, notTaken, cond := r.branch(out, ops, inst)
+	if !cond {
+		for _, s := range r.m.successors(r.targets, i) {
+			outs[s] = out
+		}
+		return outs, nil
+	}
+	// Branches to the next instruction continue there either way
+	t, _ := r.m.labelTarget(r.targets, inst.label)
+	add := func(s int, dir *rangeState) {
+		if dir == nil || s >= len(r.m.instructions) {
+			return
+		}
+		if outs[s] == nil {
+			outs[s] = dir.copy()
+		} else {
+			outs[s].merge(dir, false, nil)
+		}
+	}
+	add(t, taken)
+	add(i+1, notTaken)
+	return outs, nil
+}
+
+// Narrows the ranges after a conditional branch for both directions, given
+// its operands in push order. Returns nil for directions that are impossible,
+// and whether the instruction is a conditional branch at all.
+func (r *ranger) branch(out *rangeState, ops []rangeValue, inst *Instruction) (taken, notTaken *rangeState, cond bool) {
+	// The operands are popped, so only the ranges of related variables narrow
+	narrow := func(ranges ...interval) *rangeState {
+		s := out.copy()
+		for k, rg := range ranges {
+			w := ops[k]
+			if !s.narrow(&w, rg) {
+				return nil
+			}
+		}
+		return s
+	}
+	switch inst.op.Name {
+	case "IFEQ":
+		return narrow(point(0)), narrow(ops[0].exclude(0)), true
+	case "IFLT":
+		return narrow(interval{math.MinInt32, -1}), narrow(interval{0, math.MaxInt32}), true
+	case "IF_ICMPEQ":
+		a, b := ops[0], ops[1]
+		taken = narrow(b.interval, a.interval)
+		notA, notB := a.interval, b.interval
+		if b.lo == b.hi {
+			notA = notA.exclude(b.lo)
+		}
+		if a.lo == a.hi {
+			notB = notB.exclude(a.lo)
+		}
+		return taken, narrow(notA, notB), true
+	}
+	return nil, nil, false
+}
+
+// Warns about the suspicious values used by the reachable instructions, after analyze
+func (r *ranger) check() {
+	m := r.m
+	operands := func(i int) []rangeValue {
+		pops, _, _ := r.asm.stackEffect(m, m.instructions[i], r.params)
+		return r.in[i].stack[len(r.in[i].stack)-pops:]
+	}
+
+	// Loop counters are the variables conditional branches depend on
+	counters := make(map[int]bool)
+	for i, inst := range m.instructions {
+		if r.in[i] != nil && constBranches[inst.op.Name] != nil {
+			for _, w := range operands(i) {
+				counters[w.local] = true
+			}
+		}
+	}
+
+	for i, inst := range m.instructions {
+		in := r.in[i]
+		if in == nil {
+			continue
+		}
+		ops := operands(i)
+
+		switch name := inst.op.Name; name {
+		case "OUT":
+			if v := ops[0].interval; !v.full() && (v.lo < 0 || v.hi > 255) {
+				r.warnf(inst, "OUT writes %s, outside of 0..255", r.describe(v))
+			}
+		case "IINC":
+			v, ok := r.overflows[i]
+			if ok && counters[inst.params[0]] && r.inLoop(i) {
+				r.warnf(inst, "IINC of loop counter %s by %d may overflow, from %s", r.varName(inst.params[0]), inst.params[1], v)
+			}
+		case "IALOAD", "AIALOAD", "IASTORE", "AIASTORE":
+			if idx := ops[len(ops)-2].interval; !idx.full() && idx.lo < 0 {
+				r.warnf(inst, "%s index is %s, which is negative", name, r.describe(idx))
+			}
+		}
+
+		taken, notTaken, cond := r.branch(in, ops, inst)
+		switch {
+		case cond && taken == nil && notTaken != nil:
+			r.warnf(inst, "condition of %s is always false, the branch is never taken", inst.op.Name)
+		case cond && notTaken == nil && taken != nil:
+			r.warnf(inst, "condition of %s is always true, the branch is always taken", inst.op.Name)
+		}
+	}
+}
+
+// Describes a range of values, as always or possibly
+func (r *ranger) describe(v interval) string {
+	if v.lo == v.hi {
+		return fmt.Sprintf("always %s", v)
+	}
+	return fmt.Sprintf("in %s", v)
+}
+
+// Returns the name of the local variable
+func (r *ranger) varName(v int) string {
+	if v < len(r.m.vars) && r.m.vars[v] != "" {
+		return r.m.vars[v]
+	}
+	return fmt.Sprintf("local %d", v)
+}
+
+// Reports whether the instruction lies on a cycle of the control flow graph
+func (r *ranger) inLoop(i int) bool {
+	seen := make(map[int]bool)
+	work := r.m.successors(r.targets, i)
+	for len(work) > 0 {
+		j := work[len(work)-1]
+		work = work[:len(work)-1]
+		if j == i {
+			return true
+		}
+		if !seen[j] {
+			seen[j] = true
+			work = append(work, r.m.successors(r.targets, j)...)
+		}
+	}
+	return false
+}
+
+func (r *ranger) warnf(inst *Instruction, format string, args ...interface{}) {
+	logrus.Warnf("%s:%d > range: [.%s] %s", r.asm.fileName, inst.N, r.m.name, fmt.Sprintf(format, args...))
+}
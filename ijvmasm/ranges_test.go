@@ -0,0 +1,223 @@
+package ijvmasm_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/BlackNovaTech/gojasm/ijvmasm"
+	"github.com/BlackNovaTech/gojasm/internal/testprog"
+	"github.com/BlackNovaTech/gojasm/opconf"
+)
+
+var rangePrograms = []struct {
+	name   string
+	config string
+	source string
+	// Line and part of the message of every warning expected
+	warnings []string
+}{
+	{"output", "", `
+.constant
+big 1000
+.end-constant
+.main
+.var
+i
+.end-var
+loop:
+ILOAD i
+BIPUSH 10
+IF_ICMPEQ done
+ILOAD i
+BIPUSH '0'
+IADD
+OUT
+IINC i 1
+GOTO loop
+done:
+ILOAD i
+LDC_W big
+IADD
+OUT
+IN
+BIPUSH 0x20
+ISUB
+OUT
+HALT
+.end-main`, []string{
+		"23: OUT writes always 1010",
+		"27: OUT writes in -32..223",
+	}},
+
+	{"branches", "", `
+.main
+.var
+i
+.end-var
+BIPUSH 3
+ISTORE i
+IN
+BIPUSH 1
+IAND
+IFLT never
+IN
+BIPUSH 0x40
+IOR
+IFEQ never
+ILOAD i
+BIPUSH 3
+IF_ICMPEQ always
+never:
+ERR
+always:
+IN
+IFLT never
+HALT
+.end-main`, []string{
+		"11: IFLT is always false",
+		"15: IFEQ is always false",
+		"18: IF_ICMPEQ is always true",
+		"23: IFLT is always false",
+	}},
+
+	{"counters", "", `
+.main
+.var
+i
+n
+.end-var
+count:
+IN
+IFEQ up
+IINC n 1
+GOTO count
+up:
+IINC i 1
+ILOAD i
+IFEQ down
+GOTO up
+down:
+BIPUSH 100
+ISTORE i
+loop:
+IINC i -1
+ILOAD i
+IFEQ done
+GOTO loop
+done:
+HALT
+.end-main`, []string{
+		"13: IINC of loop counter i by 1 may overflow",
+	}},
+
+	{"arrays", "../ijvm.config", `
+.main
+.var
+a
+i
+.end-var
+BIPUSH 10
+NEWARRAY
+ISTORE a
+BIPUSH 9
+ISTORE i
+loop:
+ILOAD i
+DUP
+ILOAD a
+IASTORE
+IINC i -1
+ILOAD i
+IFLT done
+GOTO loop
+done:
+ILOAD i
+ILOAD a
+IALOAD
+POP
+IN
+BIPUSH 10
+ISUB
+ILOAD a
+IALOAD
+POP
+HALT
+.end-main`, []string{
+		"24: IALOAD index is always -1",
+		"30: IALOAD index is in -10..245",
+	}},
+
+	{"methods", "", `
+.main
+BIPUSH 0
+BIPUSH 5
+INVOKEVIRTUAL f
+OUT
+HALT
+.end-main
+
+.method f(x)
+ILOAD x
+IFLT negative
+ILOAD x
+BIPUSH 64
+IOR
+OUT
+ILOAD x
+IRETURN
+negative:
+BIPUSH 0
+ILOAD x
+ISUB
+IRETURN
+.end-method`, []string{
+		"16: OUT writes in 64..2147483647",
+	}},
+}
+
+// Assembles the program checking ranges, returning the range warnings
+func checkRanges(t *testing.T, name, config, src string) []string {
+	var ops *opconf.OpConfig
+	if config != "" {
+		ops = opconf.NewOpConfigFromPath(config)
+	}
+
+	var warnings []string
+	logged := testprog.Logged(func() {
+		testprog.Assemble(t, ops, name, src, func(asm *ijvmasm.Assembler) {
+			asm.CheckRanges = true
+		})
+	})
+	for _, msg := range logged {
+		if strings.Contains(msg, "> range:") {
+			warnings = append(warnings, msg)
+		}
+	}
+	return warnings
+}
+
+func TestCheckRanges(t *testing.T) {
+	for _, p := range rangePrograms {
+		name := p.name + ".jas"
+		warnings := checkRanges(t, name, p.config, p.source)
+		if len(warnings) != len(p.warnings) {
+			t.Errorf("%s: %d warnings, want %d: %q", name, len(warnings), len(p.warnings), warnings)
+			continue
+		}
+		for i, want := range p.warnings {
+			parts := strings.SplitN(want, ": ", 2)
+			if !strings.HasPrefix(warnings[i], name+":"+parts[0]+" >") || !strings.Contains(warnings[i], parts[1]) {
+				t.Errorf("%s: warning %q, want %q", name, warnings[i], want)
+			}
+		}
+	}
+}
+
+// The example programs are free of suspicious values
+func TestCheckRangesPrograms(t *testing.T) {
+	for _, p := range testprog.Examples(t) {
+		if warnings := checkRanges(t, p.Name, "", p.Source); len(warnings) > 0 {
+			t.Errorf("%s: unexpected warnings %q", p.Name, warnings)
+		}
+	}
+}
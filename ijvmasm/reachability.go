@@ -0,0 +1,95 @@
+package ijvmasm
+
+import (
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Returns the names of the methods invoked by the method, in order of appearance
+func (m *Method) invoked() []string {
+	var names []string
+	if m.code != nil {
+		for _, r := range m.relocs {
+			if r.Method {
+				names = append(names, r.Symbol)
+			}
+		}
+		return names
+	}
+	for _, inst := range m.instructions {
+		if inst.linkMethod {
+			names = append(names, inst.label)
+		}
+	}
+	return names
+}
+
+// Returns the methods reachable from main over invocations, in depth-first
+// order of their first invocation. Main comes first.
+func (asm *Assembler) reachableMethods() []*Method {
+	byName := make(map[string]*Method)
+	for _, m := range asm.methods[1:] {
+		if _, ok := byName[m.name]; !ok {
+			byName[m.name] = m
+		}
+	}
+
+	var order []*Method
+	seen := make(map[*Method]bool)
+	var visit func(m *Method)
+	visit = func(m *Method) {
+		seen[m] = true
+		order = append(order, m)
+		for _, name := range m.invoked() {
+			if callee, ok := byName[name]; ok && !seen[callee] {
+				visit(callee)
+			}
+		}
+	}
+	visit(asm.methods[0])
+	return order
+}
+
+// Removes the methods unreachable from main. As method constants are only
+// added when placing the methods, the removed methods take no constant pool
+// entries, and the indices of the remaining constants are left as is.
+func (asm *Assembler) removeDeadMethods() {
+	live := make(map[*Method]bool)
+	for _, m := range asm.reachableMethods() {
+		live[m] = true
+	}
+
+	var methods []*Method
+	var names []string
+	var removed uint32
+	for _, m := range asm.methods {
+		if live[m] {
+			methods = append(methods, m)
+			continue
+		}
+		removed += m.bytes
+		names = append(names, m.name)
+	}
+	if removed > 0 {
+		logrus.Infof("Removed %d unreachable methods, saving %d bytes: %s",
+			len(names), removed, strings.Join(names, ", "))
+	}
+	asm.methods = methods
+}
+
+// Places the methods reachable from main in the order they are first invoked,
+// followed by the unreachable methods in source order.
+func (asm *Assembler) reorderMethods() {
+	methods := asm.reachableMethods()
+	placed := make(map[*Method]bool)
+	for _, m := range methods {
+		placed[m] = true
+	}
+	for _, m := range asm.methods {
+		if !placed[m] {
+			methods = append(methods, m)
+		}
+	}
+	asm.methods = methods
+}
@@ -0,0 +1,118 @@
+package ijvmasm_test
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/BlackNovaTech/gojasm/ijvmasm"
+	"github.com/BlackNovaTech/gojasm/ijvmemu"
+	"github.com/BlackNovaTech/gojasm/internal/testprog"
+	"github.com/BlackNovaTech/gojasm/opconf"
+	"github.com/sirupsen/logrus"
+)
+
+// Main invokes used, which invokes shared. Neither main nor used invoke
+// unused, which only invokes itself and shared.
+const deadSource = `
+.main
+BIPUSH 0
+BIPUSH 'a'
+INVOKEVIRTUAL used
+OUT
+HALT
+.end-main
+
+.method unused(x)
+BIPUSH 0
+ILOAD x
+INVOKEVIRTUAL unused
+BIPUSH 0
+ILOAD x
+INVOKEVIRTUAL shared
+IADD
+IRETURN
+.end-method
+
+.method used(x)
+BIPUSH 0
+ILOAD x
+INVOKEVIRTUAL shared
+IRETURN
+.end-method
+
+.method shared(x)
+ILOAD x
+BIPUSH 1
+IADD
+IRETURN
+.end-method
+`
+
+func TestDeadMethods(t *testing.T) {
+	assemble := func(dead bool) *ijvmemu.Program {
+		return testprog.Assemble(t, nil, "dead.jas", deadSource, func(asm *ijvmasm.Assembler) {
+			asm.DeadMethods = dead
+		})
+	}
+	plain := assemble(false)
+	prog := assemble(true)
+
+	var methods []string
+	for _, m := range prog.Methods() {
+		methods = append(methods, m.Name)
+	}
+	if want := []string{"main", "used", "shared"}; !reflect.DeepEqual(methods, want) {
+		t.Errorf("methods %q, want %q", methods, want)
+	}
+
+	// The header and code of unused are dropped, along with its constant
+	unused := plain.MethodByName("unused")
+	saved := int(unused.End - unused.Addr)
+	if len(plain.Text)-len(prog.Text) != saved || len(plain.Constants)-len(prog.Constants) != 1 {
+		t.Errorf("removing unused saved %d bytes and %d constants, want %d bytes and 1 constant",
+			len(plain.Text)-len(prog.Text), len(plain.Constants)-len(prog.Constants), saved)
+	}
+
+	if out, _, err := testprog.Run(prog, ""); err != nil || out != "b" {
+		t.Errorf("printed %q and failed with %v, want %q", out, err, "b")
+	}
+}
+
+func TestDeadMethodsLogged(t *testing.T) {
+	logged := testprog.LoggedAt(logrus.InfoLevel, func() {
+		testprog.Assemble(t, nil, "dead.jas", deadSource, func(asm *ijvmasm.Assembler) {
+			asm.DeadMethods = true
+		})
+	})
+	want := "Removed 1 unreachable methods, saving 20 bytes: unused"
+	var removed []string
+	for _, msg := range logged {
+		if strings.HasPrefix(msg, "Removed") {
+			removed = append(removed, msg)
+		}
+	}
+	if len(removed) != 1 || removed[0] != want {
+		t.Errorf("assembling logged %q, want %q", removed, want)
+	}
+}
+
+// A second definition of a method is an error, even if it is never invoked
+func TestDeadMethodsRedefinition(t *testing.T) {
+	src := deadSource + `
+.method unused(x)
+ILOAD x
+IRETURN
+.end-method
+`
+	var ok bool
+	logged := testprog.Logged(func() {
+		asm := ijvmasm.NewAssemblerFromReader(strings.NewReader(src), "dead.jas", opconf.NewDefaultOpConfig())
+		asm.DeadMethods = true
+		ok, _ = asm.Parse()
+	})
+	want := "dead.jas:38 > linker: Method constant name conflict. `unused` already defined on line 10"
+	if ok || len(logged) != 1 || logged[0] != want {
+		t.Errorf("assembling logged %q, want %q", logged, want)
+	}
+}
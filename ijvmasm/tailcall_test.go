@@ -0,0 +1,99 @@
+package ijvmasm_test
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/BlackNovaTech/gojasm/ijvmasm"
+	"github.com/BlackNovaTech/gojasm/ijvmemu"
+	"github.com/BlackNovaTech/gojasm/internal/testprog"
+	"github.com/BlackNovaTech/gojasm/opconf"
+)
+
+// Prints the character 100 above 'a' - 100 using tail recursion
+const tailSource = `.main
+BIPUSH 0
+BIPUSH 100
+BIPUSH -3
+INVOKEVIRTUAL count
+OUT
+HALT
+.end-main
+
+.method count(n, acc)
+ILOAD n
+IFEQ done
+BIPUSH 0
+ILOAD n
+BIPUSH 1
+ISUB
+ILOAD acc
+BIPUSH 1
+IADD
+INVOKEVIRTUAL count
+IRETURN
+done:
+ILOAD acc
+IRETURN
+.end-method
+`
+
+// Counts the executed operations
+type opCounter map[string]int
+
+func (c opCounter) Before(m *ijvmemu.Machine, in *ijvmemu.Instr) {}
+
+func (c opCounter) After(m *ijvmemu.Machine, in *ijvmemu.Instr) {
+	c[in.Op.Name]++
+}
+
+func tailCalls(asm *ijvmasm.Assembler) {
+	asm.TailCalls = true
+}
+
+// Only configurations defining TAILCALL get their tail calls rewritten, the
+// program behaves the same either way.
+func TestRewriteTailCalls(t *testing.T) {
+	tests := []struct {
+		name   string
+		ops    *opconf.OpConfig
+		counts opCounter
+	}{
+		{"ijvm.config", opconf.NewOpConfigFromPath("../ijvm.config"), opCounter{"INVOKEVIRTUAL": 1, "TAILCALL": 100}},
+		{"default", opconf.NewDefaultOpConfig(), opCounter{"INVOKEVIRTUAL": 101}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			prog := testprog.Assemble(t, tt.ops, "tail.jas", tailSource, tailCalls)
+			counts := opCounter{}
+			out := new(strings.Builder)
+			m := ijvmemu.NewMachine(prog, strings.NewReader(""), out)
+			m.AddHook(counts)
+			if err := m.Run(); err != nil {
+				t.Fatal(err)
+			}
+			if out.String() != "a" {
+				t.Errorf("printed %q, want a", out.String())
+			}
+			for _, op := range []string{"INVOKEVIRTUAL", "TAILCALL"} {
+				if counts[op] != tt.counts[op] {
+					t.Errorf("executed %s %d times, want %d", op, counts[op], tt.counts[op])
+				}
+			}
+		})
+	}
+}
+
+// A configuration without TAILCALL is reported once, not for every method.
+func TestRewriteTailCallsUnavailable(t *testing.T) {
+	src := tailSource + strings.Replace(tailSource[strings.Index(tailSource, ".method"):], "count", "other", -1)
+	msgs := testprog.Logged(func() {
+		testprog.Assemble(t, nil, "tail.jas", src, tailCalls)
+	})
+	want := []string{"Operation TAILCALL is not available, not rewriting tail calls"}
+	if !reflect.DeepEqual(msgs, want) {
+		t.Errorf("logged %q, want %q", msgs, want)
+	}
+}
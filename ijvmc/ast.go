@@ -0,0 +1,127 @@
+package ijvmc
+
+type node interface {
+	position() pos
+}
+
+type expr interface {
+	node
+}
+
+type stmt interface {
+	node
+}
+
+// An integer or character literal
+type numberExpr struct {
+	pos
+	value int32
+}
+
+// A variable
+type varExpr struct {
+	pos
+	name string
+}
+
+// A unary operation: -, +, ~ or !
+type unaryExpr struct {
+	pos
+	op string
+	x  expr
+}
+
+// A binary operation, including comparisons and the logical && and ||
+type binaryExpr struct {
+	pos
+	op   string
+	x, y expr
+}
+
+// An invocation of a function or builtin
+type callExpr struct {
+	pos
+	name string
+	args []expr
+}
+
+// An assignment: =, or a compound assignment such as +=
+type assignExpr struct {
+	pos
+	op   string
+	name string
+	x    expr
+}
+
+// An increment or decrement, evaluating to the new value if prefix
+type incExpr struct {
+	pos
+	name   string
+	delta  int32
+	prefix bool
+}
+
+type blockStmt struct {
+	pos
+	stmts []stmt
+	// Position of the closing brace
+	end pos
+}
+
+// A declaration of a variable, initialized to zero without initializer
+type declStmt struct {
+	pos
+	name string
+	init expr
+}
+
+type exprStmt struct {
+	pos
+	x expr
+}
+
+type ifStmt struct {
+	pos
+	cond expr
+	then stmt
+	els  stmt
+}
+
+type whileStmt struct {
+	pos
+	cond expr
+	body stmt
+}
+
+// A for loop, whose parts are optional
+type forStmt struct {
+	pos
+	init []stmt
+	cond expr
+	post expr
+	body stmt
+}
+
+type returnStmt struct {
+	pos
+	x expr
+}
+
+// A break or continue
+type branchStmt struct {
+	pos
+	keyword string
+}
+
+type param struct {
+	pos
+	name string
+}
+
+type funcDecl struct {
+	pos
+	name   string
+	params []*param
+	void   bool
+	body   *blockStmt
+}
@@ -0,0 +1,450 @@
+// Package ijvmc compiles IJVM C, a small C-like language, into IJVM programs.
+//
+// Programs consist of functions taking and returning ints, using int
+// variables, arithmetic, comparisons, if, while and for. Multiplication,
+// division and remainder are implemented by runtime functions added to the
+// program when used. The builtins getchar and putchar read and write a
+// character using IN and OUT.
+package ijvmc
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/BlackNovaTech/gojasm/ijvmasm"
+)
+
+// Error is a compile error, located in the IJVM C source
+type Error struct {
+	File string
+	Line int
+	Col  int
+	Msg  string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s:%d:%d: %s", e.File, e.Line, e.Col, e.Msg)
+}
+
+// A builtin function, compiled into a single operation
+type builtin struct {
+	params int
+	void   bool
+	op     string
+}
+
+var builtins = map[string]builtin{
+	"getchar": {0, false, "IN"},
+	"putchar": {1, false, "OUT"},
+	// Only available to the runtime
+	"__err": {0, true, "ERR"},
+}
+
+// Reports whether the name is reserved for the runtime
+func reserved(name string) bool {
+	return strings.HasPrefix(name, "__")
+}
+
+type compiler struct {
+	asm   *ijvmasm.Assembler
+	file  string
+	funcs map[string]*funcDecl
+	// Names of the constants added for large integers, by value
+	constants map[int32]string
+	// Runtime functions invoked by the program
+	used map[string]bool
+}
+
+// Compile compiles the IJVM C source file at the given path into asm, created
+// by ijvmasm.NewBuilder, linking the resulting program. Source errors are
+// returned as an *Error.
+func Compile(asm *ijvmasm.Assembler, path string) error {
+	src, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return CompileSource(asm, path, string(src))
+}
+
+// CompileSource compiles IJVM C source code read from the given path into asm,
+// like Compile.
+func CompileSource(asm *ijvmasm.Assembler, path, src string) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			e, ok := r.(*Error)
+			if !ok {
+				panic(r)
+			}
+			err = e
+		}
+	}()
+
+	c := &compiler{
+		asm:       asm,
+		file:      path,
+		funcs:     make(map[string]*funcDecl),
+		constants: make(map[int32]string),
+		used:      make(map[string]bool),
+	}
+	funcs := newParser(path, src).program()
+	runtime := newParser(runtimeFile, runtimeSource).program()
+	c.declare(funcs, false)
+	c.declare(runtime, true)
+
+	main, ok := c.funcs["main"]
+	if !ok {
+		c.errorf(pos{1, 1}, "missing function main")
+	}
+	if len(main.params) > 0 {
+		c.errorf(main.pos, "main cannot take parameters")
+	}
+
+	c.function(main)
+	for _, f := range funcs {
+		if f != main {
+			c.function(f)
+		}
+	}
+	// Runtime functions may use each other, but never the program
+	for done := make(map[string]bool); len(done) < len(c.used); {
+		for _, f := range runtime {
+			if c.used[f.name] && !done[f.name] {
+				done[f.name] = true
+				c.file = runtimeFile
+				c.function(f)
+			}
+		}
+	}
+
+	if !asm.Link() {
+		return errors.New("building the compiled program failed")
+	}
+	return nil
+}
+
+func (c *compiler) errorf(at pos, format string, args ...interface{}) {
+	panic(&Error{File: c.file, Line: at.line, Col: at.col, Msg: fmt.Sprintf(format, args...)})
+}
+
+// Registers the functions, so they can be invoked before their declaration
+func (c *compiler) declare(funcs []*funcDecl, runtime bool) {
+	for _, f := range funcs {
+		prev, ok := c.funcs[f.name]
+		_, isBuiltin := builtins[f.name]
+		switch {
+		case ok:
+			c.errorf(f.pos, "redefinition of function %s, declared on line %d", f.name, prev.line)
+		case isBuiltin:
+			c.errorf(f.pos, "redefinition of builtin %s", f.name)
+		case reserved(f.name) && !runtime:
+			c.errorf(f.pos, "function name %s is reserved", f.name)
+		}
+		c.funcs[f.name] = f
+	}
+}
+
+// Returns the parameter count of the function or builtin invoked, and whether it returns void
+func (c *compiler) signature(call *callExpr) (int, bool) {
+	params, void := 0, false
+	if b, ok := builtins[call.name]; ok {
+		params, void = b.params, b.void
+	} else if f, ok := c.funcs[call.name]; ok {
+		params, void = len(f.params), f.void
+	} else {
+		c.errorf(call.pos, "undefined function %s", call.name)
+	}
+	if reserved(call.name) && c.file != runtimeFile {
+		c.errorf(call.pos, "undefined function %s", call.name)
+	}
+	if len(call.args) != params {
+		c.errorf(call.pos, "function %s takes %d argument(s), got %d", call.name, params, len(call.args))
+	}
+	return params, void
+}
+
+// Returns the name of the constant holding the given value, adding it if needed
+func (c *compiler) constant(v int32, N uint32) string {
+	name, ok := c.constants[v]
+	if !ok {
+		name = fmt.Sprintf("const$%d", v)
+		c.constants[v] = name
+		c.asm.AddConstant(name, v, N)
+	}
+	return name
+}
+
+// An instruction or label of a function being compiled
+type item struct {
+	label string
+	instr string
+	// Variable accessed by the instruction, widened when needed
+	variable string
+	line     uint32
+}
+
+type loop struct {
+	brk, cont string
+}
+
+// Compiles a single function. Code is generated before the IJVM method is
+// added, as its local variables are only known afterwards.
+type function struct {
+	c    *compiler
+	decl *funcDecl
+	main bool
+	code []item
+	line uint32
+	// IJVM variables, and the source names in scope, innermost scope last
+	vars   []string
+	scopes []map[string]string
+	// Amount of variables declared per source name, to name shadowing ones
+	names  map[string]int
+	temps  bool
+	labels int
+	loops  []loop
+}
+
+func (c *compiler) function(decl *funcDecl) {
+	f := &function{
+		c:     c,
+		decl:  decl,
+		main:  decl.name == "main",
+		line:  uint32(decl.line),
+		names: make(map[string]int),
+	}
+	f.openScope()
+	var params []string
+	for _, p := range decl.params {
+		params = append(params, f.declare(p.name, p.pos))
+	}
+	f.vars = nil
+
+	f.block(decl.body)
+	if !terminates(decl.body) {
+		f.line = uint32(decl.body.end.line)
+		f.exit()
+	}
+
+	vars := f.vars
+	if f.temps {
+		vars = append(vars, temp(0), temp(1))
+	}
+	declaration := "main"
+	if !f.main {
+		declaration = fmt.Sprintf("%s(%s)", decl.name, strings.Join(params, ", "))
+	}
+	// Runtime code has no source line
+	runtime := c.file == runtimeFile
+	line := func(N uint32) uint32 {
+		if runtime {
+			return 0
+		}
+		return N
+	}
+	method := c.asm.AddMethod(declaration, vars, line(uint32(decl.line)))
+	if method == nil {
+		return
+	}
+	for _, it := range f.code {
+		if it.label != "" {
+			c.asm.AddLabel(method, it.label, line(it.line))
+			continue
+		}
+		if idx, _ := method.VarIndex(it.variable); idx > 0xFF {
+			c.asm.AddInstruction(method, ijvmasm.OperationWide, line(it.line))
+		}
+		c.asm.AddInstruction(method, it.instr, line(it.line))
+	}
+	c.asm.EndMethod(method)
+}
+
+// Returns the name of a temporary variable, which cannot clash with source names
+func temp(idx int) string {
+	return fmt.Sprintf("tmp-%d", idx)
+}
+
+func (f *function) emit(format string, args ...interface{}) {
+	f.code = append(f.code, item{instr: fmt.Sprintf(format, args...), line: f.line})
+}
+
+// Emits an instruction accessing a variable
+func (f *function) emitVar(op, name string, args ...interface{}) {
+	instr := fmt.Sprintf("%s %s"+strings.Repeat(" %d", len(args)), append([]interface{}{op, name}, args...)...)
+	f.code = append(f.code, item{instr: instr, variable: name, line: f.line})
+}
+
+func (f *function) newLabel(prefix string) string {
+	f.labels++
+	return fmt.Sprintf("%s%d", prefix, f.labels)
+}
+
+// Places a label at the next instruction
+func (f *function) place(label string) {
+	f.code = append(f.code, item{label: label, line: f.line})
+}
+
+func (f *function) openScope() {
+	f.scopes = append(f.scopes, make(map[string]string))
+}
+
+func (f *function) closeScope() {
+	f.scopes = f.scopes[:len(f.scopes)-1]
+}
+
+// Declares a variable in the innermost scope, returning its IJVM name
+func (f *function) declare(name string, at pos) string {
+	scope := f.scopes[len(f.scopes)-1]
+	if _, ok := scope[name]; ok {
+		f.c.errorf(at, "redeclaration of variable %s", name)
+	}
+	if reserved(name) && f.c.file != runtimeFile {
+		f.c.errorf(at, "variable name %s is reserved", name)
+	}
+	f.names[name]++
+	ident := name
+	if n := f.names[name]; n > 1 {
+		ident = fmt.Sprintf("%s-%d", name, n)
+	}
+	scope[name] = ident
+	f.vars = append(f.vars, ident)
+	return ident
+}
+
+// Returns the IJVM name of a variable in scope
+func (f *function) lookup(name string, at pos) string {
+	for i := len(f.scopes) - 1; i >= 0; i-- {
+		if ident, ok := f.scopes[i][name]; ok {
+			return ident
+		}
+	}
+	f.c.errorf(at, "undefined variable %s", name)
+	return ""
+}
+
+// Leaves the function: main halts, other functions return zero
+func (f *function) exit() {
+	if f.main {
+		f.emit("HALT")
+		return
+	}
+	f.emit("BIPUSH 0")
+	f.emit("IRETURN")
+}
+
+// Reports whether control never flows past the end of the statement
+func terminates(s stmt) bool {
+	switch s := s.(type) {
+	case *returnStmt:
+		return true
+	case *blockStmt:
+		return len(s.stmts) > 0 && terminates(s.stmts[len(s.stmts)-1])
+	case *ifStmt:
+		return s.els != nil && terminates(s.then) && terminates(s.els)
+	}
+	return false
+}
+
+func (f *function) block(b *blockStmt) {
+	f.openScope()
+	for _, s := range b.stmts {
+		f.statement(s)
+	}
+	f.closeScope()
+}
+
+func (f *function) statement(s stmt) {
+	f.line = uint32(s.position().line)
+	switch s := s.(type) {
+	case *blockStmt:
+		f.block(s)
+	case *declStmt:
+		if s.init != nil {
+			f.value(s.init)
+		} else {
+			f.emit("BIPUSH 0")
+		}
+		f.emitVar("ISTORE", f.declare(s.name, s.pos))
+	case *exprStmt:
+		f.effect(s.x)
+	case *ifStmt:
+		end := f.newLabel("endif")
+		next := end
+		if s.els != nil {
+			next = f.newLabel("else")
+		}
+		f.jump(s.cond, next, false)
+		f.statement(s.then)
+		if s.els != nil {
+			if !terminates(s.then) {
+				f.emit("GOTO %s", end)
+			}
+			f.place(next)
+			f.statement(s.els)
+		}
+		f.place(end)
+	case *whileStmt:
+		top, end := f.newLabel("while"), f.newLabel("endwhile")
+		f.place(top)
+		f.jump(s.cond, end, false)
+		f.body(s.body, loop{end, top})
+		f.emit("GOTO %s", top)
+		f.place(end)
+	case *forStmt:
+		f.openScope()
+		for _, init := range s.init {
+			f.statement(init)
+		}
+		f.line = uint32(s.line)
+		top, next, end := f.newLabel("for"), f.newLabel("next"), f.newLabel("endfor")
+		f.place(top)
+		if s.cond != nil {
+			f.jump(s.cond, end, false)
+		}
+		f.body(s.body, loop{end, next})
+		f.line = uint32(s.line)
+		f.place(next)
+		if s.post != nil {
+			f.effect(s.post)
+		}
+		f.emit("GOTO %s", top)
+		f.place(end)
+		f.closeScope()
+	case *returnStmt:
+		switch {
+		case f.main:
+			// The result of main is discarded
+			if s.x != nil {
+				f.effect(s.x)
+			}
+			f.emit("HALT")
+		case s.x == nil && !f.decl.void:
+			f.c.errorf(s.pos, "missing return value in function %s returning int", f.decl.name)
+		case s.x != nil && f.decl.void:
+			f.c.errorf(s.pos, "return value in function %s returning void", f.decl.name)
+		case s.x == nil:
+			f.exit()
+		default:
+			f.value(s.x)
+			f.emit("IRETURN")
+		}
+	case *branchStmt:
+		if len(f.loops) == 0 {
+			f.c.errorf(s.pos, "%s outside of a loop", s.keyword)
+		}
+		l := f.loops[len(f.loops)-1]
+		if s.keyword == "break" {
+			f.emit("GOTO %s", l.brk)
+		} else {
+			f.emit("GOTO %s", l.cont)
+		}
+	}
+}
+
+// Compiles the body of a loop
+func (f *function) body(s stmt, l loop) {
+	f.loops = append(f.loops, l)
+	f.statement(s)
+	f.loops = f.loops[:len(f.loops)-1]
+}
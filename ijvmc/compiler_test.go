@@ -0,0 +1,250 @@
+package ijvmc_test
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/BlackNovaTech/gojasm/ijvmasm"
+	"github.com/BlackNovaTech/gojasm/ijvmc"
+	"github.com/BlackNovaTech/gojasm/ijvmemu"
+	"github.com/BlackNovaTech/gojasm/opconf"
+	"github.com/sirupsen/logrus"
+)
+
+func init() {
+	logrus.SetLevel(logrus.WarnLevel)
+}
+
+// Compiles the source and runs it on the input, returning its output
+func run(t *testing.T, src, input string) (string, error) {
+	asm := ijvmasm.NewBuilder("test.ic", opconf.NewDefaultOpConfig())
+	if err := ijvmc.CompileSource(asm, "test.ic", src); err != nil {
+		t.Fatalf("compilation failed: %v", err)
+	}
+	prog, err := ijvmemu.FromAssembler(asm)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out := new(strings.Builder)
+	m := ijvmemu.NewMachine(prog, strings.NewReader(input), out)
+	m.MaxSteps = 1 << 24
+	err = m.Run()
+	return out.String(), err
+}
+
+// Prints the 32 bits of n, using neither the runtime nor shifts
+const printBits = `
+void bits(int n) {
+	for (int i = 0; i < 32; i++) {
+		putchar('0' + (n < 0));
+		n = n + n;
+	}
+	putchar('\n');
+}
+`
+
+// Multiplication, division and remainder wrap around and truncate like Go
+func TestArithmetic(t *testing.T) {
+	values := []int32{0, 1, -1, 2, -2, 7, -7, 10, -10, 12345, -54321, math.MaxInt32, math.MinInt32}
+
+	src := new(strings.Builder)
+	want := new(strings.Builder)
+	src.WriteString(printBits + "int main() {\n\tint a;\n\tint b;\n")
+	for _, a := range values {
+		for _, b := range values {
+			fmt.Fprintf(src, "\ta = %#x;\n\tb = %#x;\n\tbits(a * b);\n", uint32(a), uint32(b))
+			fmt.Fprintf(want, "%032b\n", uint32(a*b))
+			if b != 0 {
+				src.WriteString("\tbits(a / b);\n\tbits(a % b);\n")
+				fmt.Fprintf(want, "%032b\n%032b\n", uint32(a/b), uint32(a%b))
+			}
+		}
+	}
+	src.WriteString("}\n")
+
+	out, err := run(t, src.String(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, exp := strings.Split(out, "\n"), strings.Split(want.String(), "\n")
+	if len(got) != len(exp) {
+		t.Fatalf("%d results, want %d", len(got), len(exp))
+	}
+	for i := range exp {
+		if got[i] != exp[i] {
+			t.Errorf("result %d is %s, want %s", i, got[i], exp[i])
+		}
+	}
+}
+
+var programs = []struct {
+	name   string
+	src    string
+	input  string
+	output string
+	// Whether the program ends by executing ERR
+	err bool
+}{
+	{"division by zero", `
+int main() {
+	int z = 0;
+	putchar('a');
+	putchar(1 / z);
+	putchar('b');
+}`, "", "a", true},
+
+	{"remainder by zero", `
+int main() {
+	int z = getchar() - 'x';
+	putchar('a' + 7 % z);
+}`, "x", "", true},
+
+	{"break and continue", `
+int main() {
+	for (int i = 0; i < 10; i++) {
+		if (i == 2)
+			continue;
+		if (i == 6)
+			break;
+		putchar('0' + i);
+	}
+	putchar('|');
+	int i = 0;
+	while (1) {
+		i++;
+		int j = 0;
+		while (1) {
+			j++;
+			if (j > i)
+				break;
+			if (j == 2)
+				continue;
+			putchar('a' + j);
+		}
+		if (i < 3)
+			continue;
+		break;
+	}
+}`, "", "01345|bbbd", false},
+
+	{"short-circuit", `
+int t(int c) {
+	putchar(c);
+	return 7;
+}
+
+int f(int c) {
+	putchar(c);
+	return 0;
+}
+
+int main() {
+	if (f('a') && t('b'))
+		putchar('X');
+	if (t('c') || t('d'))
+		putchar('Y');
+	if (t('e') && f('g'))
+		putchar('Z');
+	int x = f('h') || t('i');
+	putchar('0' + x);
+	x = t('j') && t('k');
+	putchar('0' + x);
+	x = f('l') || f('m') || t('n');
+	putchar('0' + x);
+}`, "", "acYeghi1jk1lmn1", false},
+
+	{"input", `
+int main() {
+	int c;
+	while ((c = getchar()) != 0)
+		putchar(c - 'a' + 'A');
+}`, "hello", "HELLO", false},
+}
+
+func TestPrograms(t *testing.T) {
+	for _, p := range programs {
+		out, err := run(t, p.src, p.input)
+		if p.err {
+			if !errors.Is(err, ijvmemu.ErrErrInstruction) {
+				t.Errorf("%s: expected ERR, got %v", p.name, err)
+			}
+		} else if err != nil {
+			t.Errorf("%s: %v", p.name, err)
+		}
+		if out != p.output {
+			t.Errorf("%s: output %q, want %q", p.name, out, p.output)
+		}
+	}
+}
+
+var errorPrograms = []struct {
+	src string
+	// Prefix of the error
+	err string
+}{
+	{`int main() {
+    int x = 1
+    putchar(x);
+}`, "e.ic:3:5: expected `;`, found `putchar`"},
+
+	{`int main() {
+    putchar(y);
+}`, "e.ic:2:13: undefined variable y"},
+
+	{`int main() {
+    foo(1);
+}`, "e.ic:2:5: undefined function foo"},
+
+	{`int main() {
+    putchar(1, 2);
+}`, "e.ic:2:5: function putchar takes 1 argument(s), got 2"},
+
+	{`void f() {
+}
+
+int main() {
+    int x = f();
+}`, "e.ic:5:13: function f returns void"},
+
+	{`int main() {
+    if (1)
+        break;
+}`, "e.ic:3:9: break outside of a loop"},
+
+	{`int main() {
+    int x;
+    int x;
+}`, "e.ic:3:9: redeclaration of variable x"},
+
+	{`int main() {
+    return 0x100000000;
+}`, "e.ic:2:12: "},
+
+	{`int main() {
+    return 1 @ 2;
+}`, "e.ic:2:14: unexpected character '@'"},
+
+	{`int f() {
+    return 1;
+}`, "e.ic:1:1: missing function main"},
+}
+
+// Errors are located at the line and column of the source
+func TestErrors(t *testing.T) {
+	for _, p := range errorPrograms {
+		asm := ijvmasm.NewBuilder("e.ic", opconf.NewDefaultOpConfig())
+		err := ijvmc.CompileSource(asm, "e.ic", p.src)
+		var cerr *ijvmc.Error
+		if !errors.As(err, &cerr) {
+			t.Errorf("expected error %q, got %v", p.err, err)
+			continue
+		}
+		if !strings.HasPrefix(err.Error(), p.err) {
+			t.Errorf("error %q, want %q", err, p.err)
+		}
+	}
+}
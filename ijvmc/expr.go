@@ -0,0 +1,484 @@
+package ijvmc
+
+import (
+	"strings"
+
+	"github.com/BlackNovaTech/gojasm/ijvmasm"
+)
+
+// Evaluates constant expressions, wrapping around like IJVM arithmetic.
+// Divisions by zero are left to fail at run time.
+func constant(e expr) (int32, bool) {
+	switch e := e.(type) {
+	case *numberExpr:
+		return e.value, true
+	case *unaryExpr:
+		x, ok := constant(e.x)
+		if !ok {
+			return 0, false
+		}
+		switch e.op {
+		case "-":
+			return -x, true
+		case "~":
+			return ^x, true
+		case "!":
+			return truth(x == 0), true
+		}
+		return x, true
+	case *binaryExpr:
+		x, okx := constant(e.x)
+		y, oky := constant(e.y)
+		switch {
+		case okx && e.op == "&&" && x == 0:
+			return 0, true
+		case okx && e.op == "||" && x != 0:
+			return 1, true
+		case !okx || !oky:
+			return 0, false
+		}
+		switch e.op {
+		case "+":
+			return x + y, true
+		case "-":
+			return x - y, true
+		case "*":
+			return x * y, true
+		case "/", "%":
+			if y == 0 {
+				return 0, false
+			}
+			if e.op == "/" {
+				return x / y, true
+			}
+			return x % y, true
+		case "&":
+			return x & y, true
+		case "|":
+			return x | y, true
+		case "^":
+			return x ^ y, true
+		case "==":
+			return truth(x == y), true
+		case "!=":
+			return truth(x != y), true
+		case "<":
+			return truth(x < y), true
+		case "<=":
+			return truth(x <= y), true
+		case ">":
+			return truth(x > y), true
+		case ">=":
+			return truth(x >= y), true
+		case "&&":
+			return truth(y != 0), true
+		case "||":
+			return truth(y != 0), true
+		}
+	}
+	return 0, false
+}
+
+func truth(b bool) int32 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// Reports whether evaluating the expression assigns a variable
+func assigns(e expr) bool {
+	switch e := e.(type) {
+	case *assignExpr, *incExpr:
+		return true
+	case *unaryExpr:
+		return assigns(e.x)
+	case *binaryExpr:
+		return assigns(e.x) || assigns(e.y)
+	case *callExpr:
+		for _, arg := range e.args {
+			if assigns(arg) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Runtime functions implementing the operations IJVM lacks
+var runtimeOps = map[string]string{
+	"*": "__mul",
+	"/": "__div",
+	"%": "__mod",
+}
+
+// Simple operations that translate one to one
+var simpleOps = map[string]string{
+	"+": "IADD",
+	"-": "ISUB",
+	"&": "IAND",
+	"|": "IOR",
+}
+
+// Pushes the value of an expression
+func (f *function) value(e expr) {
+	if v, ok := constant(e); ok {
+		f.pushConstant(v)
+		return
+	}
+
+	switch e := e.(type) {
+	case *varExpr:
+		f.emitVar("ILOAD", f.lookup(e.name, e.pos))
+	case *unaryExpr:
+		switch e.op {
+		case "-":
+			f.emit("BIPUSH 0")
+			f.value(e.x)
+			f.emit("ISUB")
+		case "~":
+			// ~x = -1 - x
+			f.emit("BIPUSH -1")
+			f.value(e.x)
+			f.emit("ISUB")
+		case "!":
+			f.boolean(e)
+		default:
+			f.value(e.x)
+		}
+	case *binaryExpr:
+		if op, ok := simpleOps[e.op]; ok {
+			f.value(e.x)
+			f.value(e.y)
+			f.emit(op)
+			return
+		}
+		if name, ok := runtimeOps[e.op]; ok {
+			f.invoke(name, []expr{e.x, e.y})
+			return
+		}
+		if e.op == "^" {
+			// x ^ y = (x | y) - (x & y)
+			a, b := f.operands(e.x, e.y)
+			for _, op := range []string{"IOR", "IAND"} {
+				f.load(a)
+				f.load(b)
+				f.emit(op)
+			}
+			f.emit("ISUB")
+			return
+		}
+		f.boolean(e)
+	case *callExpr:
+		f.call(e, true)
+	case *assignExpr:
+		f.assign(e, true)
+	case *incExpr:
+		f.inc(e, true)
+	}
+}
+
+// Evaluates an expression for its side effects only
+func (f *function) effect(e expr) {
+	switch e := e.(type) {
+	case *callExpr:
+		f.call(e, false)
+	case *assignExpr:
+		f.assign(e, false)
+	case *incExpr:
+		f.inc(e, false)
+	default:
+		f.value(e)
+		f.emit("POP")
+	}
+}
+
+// Pushes an integer, using a constant if it does not fit in a byte
+func (f *function) pushConstant(v int32) {
+	if v >= -128 && v <= 127 {
+		f.emit("BIPUSH %d", v)
+		return
+	}
+	f.emit("LDC_W %s", f.c.constant(v, f.line))
+}
+
+// Compiles an invocation, pushing its result if keep is set
+func (f *function) call(e *callExpr, keep bool) {
+	_, void := f.c.signature(e)
+	if void && keep {
+		f.c.errorf(e.pos, "function %s returns void, its value cannot be used", e.name)
+	}
+
+	b, ok := builtins[e.name]
+	if !ok {
+		f.invoke(e.name, e.args)
+		if !keep {
+			f.emit("POP")
+		}
+		return
+	}
+	for _, arg := range e.args {
+		f.value(arg)
+	}
+	// putchar evaluates to the character written
+	if b.op == "OUT" && keep {
+		f.emit("DUP")
+	}
+	f.emit(b.op)
+	if b.op == "IN" && !keep {
+		f.emit("POP")
+	}
+}
+
+// Invokes a function, pushing its result
+func (f *function) invoke(name string, args []expr) {
+	if reserved(name) {
+		f.c.used[name] = true
+	}
+	f.emit("BIPUSH 0")
+	for _, arg := range args {
+		f.value(arg)
+	}
+	f.emit("%s %s", ijvmasm.OperationInvoke, name)
+}
+
+// Compiles an assignment, pushing the assigned value if keep is set
+func (f *function) assign(e *assignExpr, keep bool) {
+	name := f.lookup(e.name, e.pos)
+	x := e.x
+	if e.op != "=" {
+		op := strings.TrimSuffix(e.op, "=")
+		if v, ok := constant(e.x); ok && !keep && (op == "+" || op == "-") {
+			if op == "-" {
+				v = -v
+			}
+			if v >= -128 && v <= 127 {
+				f.emitVar("IINC", name, v)
+				return
+			}
+		}
+		x = &binaryExpr{pos: e.pos, op: op, x: &varExpr{pos: e.pos, name: e.name}, y: e.x}
+	}
+	f.value(x)
+	if keep {
+		f.emit("DUP")
+	}
+	f.emitVar("ISTORE", name)
+}
+
+// Compiles an increment or decrement, pushing its value if keep is set
+func (f *function) inc(e *incExpr, keep bool) {
+	name := f.lookup(e.name, e.pos)
+	if keep && !e.prefix {
+		f.emitVar("ILOAD", name)
+	}
+	f.emitVar("IINC", name, e.delta)
+	if keep && e.prefix {
+		f.emitVar("ILOAD", name)
+	}
+}
+
+// Pushes 1 if the condition holds, and 0 otherwise
+func (f *function) boolean(e expr) {
+	no, end := f.newLabel("false"), f.newLabel("endbool")
+	f.jump(e, no, false)
+	f.emit("BIPUSH 1")
+	f.emit("GOTO %s", end)
+	f.place(no)
+	f.emit("BIPUSH 0")
+	f.place(end)
+}
+
+// Jumps to target if the truth of the condition equals when, and falls through otherwise
+func (f *function) jump(e expr, target string, when bool) {
+	if v, ok := constant(e); ok {
+		if (v != 0) == when {
+			f.emit("GOTO %s", target)
+		}
+		return
+	}
+
+	switch e := e.(type) {
+	case *unaryExpr:
+		if e.op == "!" {
+			f.jump(e.x, target, !when)
+			return
+		}
+	case *binaryExpr:
+		switch e.op {
+		case "&&", "||":
+			// Jumping if x || y holds is jumping if x holds or y holds,
+			// and likewise for x && y not holding
+			if (e.op == "||") == when {
+				f.jump(e.x, target, when)
+				f.jump(e.y, target, when)
+				return
+			}
+			skip := f.newLabel("skip")
+			f.jump(e.x, skip, !when)
+			f.jump(e.y, target, when)
+			f.place(skip)
+			return
+		case "==", "!=":
+			f.equal(e.x, e.y, target, when == (e.op == "=="))
+			return
+		case "<", ">", "<=", ">=":
+			f.compare(e, target, when)
+			return
+		}
+	}
+	f.value(e)
+	f.branch("IFEQ", target, !when)
+}
+
+// Emits a conditional branch to target if its condition equals when
+func (f *function) branch(op, target string, when bool) {
+	if when {
+		f.emit("%s %s", op, target)
+		return
+	}
+	skip := f.newLabel("skip")
+	f.emit("%s %s", op, skip)
+	f.emit("GOTO %s", target)
+	f.place(skip)
+}
+
+// Jumps to target if x == y equals when
+func (f *function) equal(x, y expr, target string, when bool) {
+	a, aok := constant(x)
+	b, bok := constant(y)
+	op := "IFEQ"
+	switch {
+	case bok && b == 0:
+		f.value(x)
+	case aok && a == 0:
+		f.value(y)
+	default:
+		f.value(x)
+		f.value(y)
+		op = "IF_ICMPEQ"
+	}
+	f.branch(op, target, when)
+}
+
+// Jumps to target if the ordering comparison equals when
+func (f *function) compare(e *binaryExpr, target string, when bool) {
+	// Comparing with zero only needs the sign
+	if v, ok := constant(e.y); ok && v == 0 && (e.op == "<" || e.op == ">=") {
+		f.value(e.x)
+		f.branch("IFLT", target, when == (e.op == "<"))
+		return
+	}
+	if v, ok := constant(e.x); ok && v == 0 && (e.op == ">" || e.op == "<=") {
+		f.value(e.y)
+		f.branch("IFLT", target, when == (e.op == ">"))
+		return
+	}
+
+	a, b := f.operands(e.x, e.y)
+	switch e.op {
+	case "<":
+		f.less(a, b, target, when)
+	case ">":
+		f.less(b, a, target, when)
+	case "<=":
+		f.less(b, a, target, !when)
+	case ">=":
+		f.less(a, b, target, !when)
+	}
+}
+
+// An operand of a comparison or xor, which is read after both operands are evaluated
+type operand struct {
+	variable string
+	value    int32
+	constant bool
+}
+
+// Evaluates the operands x and y, left to right. Constants and variables are
+// read in place, other operands are stored in temporary variables.
+func (f *function) operands(x, y expr) (operand, operand) {
+	read := func(e expr, idx int, direct bool) (operand, bool) {
+		if v, ok := constant(e); ok {
+			return operand{value: v, constant: true}, false
+		}
+		if v, ok := e.(*varExpr); ok && direct {
+			return operand{variable: f.lookup(v.name, v.pos)}, false
+		}
+		f.value(e)
+		f.temps = true
+		return operand{variable: temp(idx)}, true
+	}
+	// A variable read after evaluating y must not be assigned by y
+	a, pusheda := read(x, 0, !assigns(y))
+	b, pushedb := read(y, 1, true)
+	if pushedb {
+		f.emitVar("ISTORE", b.variable)
+	}
+	if pusheda {
+		f.emitVar("ISTORE", a.variable)
+	}
+	return a, b
+}
+
+func (f *function) load(o operand) {
+	if o.constant {
+		f.pushConstant(o.value)
+		return
+	}
+	f.emitVar("ILOAD", o.variable)
+}
+
+// Jumps to target if a < b equals when. Only values of the same sign are
+// subtracted, so the comparison never overflows.
+func (f *function) less(a, b operand, target string, when bool) {
+	local := f.newLabel("skip")
+	yes, no := target, local
+	if !when {
+		yes, no = local, target
+	}
+
+	switch {
+	case b.constant && b.value > 0:
+		// Negative values are less
+		f.load(a)
+		f.emit("IFLT %s", yes)
+	case b.constant:
+		// Values of at least zero are not less
+		same := f.newLabel("same")
+		f.load(a)
+		f.emit("IFLT %s", same)
+		f.emit("GOTO %s", no)
+		f.place(same)
+	case a.constant && a.value >= 0:
+		f.load(b)
+		f.emit("IFLT %s", no)
+	case a.constant:
+		same := f.newLabel("same")
+		f.load(b)
+		f.emit("IFLT %s", same)
+		f.emit("GOTO %s", yes)
+		f.place(same)
+	default:
+		negative, same := f.newLabel("negative"), f.newLabel("same")
+		f.load(a)
+		f.emit("IFLT %s", negative)
+		f.load(b)
+		f.emit("IFLT %s", no)
+		f.emit("GOTO %s", same)
+		f.place(negative)
+		f.load(b)
+		f.emit("IFLT %s", same)
+		f.emit("GOTO %s", yes)
+		f.place(same)
+	}
+
+	f.load(a)
+	f.load(b)
+	f.emit("ISUB")
+	f.emit("IFLT %s", yes)
+	if no != local {
+		f.emit("GOTO %s", no)
+	}
+	f.place(local)
+}
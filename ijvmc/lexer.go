@@ -0,0 +1,198 @@
+package ijvmc
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/BlackNovaTech/gojasm/parsers"
+)
+
+// A position in the source
+type pos struct {
+	line, col int
+}
+
+func (p pos) position() pos {
+	return p
+}
+
+// Kinds of tokens
+const (
+	tokEOF = iota
+	tokIdent
+	tokKeyword
+	tokNumber
+	tokPunct
+)
+
+type token struct {
+	kind int
+	text string
+	// Value of number and character literals
+	value int32
+	pos
+}
+
+var keywords = map[string]bool{
+	"int":      true,
+	"void":     true,
+	"if":       true,
+	"else":     true,
+	"while":    true,
+	"for":      true,
+	"return":   true,
+	"break":    true,
+	"continue": true,
+}
+
+// Punctuation, longest first so the longest match wins
+var punctuation = []string{
+	"==", "!=", "<=", ">=", "&&", "||", "++", "--",
+	"+=", "-=", "*=", "/=", "%=", "&=", "|=", "^=",
+	"+", "-", "*", "/", "%", "&", "|", "^", "~", "!", "<", ">", "=",
+	"(", ")", "{", "}", ",", ";",
+}
+
+// Escape sequences of character literals
+var escapes = map[byte]int32{
+	'n':  '\n',
+	't':  '\t',
+	'r':  '\r',
+	'0':  0,
+	'\\': '\\',
+	'\'': '\'',
+	'"':  '"',
+}
+
+// Splits source code into tokens
+type lexer struct {
+	src  string
+	off  int
+	line int
+	col  int
+	file string
+}
+
+func newLexer(file, src string) *lexer {
+	return &lexer{src: src, line: 1, col: 1, file: file}
+}
+
+func (l *lexer) errorf(p pos, format string, args ...interface{}) {
+	panic(&Error{File: l.file, Line: p.line, Col: p.col, Msg: fmt.Sprintf(format, args...)})
+}
+
+// Advances n bytes, keeping track of the line and column
+func (l *lexer) skip(n int) {
+	for ; n > 0 && l.off < len(l.src); n-- {
+		if l.src[l.off] == '\n' {
+			l.line++
+			l.col = 0
+		}
+		l.off++
+		l.col++
+	}
+}
+
+// Skips whitespace and comments
+func (l *lexer) space() {
+	for l.off < len(l.src) {
+		rest := l.src[l.off:]
+		switch {
+		case strings.ContainsRune(" \t\r\n", rune(rest[0])):
+			l.skip(1)
+		case strings.HasPrefix(rest, "//"):
+			end := strings.IndexByte(rest, '\n')
+			if end < 0 {
+				end = len(rest)
+			}
+			l.skip(end)
+		case strings.HasPrefix(rest, "/*"):
+			start := pos{l.line, l.col}
+			end := strings.Index(rest[2:], "*/")
+			if end < 0 {
+				l.errorf(start, "unterminated comment")
+			}
+			l.skip(end + 4)
+		default:
+			return
+		}
+	}
+}
+
+func isLetter(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_'
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// Returns the next token
+func (l *lexer) next() token {
+	l.space()
+	t := token{pos: pos{l.line, l.col}}
+	if l.off >= len(l.src) {
+		return t
+	}
+
+	rest := l.src[l.off:]
+	c := rest[0]
+	switch {
+	case isLetter(c) || isDigit(c):
+		n := 1
+		for n < len(rest) && (isLetter(rest[n]) || isDigit(rest[n])) {
+			n++
+		}
+		t.text = rest[:n]
+		switch {
+		case isDigit(c):
+			t.kind = tokNumber
+			// Literals up to 0xFFFFFFFF are allowed, wrapping around like C
+			v, err := parsers.ParseUint32(t.text)
+			if err != nil {
+				l.errorf(t.pos, "%s", err)
+			}
+			t.value = int32(v)
+		case keywords[t.text]:
+			t.kind = tokKeyword
+		default:
+			t.kind = tokIdent
+		}
+		l.skip(n)
+	case c == '\'':
+		t.kind = tokNumber
+		t.value, t.text = l.char(t.pos, rest)
+	default:
+		for _, p := range punctuation {
+			if strings.HasPrefix(rest, p) {
+				t.kind, t.text = tokPunct, p
+				l.skip(len(p))
+				return t
+			}
+		}
+		l.errorf(t.pos, "unexpected character %q", c)
+	}
+	return t
+}
+
+// Reads a character literal
+func (l *lexer) char(p pos, rest string) (int32, string) {
+	n, value := 2, int32(0)
+	switch {
+	case len(rest) < 3 || rest[1] == '\n' || rest[1] == '\'':
+		l.errorf(p, "invalid character literal")
+	case rest[1] == '\\':
+		v, ok := escapes[rest[2]]
+		if !ok {
+			l.errorf(p, "invalid escape sequence \\%c", rest[2])
+		}
+		n, value = 3, v
+	default:
+		value = int32(rest[1])
+	}
+	if n >= len(rest) || rest[n] != '\'' {
+		l.errorf(p, "invalid character literal")
+	}
+	l.skip(n + 1)
+	return value, rest[:n+1]
+}
@@ -0,0 +1,321 @@
+package ijvmc
+
+import (
+	"fmt"
+)
+
+// Parses source code into function declarations
+type parser struct {
+	lex *lexer
+	tok token
+}
+
+func newParser(file, src string) *parser {
+	p := &parser{lex: newLexer(file, src)}
+	p.next()
+	return p
+}
+
+func (p *parser) next() {
+	p.tok = p.lex.next()
+}
+
+func (p *parser) errorf(at pos, format string, args ...interface{}) {
+	p.lex.errorf(at, format, args...)
+}
+
+// Describes the current token for errors
+func (p *parser) found() string {
+	if p.tok.kind == tokEOF {
+		return "end of file"
+	}
+	return fmt.Sprintf("`%s`", p.tok.text)
+}
+
+// Reports whether the current token is the given keyword or punctuation
+func (p *parser) is(text string) bool {
+	return (p.tok.kind == tokKeyword || p.tok.kind == tokPunct) && p.tok.text == text
+}
+
+// Consumes the current token if it is the given keyword or punctuation
+func (p *parser) accept(text string) bool {
+	if p.is(text) {
+		p.next()
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(text string) pos {
+	at := p.tok.pos
+	if !p.accept(text) {
+		p.errorf(at, "expected `%s`, found %s", text, p.found())
+	}
+	return at
+}
+
+func (p *parser) ident() (string, pos) {
+	t := p.tok
+	if t.kind != tokIdent {
+		p.errorf(t.pos, "expected identifier, found %s", p.found())
+	}
+	p.next()
+	return t.text, t.pos
+}
+
+// Parses a whole program
+func (p *parser) program() []*funcDecl {
+	var funcs []*funcDecl
+	for p.tok.kind != tokEOF {
+		funcs = append(funcs, p.function())
+	}
+	return funcs
+}
+
+// function = ("int" | "void") ident "(" ["void" | "int" ident {"," "int" ident}] ")" block
+func (p *parser) function() *funcDecl {
+	f := &funcDecl{pos: p.tok.pos}
+	switch {
+	case p.accept("void"):
+		f.void = true
+	case p.accept("int"):
+	default:
+		p.errorf(p.tok.pos, "expected function declaration, found %s", p.found())
+	}
+	f.name, _ = p.ident()
+	if !p.is("(") {
+		p.errorf(p.tok.pos, "expected `(` after function name, global variables are not supported")
+	}
+	p.next()
+	if !p.accept("void") && !p.is(")") {
+		for {
+			p.expect("int")
+			name, at := p.ident()
+			f.params = append(f.params, &param{pos: at, name: name})
+			if !p.accept(",") {
+				break
+			}
+		}
+	}
+	p.expect(")")
+	f.body = p.block()
+	return f
+}
+
+// block = "{" {statement | declaration} "}"
+func (p *parser) block() *blockStmt {
+	b := &blockStmt{pos: p.expect("{")}
+	for !p.is("}") {
+		if p.tok.kind == tokEOF {
+			p.errorf(p.tok.pos, "expected `}`, found end of file")
+		}
+		if p.is("int") {
+			b.stmts = append(b.stmts, p.declaration()...)
+			continue
+		}
+		b.stmts = append(b.stmts, p.statement())
+	}
+	b.end = p.expect("}")
+	return b
+}
+
+// declaration = "int" ident ["=" expr] {"," ident ["=" expr]} ";"
+func (p *parser) declaration() []stmt {
+	p.expect("int")
+	var decls []stmt
+	for {
+		name, at := p.ident()
+		d := &declStmt{pos: at, name: name}
+		if p.accept("=") {
+			d.init = p.expr()
+		}
+		decls = append(decls, d)
+		if !p.accept(",") {
+			break
+		}
+	}
+	p.expect(";")
+	return decls
+}
+
+func (p *parser) statement() stmt {
+	at := p.tok.pos
+	switch {
+	case p.is("{"):
+		return p.block()
+	case p.is("int"):
+		p.errorf(at, "a declaration is not allowed here, use a block")
+	case p.accept(";"):
+		return &blockStmt{pos: at, end: at}
+	case p.accept("if"):
+		s := &ifStmt{pos: at, cond: p.condition()}
+		s.then = p.statement()
+		if p.accept("else") {
+			s.els = p.statement()
+		}
+		return s
+	case p.accept("while"):
+		s := &whileStmt{pos: at, cond: p.condition()}
+		s.body = p.statement()
+		return s
+	case p.accept("for"):
+		return p.forStatement(at)
+	case p.accept("return"):
+		s := &returnStmt{pos: at}
+		if !p.is(";") {
+			s.x = p.expr()
+		}
+		p.expect(";")
+		return s
+	case p.is("break"), p.is("continue"):
+		s := &branchStmt{pos: at, keyword: p.tok.text}
+		p.next()
+		p.expect(";")
+		return s
+	}
+	s := &exprStmt{pos: at, x: p.expr()}
+	p.expect(";")
+	return s
+}
+
+// Parses a parenthesized condition
+func (p *parser) condition() expr {
+	p.expect("(")
+	x := p.expr()
+	p.expect(")")
+	return x
+}
+
+// for = "for" "(" [declaration | expr ";"] [expr] ";" [expr] ")" statement
+func (p *parser) forStatement(at pos) stmt {
+	s := &forStmt{pos: at}
+	p.expect("(")
+	switch {
+	case p.is("int"):
+		s.init = p.declaration()
+	case p.accept(";"):
+	default:
+		s.init = []stmt{&exprStmt{pos: p.tok.pos, x: p.expr()}}
+		p.expect(";")
+	}
+	if !p.is(";") {
+		s.cond = p.expr()
+	}
+	p.expect(";")
+	if !p.is(")") {
+		s.post = p.expr()
+	}
+	p.expect(")")
+	s.body = p.statement()
+	return s
+}
+
+// Binary operators by precedence level, from the loosest binding level
+var binaryLevels = [][]string{
+	{"||"},
+	{"&&"},
+	{"|"},
+	{"^"},
+	{"&"},
+	{"==", "!="},
+	{"<", "<=", ">", ">="},
+	{"+", "-"},
+	{"*", "/", "%"},
+}
+
+var assignOps = map[string]bool{
+	"=": true, "+=": true, "-=": true, "*=": true, "/=": true,
+	"%=": true, "&=": true, "|=": true, "^=": true,
+}
+
+// expr = binary [assignop expr]
+func (p *parser) expr() expr {
+	x := p.binary(0)
+	if p.tok.kind != tokPunct || !assignOps[p.tok.text] {
+		return x
+	}
+	op, at := p.tok.text, p.tok.pos
+	v, ok := x.(*varExpr)
+	if !ok {
+		p.errorf(at, "cannot assign to an expression, only to a variable")
+	}
+	p.next()
+	return &assignExpr{pos: at, op: op, name: v.name, x: p.expr()}
+}
+
+// Parses left associative binary operations of the given precedence level or higher
+func (p *parser) binary(level int) expr {
+	if level == len(binaryLevels) {
+		return p.unary()
+	}
+	x := p.binary(level + 1)
+	for {
+		op, at := p.tok.text, p.tok.pos
+		matched := false
+		for _, o := range binaryLevels[level] {
+			matched = matched || p.is(o)
+		}
+		if !matched {
+			return x
+		}
+		p.next()
+		x = &binaryExpr{pos: at, op: op, x: x, y: p.binary(level + 1)}
+	}
+}
+
+// unary = ("-" | "+" | "~" | "!") unary | ("++" | "--") ident | postfix
+func (p *parser) unary() expr {
+	op, at := p.tok.text, p.tok.pos
+	switch {
+	case p.accept("-"), p.accept("+"), p.accept("~"), p.accept("!"):
+		return &unaryExpr{pos: at, op: op, x: p.unary()}
+	case p.accept("++"), p.accept("--"):
+		name, _ := p.ident()
+		return &incExpr{pos: at, name: name, delta: delta(op), prefix: true}
+	}
+	return p.postfix()
+}
+
+func delta(op string) int32 {
+	if op == "++" {
+		return 1
+	}
+	return -1
+}
+
+// postfix = number | ident ["(" [expr {"," expr}] ")" | "++" | "--"] | "(" expr ")"
+func (p *parser) postfix() expr {
+	t := p.tok
+	switch {
+	case t.kind == tokNumber:
+		p.next()
+		return &numberExpr{pos: t.pos, value: t.value}
+	case t.kind == tokIdent:
+		p.next()
+		switch {
+		case p.accept("("):
+			call := &callExpr{pos: t.pos, name: t.text}
+			if !p.accept(")") {
+				for {
+					call.args = append(call.args, p.expr())
+					if !p.accept(",") {
+						break
+					}
+				}
+				p.expect(")")
+			}
+			return call
+		case p.is("++"), p.is("--"):
+			op := p.tok.text
+			p.next()
+			return &incExpr{pos: t.pos, name: t.text, delta: delta(op)}
+		}
+		return &varExpr{pos: t.pos, name: t.text}
+	case p.accept("("):
+		x := p.expr()
+		p.expect(")")
+		return x
+	}
+	p.errorf(t.pos, "expected expression, found %s", p.found())
+	return nil
+}
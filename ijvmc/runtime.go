@@ -0,0 +1,64 @@
+package ijvmc
+
+// File name of the runtime in errors
+const runtimeFile = "<runtime>"
+
+// Runtime functions implementing multiplication, division and remainder,
+// which are only added to programs using them. Their code is not attributed
+// to any source line.
+const runtimeSource = `
+// Shift-and-add multiplication over the 32 bits of b, from the most significant bit
+int __mul(int a, int b) {
+	int r = 0;
+	for (int i = 0; i < 32; i++) {
+		r = r + r;
+		if (b < 0)
+			r = r + a;
+		b = b + b;
+	}
+	return r;
+}
+
+int __div(int a, int b) {
+	return __divmod(a, b, 0);
+}
+
+int __mod(int a, int b) {
+	return __divmod(a, b, 1);
+}
+
+// Truncating division, or the remainder having the sign of a. The magnitudes
+// are negated, so the minimum integer does not overflow.
+int __divmod(int a, int b, int mod) {
+	if (b == 0)
+		__err();
+	int x = a;
+	if (x >= 0)
+		x = -x;
+	int y = b;
+	if (y >= 0)
+		y = -y;
+
+	// Subtract the largest multiple of y by a power of two fitting in x
+	int q = 0;
+	while (x <= y) {
+		int d = y;
+		int p = 1;
+		while (d >= -0x40000000 && d + d >= x) {
+			d = d + d;
+			p = p + p;
+		}
+		x = x - d;
+		q = q + p;
+	}
+
+	if (mod) {
+		if (a < 0)
+			return x;
+		return -x;
+	}
+	if ((a < 0) != (b < 0))
+		return -q;
+	return q;
+}
+`
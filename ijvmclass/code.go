@@ -0,0 +1,52 @@
+package ijvmclass
+
+import "bytes"
+
+// code is the bytecode of a method being emitted.
+type code struct {
+	buf bytes.Buffer
+	// IJVM byte offset branched to by the branch at each offset
+	branches map[int]uint32
+	// Offset of every emitted instruction, by IJVM byte offset
+	offsets map[uint32]int
+	// Operand stack depth of the stack map frame at each offset
+	frames map[int]int
+	// Source line of the instructions starting at an offset
+	lines [][2]uint16
+	// Set iff the last written instruction does not continue with the next
+	unreachable bool
+}
+
+func newCode() *code {
+	return &code{
+		branches: make(map[int]uint32),
+		offsets:  make(map[uint32]int),
+		frames:   make(map[int]int),
+	}
+}
+
+// Writes an opcode followed by its single byte operands
+func (c *code) op(ops ...byte) {
+	c.buf.Write(ops)
+}
+
+func (c *code) u2(v uint16) {
+	c.buf.Write(u2(v))
+}
+
+// Writes a branch to the instruction at the given IJVM byte offset, its
+// offset is filled in once every instruction is written
+func (c *code) branch(op byte, target uint32) {
+	c.branches[c.buf.Len()] = target
+	c.op(op, 0, 0)
+}
+
+// Writes a load or store of a local variable, widened when needed
+func (c *code) local(op byte, idx int, wide bool) {
+	if wide || idx > 0xFF {
+		c.op(opWide, op)
+		c.u2(uint16(idx))
+		return
+	}
+	c.op(op, byte(idx))
+}
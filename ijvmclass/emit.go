@@ -0,0 +1,643 @@
+// Package ijvmclass emits IJVM programs as Java class files.
+package ijvmclass
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/BlackNovaTech/gojasm/ijvmasm"
+	"github.com/BlackNovaTech/gojasm/ijvmemu"
+)
+
+// Magic is the magic header of every class file.
+const Magic = 0xCAFEBABE
+
+// Class file version 52.0 (Java 8), the first requiring StackMapTable frames
+const (
+	majorVersion = 52
+	minorVersion = 0
+)
+
+// Access flags
+const (
+	accPublic  = 0x0001
+	accPrivate = 0x0002
+	accStatic  = 0x0008
+	accSuper   = 0x0020
+)
+
+// Verification types of stack map frames
+const (
+	verifyInteger = 1
+	verifyObject  = 7
+)
+
+// A method of the emitted class
+type method struct {
+	*ijvmemu.Method
+	name string
+	desc string
+	main bool
+	code []*ijvmemu.Instr
+	// Errors of the byte offsets execution continues at that failed to decode
+	faults map[uint32]error
+	// JVM local variable slot of the first IJVM local variable
+	base int
+	// Amount of IJVM local variables
+	size int
+}
+
+type emitter struct {
+	prog    *ijvmemu.Program
+	class   string
+	cp      *constantPool
+	methods []*method
+	byAddr  map[uint32]*method
+	names   map[string]bool
+	// The method_info structures of the class
+	buf   bytes.Buffer
+	count int
+}
+
+// Emit writes a Java class file executing the given IJVM program to out. The
+// class has the given binary name, e.g. "Caesar" or "org/example/Caesar".
+//
+// Main becomes the main method of the class, every other IJVM method a public
+// static method taking the object reference and its parameters as ints. The
+// constants of the program are added to the constant pool, and IN, OUT, HALT
+// and ERR call small helper methods of the class reading System.in and
+// writing System.out. Only the operations of the default configuration, and
+// TAILCALL, can be emitted. The operand stack depth of every instruction must
+// be the same on all paths reaching it, as required by the JVM verifier.
+func Emit(prog *ijvmemu.Program, class string, out io.Writer) error {
+	if !validClassName(class) {
+		return fmt.Errorf("invalid class name %q", class)
+	}
+
+	e := &emitter{
+		prog:   prog,
+		class:  class,
+		cp:     newConstantPool(),
+		byAddr: make(map[uint32]*method),
+		names:  map[string]bool{"main": true},
+	}
+	for _, h := range helpers {
+		e.names[h.name] = true
+	}
+
+	this := e.cp.class(class)
+	super := e.cp.class("java/lang/Object")
+	for _, c := range prog.Constants {
+		e.cp.integer(c)
+	}
+
+	if err := e.discover(); err != nil {
+		return err
+	}
+	for _, m := range e.methods {
+		if err := e.method(m); err != nil {
+			return fmt.Errorf("method %s: %s", m.Name, err)
+		}
+	}
+	for _, h := range helpers {
+		h.emit(e, h)
+	}
+
+	var attrs [][]byte
+	if prog.Debug != nil {
+		attrs = append(attrs, e.attribute("SourceFile", u2(e.cp.utf8(filepath.Base(prog.Debug.File)))))
+	}
+
+	b := new(bytes.Buffer)
+	b.Write(u4(Magic))
+	b.Write(u2(minorVersion))
+	b.Write(u2(majorVersion))
+	if err := e.cp.writeTo(b); err != nil {
+		return err
+	}
+	b.Write(u2(accPublic | accSuper))
+	b.Write(u2(this))
+	b.Write(u2(super))
+	b.Write(u2(0)) // interfaces
+	b.Write(u2(0)) // fields
+	b.Write(u2(uint16(e.count)))
+	b.Write(e.buf.Bytes())
+	b.Write(u2(uint16(len(attrs))))
+	for _, attr := range attrs {
+		b.Write(attr)
+	}
+
+	_, err := out.Write(b.Bytes())
+	return err
+}
+
+// Reports whether name is a valid binary class name
+func validClassName(name string) bool {
+	for _, part := range strings.Split(name, "/") {
+		if part == "" || strings.ContainsAny(part, ".;[<>") {
+			return false
+		}
+	}
+	return true
+}
+
+// Finds every method reachable from main, decodes their instructions and
+// adds their references to the constant pool
+func (e *emitter) discover() error {
+	methods, err := e.prog.Reachable()
+	if err != nil {
+		return err
+	}
+
+	for _, m := range methods {
+		code, faults := e.prog.Code(m)
+		em := &method{
+			Method: m,
+			main:   m.Addr == 0,
+			code:   code,
+			faults: faults,
+			size:   m.NumParams + m.NumLocals,
+		}
+
+		if em.main {
+			em.name = "main"
+			em.desc = "([Ljava/lang/String;)V"
+			// Slot 0 holds the arguments of main, and main has no header
+			// declaring its amount of locals, so it grows as needed
+			em.base = 1
+			for _, inst := range code {
+				if isLocal(inst) && int(inst.Operands[0]) >= em.size {
+					em.size = int(inst.Operands[0]) + 1
+				}
+			}
+		} else {
+			if m.NumParams > 255 {
+				return fmt.Errorf("method %s: more than 255 parameters", m.Name)
+			}
+			em.name = e.name(m.Name)
+			em.desc = "(" + strings.Repeat("I", m.NumParams) + ")I"
+		}
+		if em.base+em.size > 0xFFFF {
+			return fmt.Errorf("method %s: more than 65535 local variables", m.Name)
+		}
+
+		e.cp.methodref(e.class, em.name, em.desc)
+		e.byAddr[m.Addr] = em
+		e.methods = append(e.methods, em)
+	}
+	return nil
+}
+
+// Returns a unique JVM method name for the given IJVM method name
+func (e *emitter) name(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if strings.ContainsRune(".;[/<>", r) || r > 0x7F {
+			return '_'
+		}
+		return r
+	}, name)
+
+	unique := name
+	for i := 2; e.names[unique]; i++ {
+		unique = fmt.Sprintf("%s%d", name, i)
+	}
+	e.names[unique] = true
+	return unique
+}
+
+// Reports whether the instruction accesses a local variable
+func isLocal(inst *ijvmemu.Instr) bool {
+	switch inst.Op.Name {
+	case "ILOAD", "ISTORE", "IINC":
+		return true
+	}
+	return false
+}
+
+// Returns the amount of operand stack words popped and pushed by the instruction
+func (e *emitter) effect(m *method, inst *ijvmemu.Instr) (int, int, error) {
+	switch inst.Op.Name {
+	case "INVOKEVIRTUAL", ijvmasm.OperationTailCall:
+		callee, err := e.callee(inst)
+		if err != nil {
+			return 0, 0, err
+		}
+		return callee.NumParams, 1, nil
+	case ijvmasm.OperationReturn:
+		if m.main {
+			return 0, 0, nil
+		}
+		return 1, 0, nil
+	}
+
+	if pop, push, ok := ijvmasm.StackEffect(inst.Op.Name); ok {
+		return pop, push, nil
+	}
+	return 0, 0, fmt.Errorf("operation %s cannot be emitted", inst.Op.Name)
+}
+
+// Returns the method invoked by the instruction
+func (e *emitter) callee(inst *ijvmemu.Instr) (*method, error) {
+	addr, ok := e.prog.Invoked(inst)
+	if !ok {
+		return nil, fmt.Errorf("constant index %d out of range", inst.Operands[0])
+	}
+	return e.byAddr[addr], nil
+}
+
+// Computes the operand stack depth before every reachable instruction of the
+// method, and the maximum depth
+func (e *emitter) depths(m *method) (map[uint32]int, int, error) {
+	byPC := make(map[uint32]*ijvmemu.Instr, len(m.code))
+	for _, inst := range m.code {
+		byPC[inst.PC] = inst
+	}
+
+	depths := make(map[uint32]int)
+	var work []uint32
+	visit := func(pc uint32, depth int) error {
+		if byPC[pc] == nil {
+			return nil
+		}
+		if old, ok := depths[pc]; ok {
+			if old != depth {
+				return fmt.Errorf("pc %d: operand stack depth differs between paths (%d and %d)", pc, old, depth)
+			}
+			return nil
+		}
+		depths[pc] = depth
+		work = append(work, pc)
+		return nil
+	}
+
+	if err := visit(m.Start, 0); err != nil {
+		return nil, 0, err
+	}
+	max := 0
+	for len(work) > 0 {
+		pc := work[len(work)-1]
+		work = work[:len(work)-1]
+		inst := byPC[pc]
+
+		pops, pushes, err := e.effect(m, inst)
+		if err != nil {
+			return nil, 0, fmt.Errorf("pc %d: %s", pc, err)
+		}
+		depth := depths[pc]
+		if depth < pops {
+			return nil, 0, fmt.Errorf("pc %d: %s underflows the operand stack", pc, inst.Op.Name)
+		}
+		depth += pushes - pops
+		if depth+pops > max {
+			max = depth + pops
+		}
+
+		if isBranch(inst) {
+			if err := visit(uint32(inst.Operands[0]), depth); err != nil {
+				return nil, 0, err
+			}
+		}
+		if !ijvmasm.Terminates(inst.Op.Name) {
+			if err := visit(pc+inst.Size, depth); err != nil {
+				return nil, 0, err
+			}
+		}
+	}
+	return depths, max, nil
+}
+
+// JVM opcodes of the branching operations
+var branches = map[string]byte{
+	"GOTO":      opGoto,
+	"IFEQ":      opIfeq,
+	"IFLT":      opIflt,
+	"IF_ICMPEQ": opIfIcmpeq,
+}
+
+// JVM opcodes of the operations without operands that translate one to one
+var simple = map[string]byte{
+	"NOP":  opNop,
+	"DUP":  opDup,
+	"POP":  opPop,
+	"SWAP": opSwap,
+	"IADD": opIadd,
+	"ISUB": opIsub,
+	"IAND": opIand,
+	"IOR":  opIor,
+}
+
+// Reports whether the instruction branches to its label operand
+func isBranch(inst *ijvmemu.Instr) bool {
+	_, ok := branches[inst.Op.Name]
+	return ok
+}
+
+// Writes the method_info of an IJVM method
+func (e *emitter) method(m *method) error {
+	depths, max, err := e.depths(m)
+	if err != nil {
+		return err
+	}
+
+	c := newCode()
+	// IJVM initializes local variables to zero, the JVM requires them to be assigned
+	first := m.NumParams
+	if m.main {
+		first = 0
+	}
+	for i := first; i < m.size; i++ {
+		c.op(opIconst0)
+		c.local(opIstore, m.base+i, false)
+	}
+
+	if len(m.code) == 0 {
+		e.continueAt(m, c, m.Start)
+	}
+	for i, inst := range m.code {
+		depth, live := depths[inst.PC]
+		if !live {
+			continue
+		}
+		if c.unreachable {
+			c.frames[c.buf.Len()] = depth
+			c.unreachable = false
+		}
+		c.offsets[inst.PC] = c.buf.Len()
+		if line := e.prog.Line(inst.PC); line > 0 && line <= 0xFFFF {
+			c.lines = append(c.lines, [2]uint16{uint16(c.buf.Len()), uint16(line)})
+		}
+
+		if err := e.instruction(m, c, inst); err != nil {
+			return fmt.Errorf("pc %d: %s", inst.PC, err)
+		}
+		if ijvmasm.Terminates(inst.Op.Name) {
+			c.unreachable = true
+			continue
+		}
+
+		next := inst.PC + inst.Size
+		if i+1 < len(m.code) && m.code[i+1].PC == next {
+			continue
+		}
+		e.continueAt(m, c, next)
+	}
+
+	for at, target := range c.branches {
+		offset, ok := c.offsets[target]
+		if !ok {
+			return fmt.Errorf("branch target %d is not an instruction of the method", target)
+		}
+		rel := offset - at
+		if rel < -0x8000 || rel > 0x7FFF {
+			return fmt.Errorf("branch to pc %d exceeds the 32KB branch range of the JVM", target)
+		}
+		copy(c.buf.Bytes()[at+1:], u2(uint16(int16(rel))))
+		c.frames[offset] = depths[target]
+	}
+	if c.buf.Len() > 0xFFFF {
+		return fmt.Errorf("code exceeds the 65535 byte limit of the JVM")
+	}
+
+	locals := make([][]byte, 0, m.base+m.size)
+	if m.main {
+		locals = append(locals, append([]byte{verifyObject}, u2(e.cp.class("[Ljava/lang/String;"))...))
+	}
+	for i := 0; i < m.size; i++ {
+		locals = append(locals, []byte{verifyInteger})
+	}
+
+	attrs := [][]byte{}
+	if len(c.frames) > 0 {
+		attrs = append(attrs, e.attribute("StackMapTable", stackMapTable(c.frames, locals)))
+	}
+	if len(c.lines) > 0 {
+		table := u2(uint16(len(c.lines)))
+		for _, l := range c.lines {
+			table = append(table, u2(l[0])...)
+			table = append(table, u2(l[1])...)
+		}
+		attrs = append(attrs, e.attribute("LineNumberTable", table))
+	}
+
+	// Leave room for the helper calls halting the program
+	e.writeMethod(accPublic|accStatic, m.name, m.desc, max+1, m.base+m.size, c.buf.Bytes(), attrs...)
+	return nil
+}
+
+// Writes the code continuing execution at the given byte offset, when it
+// does not directly follow
+func (e *emitter) continueAt(m *method, c *code, pc uint32) {
+	for _, inst := range m.code {
+		if inst.PC == pc {
+			c.branch(opGoto, pc)
+			c.unreachable = true
+			return
+		}
+	}
+
+	if int(pc) >= len(e.prog.Text) {
+		// Running off the end of the program halts it
+		e.halt(c)
+		return
+	}
+	msg := fmt.Sprintf("pc %d out of bounds", pc)
+	if err := m.faults[pc]; err != nil {
+		msg = err.Error()
+	}
+	e.fail(c, msg)
+}
+
+// Writes the JVM code of a single instruction
+func (e *emitter) instruction(m *method, c *code, inst *ijvmemu.Instr) error {
+	args := inst.Operands
+	name := inst.Op.Name
+
+	local := func() (int, error) {
+		idx := int(args[0])
+		if idx >= m.size {
+			return 0, fmt.Errorf("local variable %d out of range", idx)
+		}
+		return m.base + idx, nil
+	}
+	invoke := func() error {
+		callee, err := e.callee(inst)
+		if err != nil {
+			return err
+		}
+		c.op(opInvokestatic)
+		c.u2(e.cp.methodref(e.class, callee.name, callee.desc))
+		return nil
+	}
+
+	if op, ok := simple[name]; ok {
+		c.op(op)
+		return nil
+	}
+	if op, ok := branches[name]; ok {
+		c.branch(op, uint32(args[0]))
+		return nil
+	}
+
+	switch name {
+	case "BIPUSH":
+		c.op(opBipush, byte(args[0]))
+	case "LDC_W":
+		if int(args[0]) >= len(e.prog.Constants) {
+			return fmt.Errorf("constant index %d out of range", args[0])
+		}
+		c.op(opLdcW)
+		c.u2(e.cp.integer(e.prog.Constants[args[0]]))
+	case "ILOAD", "ISTORE":
+		idx, err := local()
+		if err != nil {
+			return err
+		}
+		op := byte(opIload)
+		if name == "ISTORE" {
+			op = opIstore
+		}
+		c.local(op, idx, inst.Wide)
+	case "IINC":
+		idx, err := local()
+		if err != nil {
+			return err
+		}
+		if inst.Wide || idx > 0xFF {
+			c.op(opWide, opIinc)
+			c.u2(uint16(idx))
+			c.u2(uint16(int16(args[1])))
+		} else {
+			c.op(opIinc, byte(idx), byte(args[1]))
+		}
+	case "INVOKEVIRTUAL":
+		return invoke()
+	case ijvmasm.OperationTailCall:
+		if err := invoke(); err != nil {
+			return err
+		}
+		if m.main {
+			// The invoked method takes over the frame of main, so returning from it halts
+			c.op(opPop)
+			e.halt(c)
+		} else {
+			c.op(opIreturn)
+		}
+	case ijvmasm.OperationReturn:
+		if m.main {
+			e.halt(c)
+		} else {
+			c.op(opIreturn)
+		}
+	case "IN":
+		e.helper(c, helperIn)
+	case "OUT":
+		e.helper(c, helperOut)
+	case "HALT":
+		e.halt(c)
+	case "ERR":
+		e.fail(c, ijvmemu.ErrErrInstruction.Error())
+	default:
+		return fmt.Errorf("operation %s cannot be emitted", name)
+	}
+	return nil
+}
+
+// Writes a call of the given helper method
+func (e *emitter) helper(c *code, h *helper) {
+	c.op(opInvokestatic)
+	c.u2(e.cp.methodref(e.class, h.name, h.desc))
+}
+
+// Writes code halting the program. The helper never returns, throwing its
+// result tells the verifier execution does not continue.
+func (e *emitter) halt(c *code) {
+	e.helper(c, helperHalt)
+	c.op(opAthrow)
+	c.unreachable = true
+}
+
+// Writes code stopping the program with the given error message
+func (e *emitter) fail(c *code, msg string) {
+	c.op(opLdcW)
+	c.u2(e.cp.str(msg))
+	e.helper(c, helperFail)
+	c.op(opAthrow)
+	c.unreachable = true
+}
+
+// Returns an attribute with the given name and contents
+func (e *emitter) attribute(name string, data []byte) []byte {
+	attr := append(u2(e.cp.utf8(name)), u4(uint32(len(data)))...)
+	return append(attr, data...)
+}
+
+// Writes a method_info with a Code attribute holding the given code and attributes
+func (e *emitter) writeMethod(access uint16, name, desc string, maxStack, maxLocals int, code []byte, attrs ...[]byte) {
+	body := new(bytes.Buffer)
+	body.Write(u2(uint16(maxStack)))
+	body.Write(u2(uint16(maxLocals)))
+	body.Write(u4(uint32(len(code))))
+	body.Write(code)
+	body.Write(u2(0)) // exception table
+	body.Write(u2(uint16(len(attrs))))
+	for _, attr := range attrs {
+		body.Write(attr)
+	}
+
+	e.buf.Write(u2(access))
+	e.buf.Write(u2(e.cp.utf8(name)))
+	e.buf.Write(u2(e.cp.utf8(desc)))
+	e.buf.Write(u2(1))
+	e.buf.Write(e.attribute("Code", body.Bytes()))
+	e.count++
+}
+
+// Returns the StackMapTable attribute contents describing the given frames,
+// by offset, as the operand stack depth in ints. The locals are the same for
+// every frame.
+func stackMapTable(frames map[int]int, locals [][]byte) []byte {
+	offsets := make([]int, 0, len(frames))
+	for offset := range frames {
+		offsets = append(offsets, offset)
+	}
+	sort.Ints(offsets)
+
+	table := u2(uint16(len(offsets)))
+	prev := -1
+	for i, offset := range offsets {
+		delta := offset - prev - 1
+		depth := frames[offset]
+		prev = offset
+
+		// Later frames have the same locals as the frame before them
+		switch {
+		case i > 0 && depth == 0 && delta < 64:
+			table = append(table, byte(delta))
+		case i > 0 && depth == 0:
+			table = append(table, 251)
+			table = append(table, u2(uint16(delta))...)
+		case i > 0 && depth == 1 && delta < 64:
+			table = append(table, byte(64+delta), verifyInteger)
+		case i > 0 && depth == 1:
+			table = append(table, 247)
+			table = append(table, u2(uint16(delta))...)
+			table = append(table, verifyInteger)
+		default:
+			table = append(table, 255)
+			table = append(table, u2(uint16(delta))...)
+			table = append(table, u2(uint16(len(locals)))...)
+			for _, l := range locals {
+				table = append(table, l...)
+			}
+			table = append(table, u2(uint16(depth))...)
+			for j := 0; j < depth; j++ {
+				table = append(table, verifyInteger)
+			}
+		}
+	}
+	return table
+}
@@ -0,0 +1,441 @@
+package ijvmclass
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/BlackNovaTech/gojasm/ijvmemu"
+	"github.com/BlackNovaTech/gojasm/internal/testprog"
+	"github.com/BlackNovaTech/gojasm/opconf"
+)
+
+// Assembles the source with the given configuration, or the default one
+func assemble(t *testing.T, name, config, src string) *ijvmemu.Program {
+	var ops *opconf.OpConfig
+	if config != "" {
+		ops = opconf.NewOpConfigFromPath(config)
+	}
+	return testprog.Assemble(t, ops, name, src)
+}
+
+// Emits the program and parses the resulting class file
+func emit(t *testing.T, prog *ijvmemu.Program, class string) *classFile {
+	var out bytes.Buffer
+	if err := Emit(prog, class, &out); err != nil {
+		t.Fatal(err)
+	}
+	cf, err := parseClass(out.Bytes())
+	if err != nil {
+		t.Fatalf("emitted class does not parse: %v", err)
+	}
+	return cf
+}
+
+func (cf *classFile) method(t *testing.T, name string) *classMethod {
+	for _, m := range cf.methods {
+		if m.name == name {
+			return m
+		}
+	}
+	t.Fatalf("class has no method %s", name)
+	return nil
+}
+
+// A decoded stack map frame
+type frame struct {
+	offset int
+	// Verification types of the locals, only for full frames
+	locals []byte
+	depth  int
+}
+
+// Decodes the frame types written by stackMapTable
+func decodeFrames(t *testing.T, table []byte) []frame {
+	if table == nil {
+		return nil
+	}
+	r := &classReader{data: table}
+	var frames []frame
+	prev := -1
+	for n := r.u2(); n > 0 && r.err == nil; n-- {
+		var f frame
+		delta := 0
+		switch typ := r.u1(); {
+		case typ < 64:
+			delta = int(typ)
+		case typ < 128:
+			delta, f.depth = int(typ)-64, 1
+			r.u1()
+		case typ == 247:
+			delta, f.depth = int(r.u2()), 1
+			r.u1()
+		case typ == 251:
+			delta = int(r.u2())
+		case typ == 255:
+			delta = int(r.u2())
+			for i := r.u2(); i > 0; i-- {
+				l := r.u1()
+				if l == verifyObject {
+					r.u2()
+				}
+				f.locals = append(f.locals, l)
+			}
+			f.depth = int(r.u2())
+			r.bytes(f.depth)
+		default:
+			t.Fatalf("unexpected frame type %d", typ)
+		}
+		f.offset = prev + delta + 1
+		prev = f.offset
+		frames = append(frames, f)
+	}
+	if r.err != nil || r.pos != len(table) {
+		t.Fatalf("malformed StackMapTable: %v", r.err)
+	}
+	return frames
+}
+
+// Returns the size of the emitted JVM instruction at the start of code
+func instrSize(t *testing.T, code []byte) int {
+	switch op := code[0]; op {
+	case opBipush, opIload, opIstore:
+		return 2
+	case opIinc:
+		return 3
+	case opWide:
+		if code[1] == opIinc {
+			return 6
+		}
+		return 4
+	case opLdcW, opGetstatic, opInvokevirt, opInvokestatic, opGoto, opIfeq, opIflt, opIfge, opIfIcmpeq:
+		return 3
+	case opNop, opAconstNull, opIconst0, opIconst1, opIload0, opAload0, opPop, opDup, opSwap,
+		opIadd, opIsub, opIand, opIor, opIreturn, opAreturn, opReturn, opAthrow:
+		return 1
+	default:
+		t.Fatalf("unexpected opcode %#x", op)
+		return 0
+	}
+}
+
+// Checks that a frame is declared at every branch target and at every
+// instruction following one that does not continue with it, as the verifier
+// requires
+func checkFrames(t *testing.T, m *classMethod) {
+	want := make(map[int]bool)
+	for pc := 0; pc < len(m.code); {
+		op := m.code[pc]
+		size := instrSize(t, m.code[pc:])
+		switch op {
+		case opGoto, opIfeq, opIflt, opIfge, opIfIcmpeq:
+			want[pc+int(int16(binary.BigEndian.Uint16(m.code[pc+1:])))] = true
+		}
+		switch op {
+		case opGoto, opIreturn, opAreturn, opReturn, opAthrow:
+			if pc+size < len(m.code) {
+				want[pc+size] = true
+			}
+		}
+		pc += size
+	}
+
+	got := make(map[int]bool)
+	for _, f := range decodeFrames(t, m.stackMap) {
+		got[f.offset] = true
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("%s: frames at %v, want %v", m.name, sorted(got), sorted(want))
+	}
+}
+
+func sorted(set map[int]bool) []int {
+	var xs []int
+	for x := range set {
+		xs = append(xs, x)
+	}
+	sort.Ints(xs)
+	return xs
+}
+
+const emitSource = `
+.constant
+big 100000
+neg -5
+.end-constant
+
+.main
+.var
+x
+.end-var
+	LDC_W big
+	ISTORE x
+	BIPUSH 1
+	IN
+	IFEQ skip
+	BIPUSH 2
+	IADD
+skip:
+	OUT
+	BIPUSH 0
+	LDC_W neg
+	BIPUSH 3
+	INVOKEVIRTUAL add
+	OUT
+loop:
+	IINC x -1
+	ILOAD x
+	IFEQ done
+	GOTO loop
+done:
+	HALT
+.end-main
+
+.method add(a, b)
+.var
+t
+.end-var
+	ILOAD a
+	ILOAD b
+	IADD
+	DUP
+	ISTORE t
+	IRETURN
+.end-method
+`
+
+func TestEmitConstantPool(t *testing.T) {
+	prog := assemble(t, "emit.jas", "", emitSource)
+	cf := emit(t, prog, "org/example/Emit")
+	if cf.name != "org/example/Emit" || cf.source != "emit.jas" {
+		t.Errorf("class %s from %s, want org/example/Emit from emit.jas", cf.name, cf.source)
+	}
+
+	// Every entry is added once
+	seen := make(map[poolEntry]int)
+	var ints []int32
+	for i, e := range cf.pool[1:] {
+		if prev, ok := seen[e]; ok {
+			t.Errorf("entries %d and %d are equal", prev+1, i+1)
+		}
+		seen[e] = i
+		if e.tag == tagInteger {
+			ints = append(ints, e.value)
+		}
+	}
+	// The constants of the program include the address of add
+	if !reflect.DeepEqual(ints, prog.Constants) || ints[0] != 100000 || ints[1] != -5 {
+		t.Errorf("integer constants %v, want %v", ints, prog.Constants)
+	}
+
+	refs := make(map[string]bool)
+	for i, e := range cf.pool {
+		if e.tag == tagMethodref {
+			class, name, desc := cf.ref(uint16(i))
+			refs[class+"."+name+desc] = true
+		}
+	}
+	for _, want := range []string{
+		"org/example/Emit.main([Ljava/lang/String;)V",
+		"org/example/Emit.add(III)I",
+		"org/example/Emit.$in()I",
+		"org/example/Emit.$out(I)V",
+		"org/example/Emit.$halt()Ljava/lang/Error;",
+		"java/io/PrintStream.flush()V",
+	} {
+		if !refs[want] {
+			t.Errorf("no method reference %s", want)
+		}
+	}
+}
+
+func TestEmitMethods(t *testing.T) {
+	cf := emit(t, assemble(t, "emit.jas", "", emitSource), "Emit")
+
+	var names []string
+	for _, m := range cf.methods {
+		names = append(names, fmt.Sprintf("%#x %s%s", m.access, m.name, m.desc))
+	}
+	want := []string{
+		"0x9 main([Ljava/lang/String;)V",
+		"0x9 add(III)I",
+		"0xa $in()I",
+		"0xa $out(I)V",
+		"0xa $halt()Ljava/lang/Error;",
+		"0xa $fail(Ljava/lang/String;)Ljava/lang/Error;",
+	}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("methods %q, want %q", names, want)
+	}
+
+	// Main keeps its arguments in slot 0. The maximum stack counts the words
+	// pushed by an instruction before those it pops, and the helper calls
+	// halting the program.
+	for _, c := range []struct {
+		name                string
+		maxStack, maxLocals int
+	}{
+		{"main", 5, 2},
+		{"add", 4, 4},
+		{"$in", 2, 0},
+		{"$out", 2, 1},
+	} {
+		m := cf.method(t, c.name)
+		if m.maxStack != c.maxStack || m.maxLocals != c.maxLocals {
+			t.Errorf("%s: max_stack %d and max_locals %d, want %d and %d",
+				c.name, m.maxStack, m.maxLocals, c.maxStack, c.maxLocals)
+		}
+	}
+
+	// Locals that are not parameters are zeroed first
+	main := cf.method(t, "main")
+	if !bytes.HasPrefix(main.code, []byte{opIconst0, opIstore, 1, opLdcW}) {
+		t.Errorf("main starts with % x", main.code[:4])
+	}
+	add := cf.method(t, "add")
+	if !bytes.HasPrefix(add.code, []byte{opIconst0, opIstore, 3, opIload, 1, opIload, 2}) {
+		t.Errorf("add starts with % x", add.code[:7])
+	}
+	if len(main.lines) == 0 || main.line(len(main.code)-1) != 31 {
+		t.Errorf("main ends on line %d, want 31", main.line(len(main.code)-1))
+	}
+}
+
+func TestEmitFrames(t *testing.T) {
+	cf := emit(t, assemble(t, "emit.jas", "", emitSource), "Emit")
+
+	main := cf.method(t, "main")
+	checkFrames(t, main)
+	frames := decodeFrames(t, main.stackMap)
+	var depths []int
+	for _, f := range frames {
+		depths = append(depths, f.depth)
+	}
+	// skip holds the pushed 1, loop and done nothing
+	if !reflect.DeepEqual(depths, []int{1, 0, 0}) {
+		t.Errorf("frame depths %v, want [1 0 0]", depths)
+	}
+	if !bytes.Equal(frames[0].locals, []byte{verifyObject, verifyInteger}) {
+		t.Errorf("first frame has locals %v, want the arguments and x", frames[0].locals)
+	}
+
+	// The helpers only branch in $in
+	for _, h := range helpers {
+		checkFrames(t, cf.method(t, h.name))
+	}
+	in := decodeFrames(t, cf.method(t, "$in").stackMap)
+	if len(in) != 1 || in[0].depth != 1 {
+		t.Errorf("$in has frames %v, want a single one holding the result", in)
+	}
+}
+
+// Local variable indices are shifted by main's arguments, and widened when
+// they no longer fit in a byte
+func TestEmitWide(t *testing.T) {
+	var src strings.Builder
+	src.WriteString(".main\n.var\n")
+	for i := 0; i < 256; i++ {
+		fmt.Fprintf(&src, "v%d\n", i)
+	}
+	src.WriteString(".end-var\nIINC v2 5\nIINC v255 -3\nILOAD v255\nISTORE v2\nHALT\n.end-main\n")
+	main := emit(t, assemble(t, "wide.jas", "", src.String()), "Wide").method(t, "main")
+
+	// Skip zeroing the locals
+	var code []byte
+	for pc := 0; pc < len(main.code); pc += instrSize(t, main.code[pc:]) {
+		if main.code[pc] == opIinc || main.code[pc] == opWide && main.code[pc+1] == opIinc {
+			code = main.code[pc:]
+			break
+		}
+	}
+	want := []byte{
+		opIinc, 3, 5,
+		opWide, opIinc, 0x01, 0x00, 0xFF, 0xFD,
+		opWide, opIload, 0x01, 0x00,
+		opIstore, 3,
+	}
+	if !bytes.HasPrefix(code, want) {
+		t.Errorf("code % x, want % x", code[:len(want)], want)
+	}
+	if main.maxLocals != 257 {
+		t.Errorf("max_locals %d, want 257", main.maxLocals)
+	}
+}
+
+// Emitted classes start with the class file header, targeting Java 8
+func TestEmitHeader(t *testing.T) {
+	var out bytes.Buffer
+	if err := Emit(assemble(t, "emit.jas", "", emitSource), "org/example/Emit", &out); err != nil {
+		t.Fatal(err)
+	}
+	data := out.Bytes()
+	if len(data) < 8 || binary.BigEndian.Uint32(data) != Magic || binary.BigEndian.Uint16(data[6:]) != majorVersion {
+		t.Errorf("emitted header % X, want magic %X and major version %d", data[:8], Magic, majorVersion)
+	}
+}
+
+func TestEmitErrors(t *testing.T) {
+	prog := assemble(t, "emit.jas", "", emitSource)
+	if err := Emit(prog, "org.example.Emit", new(bytes.Buffer)); err == nil || !strings.Contains(err.Error(), "invalid class name") {
+		t.Errorf("expected an invalid class name, got %v", err)
+	}
+
+	for _, c := range []struct {
+		config string
+		src    string
+		err    string
+	}{
+		{"", ".main\nIN\nIFEQ l\nBIPUSH 1\nl:\nHALT\n.end-main\n",
+			"method main: pc 6: operand stack depth differs between paths (0 and 1)"},
+		{"", ".main\nPOP\nHALT\n.end-main\n",
+			"method main: pc 0: POP underflows the operand stack"},
+		{"../ijvm.config", ".main\nBIPUSH 1\nNEWARRAY\nPOP\nHALT\n.end-main\n",
+			"method main: pc 2: operation NEWARRAY cannot be emitted"},
+	} {
+		err := Emit(assemble(t, "err.jas", c.config, c.src), "Err", new(bytes.Buffer))
+		if err == nil || err.Error() != c.err {
+			t.Errorf("expected error %q, got %v", c.err, err)
+		}
+	}
+}
+
+func TestMethodNames(t *testing.T) {
+	e := &emitter{names: map[string]bool{"main": true, "$in": true}}
+	for _, c := range [][2]string{
+		{"main", "main2"},
+		{"main", "main3"},
+		{"a.b/c", "a_b_c"},
+		{"a_b_c", "a_b_c2"},
+		{"$in", "$in2"},
+	} {
+		if got := e.name(c[0]); got != c[1] {
+			t.Errorf("name(%q) = %q, want %q", c[0], got, c[1])
+		}
+	}
+}
+
+func TestConstantPool(t *testing.T) {
+	cp := newConstantPool()
+	a := cp.methodref("A", "f", "()I")
+	if b := cp.methodref("A", "f", "()I"); b != a {
+		t.Errorf("equal method references got indices %d and %d", a, b)
+	}
+	// Utf8 "A", class A, Utf8 "f", Utf8 "()I", name and type, method reference
+	if a != 6 || cp.class("A") != 2 || cp.utf8("f") != 3 {
+		t.Errorf("unexpected indices %d, %d and %d", a, cp.class("A"), cp.utf8("f"))
+	}
+	if cp.integer(6) == cp.utf8("\x00\x00\x00\x06") {
+		t.Errorf("entries with different tags share an index")
+	}
+
+	for i := int32(0); i < 0x10000; i++ {
+		cp.integer(i)
+	}
+	if err := cp.writeTo(new(bytes.Buffer)); err != errPoolFull {
+		t.Errorf("expected a full constant pool, got %v", err)
+	}
+}
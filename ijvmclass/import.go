@@ -0,0 +1,616 @@
+package ijvmclass
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/BlackNovaTech/gojasm/ijvmasm"
+	"github.com/sirupsen/logrus"
+)
+
+// Descriptor of the main method
+const mainDesc = "([Ljava/lang/String;)V"
+
+// I/O helpers, declared as static native methods of the imported class, and
+// the operations replacing their invocations
+var ioHelpers = map[string]struct {
+	desc string
+	op   string
+}{
+	"in":   {"()I", "IN"},
+	"out":  {"(I)V", "OUT"},
+	"halt": {"()V", "HALT"},
+	"err":  {"()V", "ERR"},
+}
+
+// An imported method
+type importMethod struct {
+	*classMethod
+	// Name of the IJVM method
+	ident  string
+	params int
+	void   bool
+	// Amount of temporary variables needed to translate the code
+	temps int
+}
+
+type importer struct {
+	asm     *ijvmasm.Assembler
+	class   *classFile
+	methods map[string]*importMethod
+	// Names of the constants added for large integers, by value
+	constants map[int32]string
+}
+
+// A decoded JVM instruction
+type classInstr struct {
+	offset int
+	op     byte
+	wide   bool
+	// Operands: the local variable index, branch target offset, constant
+	// value or constant pool index, and the IINC increment
+	args [2]int
+	size int
+}
+
+// Import parses the Java class file at the given path and builds its methods
+// into asm, created by ijvmasm.NewBuilder, linking the resulting program.
+//
+// Only integer programs can be imported: static methods taking and returning
+// ints, using int constants, arithmetic except multiplication, division and
+// shifts, local variables, branches and invocations of methods of the class.
+// The main method of the class becomes the IJVM main. I/O uses the static
+// native methods `int in()`, `void out(int)`, `void halt()` and `void err()`
+// of the class, which are replaced by IN, OUT, HALT and ERR. Any other
+// bytecode is rejected with an error naming the opcode and method.
+func Import(asm *ijvmasm.Assembler, path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	cf, err := parseClass(data)
+	if err != nil {
+		return fmt.Errorf("%s: %s", path, err)
+	}
+	if cf.source != "" {
+		// Line numbers refer to the Java source
+		asm.SetFile(filepath.Join(filepath.Dir(path), cf.source))
+	}
+
+	imp := &importer{
+		asm:       asm,
+		class:     cf,
+		methods:   make(map[string]*importMethod),
+		constants: make(map[int32]string),
+	}
+	methods, err := imp.declare()
+	if err != nil {
+		return err
+	}
+
+	decoded := make([][]*classInstr, len(methods))
+	for i, m := range methods {
+		if decoded[i], err = imp.decode(m); err != nil {
+			return fmt.Errorf("method %s: %s", m.name, err)
+		}
+	}
+	for i, m := range methods {
+		imp.build(m, decoded[i])
+	}
+
+	if !asm.Link() {
+		return errors.New("building the imported program failed")
+	}
+	return nil
+}
+
+// Checks the methods of the class, and names the methods to import, main first
+func (imp *importer) declare() ([]*importMethod, error) {
+	var main *importMethod
+	var methods []*importMethod
+	names := make(map[string]bool)
+
+	for _, cm := range imp.class.methods {
+		static := cm.access&accStatic != 0
+		switch {
+		case cm.name == "<init>":
+			// Constructors are never invoked by static code
+			logrus.Debugf("Skipping constructor %s%s", cm.name, cm.desc)
+			continue
+		case cm.name == "<clinit>":
+			return nil, errors.New("static initializers are not supported")
+		case !static:
+			return nil, fmt.Errorf("method %s: instance methods are not supported", cm.name)
+		case cm.code == nil:
+			if helper, ok := ioHelpers[cm.name]; !ok || helper.desc != cm.desc {
+				return nil, fmt.Errorf("method %s: native and abstract methods are not supported, "+
+					"except the I/O helpers int in(), void out(int), void halt() and void err()", cm.name)
+			}
+			continue
+		}
+
+		m := &importMethod{classMethod: cm}
+		if cm.name == "main" && cm.desc == mainDesc {
+			m.ident = "main"
+			main = m
+		} else {
+			if !intDescriptor(cm.desc) {
+				return nil, fmt.Errorf("method %s: only int parameters and int or void results are supported, got %s", cm.name, cm.desc)
+			}
+			m.params = strings.Index(cm.desc, ")") - 1
+			m.void = strings.HasSuffix(cm.desc, "V")
+			// Overloaded methods need unique IJVM names
+			m.ident = cm.name
+			for i := 2; names[m.ident] || m.ident == "main"; i++ {
+				m.ident = fmt.Sprintf("%s$%d", cm.name, i)
+			}
+			methods = append(methods, m)
+		}
+		names[m.ident] = true
+		imp.methods[cm.name+cm.desc] = m
+	}
+
+	if main == nil {
+		return nil, fmt.Errorf("class %s has no method public static void main(String[])", imp.class.name)
+	}
+	return append([]*importMethod{main}, methods...), nil
+}
+
+// Reports whether the method descriptor only takes ints, and returns an int or nothing
+func intDescriptor(desc string) bool {
+	if !strings.HasPrefix(desc, "(") {
+		return false
+	}
+	params, result := splitDescriptor(desc)
+	return strings.Trim(params, "I") == "" && (result == "I" || result == "V")
+}
+
+// Splits a method descriptor into its parameters and result
+func splitDescriptor(desc string) (string, string) {
+	i := strings.Index(desc, ")")
+	if i < 0 {
+		return desc, ""
+	}
+	return strings.TrimPrefix(desc[:i], "("), desc[i+1:]
+}
+
+// Decodes the code of a method, rejecting any unsupported bytecode
+func (imp *importer) decode(m *importMethod) ([]*classInstr, error) {
+	if m.handlers > 0 {
+		return nil, errors.New("exception handlers are not supported")
+	}
+
+	code := m.code
+	var insts []*classInstr
+	for pc := 0; pc < len(code); {
+		inst := &classInstr{offset: pc, op: code[pc], size: 1}
+		// Reads the next signed operand of n bytes, truncated instructions are rejected below
+		operand := func(n int) int {
+			at := pc + inst.size
+			inst.size += n
+			if at+n > len(code) {
+				return 0
+			}
+			switch n {
+			case 1:
+				return int(int8(code[at]))
+			case 2:
+				return int(int16(binary.BigEndian.Uint16(code[at:])))
+			}
+			return int(int32(binary.BigEndian.Uint32(code[at:])))
+		}
+		unsigned := func(n int) int {
+			v := operand(n)
+			if n == 1 {
+				return v & 0xFF
+			}
+			return v & 0xFFFF
+		}
+
+		if inst.op == opWide {
+			inst.wide = true
+			inst.size++
+			if pc+1 < len(code) {
+				inst.op = code[pc+1]
+			}
+		}
+
+		switch op := inst.op; {
+		case inst.wide && op != opIload && op != opIstore && op != opIinc:
+			return nil, imp.unsupported(op, pc)
+		case op == opNop, op == opPop, op == opDup, op == opSwap, op == opIadd, op == opIsub,
+			op == opIneg, op == opIand, op == opIor, op == opIreturn, op == opReturn:
+		case op == opIxor:
+			m.needTemps(2)
+		case op >= opIconstM1 && op <= opIconst5:
+			inst.args[0] = int(op) - opIconst0
+		case op == opBipush:
+			inst.args[0] = operand(1)
+		case op == opSipush:
+			inst.args[0] = operand(2)
+		case op == opLdc, op == opLdcW:
+			size := 1
+			if op == opLdcW {
+				size = 2
+			}
+			e := imp.class.entry(uint16(unsigned(size)))
+			if e.tag != tagInteger {
+				return nil, fmt.Errorf("offset %d: %s of a non-integer constant is not supported", pc, mnemonics[op])
+			}
+			inst.args[0] = int(e.value)
+		case op == opIload, op == opIstore:
+			if inst.wide {
+				inst.args[0] = unsigned(2)
+			} else {
+				inst.args[0] = unsigned(1)
+			}
+		case op >= opIload0 && op <= opIload3:
+			inst.op, inst.args[0] = opIload, int(op-opIload0)
+		case op >= opIstore0 && op <= opIstore3:
+			inst.op, inst.args[0] = opIstore, int(op-opIstore0)
+		case op == opIinc:
+			if inst.wide {
+				inst.args[0], inst.args[1] = unsigned(2), operand(2)
+			} else {
+				inst.args[0], inst.args[1] = unsigned(1), operand(1)
+			}
+		case op >= opIfeq && op <= opIfIcmple, op == opGoto:
+			inst.args[0] = pc + operand(2)
+			if op >= opIfIcmplt {
+				m.needTemps(2)
+			}
+		case op == opGotoW:
+			inst.args[0] = pc + operand(4)
+			inst.op = opGoto
+		case op == opInvokestatic:
+			idx := uint16(unsigned(2))
+			if err := imp.checkInvoke(m, idx, pc); err != nil {
+				return nil, err
+			}
+			inst.args[0] = int(idx)
+		default:
+			return nil, imp.unsupported(op, pc)
+		}
+
+		if pc+inst.size > len(code) {
+			return nil, fmt.Errorf("offset %d: truncated %s", pc, mnemonics[inst.op])
+		}
+		if inst.op == opIload || inst.op == opIstore || inst.op == opIinc {
+			if inst.args[0] >= m.maxLocals || (m.ident == "main" && inst.args[0] == 0) {
+				return nil, fmt.Errorf("offset %d: %s of a non-integer local variable %d", pc, mnemonics[inst.op], inst.args[0])
+			}
+		}
+		insts = append(insts, inst)
+		pc += inst.size
+	}
+
+	starts := make(map[int]bool)
+	for _, inst := range insts {
+		starts[inst.offset] = true
+	}
+	for _, inst := range insts {
+		if (inst.op >= opIfeq && inst.op <= opIfIcmple || inst.op == opGoto) && !starts[inst.args[0]] {
+			return nil, fmt.Errorf("offset %d: invalid branch target %d", inst.offset, inst.args[0])
+		}
+	}
+	return insts, nil
+}
+
+// Returns the error rejecting an unsupported opcode
+func (imp *importer) unsupported(op byte, pc int) error {
+	name := fmt.Sprintf("0x%02X", op)
+	if int(op) < len(mnemonics) {
+		name = mnemonics[op]
+	}
+	return fmt.Errorf("offset %d: unsupported opcode %s", pc, name)
+}
+
+// Checks whether the method referenced by an invokestatic can be invoked
+func (imp *importer) checkInvoke(m *importMethod, idx uint16, pc int) error {
+	if imp.class.entry(idx).tag != tagMethodref {
+		return fmt.Errorf("offset %d: invokestatic of an invalid method reference", pc)
+	}
+	class, name, desc := imp.class.ref(idx)
+	if class != imp.class.name {
+		return fmt.Errorf("offset %d: invokestatic of %s.%s is not supported, only methods of %s can be invoked",
+			pc, class, name, imp.class.name)
+	}
+	callee, ok := imp.methods[name+desc]
+	if !ok {
+		if helper, ok := ioHelpers[name]; ok && helper.desc == desc {
+			return nil
+		}
+		return fmt.Errorf("offset %d: invokestatic of unknown method %s%s", pc, name, desc)
+	}
+	if callee.ident == "main" {
+		return fmt.Errorf("offset %d: invoking main is not supported", pc)
+	}
+	m.needTemps(callee.params)
+	return nil
+}
+
+// Makes sure the method has at least n temporary variables
+func (m *importMethod) needTemps(n int) {
+	if n > m.temps {
+		m.temps = n
+	}
+}
+
+// Returns the name of the IJVM variable of a JVM local variable slot
+func local(idx int) string {
+	return fmt.Sprintf("lv%d", idx)
+}
+
+// Returns the name of a temporary IJVM variable
+func temp(idx int) string {
+	return fmt.Sprintf("tmp%d", idx)
+}
+
+// Returns the label of the instruction at the given offset
+func label(offset int) string {
+	return fmt.Sprintf("L%d", offset)
+}
+
+// Builds the IJVM method of a decoded method
+type methodBuilder struct {
+	asm    *ijvmasm.Assembler
+	method *ijvmasm.Method
+	line   uint32
+	// Offset of the instruction being built, and the amount of labels added for it
+	offset int
+	labels int
+}
+
+// Appends an instruction in JAS notation
+func (b *methodBuilder) emit(format string, args ...interface{}) {
+	b.asm.AddInstruction(b.method, fmt.Sprintf(format, args...), b.line)
+}
+
+// Appends an instruction accessing a variable, widened when needed
+func (b *methodBuilder) emitVar(op, name string, args ...interface{}) {
+	if idx, _ := b.method.VarIndex(name); idx > 0xFF {
+		b.emit(ijvmasm.OperationWide)
+	}
+	b.emit("%s %s"+strings.Repeat(" %d", len(args)), append([]interface{}{op, name}, args...)...)
+}
+
+// Returns a new label for code generated for the current instruction
+func (b *methodBuilder) newLabel() string {
+	b.labels++
+	return fmt.Sprintf("%s_%d", label(b.offset), b.labels)
+}
+
+// Places a label at the next instruction
+func (b *methodBuilder) place(name string) {
+	b.asm.AddLabel(b.method, name, b.line)
+}
+
+// Builds the IJVM method of a decoded method
+func (imp *importer) build(m *importMethod, insts []*classInstr) {
+	var params, vars []string
+	first := 1 // Slot 0 holds the arguments of main
+	if m.ident != "main" {
+		for i := 0; i < m.params; i++ {
+			params = append(params, local(i))
+		}
+		first = m.params
+	}
+	for i := first; i < m.maxLocals; i++ {
+		vars = append(vars, local(i))
+	}
+	for i := 0; i < m.temps; i++ {
+		vars = append(vars, temp(i))
+	}
+
+	decl := m.ident
+	if m.ident != "main" {
+		decl = fmt.Sprintf("%s(%s)", m.ident, strings.Join(params, ", "))
+	}
+	b := &methodBuilder{asm: imp.asm, line: m.line(0)}
+	if b.method = imp.asm.AddMethod(decl, vars, b.line); b.method == nil {
+		return
+	}
+
+	targets := make(map[int]bool)
+	for _, inst := range insts {
+		if inst.op >= opIfeq && inst.op <= opIfIcmple || inst.op == opGoto {
+			targets[inst.args[0]] = true
+		}
+	}
+
+	for _, inst := range insts {
+		b.offset, b.labels, b.line = inst.offset, 0, m.line(inst.offset)
+		if targets[inst.offset] {
+			b.place(label(inst.offset))
+		}
+		imp.instruction(m, b, inst)
+	}
+	imp.asm.EndMethod(b.method)
+}
+
+// Simple operations that translate one to one
+var simpleOps = map[byte]string{
+	opNop:  "NOP",
+	opPop:  "POP",
+	opDup:  "DUP",
+	opSwap: "SWAP",
+	opIadd: "IADD",
+	opIsub: "ISUB",
+	opIand: "IAND",
+	opIor:  "IOR",
+}
+
+// Builds the IJVM instructions of a single JVM instruction
+func (imp *importer) instruction(m *importMethod, b *methodBuilder, inst *classInstr) {
+	if op, ok := simpleOps[inst.op]; ok {
+		b.emit(op)
+		return
+	}
+
+	args := inst.args
+	switch op := inst.op; {
+	case op >= opIconstM1 && op <= opIconst5, op == opBipush, op == opSipush, op == opLdc, op == opLdcW:
+		imp.push(b, int32(args[0]))
+	case op == opIload:
+		b.emitVar("ILOAD", local(args[0]))
+	case op == opIstore:
+		b.emitVar("ISTORE", local(args[0]))
+	case op == opIinc:
+		if args[1] >= -128 && args[1] <= 127 {
+			b.emitVar("IINC", local(args[0]), args[1])
+			return
+		}
+		b.emitVar("ILOAD", local(args[0]))
+		imp.push(b, int32(args[1]))
+		b.emit("IADD")
+		b.emitVar("ISTORE", local(args[0]))
+	case op == opIneg:
+		b.emit("BIPUSH 0")
+		b.emit("SWAP")
+		b.emit("ISUB")
+	case op == opIxor:
+		// a ^ b = (a | b) - (a & b)
+		b.emitVar("ISTORE", temp(1))
+		b.emitVar("ISTORE", temp(0))
+		for _, f := range []string{"IOR", "IAND"} {
+			b.emitVar("ILOAD", temp(0))
+			b.emitVar("ILOAD", temp(1))
+			b.emit(f)
+		}
+		b.emit("ISUB")
+	case op == opIfeq:
+		b.emit("IFEQ %s", label(args[0]))
+	case op == opIflt:
+		b.emit("IFLT %s", label(args[0]))
+	case op == opIfIcmpeq:
+		b.emit("IF_ICMPEQ %s", label(args[0]))
+	case op == opGoto:
+		b.emit("GOTO %s", label(args[0]))
+	case op >= opIfne && op <= opIfle, op >= opIfIcmpne && op <= opIfIcmple:
+		imp.branch(b, inst)
+	case op == opIreturn:
+		b.emit("IRETURN")
+	case op == opReturn:
+		if m.ident == "main" {
+			b.emit("HALT")
+		} else {
+			// IJVM methods always return a value, which invocations of void methods pop
+			b.emit("BIPUSH 0")
+			b.emit("IRETURN")
+		}
+	case op == opInvokestatic:
+		imp.invoke(b, uint16(args[0]))
+	}
+}
+
+// Pushes an integer, using a constant if it does not fit in a byte
+func (imp *importer) push(b *methodBuilder, v int32) {
+	if v >= -128 && v <= 127 {
+		b.emit("BIPUSH %d", v)
+		return
+	}
+	name, ok := imp.constants[v]
+	if !ok {
+		name = fmt.Sprintf("const$%d", v)
+		imp.constants[v] = name
+		imp.asm.AddConstant(name, v, b.line)
+	}
+	b.emit("LDC_W %s", name)
+}
+
+// Builds the conditional branches IJVM lacks from IFEQ, IFLT and IF_ICMPEQ
+func (imp *importer) branch(b *methodBuilder, inst *classInstr) {
+	target := label(inst.args[0])
+	next := b.newLabel()
+	defer b.place(next)
+
+	switch inst.op {
+	case opIfne:
+		b.emit("IFEQ %s", next)
+		b.emit("GOTO %s", target)
+	case opIfge:
+		b.emit("IFLT %s", next)
+		b.emit("GOTO %s", target)
+	case opIfgt:
+		pop := b.newLabel()
+		b.emit("DUP")
+		b.emit("IFLT %s", pop)
+		b.emit("IFEQ %s", next)
+		b.emit("GOTO %s", target)
+		b.place(pop)
+		b.emit("POP")
+	case opIfle:
+		pop := b.newLabel()
+		b.emit("DUP")
+		b.emit("IFLT %s", pop)
+		b.emit("IFEQ %s", target)
+		b.emit("GOTO %s", next)
+		b.place(pop)
+		b.emit("POP")
+		b.emit("GOTO %s", target)
+	case opIfIcmpne:
+		b.emit("IF_ICMPEQ %s", next)
+		b.emit("GOTO %s", target)
+	default:
+		b.emitVar("ISTORE", temp(1))
+		b.emitVar("ISTORE", temp(0))
+		switch inst.op {
+		case opIfIcmplt: // a < b
+			imp.less(b, temp(0), temp(1), target, next)
+		case opIfIcmpge: // !(a < b)
+			imp.less(b, temp(0), temp(1), next, target)
+		case opIfIcmpgt: // b < a
+			imp.less(b, temp(1), temp(0), target, next)
+		case opIfIcmple: // !(b < a)
+			imp.less(b, temp(1), temp(0), next, target)
+		}
+	}
+}
+
+// Branches to yes if variable a is less than variable b, and to no otherwise.
+// Only values of the same sign are subtracted, so the comparison never overflows.
+func (imp *importer) less(b *methodBuilder, x, y, yes, no string) {
+	negative, same := b.newLabel(), b.newLabel()
+	b.emitVar("ILOAD", x)
+	b.emit("IFLT %s", negative)
+	b.emitVar("ILOAD", y)
+	b.emit("IFLT %s", no)
+	b.emit("GOTO %s", same)
+	b.place(negative)
+	b.emitVar("ILOAD", y)
+	b.emit("IFLT %s", same)
+	b.emit("GOTO %s", yes)
+	b.place(same)
+	b.emitVar("ILOAD", x)
+	b.emitVar("ILOAD", y)
+	b.emit("ISUB")
+	b.emit("IFLT %s", yes)
+	b.emit("GOTO %s", no)
+}
+
+// Builds an invocation of a method of the class, or an I/O helper
+func (imp *importer) invoke(b *methodBuilder, idx uint16) {
+	_, name, desc := imp.class.ref(idx)
+	callee, ok := imp.methods[name+desc]
+	if !ok {
+		b.emit(ioHelpers[name].op)
+		return
+	}
+
+	// The object reference precedes the arguments, which are already on the stack
+	for i := callee.params - 1; i >= 0; i-- {
+		b.emitVar("ISTORE", temp(i))
+	}
+	b.emit("BIPUSH 0")
+	for i := 0; i < callee.params; i++ {
+		b.emitVar("ILOAD", temp(i))
+	}
+	b.emit("%s %s", ijvmasm.OperationInvoke, callee.ident)
+	if callee.void {
+		b.emit("POP")
+	}
+}
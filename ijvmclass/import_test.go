@@ -0,0 +1,175 @@
+package ijvmclass
+
+import (
+	"context"
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/BlackNovaTech/gojasm/ijvmasm"
+	"github.com/BlackNovaTech/gojasm/ijvmemu"
+	"github.com/BlackNovaTech/gojasm/opconf"
+)
+
+// The class files in testdata are assembled by hand from the Java sources
+// next to them, using the bytecode javac emits for them
+
+// Imports one of the class files in testdata
+func importClass(t *testing.T, name string) (*ijvmemu.Program, error) {
+	path := filepath.Join("testdata", name)
+	asm := ijvmasm.NewBuilder(path, opconf.NewDefaultOpConfig())
+	if err := Import(asm, path); err != nil {
+		return nil, err
+	}
+	return ijvmemu.FromAssembler(asm)
+}
+
+// Values around the edges of the comparisons
+var importValues = []int32{0, 1, -1, 2, -2, 127, -128, 1000, -1000, math.MaxInt32, math.MinInt32, math.MaxInt32 - 1, math.MinInt32 + 1}
+
+// Returns the output of Compare.compare
+func compareOutput(a, b int32) string {
+	var out strings.Builder
+	for _, c := range []struct {
+		holds bool
+		char  byte
+	}{
+		{a < b, '<'}, {a >= b, 'G'}, {a > b, '>'}, {a <= b, 'L'}, {a == b, '='}, {a != b, '!'},
+		{a < 0, 'n'}, {a >= 0, 'p'}, {a > 0, 'P'}, {a <= 0, 'N'}, {a == 0, 'z'}, {b != 0, 'Z'},
+	} {
+		if c.holds {
+			out.WriteByte(c.char)
+		}
+	}
+	out.WriteByte('\n')
+	return out.String()
+}
+
+// The branches IJVM lacks are rewritten without overflowing
+func TestImportComparisons(t *testing.T) {
+	prog, err := importClass(t, "Compare.class")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out := new(strings.Builder)
+	if err := ijvmemu.NewMachine(prog, strings.NewReader(""), out).Run(); err != nil {
+		t.Fatal(err)
+	}
+	want := compareOutput(1, 2) + compareOutput(-1, 100) + compareOutput(1000, -1000) +
+		compareOutput(math.MinInt32, math.MaxInt32)
+	if out.String() != want {
+		t.Errorf("main printed %q, want %q", out, want)
+	}
+
+	for _, a := range importValues {
+		for _, b := range importValues {
+			out.Reset()
+			m := ijvmemu.NewMachine(prog, strings.NewReader(""), out)
+			if _, err := m.Call(context.Background(), "compare", a, b); err != nil {
+				t.Fatalf("compare(%d, %d): %v", a, b, err)
+			}
+			if want := compareOutput(a, b); out.String() != want {
+				t.Errorf("compare(%d, %d) printed %q, want %q", a, b, out, want)
+			}
+		}
+	}
+}
+
+// Exclusive or uses temporaries, and increments outside the range of IINC
+// are added instead
+func TestImportXorAndWide(t *testing.T) {
+	prog, err := importClass(t, "Xor.class")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out := new(strings.Builder)
+	if err := ijvmemu.NewMachine(prog, strings.NewReader("AB"), out).Run(); err != nil {
+		t.Fatal(err)
+	}
+	if want := "\x03K\x03C"; out.String() != want {
+		t.Errorf("main printed %q, want %q", out, want)
+	}
+
+	for _, a := range importValues {
+		for _, b := range importValues {
+			m := ijvmemu.NewMachine(prog, strings.NewReader(""), ioutil.Discard)
+			got, err := m.Call(context.Background(), "mix", a, b)
+			if err != nil {
+				t.Fatalf("mix(%d, %d): %v", a, b, err)
+			}
+			if got != a^b {
+				t.Errorf("mix(%d, %d) = %d, want %d", a, b, got, a^b)
+			}
+		}
+	}
+
+	// Lines refer to the Java source
+	if file := filepath.Base(prog.Debug.File); file != "Xor.java" {
+		t.Errorf("debug information refers to %s, want Xor.java", file)
+	}
+}
+
+func TestImportRejects(t *testing.T) {
+	for _, c := range []struct {
+		class string
+		err   string
+	}{
+		{"Mul.class", "method mul: offset 2: unsupported opcode imul"},
+		{"Instance.class", "method get: instance methods are not supported"},
+	} {
+		if _, err := importClass(t, c.class); err == nil || err.Error() != c.err {
+			t.Errorf("%s: expected error %q, got %v", c.class, c.err, err)
+		}
+	}
+}
+
+func TestParseClass(t *testing.T) {
+	data, err := ioutil.ReadFile(filepath.Join("testdata", "Compare.class"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cf, err := parseClass(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cf.name != "Compare" || cf.source != "Compare.java" {
+		t.Errorf("class %s from %s, want Compare from Compare.java", cf.name, cf.source)
+	}
+
+	var names []string
+	for _, m := range cf.methods {
+		names = append(names, m.name+m.desc)
+	}
+	if strings.Join(names, " ") != "<init>()V out(I)V compare(II)V main([Ljava/lang/String;)V" {
+		t.Errorf("methods %q", names)
+	}
+	if out := cf.method(t, "out"); out.code != nil || out.access != 0x0108 {
+		t.Errorf("out is not a static native method")
+	}
+	compare := cf.method(t, "compare")
+	if compare.maxLocals != 2 || compare.handlers != 0 {
+		t.Errorf("compare has %d locals and %d handlers, want 2 and 0", compare.maxLocals, compare.handlers)
+	}
+	for _, c := range [][2]int{{0, 6}, {9, 6}, {10, 7}, {len(compare.code) - 1, 19}} {
+		if line := compare.line(c[0]); line != uint32(c[1]) {
+			t.Errorf("offset %d is on line %d, want %d", c[0], line, c[1])
+		}
+	}
+
+	for _, c := range []struct {
+		data []byte
+		err  string
+	}{
+		{data[:len(data)/2], "class file is truncated"},
+		{append([]byte{0xCA, 0xFE, 0xBA, 0xBF}, data[4:]...), "not a class file, invalid magic header"},
+		{append(append([]byte{}, data[:10]...), append([]byte{2}, data[11:]...)...), "invalid constant pool tag 2 at index 1"},
+	} {
+		if _, err := parseClass(c.data); err == nil || err.Error() != c.err {
+			t.Errorf("expected error %q, got %v", c.err, err)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package ijvmclass
+
+// JVM opcodes of the emitted and imported code
+const (
+	opNop          = 0x00
+	opAconstNull   = 0x01
+	opIconstM1     = 0x02
+	opIconst0      = 0x03
+	opIconst1      = 0x04
+	opIconst5      = 0x08
+	opBipush       = 0x10
+	opSipush       = 0x11
+	opLdc          = 0x12
+	opLdcW         = 0x13
+	opIload        = 0x15
+	opIload0       = 0x1A
+	opIload3       = 0x1D
+	opAload0       = 0x2A
+	opIstore       = 0x36
+	opIstore0      = 0x3B
+	opIstore3      = 0x3E
+	opPop          = 0x57
+	opDup          = 0x59
+	opSwap         = 0x5F
+	opIadd         = 0x60
+	opIsub         = 0x64
+	opIneg         = 0x74
+	opIand         = 0x7E
+	opIor          = 0x80
+	opIxor         = 0x82
+	opIinc         = 0x84
+	opIfeq         = 0x99
+	opIfne         = 0x9A
+	opIflt         = 0x9B
+	opIfge         = 0x9C
+	opIfgt         = 0x9D
+	opIfle         = 0x9E
+	opIfIcmpeq     = 0x9F
+	opIfIcmpne     = 0xA0
+	opIfIcmplt     = 0xA1
+	opIfIcmpge     = 0xA2
+	opIfIcmpgt     = 0xA3
+	opIfIcmple     = 0xA4
+	opGoto         = 0xA7
+	opIreturn      = 0xAC
+	opAreturn      = 0xB0
+	opReturn       = 0xB1
+	opGetstatic    = 0xB2
+	opInvokevirt   = 0xB6
+	opInvokestatic = 0xB8
+	opAthrow       = 0xBF
+	opWide         = 0xC4
+	opGotoW        = 0xC8
+)
+
+// Mnemonics of every JVM opcode, for error messages
+var mnemonics = [...]string{
+	"nop", "aconst_null", "iconst_m1", "iconst_0", "iconst_1", "iconst_2", "iconst_3", "iconst_4",
+	"iconst_5", "lconst_0", "lconst_1", "fconst_0", "fconst_1", "fconst_2", "dconst_0", "dconst_1",
+	"bipush", "sipush", "ldc", "ldc_w", "ldc2_w", "iload", "lload", "fload", "dload", "aload",
+	"iload_0", "iload_1", "iload_2", "iload_3", "lload_0", "lload_1", "lload_2", "lload_3", "fload_0",
+	"fload_1", "fload_2", "fload_3", "dload_0", "dload_1", "dload_2", "dload_3", "aload_0", "aload_1",
+	"aload_2", "aload_3", "iaload", "laload", "faload", "daload", "aaload", "baload", "caload",
+	"saload", "istore", "lstore", "fstore", "dstore", "astore", "istore_0", "istore_1", "istore_2",
+	"istore_3", "lstore_0", "lstore_1", "lstore_2", "lstore_3", "fstore_0", "fstore_1", "fstore_2",
+	"fstore_3", "dstore_0", "dstore_1", "dstore_2", "dstore_3", "astore_0", "astore_1", "astore_2",
+	"astore_3", "iastore", "lastore", "fastore", "dastore", "aastore", "bastore", "castore",
+	"sastore", "pop", "pop2", "dup", "dup_x1", "dup_x2", "dup2", "dup2_x1", "dup2_x2", "swap", "iadd",
+	"ladd", "fadd", "dadd", "isub", "lsub", "fsub", "dsub", "imul", "lmul", "fmul", "dmul", "idiv",
+	"ldiv", "fdiv", "ddiv", "irem", "lrem", "frem", "drem", "ineg", "lneg", "fneg", "dneg", "ishl",
+	"lshl", "ishr", "lshr", "iushr", "lushr", "iand", "land", "ior", "lor", "ixor", "lxor", "iinc",
+	"i2l", "i2f", "i2d", "l2i", "l2f", "l2d", "f2i", "f2l", "f2d", "d2i", "d2l", "d2f", "i2b", "i2c",
+	"i2s", "lcmp", "fcmpl", "fcmpg", "dcmpl", "dcmpg", "ifeq", "ifne", "iflt", "ifge", "ifgt", "ifle",
+	"if_icmpeq", "if_icmpne", "if_icmplt", "if_icmpge", "if_icmpgt", "if_icmple", "if_acmpeq",
+	"if_acmpne", "goto", "jsr", "ret", "tableswitch", "lookupswitch", "ireturn", "lreturn", "freturn",
+	"dreturn", "areturn", "return", "getstatic", "putstatic", "getfield", "putfield", "invokevirtual",
+	"invokespecial", "invokestatic", "invokeinterface", "invokedynamic", "new", "newarray",
+	"anewarray", "arraylength", "athrow", "checkcast", "instanceof", "monitorenter", "monitorexit",
+	"wide", "multianewarray", "ifnull", "ifnonnull", "goto_w", "jsr_w",
+}
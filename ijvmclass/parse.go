@@ -0,0 +1,232 @@
+package ijvmclass
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// A constant pool entry of a parsed class file
+type poolEntry struct {
+	tag byte
+	// Indices of the referenced entries, for e.g. classes and method references
+	a, b uint16
+	// Value of integer entries
+	value int32
+	// Text of Utf8 entries
+	text string
+}
+
+// A method of a parsed class file
+type classMethod struct {
+	access uint16
+	name   string
+	desc   string
+	// Code is nil for native and abstract methods
+	code      []byte
+	maxStack  int
+	maxLocals int
+	handlers  int
+	// Contents of the StackMapTable attribute, if any
+	stackMap []byte
+	// Source line of the code starting at each offset, ordered by offset
+	lines [][2]int
+}
+
+// A parsed class file, holding only what imports and checks of emitted classes need
+type classFile struct {
+	pool    []poolEntry
+	name    string
+	source  string
+	methods []*classMethod
+}
+
+// Reads a class file, keeping the first error
+type classReader struct {
+	data []byte
+	pos  int
+	err  error
+}
+
+var errTruncated = errors.New("class file is truncated")
+
+func (r *classReader) bytes(n int) []byte {
+	if r.err != nil || n < 0 || r.pos+n > len(r.data) {
+		if r.err == nil {
+			r.err = errTruncated
+		}
+		return make([]byte, n)
+	}
+	b := r.data[r.pos : r.pos+n]
+	r.pos += n
+	return b
+}
+
+func (r *classReader) u1() byte {
+	return r.bytes(1)[0]
+}
+
+func (r *classReader) u2() uint16 {
+	return binary.BigEndian.Uint16(r.bytes(2))
+}
+
+func (r *classReader) u4() uint32 {
+	return binary.BigEndian.Uint32(r.bytes(4))
+}
+
+// Sizes of the constant pool entries imports do not use, by tag
+var entrySizes = map[byte]int{
+	4:  4, // Float
+	5:  8, // Long
+	6:  8, // Double
+	11: 4, // InterfaceMethodref
+	15: 3, // MethodHandle
+	16: 2, // MethodType
+	17: 4, // Dynamic
+	18: 4, // InvokeDynamic
+	19: 2, // Module
+	20: 2, // Package
+}
+
+// Parses the parts of a class file needed to import its methods
+func parseClass(data []byte) (*classFile, error) {
+	r := &classReader{data: data}
+	if r.u4() != Magic {
+		return nil, errors.New("not a class file, invalid magic header")
+	}
+	r.u2() // minor version
+	r.u2() // major version
+
+	cf := &classFile{pool: make([]poolEntry, r.u2())}
+	for i := 1; i < len(cf.pool) && r.err == nil; i++ {
+		e := poolEntry{tag: r.u1()}
+		switch e.tag {
+		case tagUtf8:
+			// Modified UTF-8 only differs from UTF-8 for NUL and supplementary characters
+			e.text = string(r.bytes(int(r.u2())))
+		case tagInteger:
+			e.value = int32(r.u4())
+		case tagClass, tagString:
+			e.a = r.u2()
+		case tagFieldref, tagMethodref, tagNameAndType:
+			e.a, e.b = r.u2(), r.u2()
+		default:
+			size, ok := entrySizes[e.tag]
+			if !ok {
+				return nil, fmt.Errorf("invalid constant pool tag %d at index %d", e.tag, i)
+			}
+			r.bytes(size)
+		}
+		cf.pool[i] = e
+		// Longs and doubles take two entries
+		if e.tag == 5 || e.tag == 6 {
+			i++
+		}
+	}
+
+	r.u2() // access flags
+	cf.name = cf.className(r.u2())
+	r.u2() // super class
+	r.bytes(2 * int(r.u2()))
+
+	for n := r.u2(); n > 0 && r.err == nil; n-- {
+		r.bytes(6)
+		cf.attributes(r, nil)
+	}
+
+	for n := r.u2(); n > 0 && r.err == nil; n-- {
+		m := &classMethod{
+			access: r.u2(),
+			name:   cf.utf8(r.u2()),
+			desc:   cf.utf8(r.u2()),
+		}
+		cf.attributes(r, func(name string, attr *classReader) {
+			if name != "Code" {
+				return
+			}
+			m.maxStack = int(attr.u2())
+			m.maxLocals = int(attr.u2())
+			m.code = attr.bytes(int(attr.u4()))
+			m.handlers = int(attr.u2())
+			attr.bytes(8 * m.handlers)
+			cf.attributes(attr, func(name string, attr *classReader) {
+				if name == "StackMapTable" {
+					m.stackMap = attr.data
+					return
+				}
+				if name != "LineNumberTable" {
+					return
+				}
+				for n := attr.u2(); n > 0; n-- {
+					m.lines = append(m.lines, [2]int{int(attr.u2()), int(attr.u2())})
+				}
+				if attr.err != nil {
+					r.err = attr.err
+				}
+			})
+			if attr.err != nil {
+				r.err = attr.err
+			}
+		})
+		sort.Slice(m.lines, func(i, j int) bool { return m.lines[i][0] < m.lines[j][0] })
+		cf.methods = append(cf.methods, m)
+	}
+
+	cf.attributes(r, func(name string, attr *classReader) {
+		if name == "SourceFile" {
+			cf.source = cf.utf8(attr.u2())
+		}
+	})
+
+	if r.err != nil {
+		return nil, r.err
+	}
+	return cf, nil
+}
+
+// Reads a list of attributes, passing each to f if it is not nil
+func (cf *classFile) attributes(r *classReader, f func(name string, attr *classReader)) {
+	for n := r.u2(); n > 0 && r.err == nil; n-- {
+		name := cf.utf8(r.u2())
+		data := r.bytes(int(r.u4()))
+		if f != nil && r.err == nil {
+			f(name, &classReader{data: data})
+		}
+	}
+}
+
+// Returns the entry at the given index, or an empty entry if it is invalid
+func (cf *classFile) entry(idx uint16) poolEntry {
+	if int(idx) < len(cf.pool) {
+		return cf.pool[idx]
+	}
+	return poolEntry{}
+}
+
+func (cf *classFile) utf8(idx uint16) string {
+	return cf.entry(idx).text
+}
+
+func (cf *classFile) className(idx uint16) string {
+	return cf.utf8(cf.entry(idx).a)
+}
+
+// Returns the class, name and descriptor of the method or field reference at the given index
+func (cf *classFile) ref(idx uint16) (class, name, desc string) {
+	e := cf.entry(idx)
+	nt := cf.entry(e.b)
+	return cf.className(e.a), cf.utf8(nt.a), cf.utf8(nt.b)
+}
+
+// Returns the source line of the code at the given offset, or 0 if unknown
+func (m *classMethod) line(offset int) uint32 {
+	line := 0
+	for _, l := range m.lines {
+		if l[0] > offset {
+			break
+		}
+		line = l[1]
+	}
+	return uint32(line)
+}
@@ -0,0 +1,111 @@
+package ijvmclass
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+)
+
+// Constant pool tags
+const (
+	tagUtf8        = 1
+	tagInteger     = 3
+	tagClass       = 7
+	tagString      = 8
+	tagFieldref    = 9
+	tagMethodref   = 10
+	tagNameAndType = 12
+)
+
+var errPoolFull = errors.New("constant pool holds more than 65535 entries")
+
+// constantPool builds the constant pool of a class file, reusing equal entries.
+type constantPool struct {
+	buf     bytes.Buffer
+	entries map[string]uint16
+	next    int
+	err     error
+}
+
+func newConstantPool() *constantPool {
+	return &constantPool{
+		entries: make(map[string]uint16),
+		next:    1,
+	}
+}
+
+// Returns the index of the entry with the given tag and contents, adding it if needed
+func (cp *constantPool) entry(tag byte, data []byte) uint16 {
+	key := string(append([]byte{tag}, data...))
+	if idx, ok := cp.entries[key]; ok {
+		return idx
+	}
+	if cp.next > 0xFFFF {
+		cp.err = errPoolFull
+		return 0
+	}
+
+	idx := uint16(cp.next)
+	cp.next++
+	cp.entries[key] = idx
+	cp.buf.WriteByte(tag)
+	cp.buf.Write(data)
+	return idx
+}
+
+func (cp *constantPool) utf8(s string) uint16 {
+	// JAS identifiers are ASCII, which modified UTF-8 encodes like UTF-8
+	data := make([]byte, 2, 2+len(s))
+	binary.BigEndian.PutUint16(data, uint16(len(s)))
+	return cp.entry(tagUtf8, append(data, s...))
+}
+
+func (cp *constantPool) integer(v int32) uint16 {
+	data := make([]byte, 4)
+	binary.BigEndian.PutUint32(data, uint32(v))
+	return cp.entry(tagInteger, data)
+}
+
+func (cp *constantPool) class(name string) uint16 {
+	return cp.entry(tagClass, u2(cp.utf8(name)))
+}
+
+func (cp *constantPool) str(s string) uint16 {
+	return cp.entry(tagString, u2(cp.utf8(s)))
+}
+
+func (cp *constantPool) nameAndType(name, desc string) uint16 {
+	return cp.entry(tagNameAndType, append(u2(cp.utf8(name)), u2(cp.utf8(desc))...))
+}
+
+func (cp *constantPool) fieldref(class, name, desc string) uint16 {
+	return cp.entry(tagFieldref, append(u2(cp.class(class)), u2(cp.nameAndType(name, desc))...))
+}
+
+func (cp *constantPool) methodref(class, name, desc string) uint16 {
+	return cp.entry(tagMethodref, append(u2(cp.class(class)), u2(cp.nameAndType(name, desc))...))
+}
+
+// Writes the constant pool count and its entries
+func (cp *constantPool) writeTo(out *bytes.Buffer) error {
+	if cp.err != nil {
+		return cp.err
+	}
+	out.Write(u2(uint16(cp.next)))
+	out.Write(cp.buf.Bytes())
+	return nil
+}
+
+// Returns v as big endian u2
+func u2(v uint16) []byte {
+	b := make([]byte, 2)
+	binary.BigEndian.PutUint16(b, v)
+	return b
+}
+
+// Returns v as big endian u4
+func u4(v uint32) []byte {
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, v)
+	return b
+}
@@ -0,0 +1,100 @@
+package ijvmclass
+
+// helper is a runtime support method added to every emitted class.
+type helper struct {
+	name string
+	desc string
+	emit func(e *emitter, h *helper)
+}
+
+// Helper names start with $, so they never clash with IJVM methods
+var (
+	helperIn = &helper{
+		name: "$in",
+		desc: "()I",
+		emit: func(e *emitter, h *helper) {
+			// Reads a byte from stdin, or 0 at the end of the input
+			c := newCode()
+			c.op(opGetstatic)
+			c.u2(e.cp.fieldref("java/lang/System", "in", "Ljava/io/InputStream;"))
+			c.op(opInvokevirt)
+			c.u2(e.cp.methodref("java/io/InputStream", "read", "()I"))
+			c.op(opDup)
+			c.op(opIfge, 0, 5)
+			c.op(opPop)
+			c.op(opIconst0)
+			c.frames[c.buf.Len()] = 1
+			c.op(opIreturn)
+			e.writeMethod(accPrivate|accStatic, h.name, h.desc, 2, 0, c.buf.Bytes(),
+				e.attribute("StackMapTable", stackMapTable(c.frames, nil)))
+		},
+	}
+
+	helperOut = &helper{
+		name: "$out",
+		desc: "(I)V",
+		emit: func(e *emitter, h *helper) {
+			// Writes a byte to stdout
+			c := newCode()
+			c.op(opGetstatic)
+			c.u2(e.cp.fieldref("java/lang/System", "out", "Ljava/io/PrintStream;"))
+			c.op(opIload0)
+			c.op(opInvokevirt)
+			c.u2(e.cp.methodref("java/io/PrintStream", "write", "(I)V"))
+			c.op(opReturn)
+			e.writeMethod(accPrivate|accStatic, h.name, h.desc, 2, 1, c.buf.Bytes())
+		},
+	}
+
+	helperHalt = &helper{
+		name: "$halt",
+		desc: "()Ljava/lang/Error;",
+		emit: func(e *emitter, h *helper) {
+			// Flushes stdout and exits, the result only exists to be thrown
+			c := newCode()
+			e.flush(c)
+			c.op(opIconst0)
+			c.op(opInvokestatic)
+			c.u2(e.cp.methodref("java/lang/System", "exit", "(I)V"))
+			c.op(opAconstNull, opAreturn)
+			e.writeMethod(accPrivate|accStatic, h.name, h.desc, 1, 0, c.buf.Bytes())
+		},
+	}
+
+	helperFail = &helper{
+		name: "$fail",
+		desc: "(Ljava/lang/String;)Ljava/lang/Error;",
+		emit: func(e *emitter, h *helper) {
+			// Flushes stdout, prints the message to stderr and exits with status 1
+			c := newCode()
+			e.flush(c)
+			stderr := e.cp.fieldref("java/lang/System", "err", "Ljava/io/PrintStream;")
+			c.op(opGetstatic)
+			c.u2(stderr)
+			c.op(opLdcW)
+			c.u2(e.cp.str("error: "))
+			c.op(opInvokevirt)
+			c.u2(e.cp.methodref("java/io/PrintStream", "print", "(Ljava/lang/String;)V"))
+			c.op(opGetstatic)
+			c.u2(stderr)
+			c.op(opAload0)
+			c.op(opInvokevirt)
+			c.u2(e.cp.methodref("java/io/PrintStream", "println", "(Ljava/lang/String;)V"))
+			c.op(opIconst1)
+			c.op(opInvokestatic)
+			c.u2(e.cp.methodref("java/lang/System", "exit", "(I)V"))
+			c.op(opAconstNull, opAreturn)
+			e.writeMethod(accPrivate|accStatic, h.name, h.desc, 2, 1, c.buf.Bytes())
+		},
+	}
+
+	helpers = []*helper{helperIn, helperOut, helperHalt, helperFail}
+)
+
+// Writes code flushing stdout
+func (e *emitter) flush(c *code) {
+	c.op(opGetstatic)
+	c.u2(e.cp.fieldref("java/lang/System", "out", "Ljava/io/PrintStream;"))
+	c.op(opInvokevirt)
+	c.u2(e.cp.methodref("java/io/PrintStream", "flush", "()V"))
+}
@@ -0,0 +1,27 @@
+// Prints the comparisons of its arguments that hold
+public class Compare {
+    static native void out(int c);
+
+    static void compare(int a, int b) {
+        if (a < b) out('<');
+        if (a >= b) out('G');
+        if (a > b) out('>');
+        if (a <= b) out('L');
+        if (a == b) out('=');
+        if (a != b) out('!');
+        if (a < 0) out('n');
+        if (a >= 0) out('p');
+        if (a > 0) out('P');
+        if (a <= 0) out('N');
+        if (a == 0) out('z');
+        if (b != 0) out('Z');
+        out('\n');
+    }
+
+    public static void main(String[] args) {
+        compare(1, 2);
+        compare(-1, 100);
+        compare(1000, -1000);
+        compare(-2147483648, 2147483647);
+    }
+}
@@ -0,0 +1,8 @@
+public class Instance {
+    int get() {
+        return 1;
+    }
+
+    public static void main(String[] args) {
+    }
+}
@@ -0,0 +1,9 @@
+public class Mul {
+    static int mul(int a, int b) {
+        return a * b;
+    }
+
+    public static void main(String[] args) {
+        mul(6, 7);
+    }
+}
@@ -0,0 +1,21 @@
+// Mixes its input using exclusive or
+public class Xor {
+    static native int in();
+    static native void out(int c);
+
+    static int mix(int a, int b) {
+        return a ^ b;
+    }
+
+    public static void main(String[] args) {
+        int a = in();
+        int b = in();
+        out(a ^ b);
+        a += 1000;
+        a -= 990;
+        out(a);
+        out(mix(b, 65));
+        b++;
+        out(b);
+    }
+}
@@ -0,0 +1,97 @@
+package ijvmemu
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/BlackNovaTech/gojasm/ijvmasm"
+	"github.com/BlackNovaTech/gojasm/opconf"
+)
+
+// Invoked returns the address of the method invoked by the instruction, if it invokes one.
+func (p *Program) Invoked(inst *Instr) (uint32, bool) {
+	for i, arg := range inst.Op.Args {
+		if arg == opconf.ArgMethod {
+			idx := int(inst.Operands[i])
+			if idx >= len(p.Constants) {
+				return 0, false
+			}
+			return uint32(p.Constants[idx]), true
+		}
+	}
+	return 0, false
+}
+
+// Code decodes the instructions of a method reachable from its start, following
+// fall-throughs and branches, ordered by address. Byte offsets execution may
+// continue at that fail to decode are returned with their error.
+func (p *Program) Code(m *Method) ([]*Instr, map[uint32]error) {
+	decoded := make(map[uint32]*Instr)
+	faults := make(map[uint32]error)
+	work := []uint32{m.Start}
+
+	for len(work) > 0 {
+		pc := work[len(work)-1]
+		work = work[:len(work)-1]
+		if decoded[pc] != nil || faults[pc] != nil || int(pc) >= len(p.Text) {
+			continue
+		}
+
+		inst, err := Decode(p.Text, pc, p.ops)
+		if err != nil {
+			faults[pc] = err
+			continue
+		}
+		decoded[pc] = inst
+
+		for i, arg := range inst.Op.Args {
+			if arg == opconf.ArgLabel {
+				work = append(work, uint32(inst.Operands[i]))
+			}
+		}
+		if !ijvmasm.Terminates(inst.Op.Name) {
+			work = append(work, pc+inst.Size)
+		}
+	}
+
+	code := make([]*Instr, 0, len(decoded))
+	for _, inst := range decoded {
+		code = append(code, inst)
+	}
+	sort.Slice(code, func(i, j int) bool { return code[i].PC < code[j].PC })
+	return code, faults
+}
+
+// Reachable returns main, every method with debug information, and every
+// method invoked by them, ordered by address.
+func (p *Program) Reachable() ([]*Method, error) {
+	work := append([]*Method{p.MethodAt(0)}, p.methods...)
+	seen := make(map[uint32]*Method)
+
+	for len(work) > 0 {
+		m := work[len(work)-1]
+		work = work[:len(work)-1]
+		if seen[m.Addr] != nil {
+			continue
+		}
+		seen[m.Addr] = m
+
+		code, _ := p.Code(m)
+		for _, inst := range code {
+			if addr, ok := p.Invoked(inst); ok {
+				callee, err := p.Method(addr)
+				if err != nil {
+					return nil, fmt.Errorf("pc %d: %s", inst.PC, err)
+				}
+				work = append(work, callee)
+			}
+		}
+	}
+
+	methods := make([]*Method, 0, len(seen))
+	for _, m := range seen {
+		methods = append(methods, m)
+	}
+	sort.Slice(methods, func(i, j int) bool { return methods[i].Addr < methods[j].Addr })
+	return methods, nil
+}
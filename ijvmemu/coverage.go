@@ -0,0 +1,244 @@
+package ijvmemu
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/BlackNovaTech/gojasm/opconf"
+)
+
+// ErrNoDebugInfo is returned when source information is required, but the program has none.
+var ErrNoDebugInfo = errors.New("program carries no debug information")
+
+// Coverage is a Hook recording which instructions executed and which
+// directions every conditional branch took. A single Coverage can observe
+// multiple machines executing the same program, accumulating their coverage.
+type Coverage struct {
+	counts   map[uint32]uint64
+	branches map[uint32]*BranchCoverage
+}
+
+// BranchCoverage records how often a conditional branch was (not) taken.
+type BranchCoverage struct {
+	Taken    uint64
+	NotTaken uint64
+}
+
+// NewCoverage returns an empty Coverage.
+func NewCoverage() *Coverage {
+	return &Coverage{
+		counts:   make(map[uint32]uint64),
+		branches: make(map[uint32]*BranchCoverage),
+	}
+}
+
+// Before implements Hook
+func (c *Coverage) Before(m *Machine, in *Instr) {}
+
+// After implements Hook
+func (c *Coverage) After(m *Machine, in *Instr) {
+	c.counts[in.PC]++
+	if !isConditional(in.Op) {
+		return
+	}
+
+	branch, ok := c.branches[in.PC]
+	if !ok {
+		branch = &BranchCoverage{}
+		c.branches[in.PC] = branch
+	}
+	if m.PC() == in.PC+in.Size {
+		branch.NotTaken++
+	} else {
+		branch.Taken++
+	}
+}
+
+// Failed implements FailHook, the failing instruction counts as executed but
+// took neither branch direction
+func (c *Coverage) Failed(m *Machine, in *Instr, err error) {
+	c.counts[in.PC]++
+}
+
+// Count returns how often the instruction at the given byte offset executed.
+func (c *Coverage) Count(pc uint32) uint64 {
+	return c.counts[pc]
+}
+
+// Branch returns the coverage of the conditional branch at the given byte offset,
+// or nil if it never executed.
+func (c *Coverage) Branch(pc uint32) *BranchCoverage {
+	return c.branches[pc]
+}
+
+// Returns true iff the operation is a conditional branch
+func isConditional(op *opconf.Operation) bool {
+	for _, arg := range op.Args {
+		if arg == opconf.ArgLabel {
+			return op.Name != "GOTO"
+		}
+	}
+	return false
+}
+
+// Coverage of a single source line
+type lineCoverage struct {
+	count    uint64
+	branches []*branchPoint
+}
+
+type branchPoint struct {
+	op       string
+	executed bool
+	BranchCoverage
+}
+
+// Collects the coverage of prog per source line
+func (c *Coverage) lines(prog *Program) (map[uint32]*lineCoverage, error) {
+	if prog.Debug == nil {
+		return nil, ErrNoDebugInfo
+	}
+
+	lines := make(map[uint32]*lineCoverage)
+	for _, mi := range prog.Debug.Methods {
+		var wide *Instr
+		for _, li := range mi.Lines {
+			line, ok := lines[li.N]
+			if !ok {
+				line = &lineCoverage{}
+				lines[li.N] = line
+			}
+
+			// Widened instructions execute together with their WIDE prefix
+			count := c.counts[li.B]
+			if wide != nil && wide.PC+1 == li.B {
+				count = c.counts[wide.PC]
+			}
+			if count > line.count {
+				line.count = count
+			}
+
+			in, err := Decode(prog.Text, li.B, prog.ops)
+			if err != nil {
+				return nil, err
+			}
+			wide = nil
+			if in.Wide {
+				wide = in
+			}
+			if isConditional(in.Op) {
+				bp := &branchPoint{op: in.Op.Name}
+				if b, ok := c.branches[li.B]; ok {
+					bp.executed = true
+					bp.BranchCoverage = *b
+				}
+				line.branches = append(line.branches, bp)
+			}
+		}
+	}
+	return lines, nil
+}
+
+// WriteAnnotated writes the source of prog to out, annotating every line with
+// its execution count and the directions taken by its conditional branches.
+// Lines containing code that never executed are marked with #####.
+func (c *Coverage) WriteAnnotated(out io.Writer, prog *Program) error {
+	lines, err := c.lines(prog)
+	if err != nil {
+		return err
+	}
+
+	source := readSource(prog.Debug.File)
+	if source == nil {
+		return fmt.Errorf("could not read source file %s", prog.Debug.File)
+	}
+
+	for i, text := range source {
+		line, ok := lines[uint32(i+1)]
+		count := "-"
+		if ok && line.count == 0 {
+			count = "#####"
+		} else if ok {
+			count = fmt.Sprint(line.count)
+		}
+
+		if _, err := fmt.Fprintf(out, "%9s:%5d:%s", count, i+1, text); err != nil {
+			return err
+		}
+		if ok {
+			for _, b := range line.branches {
+				if !b.executed {
+					fmt.Fprintf(out, "    [%s never executed]", b.op)
+				} else {
+					fmt.Fprintf(out, "    [%s taken %d, not taken %d]", b.op, b.Taken, b.NotTaken)
+				}
+			}
+		}
+		if _, err := fmt.Fprintln(out); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteLCOV writes the coverage of prog to out as an LCOV tracefile.
+func (c *Coverage) WriteLCOV(out io.Writer, prog *Program) error {
+	lines, err := c.lines(prog)
+	if err != nil {
+		return err
+	}
+
+	keys := make([]uint32, 0, len(lines))
+	for n := range lines {
+		keys = append(keys, n)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+
+	fmt.Fprintf(out, "TN:\nSF:%s\n", prog.Debug.File)
+
+	hit := 0
+	for _, m := range prog.Methods() {
+		fmt.Fprintf(out, "FN:%d,%s\n", m.N, m.Name)
+	}
+	for _, m := range prog.Methods() {
+		count := c.counts[m.Start]
+		if count > 0 {
+			hit++
+		}
+		fmt.Fprintf(out, "FNDA:%d,%s\n", count, m.Name)
+	}
+	fmt.Fprintf(out, "FNF:%d\nFNH:%d\n", len(prog.Methods()), hit)
+
+	found, hit := 0, 0
+	for _, n := range keys {
+		for i, b := range lines[n].branches {
+			found += 2
+			if !b.executed {
+				fmt.Fprintf(out, "BRDA:%d,%d,0,-\nBRDA:%d,%d,1,-\n", n, i, n, i)
+				continue
+			}
+			fmt.Fprintf(out, "BRDA:%d,%d,0,%d\nBRDA:%d,%d,1,%d\n", n, i, b.Taken, n, i, b.NotTaken)
+			if b.Taken > 0 {
+				hit++
+			}
+			if b.NotTaken > 0 {
+				hit++
+			}
+		}
+	}
+	fmt.Fprintf(out, "BRF:%d\nBRH:%d\n", found, hit)
+
+	hit = 0
+	for _, n := range keys {
+		if lines[n].count > 0 {
+			hit++
+		}
+		fmt.Fprintf(out, "DA:%d,%d\n", n, lines[n].count)
+	}
+	fmt.Fprintf(out, "LF:%d\nLH:%d\n", len(keys), hit)
+
+	_, err = fmt.Fprintln(out, "end_of_record")
+	return err
+}
@@ -0,0 +1,57 @@
+package ijvmemu
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// The loop of coverage.jas never takes its IFLT, and takes its IFEQ once out
+// of three times. The unused method never executes.
+func TestWriteLCOV(t *testing.T) {
+	prog := loadTestProgram(t, "coverage.jas")
+	coverage := NewCoverage()
+	m := NewMachine(prog, strings.NewReader(""), ioutil.Discard)
+	m.AddHook(coverage)
+	if err := m.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	if err := coverage.WriteLCOV(&out, prog); err != nil {
+		t.Fatal(err)
+	}
+	want, err := ioutil.ReadFile(filepath.Join("testdata", "coverage.lcov"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != string(want) {
+		t.Errorf("wrote\n%s\nwant\n%s", out.String(), want)
+	}
+}
+
+// The instruction failing the program is covered.
+func TestCoverageFailure(t *testing.T) {
+	prog := loadTestProgram(t, "fault.jas")
+	coverage := NewCoverage()
+	m := NewMachine(prog, strings.NewReader(""), ioutil.Discard)
+	m.AddHook(coverage)
+	if err := m.Run(); !errors.Is(err, ErrErrInstruction) {
+		t.Fatalf("ran with %v, want %v", err, ErrErrInstruction)
+	}
+
+	// ERR is the last instruction, at byte offset 6
+	if n := coverage.Count(6); n != 1 {
+		t.Errorf("ERR executed %d times, want 1", n)
+	}
+	var out bytes.Buffer
+	if err := coverage.WriteLCOV(&out, prog); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "DA:8,1\n") {
+		t.Errorf("line of ERR not covered:\n%s", out.String())
+	}
+}
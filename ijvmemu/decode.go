@@ -0,0 +1,97 @@
+package ijvmemu
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/BlackNovaTech/gojasm/ijvmasm"
+	"github.com/BlackNovaTech/gojasm/opconf"
+)
+
+// Instr is a single decoded instruction. A WIDE prefix is folded into the
+// instruction it widens.
+type Instr struct {
+	Op *opconf.Operation
+	// PC is the byte offset of the instruction, or of its WIDE prefix
+	PC uint32
+	// Size is the amount of bytes the instruction occupies, including any WIDE prefix
+	Size uint32
+	// Wide is set iff the instruction was prefixed by WIDE
+	Wide bool
+	// Operands holds the decoded arguments. Labels are decoded into absolute
+	// byte offsets, all other arguments into their (sign extended) value.
+	Operands []int32
+
+	// Predecoded dispatch information, see predecode
+	kind   opKind
+	next   int32
+	target int32
+}
+
+// Decode decodes the instruction located at the given byte offset.
+func Decode(text []byte, pc uint32, ops *opconf.OpConfig) (*Instr, error) {
+	inst := &Instr{PC: pc}
+	pos := pc
+
+	if int(pos) >= len(text) {
+		return nil, fmt.Errorf("pc %d out of bounds", pos)
+	}
+
+	op := ops.GetOpByCode(text[pos])
+	if op != nil && op.Name == ijvmasm.OperationWide {
+		inst.Wide = true
+		pos++
+		if int(pos) >= len(text) {
+			return nil, fmt.Errorf("pc %d out of bounds", pos)
+		}
+		op = ops.GetOpByCode(text[pos])
+	}
+	if op == nil {
+		return nil, fmt.Errorf("unknown opcode 0x%02X at %d", text[pos], pos)
+	}
+	opPC := pos
+	pos++
+
+	inst.Op = op
+	inst.Operands = make([]int32, len(op.Args))
+	for i, arg := range op.Args {
+		size := uint32(2)
+		if arg == opconf.ArgByte || (arg == opconf.ArgVar && !inst.Wide) {
+			size = 1
+		}
+		if int(pos+size) > len(text) {
+			return nil, fmt.Errorf("truncated %s at %d", op.Name, inst.PC)
+		}
+
+		switch arg {
+		case opconf.ArgByte:
+			inst.Operands[i] = int32(int8(text[pos]))
+		case opconf.ArgVar:
+			if inst.Wide {
+				inst.Operands[i] = int32(binary.BigEndian.Uint16(text[pos:]))
+			} else {
+				inst.Operands[i] = int32(text[pos])
+			}
+		case opconf.ArgLabel:
+			inst.Operands[i] = int32(opPC) + int32(int16(binary.BigEndian.Uint16(text[pos:])))
+		default:
+			inst.Operands[i] = int32(binary.BigEndian.Uint16(text[pos:]))
+		}
+		pos += size
+	}
+
+	inst.Size = pos - pc
+	return inst, nil
+}
+
+// String formats the instruction in JAS-like notation.
+func (in *Instr) String() string {
+	s := in.Op.Name
+	if in.Wide {
+		s = ijvmasm.OperationWide + " " + s
+	}
+	for _, o := range in.Operands {
+		s += fmt.Sprintf(" %d", o)
+	}
+	return s
+}
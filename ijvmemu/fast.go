@@ -0,0 +1,289 @@
+package ijvmemu
+
+import (
+	"context"
+	"sort"
+
+	"github.com/BlackNovaTech/gojasm/ijvmasm"
+	"github.com/BlackNovaTech/gojasm/opconf"
+)
+
+// Kind of a predecoded instruction, selecting how the fast interpreter loop
+// dispatches it. Common operations are executed inline, all others through
+// their InstructionHandler.
+type opKind uint8
+
+const (
+	kindGeneric opKind = iota
+	// Placeholder for a byte offset that could not be predecoded
+	kindTrap
+	kindNop
+	kindBipush
+	kindLdcW
+	kindDup
+	kindPop
+	kindSwap
+	kindIadd
+	kindIsub
+	kindIand
+	kindIor
+	kindGoto
+	kindIfeq
+	kindIflt
+	kindIfIcmpeq
+	kindIload
+	kindIstore
+	kindIinc
+	kindOut
+	numKinds
+)
+
+var inlineKinds = map[string]opKind{
+	"NOP":       kindNop,
+	"WIDE":      kindNop,
+	"BIPUSH":    kindBipush,
+	"LDC_W":     kindLdcW,
+	"DUP":       kindDup,
+	"POP":       kindPop,
+	"SWAP":      kindSwap,
+	"IADD":      kindIadd,
+	"ISUB":      kindIsub,
+	"IAND":      kindIand,
+	"IOR":       kindIor,
+	"GOTO":      kindGoto,
+	"IFEQ":      kindIfeq,
+	"IFLT":      kindIflt,
+	"IF_ICMPEQ": kindIfIcmpeq,
+	"ILOAD":     kindIload,
+	"ISTORE":    kindIstore,
+	"IINC":      kindIinc,
+	"OUT":       kindOut,
+}
+
+// Decodes every instruction reachable from main and the known methods once,
+// following fall-throughs, branches and the methods referenced by invocations.
+// Successors and branch targets are resolved into indices of the instruction
+// stream. Byte offsets that fail to decode are represented by traps, which
+// fall back to decoding at runtime.
+func (p *Program) predecode() {
+	decoded := make(map[uint32]*Instr)
+	work := []uint32{0}
+	for _, m := range p.methods {
+		work = append(work, m.Start)
+	}
+
+	for len(work) > 0 {
+		pc := work[len(work)-1]
+		work = work[:len(work)-1]
+		if _, ok := decoded[pc]; ok || int(pc) >= len(p.Text) {
+			continue
+		}
+		inst, err := Decode(p.Text, pc, p.ops)
+		if err != nil {
+			continue
+		}
+		decoded[pc] = inst
+
+		for i, arg := range inst.Op.Args {
+			switch arg {
+			case opconf.ArgLabel:
+				work = append(work, uint32(inst.Operands[i]))
+			case opconf.ArgMethod:
+				if idx := int(inst.Operands[i]); idx < len(p.Constants) {
+					work = append(work, uint32(p.Constants[idx])+4)
+				}
+			}
+		}
+		if !ijvmasm.Terminates(inst.Op.Name) {
+			work = append(work, pc+inst.Size)
+		}
+	}
+
+	p.code = make([]*Instr, 0, len(decoded))
+	for _, inst := range decoded {
+		p.code = append(p.code, inst)
+	}
+	sort.Slice(p.code, func(i, j int) bool {
+		return p.code[i].PC < p.code[j].PC
+	})
+
+	p.at = make([]int32, len(p.Text)+1)
+	for i := range p.at {
+		p.at[i] = -1
+	}
+	for i, inst := range p.code {
+		p.at[inst.PC] = int32(i)
+	}
+
+	resolve := func(pc uint32) int32 {
+		if int(pc) < len(p.at) && p.at[pc] >= 0 {
+			return p.at[pc]
+		}
+		p.code = append(p.code, &Instr{PC: pc, kind: kindTrap})
+		idx := int32(len(p.code) - 1)
+		if int(pc) < len(p.at) {
+			p.at[pc] = idx
+		}
+		return idx
+	}
+
+	for _, inst := range p.code[:len(decoded)] {
+		inst.kind = inlineKinds[inst.Op.Name]
+		inst.next = resolve(inst.PC + inst.Size)
+		inst.target = -1
+		for i, arg := range inst.Op.Args {
+			if arg == opconf.ArgLabel {
+				inst.target = resolve(uint32(inst.Operands[i]))
+			}
+		}
+	}
+}
+
+// Returns the index of the predecoded instruction at the PC, or -1 if there is none
+func (m *Machine) lookup() int32 {
+	if int(m.pc) < len(m.prog.at) {
+		return m.prog.at[m.pc]
+	}
+	return -1
+}
+
+// Executes like run, dispatching the predecoded instruction stream. Hooks are
+// not supported. The PC is only kept up to date when leaving the loop, and
+// before operations executed through their handler; in between, the index ip
+// of the next instruction is leading. Whenever ip is negative the PC is valid.
+func (m *Machine) runFast(ctx context.Context, done func() bool) (err error) {
+	code := m.prog.code
+	var inst *Instr
+	ip := m.lookup()
+
+	defer func() {
+		if r := recover(); r != nil {
+			x, ok := r.(error)
+			if !ok {
+				panic(r)
+			}
+			m.pc = inst.PC + inst.Size
+			err = m.fault(inst.PC, x)
+		}
+	}()
+	sync := func() {
+		if ip >= 0 {
+			m.pc = code[ip].PC
+		}
+	}
+
+	for !m.halted && (done == nil || !done()) {
+		if m.MaxSteps > 0 && m.steps >= m.MaxSteps {
+			sync()
+			return &LimitError{Limit: LimitSteps, Max: m.MaxSteps}
+		}
+		// Polling the context every step is too expensive
+		if m.steps&0x3FF == 0 {
+			select {
+			case <-ctx.Done():
+				sync()
+				return contextError(ctx)
+			default:
+			}
+		}
+
+		if ip < 0 {
+			if err := m.Step(); err != nil {
+				return err
+			}
+			ip = m.lookup()
+			continue
+		}
+
+		inst = code[ip]
+		ip = inst.next
+		kind := inst.kind
+		if !m.inline[kind] {
+			kind = kindGeneric
+		}
+
+		switch kind {
+		case kindTrap:
+			m.pc = inst.PC
+			if err := m.Step(); err != nil {
+				return err
+			}
+			ip = m.lookup()
+			continue
+		case kindNop:
+		case kindBipush:
+			m.Push(inst.Operands[0])
+		case kindLdcW:
+			m.Push(m.Constant(inst.Operands[0]))
+		case kindDup:
+			v := m.Pop()
+			m.Push(v)
+			m.Push(v)
+		case kindPop:
+			m.Pop()
+		case kindSwap:
+			b, a := m.Pop(), m.Pop()
+			m.Push(b)
+			m.Push(a)
+		case kindIadd:
+			b, a := m.Pop(), m.Pop()
+			m.Push(a + b)
+		case kindIsub:
+			b, a := m.Pop(), m.Pop()
+			m.Push(a - b)
+		case kindIand:
+			b, a := m.Pop(), m.Pop()
+			m.Push(a & b)
+		case kindIor:
+			b, a := m.Pop(), m.Pop()
+			m.Push(a | b)
+		case kindGoto:
+			ip = inst.target
+		case kindIfeq:
+			if m.Pop() == 0 {
+				ip = inst.target
+			}
+		case kindIflt:
+			if m.Pop() < 0 {
+				ip = inst.target
+			}
+		case kindIfIcmpeq:
+			if m.Pop() == m.Pop() {
+				ip = inst.target
+			}
+		case kindIload:
+			if idx := int(inst.Operands[0]); idx < len(m.frame.Locals) {
+				m.Push(m.frame.Locals[idx])
+			} else {
+				m.Push(m.Local(idx))
+			}
+		case kindIstore:
+			m.store(int(inst.Operands[0]), m.Pop())
+		case kindIinc:
+			idx := int(inst.Operands[0])
+			m.store(idx, m.Local(idx)+inst.Operands[1])
+		case kindOut:
+			if err := m.WriteByte(byte(m.Pop())); err != nil {
+				panic(err)
+			}
+		default:
+			m.pc = inst.PC + inst.Size
+			m.writes = m.writes[:0]
+			m.exec(inst)
+			ip = m.lookup()
+		}
+		m.steps++
+	}
+
+	sync()
+	return nil
+}
+
+// Writes a local variable without recording the write
+func (m *Machine) store(idx int, v int32) {
+	if idx < len(m.frame.Locals) {
+		m.frame.Locals[idx] = v
+		return
+	}
+	m.SetLocal(idx, v)
+}
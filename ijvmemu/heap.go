@@ -0,0 +1,170 @@
+package ijvmemu
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrNullReference is raised when dereferencing the null reference
+	ErrNullReference = errors.New("null reference")
+	// ErrNegativeSize is raised when allocating an array with a negative size
+	ErrNegativeSize = errors.New("negative array size")
+)
+
+// First reference handed out by the heap. References start well above common
+// integer values, so conservatively scanned integers rarely look like references.
+const refBase = 0x00100000
+
+// Array is a single object on the heap.
+type Array struct {
+	// Refs is set for reference arrays, whose elements are traced by the collector
+	Refs bool
+	Data []int32
+
+	marked bool
+}
+
+// HeapStats holds the allocation and collection statistics of a Heap.
+type HeapStats struct {
+	Allocations    uint64
+	AllocatedWords uint64
+	Collections    uint64
+	Freed          uint64
+	FreedWords     uint64
+	Live           uint64
+	LiveWords      uint64
+	PeakWords      uint64
+}
+
+// Heap holds the arrays allocated by a program, and reclaims them using a
+// mark-and-sweep collector.
+type Heap struct {
+	// Threshold triggers a collection once this many words were allocated
+	// since the last collection, if non-zero
+	Threshold uint64
+
+	objects map[int32]*Array
+	next    int32
+	pending uint64
+	stats   HeapStats
+}
+
+// NewHeap returns an empty Heap.
+func NewHeap() *Heap {
+	return &Heap{
+		objects: make(map[int32]*Array),
+		next:    refBase,
+	}
+}
+
+// Stats returns the current statistics of the heap.
+func (h *Heap) Stats() HeapStats {
+	return h.stats
+}
+
+// Pressure returns true iff enough words were allocated to trigger a collection.
+func (h *Heap) Pressure() bool {
+	return h.Threshold > 0 && h.pending >= h.Threshold
+}
+
+// Alloc allocates a zeroed array of the given size and returns its reference.
+func (h *Heap) Alloc(size int32, refs bool) (int32, error) {
+	if size < 0 {
+		return 0, ErrNegativeSize
+	}
+
+	ref := h.next
+	h.next++
+	h.objects[ref] = &Array{Refs: refs, Data: make([]int32, size)}
+
+	h.pending += uint64(size)
+	h.stats.Allocations++
+	h.stats.AllocatedWords += uint64(size)
+	h.stats.Live++
+	h.stats.LiveWords += uint64(size)
+	if h.stats.LiveWords > h.stats.PeakWords {
+		h.stats.PeakWords = h.stats.LiveWords
+	}
+	return ref, nil
+}
+
+// Get returns the array referenced by ref.
+func (h *Heap) Get(ref int32) (*Array, error) {
+	if ref == 0 {
+		return nil, ErrNullReference
+	}
+	arr, ok := h.objects[ref]
+	if !ok {
+		return nil, fmt.Errorf("invalid reference 0x%08X", ref)
+	}
+	return arr, nil
+}
+
+// Valid returns true iff ref is null or references a live array.
+func (h *Heap) Valid(ref int32) bool {
+	_, ok := h.objects[ref]
+	return ref == 0 || ok
+}
+
+// Collect frees every array not reachable from the given roots. Roots are
+// scanned conservatively: any value equal to a live reference keeps it alive.
+// Reference arrays are traced, integer arrays are not.
+func (h *Heap) Collect(roots ...[]int32) {
+	var work []*Array
+	mark := func(ref int32) {
+		if arr, ok := h.objects[ref]; ok && !arr.marked {
+			arr.marked = true
+			if arr.Refs {
+				work = append(work, arr)
+			}
+		}
+	}
+
+	for _, words := range roots {
+		for _, w := range words {
+			mark(w)
+		}
+	}
+	for len(work) > 0 {
+		arr := work[len(work)-1]
+		work = work[:len(work)-1]
+		for _, w := range arr.Data {
+			mark(w)
+		}
+	}
+
+	for ref, arr := range h.objects {
+		if arr.marked {
+			arr.marked = false
+			continue
+		}
+		delete(h.objects, ref)
+		h.stats.Freed++
+		h.stats.FreedWords += uint64(len(arr.Data))
+		h.stats.Live--
+		h.stats.LiveWords -= uint64(len(arr.Data))
+	}
+
+	h.pending = 0
+	h.stats.Collections++
+}
+
+// Returns the array referenced by ref, panicking unless it has the given kind
+// and idx is within its bounds
+func (h *Heap) element(ref, idx int32, refs bool) *Array {
+	arr, err := h.Get(ref)
+	if err != nil {
+		panic(err)
+	}
+	if arr.Refs != refs {
+		if refs {
+			panic(errors.New("integer array used as reference array"))
+		}
+		panic(errors.New("reference array used as integer array"))
+	}
+	if idx < 0 || int(idx) >= len(arr.Data) {
+		panic(fmt.Errorf("index %d out of bounds for array of length %d", idx, len(arr.Data)))
+	}
+	return arr
+}
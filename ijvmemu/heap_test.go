@@ -0,0 +1,234 @@
+package ijvmemu
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// Runs the program, returning its output and the error it stopped with
+func runHeap(t *testing.T, prog *Program, threshold uint64, hooks ...Hook) (*Machine, string, error) {
+	t.Helper()
+	out := new(strings.Builder)
+	m := NewMachine(prog, strings.NewReader(""), out)
+	m.Heap().Threshold = threshold
+	for _, h := range hooks {
+		m.AddHook(h)
+	}
+	err := m.Run()
+	return m, out.String(), err
+}
+
+// Fills an array with "abc" and prints it
+const arraySource = `.main
+.var
+arr
+i
+.end-var
+BIPUSH 3
+NEWARRAY
+ISTORE arr
+fill:
+BIPUSH 'a'
+ILOAD i
+IADD
+ILOAD i
+ILOAD arr
+IASTORE
+IINC i 1
+ILOAD i
+BIPUSH 3
+IF_ICMPEQ print
+GOTO fill
+print:
+BIPUSH 0
+ISTORE i
+loop:
+ILOAD i
+ILOAD arr
+IALOAD
+OUT
+IINC i 1
+ILOAD i
+BIPUSH 3
+IF_ICMPEQ done
+GOTO loop
+done:
+HALT
+.end-main
+`
+
+func TestArrays(t *testing.T) {
+	_, out, err := runHeap(t, assembleExtended(t, arraySource), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != "abc" {
+		t.Errorf("printed %q, want %q", out, "abc")
+	}
+}
+
+func TestArrayErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		// Body of main, the last instruction fails
+		body string
+		err  string
+	}{
+		{"load out of bounds", "BIPUSH 3\nBIPUSH 3\nNEWARRAY\nIALOAD", "index 3 out of bounds for array of length 3"},
+		{"store out of bounds", "BIPUSH 1\nBIPUSH 3\nBIPUSH 3\nNEWARRAY\nIASTORE", "index 3 out of bounds for array of length 3"},
+		{"negative index", "BIPUSH 1\nBIPUSH -1\nBIPUSH 3\nNEWARRAY\nIASTORE", "index -1 out of bounds for array of length 3"},
+		{"negative size", "BIPUSH -1\nNEWARRAY", "negative array size"},
+		{"null load", "BIPUSH 0\nBIPUSH 0\nIALOAD", "null reference"},
+		{"null store", "BIPUSH 0\nBIPUSH 0\nBIPUSH 0\nAIASTORE", "null reference"},
+		{"integer array as references", "BIPUSH 0\nBIPUSH 1\nNEWARRAY\nAIALOAD", "integer array used as reference array"},
+		{"reference array as integers", "BIPUSH 0\nBIPUSH 1\nANEWARRAY\nIALOAD", "reference array used as integer array"},
+		{"invalid reference", "BIPUSH 5\nBIPUSH 0\nBIPUSH 1\nANEWARRAY\nAIASTORE", "invalid reference 0x00000005 stored in reference array"},
+		{"invalid array", "BIPUSH 0\nBIPUSH 5\nIALOAD", "invalid reference 0x00000005"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src := ".main\n" + tt.body + "\nHALT\n.end-main\n"
+			_, _, err := runHeap(t, assembleExtended(t, src), 0)
+
+			var rerr *RuntimeError
+			if !errors.As(err, &rerr) {
+				t.Fatalf("ran with %v, want a RuntimeError", err)
+			}
+			line := uint32(strings.Count(tt.body, "\n") + 2)
+			if rerr.Err.Error() != tt.err || rerr.Method != "main" || rerr.Line != line {
+				t.Errorf("failed with %q in %s:%d, want %q in main:%d", rerr.Err, rerr.Method, rerr.Line, tt.err, line)
+			}
+		})
+	}
+}
+
+// Keeps an integer array alive only through a reference array held in a local
+// variable, while dropping a third array before collecting garbage
+const gcSource = `.main
+.var
+outer
+.end-var
+BIPUSH 1
+ANEWARRAY
+ISTORE outer
+BIPUSH 4
+NEWARRAY
+BIPUSH 0
+ILOAD outer
+AIASTORE
+BIPUSH 8
+NEWARRAY
+POP
+GC
+BIPUSH 'x'
+BIPUSH 0
+BIPUSH 0
+ILOAD outer
+AIALOAD
+IASTORE
+BIPUSH 0
+BIPUSH 0
+ILOAD outer
+AIALOAD
+IALOAD
+OUT
+HALT
+.end-main
+`
+
+// Records the heap statistics around the GC instruction
+type gcHook struct {
+	before, after HeapStats
+}
+
+func (h *gcHook) Before(m *Machine, in *Instr) {
+	if in.Op.Name == "GC" {
+		h.before = m.Heap().Stats()
+	}
+}
+
+func (h *gcHook) After(m *Machine, in *Instr) {
+	if in.Op.Name == "GC" {
+		h.after = m.Heap().Stats()
+	}
+}
+
+func TestGC(t *testing.T) {
+	hook := &gcHook{}
+	_, out, err := runHeap(t, assembleExtended(t, gcSource), 0, hook)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != "x" {
+		t.Errorf("printed %q, want %q", out, "x")
+	}
+
+	before := HeapStats{Allocations: 3, AllocatedWords: 13, Live: 3, LiveWords: 13, PeakWords: 13}
+	if hook.before != before {
+		t.Errorf("stats before collecting %+v, want %+v", hook.before, before)
+	}
+	after := HeapStats{Allocations: 3, AllocatedWords: 13, Collections: 1, Freed: 1, FreedWords: 8, Live: 2, LiveWords: 5, PeakWords: 13}
+	if hook.after != after {
+		t.Errorf("stats after collecting %+v, want %+v", hook.after, after)
+	}
+}
+
+// Only reference arrays keep the arrays they hold alive.
+func TestCollectTracesReferenceArrays(t *testing.T) {
+	for _, refs := range []bool{false, true} {
+		h := NewHeap()
+		outer, _ := h.Alloc(1, refs)
+		inner, _ := h.Alloc(1, false)
+		arr, _ := h.Get(outer)
+		arr.Data[0] = inner
+
+		h.Collect([]int32{outer})
+		if !h.Valid(outer) || h.Valid(inner) != refs {
+			t.Errorf("reference array %v: outer kept %v, inner kept %v", refs, h.Valid(outer), h.Valid(inner))
+		}
+	}
+}
+
+// Allocates ten arrays of ten words, dropping each right away
+const pressureSource = `.main
+.var
+i
+.end-var
+loop:
+BIPUSH 10
+NEWARRAY
+POP
+IINC i 1
+ILOAD i
+BIPUSH 10
+IF_ICMPEQ done
+GOTO loop
+done:
+HALT
+.end-main
+`
+
+// Without a threshold only GC collects garbage. With a threshold of 25 words
+// every fourth allocation collects the three arrays allocated before it.
+func TestGCThreshold(t *testing.T) {
+	prog := assembleExtended(t, pressureSource)
+	tests := []struct {
+		threshold uint64
+		want      HeapStats
+	}{
+		{0, HeapStats{Allocations: 10, AllocatedWords: 100, Live: 10, LiveWords: 100, PeakWords: 100}},
+		{25, HeapStats{Allocations: 10, AllocatedWords: 100, Collections: 3, Freed: 9, FreedWords: 90, Live: 1, LiveWords: 10, PeakWords: 30}},
+	}
+
+	for _, tt := range tests {
+		m, _, err := runHeap(t, prog, tt.threshold)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stats := m.Heap().Stats(); stats != tt.want {
+			t.Errorf("threshold %d: stats %+v, want %+v", tt.threshold, stats, tt.want)
+		}
+	}
+}
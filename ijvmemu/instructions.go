@@ -0,0 +1,215 @@
+package ijvmemu
+
+import (
+	"fmt"
+	"io"
+	"net"
+)
+
+// InstructionHandler implements the semantics of an operation.
+//
+// Handlers access the machine state through the methods of Machine, e.g. Pop,
+// Push, Local, Jump and Heap. When those fail (e.g. on stack underflow) they
+// panic with an error, which Step recovers into a RuntimeError just like an
+// error returned by Exec. The PC already points at the next instruction when
+// Exec is called.
+type InstructionHandler interface {
+	Exec(m *Machine, inst *Instr) error
+}
+
+// InstructionFunc is an InstructionHandler implemented by a function.
+type InstructionFunc func(m *Machine, inst *Instr) error
+
+// Exec implements InstructionHandler
+func (f InstructionFunc) Exec(m *Machine, inst *Instr) error {
+	return f(m, inst)
+}
+
+// InstructionSet maps operation names to the handlers executing them.
+type InstructionSet map[string]InstructionHandler
+
+// DefaultInstructions returns a new InstructionSet holding the operations of
+// the default configuration, and the array, garbage collection, networking
+// and tail call operations of the sample configuration.
+func DefaultInstructions() InstructionSet {
+	set := make(InstructionSet, len(defaultInstructions))
+	for name, f := range defaultInstructions {
+		set[name] = f
+	}
+	return set
+}
+
+var defaultInstructions = map[string]InstructionFunc{
+	"NOP":  execNop,
+	"WIDE": execNop,
+	"BIPUSH": func(m *Machine, inst *Instr) error {
+		m.Push(inst.Operands[0])
+		return nil
+	},
+	"LDC_W": func(m *Machine, inst *Instr) error {
+		m.Push(m.Constant(inst.Operands[0]))
+		return nil
+	},
+	"DUP": func(m *Machine, inst *Instr) error {
+		v := m.Pop()
+		m.Push(v)
+		m.Push(v)
+		return nil
+	},
+	"POP": func(m *Machine, inst *Instr) error {
+		m.Pop()
+		return nil
+	},
+	"SWAP": func(m *Machine, inst *Instr) error {
+		b, a := m.Pop(), m.Pop()
+		m.Push(b)
+		m.Push(a)
+		return nil
+	},
+	"IADD": execBinary(func(a, b int32) int32 { return a + b }),
+	"ISUB": execBinary(func(a, b int32) int32 { return a - b }),
+	"IAND": execBinary(func(a, b int32) int32 { return a & b }),
+	"IOR":  execBinary(func(a, b int32) int32 { return a | b }),
+	"GOTO": func(m *Machine, inst *Instr) error {
+		m.Jump(uint32(inst.Operands[0]))
+		return nil
+	},
+	"IFEQ": execBranch(func(m *Machine) bool { return m.Pop() == 0 }),
+	"IFLT": execBranch(func(m *Machine) bool { return m.Pop() < 0 }),
+	"IF_ICMPEQ": execBranch(func(m *Machine) bool {
+		return m.Pop() == m.Pop()
+	}),
+	"ILOAD": func(m *Machine, inst *Instr) error {
+		m.Push(m.Local(int(inst.Operands[0])))
+		return nil
+	},
+	"ISTORE": func(m *Machine, inst *Instr) error {
+		m.SetLocal(int(inst.Operands[0]), m.Pop())
+		return nil
+	},
+	"IINC": func(m *Machine, inst *Instr) error {
+		idx := int(inst.Operands[0])
+		m.SetLocal(idx, m.Local(idx)+inst.Operands[1])
+		return nil
+	},
+	"INVOKEVIRTUAL": func(m *Machine, inst *Instr) error {
+		m.Invoke(m.Constant(inst.Operands[0]))
+		return nil
+	},
+	"TAILCALL": func(m *Machine, inst *Instr) error {
+		m.TailCall(m.Constant(inst.Operands[0]))
+		return nil
+	},
+	"IRETURN": func(m *Machine, inst *Instr) error {
+		m.Return()
+		return nil
+	},
+	"IN": func(m *Machine, inst *Instr) error {
+		b, err := m.ReadByte()
+		if err == io.EOF {
+			b, err = 0, nil
+		}
+		if err != nil {
+			return err
+		}
+		m.Push(int32(b))
+		return nil
+	},
+	"OUT": func(m *Machine, inst *Instr) error {
+		return m.WriteByte(byte(m.Pop()))
+	},
+	"HALT": func(m *Machine, inst *Instr) error {
+		m.Halt()
+		return nil
+	},
+	"ERR": func(m *Machine, inst *Instr) error {
+		return ErrErrInstruction
+	},
+	"NEWARRAY": func(m *Machine, inst *Instr) error {
+		m.Push(m.Alloc(m.Pop(), false))
+		return nil
+	},
+	"ANEWARRAY": func(m *Machine, inst *Instr) error {
+		m.Push(m.Alloc(m.Pop(), true))
+		return nil
+	},
+	"IALOAD":  execLoad(false),
+	"AIALOAD": execLoad(true),
+	"IASTORE": func(m *Machine, inst *Instr) error {
+		ref, idx, v := m.Pop(), m.Pop(), m.Pop()
+		m.heap.element(ref, idx, false).Data[idx] = v
+		return nil
+	},
+	"AIASTORE": func(m *Machine, inst *Instr) error {
+		ref, idx, v := m.Pop(), m.Pop(), m.Pop()
+		arr := m.heap.element(ref, idx, true)
+		if !m.heap.Valid(v) {
+			return fmt.Errorf("invalid reference 0x%08X stored in reference array", v)
+		}
+		arr.Data[idx] = v
+		return nil
+	},
+	"GC": func(m *Machine, inst *Instr) error {
+		m.Collect()
+		return nil
+	},
+	"NETBIND": func(m *Machine, inst *Instr) error {
+		port := m.Pop()
+		m.Push(m.netOpen(func(b NetBackend) (net.Conn, error) {
+			return b.Listen(int(port), m.NetTimeout)
+		}))
+		return nil
+	},
+	"NETCONNECT": func(m *Machine, inst *Instr) error {
+		port, host := m.Pop(), m.Pop()
+		m.Push(m.netOpen(func(b NetBackend) (net.Conn, error) {
+			return b.Dial(uint32(host), int(port), m.NetTimeout)
+		}))
+		return nil
+	},
+	"NETIN": func(m *Machine, inst *Instr) error {
+		m.Push(m.netIn(m.Pop()))
+		return nil
+	},
+	"NETOUT": func(m *Machine, inst *Instr) error {
+		ref, v := m.Pop(), m.Pop()
+		m.netOut(ref, v)
+		return nil
+	},
+	"NETCLOSE": func(m *Machine, inst *Instr) error {
+		m.netClose(m.Pop())
+		return nil
+	},
+}
+
+func execNop(m *Machine, inst *Instr) error {
+	return nil
+}
+
+// Returns a handler popping b and a, and pushing f(a, b)
+func execBinary(f func(a, b int32) int32) InstructionFunc {
+	return func(m *Machine, inst *Instr) error {
+		b, a := m.Pop(), m.Pop()
+		m.Push(f(a, b))
+		return nil
+	}
+}
+
+// Returns a handler jumping to the label operand if cond holds
+func execBranch(cond func(m *Machine) bool) InstructionFunc {
+	return func(m *Machine, inst *Instr) error {
+		if cond(m) {
+			m.Jump(uint32(inst.Operands[0]))
+		}
+		return nil
+	}
+}
+
+// Returns a handler popping a reference and an index, and pushing the element
+func execLoad(refs bool) InstructionFunc {
+	return func(m *Machine, inst *Instr) error {
+		ref, idx := m.Pop(), m.Pop()
+		m.Push(m.heap.element(ref, idx, refs).Data[idx])
+		return nil
+	}
+}
@@ -0,0 +1,113 @@
+package ijvmemu
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/BlackNovaTech/gojasm/opconf"
+)
+
+// A configuration adding SQUARE and ADDN to a few of the default operations
+const customConfig = `0x10 BIPUSH byte
+0x60 IADD
+0xFD OUT
+0xFF HALT
+0xF0 SQUARE
+0xF1 ADDN byte`
+
+// Prints 7 * 7 + 48, which is 'a'
+const customSource = `.main
+BIPUSH 7
+SQUARE
+ADDN 48
+OUT
+HALT
+.end-main
+`
+
+// Prints 'c' + 2, which is 'e'
+const addSource = `.main
+BIPUSH 'c'
+BIPUSH 2
+IADD
+OUT
+HALT
+.end-main
+`
+
+var (
+	execSquare = InstructionFunc(func(m *Machine, inst *Instr) error {
+		v := m.Pop()
+		m.Push(v * v)
+		return nil
+	})
+	execAddN = InstructionFunc(func(m *Machine, inst *Instr) error {
+		m.Push(m.Pop() + inst.Operands[0])
+		return nil
+	})
+	execSub = InstructionFunc(func(m *Machine, inst *Instr) error {
+		b, a := m.Pop(), m.Pop()
+		m.Push(a - b)
+		return nil
+	})
+	errRefused = errors.New("refused")
+	execRefuse = InstructionFunc(func(m *Machine, inst *Instr) error {
+		return errRefused
+	})
+)
+
+// Runs the program with the given handlers registered, stepping through every
+// instruction if hooked, returning its output and the error it stopped with
+func runHandled(prog *Program, handlers map[string]InstructionHandler, hooked bool) (string, error) {
+	out := new(strings.Builder)
+	m := NewMachine(prog, strings.NewReader(""), out)
+	for name, h := range handlers {
+		m.Handle(name, h)
+	}
+	if hooked {
+		m.AddHook(nopHook{})
+	}
+	err := m.Run()
+	return out.String(), err
+}
+
+func TestHandle(t *testing.T) {
+	custom := assembleSource(t, opconf.NewOpConfig(strings.NewReader(customConfig), "custom"), customSource)
+	add := assembleSource(t, opconf.NewDefaultOpConfig(), addSource)
+
+	tests := []struct {
+		name     string
+		prog     *Program
+		handlers map[string]InstructionHandler
+		out      string
+		err      string
+	}{
+		{"built-in", add, nil, "e", ""},
+		{"override built-in", add, map[string]InstructionHandler{"IADD": execSub}, "a", ""},
+		{"custom", custom, map[string]InstructionHandler{"SQUARE": execSquare, "ADDN": execAddN}, "a", ""},
+		{"unhandled", custom, map[string]InstructionHandler{"SQUARE": execSquare}, "", "operation ADDN is not supported"},
+		{"failing handler", custom, map[string]InstructionHandler{"SQUARE": execRefuse}, "", "refused"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, hooked := range []bool{false, true} {
+				out, err := runHandled(tt.prog, tt.handlers, hooked)
+				if tt.err == "" {
+					if err != nil {
+						t.Fatalf("hooked %v: %v", hooked, err)
+					}
+				} else {
+					var rerr *RuntimeError
+					if !errors.As(err, &rerr) || rerr.Err.Error() != tt.err {
+						t.Fatalf("hooked %v: ran with %v, want a RuntimeError caused by %q", hooked, err, tt.err)
+					}
+				}
+				if out != tt.out {
+					t.Errorf("hooked %v: printed %q, want %q", hooked, out, tt.out)
+				}
+			}
+		})
+	}
+}
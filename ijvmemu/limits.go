@@ -0,0 +1,87 @@
+package ijvmemu
+
+import (
+	"context"
+	"fmt"
+)
+
+// Limits bounds the resources a program may use. Zero values mean no limit.
+type Limits struct {
+	// MaxSteps limits the amount of executed instructions
+	MaxSteps uint64
+	// MaxStackDepth limits the amount of words on the operand stack, summed over all frames
+	MaxStackDepth uint64
+	// MaxFrames limits the amount of frames on the call stack, including main
+	MaxFrames uint64
+	// MaxHeapWords limits the amount of live words on the heap
+	MaxHeapWords uint64
+	// MaxOutputBytes limits the amount of bytes written by OUT
+	MaxOutputBytes uint64
+}
+
+// Limit identifies the limit that stopped a program.
+type Limit int
+
+// Reasons for stopping a program early
+const (
+	LimitSteps Limit = iota + 1
+	LimitTimeout
+	LimitStackDepth
+	LimitFrames
+	LimitHeap
+	LimitOutput
+)
+
+var limitNames = map[Limit]string{
+	LimitSteps:      "steps",
+	LimitTimeout:    "timeout",
+	LimitStackDepth: "stack depth",
+	LimitFrames:     "frames",
+	LimitHeap:       "heap",
+	LimitOutput:     "output",
+}
+
+func (l Limit) String() string {
+	if name, ok := limitNames[l]; ok {
+		return name
+	}
+	return fmt.Sprintf("Limit(%d)", int(l))
+}
+
+// LimitError is the reason a program stopped because it exceeded a limit.
+// Limits exceeded by an instruction are reported wrapped in a RuntimeError,
+// use errors.As to retrieve it.
+type LimitError struct {
+	Limit Limit
+	// Max is the exceeded maximum, zero for timeouts
+	Max uint64
+	// Err is the error of the context, for timeouts
+	Err error
+}
+
+func (e *LimitError) Error() string {
+	if e.Limit == LimitTimeout {
+		return fmt.Sprintf("timeout exceeded: %s", e.Err)
+	}
+	return fmt.Sprintf("%s limit of %d exceeded", e.Limit, e.Max)
+}
+
+// Unwrap returns the cause of the LimitError, if any.
+func (e *LimitError) Unwrap() error {
+	return e.Err
+}
+
+// Returns the error describing why the given done context stopped the program
+func contextError(ctx context.Context) error {
+	if ctx.Err() == context.DeadlineExceeded {
+		return &LimitError{Limit: LimitTimeout, Err: ctx.Err()}
+	}
+	return ctx.Err()
+}
+
+// Panics with a LimitError if n exceeds the given maximum, if any
+func checkLimit(limit Limit, n, max uint64) {
+	if max > 0 && n > max {
+		panic(&LimitError{Limit: limit, Max: max})
+	}
+}
@@ -0,0 +1,101 @@
+package ijvmemu
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Loops forever using the given instructions as the body of the loop
+func loopSource(body string) string {
+	return ".main\nloop:\n" + body + "\nGOTO loop\n.end-main\n"
+}
+
+// Recurses forever
+const recurseSource = `.main
+BIPUSH 0
+INVOKEVIRTUAL recurse
+HALT
+.end-main
+
+.method recurse()
+BIPUSH 0
+INVOKEVIRTUAL recurse
+IRETURN
+.end-method
+`
+
+func TestLimits(t *testing.T) {
+	tests := []struct {
+		src     string
+		limits  Limits
+		timeout time.Duration
+		want    LimitError
+	}{
+		{loopSource("NOP"), Limits{MaxSteps: 100}, 0, LimitError{Limit: LimitSteps, Max: 100}},
+		{loopSource("NOP"), Limits{}, 10 * time.Millisecond, LimitError{Limit: LimitTimeout, Err: context.DeadlineExceeded}},
+		{loopSource("BIPUSH 1"), Limits{MaxStackDepth: 10}, 0, LimitError{Limit: LimitStackDepth, Max: 10}},
+		{recurseSource, Limits{MaxFrames: 50}, 0, LimitError{Limit: LimitFrames, Max: 50}},
+		{loopSource("BIPUSH 10\nNEWARRAY"), Limits{MaxHeapWords: 100}, 0, LimitError{Limit: LimitHeap, Max: 100}},
+		{loopSource("BIPUSH 'a'\nOUT"), Limits{MaxOutputBytes: 5}, 0, LimitError{Limit: LimitOutput, Max: 5}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.want.Limit.String(), func(t *testing.T) {
+			prog := assembleExtended(t, tt.src)
+			for _, hooked := range []bool{false, true} {
+				ctx := context.Background()
+				if tt.timeout > 0 {
+					var cancel context.CancelFunc
+					ctx, cancel = context.WithTimeout(ctx, tt.timeout)
+					defer cancel()
+				}
+
+				m := NewMachine(prog, strings.NewReader(""), ioutil.Discard)
+				m.Limits = tt.limits
+				if hooked {
+					m.AddHook(nopHook{})
+				}
+				err := m.RunContext(ctx)
+
+				var lerr *LimitError
+				if !errors.As(err, &lerr) {
+					t.Fatalf("hooked %v: ran with %v, want a LimitError", hooked, err)
+				}
+				if *lerr != tt.want {
+					t.Errorf("hooked %v: exceeded %+v, want %+v", hooked, *lerr, tt.want)
+				}
+			}
+		})
+	}
+}
+
+// Tail recursion runs in a constant amount of frames, so only the program
+// using TAILCALL survives the frame limit.
+func TestTailCallFrameLimit(t *testing.T) {
+	tests := []struct {
+		invoke string
+		err    bool
+	}{
+		{"INVOKEVIRTUAL", true},
+		{"TAILCALL", false},
+	}
+
+	for _, tt := range tests {
+		out := new(strings.Builder)
+		m := NewMachine(assembleExtended(t, strings.Replace(tailRecursionSource, "%s", tt.invoke, 1)), strings.NewReader(""), out)
+		m.MaxFrames = 100
+		err := m.Run()
+
+		var lerr *LimitError
+		if tt.err != errors.As(err, &lerr) {
+			t.Errorf("%s: ran with %v", tt.invoke, err)
+		}
+		if !tt.err && out.String() != "y" {
+			t.Errorf("%s: printed %q, want y", tt.invoke, out.String())
+		}
+	}
+}
@@ -0,0 +1,564 @@
+package ijvmemu
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+var (
+	// ErrHalted is returned when stepping a machine that already halted
+	ErrHalted = errors.New("machine halted")
+	// ErrErrInstruction is the cause of the RuntimeError raised by the ERR instruction
+	ErrErrInstruction = errors.New("ERR instruction executed")
+	// ErrStackUnderflow is raised when popping from an empty operand stack
+	ErrStackUnderflow = errors.New("operand stack underflow")
+)
+
+// RuntimeError is returned when the executed program fails.
+// It carries the location of the failing instruction.
+type RuntimeError struct {
+	PC     uint32
+	Method string
+	Line   uint32
+	Err    error
+}
+
+func (e *RuntimeError) Error() string {
+	if e.Line > 0 {
+		return fmt.Sprintf("%s:%d (pc %d): %s", e.Method, e.Line, e.PC, e.Err)
+	}
+	return fmt.Sprintf("%s (pc %d): %s", e.Method, e.PC, e.Err)
+}
+
+// Unwrap returns the cause of the RuntimeError.
+func (e *RuntimeError) Unwrap() error {
+	return e.Err
+}
+
+// Frame is a single method invocation on the call stack.
+type Frame struct {
+	Method *Method
+	// Locals holds the parameters followed by the local variables
+	Locals []int32
+	// Base is the height of the operand stack when the frame was entered
+	Base int
+	// Return is the byte offset execution continues at after returning
+	Return uint32
+}
+
+// LocalWrite records a single write to a local variable of the current frame.
+type LocalWrite struct {
+	Index int
+	Value int32
+}
+
+// Hook observes the instructions executed by a Machine.
+type Hook interface {
+	// Before is called right before the instruction is executed.
+	Before(m *Machine, in *Instr)
+	// After is called after the instruction executed successfully.
+	After(m *Machine, in *Instr)
+}
+
+// FailHook is a Hook that is also notified of instructions failing to execute.
+type FailHook interface {
+	Hook
+	// Failed is called instead of After when the instruction failed with err.
+	Failed(m *Machine, in *Instr, err error)
+}
+
+// Machine is an IJVM interpreter executing a single Program.
+type Machine struct {
+	// Limits bounds the resources used by the program
+	Limits
+	// Net provides the connections of the NET* operations, which fail if it is nil
+	Net NetBackend
+	// NetTimeout is the time NET* operations wait before giving up
+	NetTimeout time.Duration
+
+	prog *Program
+
+	pc     uint32
+	stack  []int32
+	frames []*Frame
+	frame  *Frame
+
+	heap *Heap
+
+	conns    map[int32]*netConn
+	nextConn int32
+
+	in  *bufio.Reader
+	out *bufio.Writer
+
+	steps  uint64
+	halted bool
+
+	// Amount of input bytes consumed, and of output bytes produced so far
+	inBytes  uint64
+	outBytes uint64
+
+	instructions InstructionSet
+	// Whether each kind of predecoded instruction may be executed inline
+	inline [numKinds]bool
+
+	hooks  []Hook
+	writes []LocalWrite
+	cache  map[uint32]*Instr
+}
+
+// NewMachine returns a Machine ready to execute the given program from the
+// start of main, reading IN from in and writing OUT to out.
+func NewMachine(prog *Program, in io.Reader, out io.Writer) *Machine {
+	main := prog.MethodAt(0)
+	frame := &Frame{
+		Method: main,
+		Locals: make([]int32, main.NumParams+main.NumLocals),
+	}
+
+	m := &Machine{
+		NetTimeout: DefaultNetTimeout,
+
+		prog:   prog,
+		stack:  make([]int32, 0, 64),
+		frames: []*Frame{frame},
+		frame:  frame,
+		heap:   NewHeap(),
+		conns:  make(map[int32]*netConn),
+		in:     bufio.NewReader(in),
+		out:    bufio.NewWriter(out),
+		cache:  make(map[uint32]*Instr),
+
+		instructions: DefaultInstructions(),
+	}
+	for k := range m.inline {
+		m.inline[k] = true
+	}
+	return m
+}
+
+// AddHook registers a Hook observing every executed instruction.
+func (m *Machine) AddHook(h Hook) {
+	m.hooks = append(m.hooks, h)
+}
+
+// Handle registers the handler executing the operation with the given name,
+// replacing any handler previously registered for it.
+func (m *Machine) Handle(name string, h InstructionHandler) {
+	m.instructions[name] = h
+	if kind, ok := inlineKinds[name]; ok {
+		m.inline[kind] = false
+	}
+}
+
+// Program returns the program executed by the machine.
+func (m *Machine) Program() *Program {
+	return m.prog
+}
+
+// PC returns the byte offset of the next instruction.
+func (m *Machine) PC() uint32 {
+	return m.pc
+}
+
+// Steps returns the amount of executed instructions.
+func (m *Machine) Steps() uint64 {
+	return m.steps
+}
+
+// Halted returns true iff the program finished executing.
+func (m *Machine) Halted() bool {
+	return m.halted
+}
+
+// Heap returns the heap holding the arrays allocated by the program.
+func (m *Machine) Heap() *Heap {
+	return m.heap
+}
+
+// Frame returns the frame of the method currently executing.
+func (m *Machine) Frame() *Frame {
+	return m.frame
+}
+
+// Frames returns the call stack, outermost frame first.
+func (m *Machine) Frames() []*Frame {
+	return m.frames
+}
+
+// Stack returns the operand stack of the current frame, bottom first.
+func (m *Machine) Stack() []int32 {
+	return m.stack[m.frame.Base:]
+}
+
+// LocalWrites returns the local variable writes of the last executed instruction.
+func (m *Machine) LocalWrites() []LocalWrite {
+	return m.writes
+}
+
+// Jump continues execution at the given byte offset.
+func (m *Machine) Jump(pc uint32) {
+	m.pc = pc
+}
+
+// Halt stops the machine after the current instruction.
+func (m *Machine) Halt() {
+	m.halted = true
+}
+
+// ReadByte reads a single byte from the input of the machine.
+func (m *Machine) ReadByte() (byte, error) {
+	b, err := m.in.ReadByte()
+	if err == nil {
+		m.inBytes++
+	}
+	return b, err
+}
+
+// WriteByte writes a single byte to the output of the machine.
+func (m *Machine) WriteByte(b byte) error {
+	checkLimit(LimitOutput, m.outBytes+1, m.MaxOutputBytes)
+	m.outBytes++
+	return m.out.WriteByte(b)
+}
+
+// OutputBytes returns the amount of output bytes produced so far.
+func (m *Machine) OutputBytes() uint64 {
+	return m.outBytes
+}
+
+// Flush flushes any buffered output.
+func (m *Machine) Flush() error {
+	return m.out.Flush()
+}
+
+// Run executes the program until it halts or fails.
+func (m *Machine) Run() error {
+	return m.RunContext(context.Background())
+}
+
+// RunContext executes the program until it halts, fails, exceeds one of its
+// Limits, or the given context is done. A context exceeding its deadline is
+// reported as a LimitError.
+func (m *Machine) RunContext(ctx context.Context) error {
+	return m.run(ctx, nil)
+}
+
+// Call invokes the method with the given name as INVOKEVIRTUAL would, pushing
+// a zero object reference followed by the given arguments, and executes until
+// it returns. Returns the value returned by the method.
+func (m *Machine) Call(ctx context.Context, name string, args ...int32) (int32, error) {
+	method := m.prog.MethodByName(name)
+	if method == nil || method.Addr == 0 {
+		return 0, fmt.Errorf("unknown method `%s`", name)
+	}
+	if method.NumParams != len(args)+1 {
+		return 0, fmt.Errorf("method `%s` takes %d arguments, got %d", name, method.NumParams-1, len(args))
+	}
+
+	depth := len(m.frames)
+	if err := m.enter(method, args); err != nil {
+		return 0, err
+	}
+	if err := m.run(ctx, func() bool { return len(m.frames) <= depth }); err != nil {
+		return 0, err
+	}
+	if len(m.frames) > depth {
+		return 0, fmt.Errorf("method `%s` halted before returning", name)
+	}
+	return m.Pop(), nil
+}
+
+// Pushes a zero object reference and the given arguments, and invokes the
+// method, returning any limit it exceeded while doing so
+func (m *Machine) enter(method *Method, args []int32) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			x, ok := r.(error)
+			if !ok {
+				panic(r)
+			}
+			err = m.fault(m.pc, x)
+		}
+	}()
+
+	m.Push(0)
+	for _, arg := range args {
+		m.Push(arg)
+	}
+	m.Invoke(int32(method.Addr))
+	return nil
+}
+
+// Executes until the program halts, fails, a limit is exceeded, or done returns
+// true. Without hooks, the predecoded instructions are executed by runFast.
+func (m *Machine) run(ctx context.Context, done func() bool) error {
+	defer m.Flush()
+	if len(m.hooks) == 0 {
+		return m.runFast(ctx, done)
+	}
+	if done == nil {
+		done = func() bool { return false }
+	}
+	for !m.halted && !done() {
+		if m.MaxSteps > 0 && m.steps >= m.MaxSteps {
+			return &LimitError{Limit: LimitSteps, Max: m.MaxSteps}
+		}
+		// Polling the context every step is too expensive
+		if m.steps&0x3FF == 0 {
+			select {
+			case <-ctx.Done():
+				return contextError(ctx)
+			default:
+			}
+		}
+		if err := m.Step(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Step executes a single instruction.
+func (m *Machine) Step() (err error) {
+	if m.halted {
+		return ErrHalted
+	}
+
+	// Falling off the end of the program terminates it
+	if int(m.pc) == len(m.prog.Text) {
+		m.halted = true
+		return nil
+	}
+
+	inst, err := m.fetch(m.pc)
+	if err != nil {
+		return m.fault(m.pc, err)
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			switch x := r.(type) {
+			case error:
+				err = m.fault(inst.PC, x)
+				for _, h := range m.hooks {
+					if fh, ok := h.(FailHook); ok {
+						fh.Failed(m, inst, err)
+					}
+				}
+			default:
+				panic(r)
+			}
+		}
+	}()
+
+	m.writes = m.writes[:0]
+	for _, h := range m.hooks {
+		h.Before(m, inst)
+	}
+
+	m.pc = inst.PC + inst.Size
+	m.exec(inst)
+	m.steps++
+
+	for _, h := range m.hooks {
+		h.After(m, inst)
+	}
+	return nil
+}
+
+// Fetches the decoded instruction at the given byte offset
+func (m *Machine) fetch(pc uint32) (*Instr, error) {
+	if int(pc) < len(m.prog.at) {
+		if idx := m.prog.at[pc]; idx >= 0 && m.prog.code[idx].kind != kindTrap {
+			return m.prog.code[idx], nil
+		}
+	}
+	if inst, ok := m.cache[pc]; ok {
+		return inst, nil
+	}
+	inst, err := Decode(m.prog.Text, pc, m.prog.ops)
+	if err != nil {
+		return nil, err
+	}
+	m.cache[pc] = inst
+	return inst, nil
+}
+
+// Wraps err into a RuntimeError located at the given byte offset
+func (m *Machine) fault(pc uint32, err error) error {
+	rerr := &RuntimeError{PC: pc, Line: m.prog.Line(pc), Err: err}
+	if method := m.prog.MethodAt(pc); method != nil {
+		rerr.Method = method.Name
+	}
+	return rerr
+}
+
+// Executes a single instruction, panicking with an error on failure
+func (m *Machine) exec(inst *Instr) {
+	h, ok := m.instructions[inst.Op.Name]
+	if !ok {
+		panic(fmt.Errorf("operation %s is not supported", inst.Op.Name))
+	}
+	if err := h.Exec(m, inst); err != nil {
+		panic(err)
+	}
+}
+
+// Push pushes v onto the operand stack.
+func (m *Machine) Push(v int32) {
+	checkLimit(LimitStackDepth, uint64(len(m.stack)+1), m.MaxStackDepth)
+	m.stack = append(m.stack, v)
+}
+
+// Pop pops the top of the operand stack of the current frame.
+func (m *Machine) Pop() int32 {
+	if len(m.stack) <= m.frame.Base {
+		panic(ErrStackUnderflow)
+	}
+	v := m.stack[len(m.stack)-1]
+	m.stack = m.stack[:len(m.stack)-1]
+	return v
+}
+
+// Constant returns the constant with the given index.
+func (m *Machine) Constant(idx int32) int32 {
+	if int(idx) >= len(m.prog.Constants) {
+		panic(fmt.Errorf("constant index %d out of range", idx))
+	}
+	return m.prog.Constants[idx]
+}
+
+// Local returns the local variable with the given index of the current frame.
+func (m *Machine) Local(idx int) int32 {
+	if idx >= len(m.frame.Locals) {
+		if len(m.frames) > 1 {
+			panic(fmt.Errorf("local variable %d out of range", idx))
+		}
+		// Main has no header declaring its amount of locals, so it grows on demand
+		return 0
+	}
+	return m.frame.Locals[idx]
+}
+
+// SetLocal sets the local variable with the given index of the current frame.
+func (m *Machine) SetLocal(idx int, v int32) {
+	if idx >= len(m.frame.Locals) {
+		if len(m.frames) > 1 {
+			panic(fmt.Errorf("local variable %d out of range", idx))
+		}
+		m.frame.Locals = append(m.frame.Locals, make([]int32, idx+1-len(m.frame.Locals))...)
+	}
+	m.frame.Locals[idx] = v
+	m.writes = append(m.writes, LocalWrite{Index: idx, Value: v})
+}
+
+// Alloc allocates an array and returns its reference, collecting garbage
+// first when under allocation pressure.
+func (m *Machine) Alloc(size int32, refs bool) int32 {
+	if m.heap.Pressure() {
+		m.Collect()
+	}
+	if m.MaxHeapWords > 0 && size > 0 {
+		words := func() uint64 { return m.heap.Stats().LiveWords + uint64(size) }
+		if words() > m.MaxHeapWords {
+			m.Collect()
+		}
+		checkLimit(LimitHeap, words(), m.MaxHeapWords)
+	}
+	ref, err := m.heap.Alloc(size, refs)
+	if err != nil {
+		panic(err)
+	}
+	return ref
+}
+
+// Collect collects garbage, using the operand stack and all local variables as roots.
+func (m *Machine) Collect() {
+	roots := make([][]int32, 0, len(m.frames)+1)
+	roots = append(roots, m.stack)
+	for _, f := range m.frames {
+		roots = append(roots, f.Locals)
+	}
+	m.heap.Collect(roots...)
+}
+
+// Invoke invokes the method whose header is located at the given address,
+// passing the parameters on top of the operand stack.
+func (m *Machine) Invoke(addr int32) {
+	method, err := m.prog.Method(uint32(addr))
+	if err != nil {
+		panic(err)
+	}
+
+	n := method.NumParams
+	if len(m.stack)-m.frame.Base < n {
+		panic(ErrStackUnderflow)
+	}
+
+	checkLimit(LimitFrames, uint64(len(m.frames)+1), m.MaxFrames)
+
+	frame := &Frame{
+		Method: method,
+		Locals: make([]int32, n+method.NumLocals),
+		Base:   len(m.stack) - n,
+		Return: m.pc,
+	}
+	copy(frame.Locals, m.stack[frame.Base:])
+	m.stack = m.stack[:frame.Base]
+
+	m.frames = append(m.frames, frame)
+	m.frame = frame
+	m.pc = method.Start
+}
+
+// TailCall invokes the method whose header is located at the given address,
+// reusing the current frame. The invoked method returns directly to the current caller.
+func (m *Machine) TailCall(addr int32) {
+	method, err := m.prog.Method(uint32(addr))
+	if err != nil {
+		panic(err)
+	}
+
+	n := method.NumParams
+	if len(m.stack)-m.frame.Base < n {
+		panic(ErrStackUnderflow)
+	}
+
+	frame := m.frame
+	size := n + method.NumLocals
+	if cap(frame.Locals) >= size {
+		frame.Locals = frame.Locals[:size]
+		for i := n; i < size; i++ {
+			frame.Locals[i] = 0
+		}
+	} else {
+		frame.Locals = make([]int32, size)
+	}
+	copy(frame.Locals, m.stack[len(m.stack)-n:])
+	m.stack = m.stack[:frame.Base]
+
+	frame.Method = method
+	m.pc = method.Start
+}
+
+// Return returns from the current method, pushing its return value onto the
+// caller's stack. Returning from main halts the machine.
+func (m *Machine) Return() {
+	if len(m.frames) == 1 {
+		m.halted = true
+		return
+	}
+
+	v := m.Pop()
+	frame := m.frame
+	m.stack = m.stack[:frame.Base]
+	m.frames = m.frames[:len(m.frames)-1]
+	m.frame = m.frames[len(m.frames)-1]
+	m.Push(v)
+	m.pc = frame.Return
+}
@@ -0,0 +1,112 @@
+package ijvmemu
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/BlackNovaTech/gojasm/ijvmasm"
+	"github.com/BlackNovaTech/gojasm/opconf"
+	"github.com/sirupsen/logrus"
+)
+
+func init() {
+	logrus.SetLevel(logrus.WarnLevel)
+}
+
+// Assembles one of the programs in testdata
+func loadTestProgram(tb testing.TB, name string) *Program {
+	asm := ijvmasm.NewAssembler(filepath.Join("testdata", name), opconf.NewDefaultOpConfig())
+	if ok, err := asm.Parse(); !ok || err != nil {
+		tb.Fatalf("assembling %s failed: %v", name, err)
+	}
+	prog, err := FromAssembler(asm)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return prog
+}
+
+// Assembles the JAS source using the given configuration
+func assembleSource(tb testing.TB, ops *opconf.OpConfig, src string) *Program {
+	path := filepath.Join(tb.TempDir(), "test.jas")
+	if err := ioutil.WriteFile(path, []byte(src), 0644); err != nil {
+		tb.Fatal(err)
+	}
+	asm := ijvmasm.NewAssembler(path, ops)
+	if ok, err := asm.Parse(); !ok || err != nil {
+		tb.Fatalf("assembly failed: %v", err)
+	}
+	prog, err := FromAssembler(asm)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return prog
+}
+
+// Assembles the JAS source using the sample ijvm.config of the repository
+func assembleExtended(tb testing.TB, src string) *Program {
+	return assembleSource(tb, opconf.NewOpConfigFromPath(filepath.Join("..", "ijvm.config")), src)
+}
+
+// A hook doing nothing, forcing the machine to step through instructions one by one
+type nopHook struct{}
+
+func (nopHook) Before(m *Machine, in *Instr) {}
+func (nopHook) After(m *Machine, in *Instr)  {}
+
+func benchmarkProgram(b *testing.B, name string, hooked bool) {
+	prog := loadTestProgram(b, name)
+	var steps uint64
+	var elapsed time.Duration
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		m := NewMachine(prog, strings.NewReader(""), ioutil.Discard)
+		if hooked {
+			m.AddHook(nopHook{})
+		}
+
+		start := time.Now()
+		if err := m.Run(); err != nil {
+			b.Fatal(err)
+		}
+		elapsed += time.Since(start)
+		steps += m.Steps()
+	}
+	b.ReportMetric(float64(steps)/elapsed.Seconds(), "instr/s")
+}
+
+func BenchmarkLoop(b *testing.B)             { benchmarkProgram(b, "loop.jas", false) }
+func BenchmarkLoopStepped(b *testing.B)      { benchmarkProgram(b, "loop.jas", true) }
+func BenchmarkRecursion(b *testing.B)        { benchmarkProgram(b, "recursion.jas", false) }
+func BenchmarkRecursionStepped(b *testing.B) { benchmarkProgram(b, "recursion.jas", true) }
+func BenchmarkPrint(b *testing.B)            { benchmarkProgram(b, "print.jas", false) }
+func BenchmarkPrintStepped(b *testing.B)     { benchmarkProgram(b, "print.jas", true) }
+
+// The predecoded and stepping interpreters must agree on the outcome of every program
+func TestFastMatchesStepped(t *testing.T) {
+	for _, name := range []string{"loop.jas", "recursion.jas", "print.jas"} {
+		prog := loadTestProgram(t, name)
+		run := func(hooked bool) (string, uint64) {
+			out := new(strings.Builder)
+			m := NewMachine(prog, strings.NewReader(""), out)
+			if hooked {
+				m.AddHook(nopHook{})
+			}
+			if err := m.Run(); err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+			return out.String(), m.Steps()
+		}
+
+		fastOut, fastSteps := run(false)
+		stepOut, stepSteps := run(true)
+		if fastOut != stepOut || fastSteps != stepSteps {
+			t.Errorf("%s: fast run produced %q in %d steps, stepped run %q in %d steps",
+				name, fastOut, fastSteps, stepOut, stepSteps)
+		}
+	}
+}
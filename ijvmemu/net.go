@@ -0,0 +1,160 @@
+package ijvmemu
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// DefaultNetTimeout is the time NET* operations wait before giving up
+const DefaultNetTimeout = 10 * time.Second
+
+// ErrNetDisabled is raised by NET* operations when the machine has no NetBackend
+var ErrNetDisabled = errors.New("networking is disabled")
+
+// NetBackend provides the connections used by the NET* operations.
+// Implementations must give up after the given timeout.
+type NetBackend interface {
+	// Listen waits for a single incoming connection on the given port.
+	Listen(port int, timeout time.Duration) (net.Conn, error)
+	// Dial connects to the given port of the given IPv4 host.
+	Dial(host uint32, port int, timeout time.Duration) (net.Conn, error)
+}
+
+// TCPBackend is a NetBackend using loopback TCP connections.
+// Connections to hosts other than loopback are refused.
+type TCPBackend struct{}
+
+// Listen implements NetBackend
+func (TCPBackend) Listen(port int, timeout time.Duration) (net.Conn, error) {
+	ln, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+	if err != nil {
+		return nil, err
+	}
+	defer ln.Close()
+
+	ln.(*net.TCPListener).SetDeadline(time.Now().Add(timeout))
+	return ln.Accept()
+}
+
+// Dial implements NetBackend
+func (TCPBackend) Dial(host uint32, port int, timeout time.Duration) (net.Conn, error) {
+	ip := net.IPv4(byte(host>>24), byte(host>>16), byte(host>>8), byte(host))
+	if !ip.IsLoopback() {
+		return nil, fmt.Errorf("connecting to %s refused, only loopback is allowed", ip)
+	}
+	return net.DialTimeout("tcp", fmt.Sprintf("%s:%d", ip, port), timeout)
+}
+
+// PipeBackend is a NetBackend connecting its peers using in-memory pipes,
+// ignoring hosts. Both the program and Go code (e.g. tests) can act as peers.
+type PipeBackend struct {
+	mu    sync.Mutex
+	ports map[int]chan net.Conn
+}
+
+// NewPipeBackend returns a PipeBackend without any listeners.
+func NewPipeBackend() *PipeBackend {
+	return &PipeBackend{ports: make(map[int]chan net.Conn)}
+}
+
+func (p *PipeBackend) port(port int) chan net.Conn {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.ports[port]; !ok {
+		p.ports[port] = make(chan net.Conn)
+	}
+	return p.ports[port]
+}
+
+// Listen implements NetBackend
+func (p *PipeBackend) Listen(port int, timeout time.Duration) (net.Conn, error) {
+	select {
+	case conn := <-p.port(port):
+		return conn, nil
+	case <-time.After(timeout):
+		return nil, fmt.Errorf("listening on port %d timed out", port)
+	}
+}
+
+// Dial implements NetBackend
+func (p *PipeBackend) Dial(host uint32, port int, timeout time.Duration) (net.Conn, error) {
+	local, remote := net.Pipe()
+	select {
+	case p.port(port) <- remote:
+		return local, nil
+	case <-time.After(timeout):
+		local.Close()
+		remote.Close()
+		return nil, fmt.Errorf("connecting to port %d timed out", port)
+	}
+}
+
+// An open connection of the program
+type netConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// Returns the connection referenced by the given netref
+func (m *Machine) netConn(ref int32) *netConn {
+	c, ok := m.conns[ref]
+	if !ok {
+		panic(fmt.Errorf("invalid netref %d", ref))
+	}
+	return c
+}
+
+// Registers a new connection and returns its netref, or 0 if it failed to open
+func (m *Machine) netOpen(open func(NetBackend) (net.Conn, error)) int32 {
+	if m.Net == nil {
+		panic(ErrNetDisabled)
+	}
+	conn, err := open(m.Net)
+	if err != nil {
+		return 0
+	}
+
+	m.nextConn++
+	m.conns[m.nextConn] = &netConn{conn: conn, reader: bufio.NewReader(conn)}
+	return m.nextConn
+}
+
+// Reads a single byte from the connection, returning 0 on end of stream or timeout
+func (m *Machine) netIn(ref int32) int32 {
+	c := m.netConn(ref)
+	c.conn.SetReadDeadline(time.Now().Add(m.NetTimeout))
+	b, err := c.reader.ReadByte()
+	if err != nil {
+		return 0
+	}
+	return int32(b)
+}
+
+// Writes a single byte to the connection, discarding it when the peer is gone or on timeout
+func (m *Machine) netOut(ref, v int32) {
+	c := m.netConn(ref)
+	c.conn.SetWriteDeadline(time.Now().Add(m.NetTimeout))
+	c.conn.Write([]byte{byte(v)})
+}
+
+func (m *Machine) netClose(ref int32) {
+	c := m.netConn(ref)
+	c.conn.Close()
+	delete(m.conns, ref)
+}
+
+// Close closes every connection the program left open.
+func (m *Machine) Close() error {
+	var err error
+	for ref, c := range m.conns {
+		if cerr := c.conn.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+		delete(m.conns, ref)
+	}
+	return err
+}
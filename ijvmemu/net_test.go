@@ -0,0 +1,252 @@
+package ijvmemu
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Runs the program in the background on the backend, returning a channel
+// receiving the error it stops with
+func runNet(prog *Program, backend NetBackend, timeout time.Duration) <-chan error {
+	done := make(chan error, 1)
+	go func() {
+		m := NewMachine(prog, strings.NewReader(""), ioutil.Discard)
+		m.Net = backend
+		m.NetTimeout = timeout
+		m.MaxSteps = 1 << 20
+		defer m.Close()
+		done <- m.Run()
+	}()
+	return done
+}
+
+// Runs the program on the backend, returning its output
+func runNetOutput(t *testing.T, prog *Program, backend NetBackend, timeout time.Duration) string {
+	out := new(strings.Builder)
+	m := NewMachine(prog, strings.NewReader(""), out)
+	m.Net = backend
+	m.NetTimeout = timeout
+	m.MaxSteps = 1 << 20
+	defer m.Close()
+	if err := m.Run(); err != nil {
+		t.Fatal(err)
+	}
+	return out.String()
+}
+
+// Echoes every byte received on port 80 incremented by one, until the peer
+// closes the connection
+const netEcho = `
+.main
+.var
+conn
+c
+.end-var
+BIPUSH 80
+NETBIND
+ISTORE conn
+loop:
+ILOAD conn
+NETIN
+DUP
+ISTORE c
+IFEQ done
+ILOAD c
+BIPUSH 1
+IADD
+ILOAD conn
+NETOUT
+GOTO loop
+done:
+ILOAD conn
+NETCLOSE
+HALT
+.end-main
+`
+
+func TestNetBind(t *testing.T) {
+	backend := NewPipeBackend()
+	done := runNet(assembleExtended(t, netEcho), backend, time.Second)
+
+	conn, err := backend.Dial(0, 80, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Write([]byte("abc")); err != nil {
+		t.Fatal(err)
+	}
+	got := make([]byte, 3)
+	if _, err := io.ReadFull(conn, got); err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "bcd" {
+		t.Errorf("echoed %q, want %q", got, "bcd")
+	}
+	conn.Close()
+
+	if err := <-done; err != nil {
+		t.Errorf("program failed: %v", err)
+	}
+}
+
+// Connects to port 80, sends "hi", and prints the byte received in reply
+const netClient = `
+.main
+.var
+conn
+.end-var
+BIPUSH 0
+BIPUSH 80
+NETCONNECT
+DUP
+ISTORE conn
+IFEQ failed
+BIPUSH 'h'
+ILOAD conn
+NETOUT
+BIPUSH 'i'
+ILOAD conn
+NETOUT
+ILOAD conn
+NETIN
+OUT
+ILOAD conn
+NETCLOSE
+HALT
+failed:
+BIPUSH '-'
+OUT
+HALT
+.end-main
+`
+
+func TestNetConnect(t *testing.T) {
+	backend := NewPipeBackend()
+	received := make(chan string, 1)
+	go func() {
+		conn, err := backend.Listen(80, time.Second)
+		if err != nil {
+			received <- err.Error()
+			return
+		}
+		defer conn.Close()
+		got := make([]byte, 2)
+		if _, err := io.ReadFull(conn, got); err != nil {
+			received <- err.Error()
+			return
+		}
+		conn.Write([]byte("!"))
+		// The program closing its end ends the stream
+		rest, _ := ioutil.ReadAll(conn)
+		received <- string(got) + string(rest)
+	}()
+
+	out := runNetOutput(t, assembleExtended(t, netClient), backend, time.Second)
+	if out != "!" {
+		t.Errorf("printed %q, want %q", out, "!")
+	}
+	if got := <-received; got != "hi" {
+		t.Errorf("peer received %q, want %q", got, "hi")
+	}
+}
+
+// A backend waiting for the peer to listen regardless of the timeout of the
+// machine, so only reads time out
+type patientDial struct {
+	*PipeBackend
+}
+
+func (b patientDial) Dial(host uint32, port int, timeout time.Duration) (net.Conn, error) {
+	return b.PipeBackend.Dial(host, port, time.Second)
+}
+
+// Operations waiting on a peer give up after the timeout, without failing
+func TestNetTimeout(t *testing.T) {
+	// Nobody connects or listens
+	prog := assembleExtended(t, `
+.main
+BIPUSH 80
+NETBIND
+BIPUSH '0'
+IADD
+OUT
+BIPUSH 0
+BIPUSH 80
+NETCONNECT
+BIPUSH '0'
+IADD
+OUT
+HALT
+.end-main
+`)
+	if out := runNetOutput(t, prog, NewPipeBackend(), 10*time.Millisecond); out != "00" {
+		t.Errorf("printed %q, want %q", out, "00")
+	}
+
+	// The peer never writes, so NETIN reads 0
+	backend := NewPipeBackend()
+	go func() {
+		if conn, err := backend.Listen(80, time.Second); err == nil {
+			defer conn.Close()
+			ioutil.ReadAll(conn)
+		}
+	}()
+	if out := runNetOutput(t, assembleExtended(t, netClient), patientDial{backend}, 10*time.Millisecond); out != "\x00" {
+		t.Errorf("printed %q, want %q", out, "\x00")
+	}
+}
+
+// Reading from a connection closed by the peer gives 0, and writing to it is
+// discarded
+func TestNetPeerClosed(t *testing.T) {
+	backend := NewPipeBackend()
+	go func() {
+		if conn, err := backend.Listen(80, time.Second); err == nil {
+			conn.Close()
+		}
+	}()
+	if out := runNetOutput(t, assembleExtended(t, netClient), backend, time.Second); out != "\x00" {
+		t.Errorf("printed %q, want %q", out, "\x00")
+	}
+}
+
+func TestNetErrors(t *testing.T) {
+	// Connections can no longer be used once closed
+	backend := NewPipeBackend()
+	go func() {
+		if conn, err := backend.Listen(80, time.Second); err == nil {
+			conn.Close()
+		}
+	}()
+	prog := assembleExtended(t, `
+.main
+.var
+conn
+.end-var
+BIPUSH 0
+BIPUSH 80
+NETCONNECT
+ISTORE conn
+ILOAD conn
+NETCLOSE
+ILOAD conn
+NETIN
+HALT
+.end-main
+`)
+	err := <-runNet(prog, backend, time.Second)
+	if err == nil || !strings.Contains(err.Error(), "invalid netref 1") {
+		t.Errorf("expected an invalid netref, got %v", err)
+	}
+
+	// Without a backend, networking is disabled
+	err = <-runNet(prog, nil, time.Second)
+	if !errors.Is(err, ErrNetDisabled) {
+		t.Errorf("expected %v, got %v", ErrNetDisabled, err)
+	}
+}
@@ -0,0 +1,193 @@
+package ijvmemu
+
+import (
+	"compress/gzip"
+	"io"
+)
+
+// Minimal protocol buffer encoder, sufficient for writing pprof profiles
+// (github.com/google/pprof/proto/profile.proto) without extra dependencies.
+type protoBuffer struct {
+	data []byte
+}
+
+func (b *protoBuffer) varint(x uint64) {
+	for x >= 0x80 {
+		b.data = append(b.data, byte(x)|0x80)
+		x >>= 7
+	}
+	b.data = append(b.data, byte(x))
+}
+
+func (b *protoBuffer) tag(field, wire int) {
+	b.varint(uint64(field)<<3 | uint64(wire))
+}
+
+func (b *protoBuffer) uint64(field int, x uint64) {
+	if x == 0 {
+		return
+	}
+	b.tag(field, 0)
+	b.varint(x)
+}
+
+func (b *protoBuffer) int64(field int, x int64) {
+	b.uint64(field, uint64(x))
+}
+
+func (b *protoBuffer) bytes(field int, data []byte) {
+	b.tag(field, 2)
+	b.varint(uint64(len(data)))
+	b.data = append(b.data, data...)
+}
+
+func (b *protoBuffer) string(field int, s string) {
+	b.bytes(field, []byte(s))
+}
+
+func (b *protoBuffer) packed(field int, xs []uint64) {
+	if len(xs) == 0 {
+		return
+	}
+	nested := &protoBuffer{}
+	for _, x := range xs {
+		nested.varint(x)
+	}
+	b.bytes(field, nested.data)
+}
+
+func (b *protoBuffer) message(field int, encode func(*protoBuffer)) {
+	nested := &protoBuffer{}
+	encode(nested)
+	b.bytes(field, nested.data)
+}
+
+// Field numbers of profile.proto
+const (
+	pprofSampleType  = 1
+	pprofSample      = 2
+	pprofLocation    = 4
+	pprofFunction    = 5
+	pprofStringTable = 6
+	pprofPeriodType  = 11
+	pprofPeriod      = 12
+
+	pprofValueTypeType = 1
+	pprofValueTypeUnit = 2
+
+	pprofSampleLocation = 1
+	pprofSampleValue    = 2
+
+	pprofLocationID      = 1
+	pprofLocationAddress = 3
+	pprofLocationLine    = 4
+
+	pprofLineFunction = 1
+	pprofLineLine     = 2
+
+	pprofFunctionID        = 1
+	pprofFunctionName      = 2
+	pprofFunctionSystem    = 3
+	pprofFunctionFilename  = 4
+	pprofFunctionStartLine = 5
+)
+
+// pprofSampleData is a single sample of a pprof profile.
+type pprofSampleData struct {
+	// Stack holds the program counters of the sample, innermost first
+	Stack  []uint32
+	Values []int64
+}
+
+// Writes a gzipped pprof profile of the given samples to out
+func writePprof(out io.Writer, prog *Program, types [][2]string, samples []*pprofSampleData) error {
+	strings := []string{""}
+	stringIdx := map[string]int64{"": 0}
+	str := func(s string) int64 {
+		if idx, ok := stringIdx[s]; ok {
+			return idx
+		}
+		stringIdx[s] = int64(len(strings))
+		strings = append(strings, s)
+		return stringIdx[s]
+	}
+
+	b := &protoBuffer{}
+	for _, t := range types {
+		b.message(pprofSampleType, func(vt *protoBuffer) {
+			vt.int64(pprofValueTypeType, str(t[0]))
+			vt.int64(pprofValueTypeUnit, str(t[1]))
+		})
+	}
+
+	locations := make(map[uint32]uint64)
+	var locationOrder []uint32
+	for _, s := range samples {
+		ids := make([]uint64, len(s.Stack))
+		for i, pc := range s.Stack {
+			if _, ok := locations[pc]; !ok {
+				locations[pc] = uint64(len(locations) + 1)
+				locationOrder = append(locationOrder, pc)
+			}
+			ids[i] = locations[pc]
+		}
+		values := make([]uint64, len(s.Values))
+		for i, v := range s.Values {
+			values[i] = uint64(v)
+		}
+		b.message(pprofSample, func(sb *protoBuffer) {
+			sb.packed(pprofSampleLocation, ids)
+			sb.packed(pprofSampleValue, values)
+		})
+	}
+
+	file := ""
+	if prog.Debug != nil {
+		file = prog.Debug.File
+	}
+
+	functions := make(map[*Method]uint64)
+	var functionOrder []*Method
+	for _, pc := range locationOrder {
+		method := prog.MethodAt(pc)
+		if _, ok := functions[method]; !ok {
+			functions[method] = uint64(len(functions) + 1)
+			functionOrder = append(functionOrder, method)
+		}
+		b.message(pprofLocation, func(lb *protoBuffer) {
+			lb.uint64(pprofLocationID, locations[pc])
+			lb.uint64(pprofLocationAddress, uint64(pc))
+			lb.message(pprofLocationLine, func(line *protoBuffer) {
+				line.uint64(pprofLineFunction, functions[method])
+				line.int64(pprofLineLine, int64(prog.Line(pc)))
+			})
+		})
+	}
+
+	for _, method := range functionOrder {
+		b.message(pprofFunction, func(fb *protoBuffer) {
+			fb.uint64(pprofFunctionID, functions[method])
+			fb.int64(pprofFunctionName, str(method.Name))
+			fb.int64(pprofFunctionSystem, str(method.Name))
+			fb.int64(pprofFunctionFilename, str(file))
+			fb.int64(pprofFunctionStartLine, int64(method.N))
+		})
+	}
+
+	b.message(pprofPeriodType, func(vt *protoBuffer) {
+		vt.int64(pprofValueTypeType, str(types[0][0]))
+		vt.int64(pprofValueTypeUnit, str(types[0][1]))
+	})
+	b.int64(pprofPeriod, 1)
+
+	// The string table is written last, as the messages above still register strings
+	for _, s := range strings {
+		b.string(pprofStringTable, s)
+	}
+
+	gz := gzip.NewWriter(out)
+	if _, err := gz.Write(b.data); err != nil {
+		return err
+	}
+	return gz.Close()
+}
@@ -0,0 +1,447 @@
+package ijvmemu
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/BlackNovaTech/gojasm/ijvmasm"
+	"github.com/BlackNovaTech/gojasm/opconf"
+)
+
+// Cost is the amount of executed instructions and estimated clock cycles.
+type Cost struct {
+	Instructions uint64
+	Cycles       uint64
+}
+
+func (c *Cost) add(o Cost) {
+	c.Instructions += o.Instructions
+	c.Cycles += o.Cycles
+}
+
+func (c Cost) sub(o Cost) Cost {
+	return Cost{c.Instructions - o.Instructions, c.Cycles - o.Cycles}
+}
+
+// Profiler is a Hook counting executed instructions per opcode, method,
+// source line and basic block, and estimating the clock cycles they took.
+type Profiler struct {
+	costs *opconf.CostTable
+
+	total Cost
+
+	root   *callNode
+	node   *callNode
+	instrs map[uint32]*Instr
+
+	// Basic block leaders, as observed during execution
+	leaders  map[uint32]bool
+	next     uint32
+	branched bool
+
+	calls     map[*Method]uint64
+	inclusive map[*Method]*Cost
+	active    map[*Method]int
+	entries   []*callEntry
+
+	frame *Frame
+	depth int
+}
+
+// Node in the tree of observed call stacks
+type callNode struct {
+	parent   *callNode
+	site     uint32
+	method   *Method
+	children map[callSite]*callNode
+	counts   map[uint32]uint64
+}
+
+type callSite struct {
+	pc     uint32
+	method *Method
+}
+
+// A method invocation that has not yet returned
+type callEntry struct {
+	method    *Method
+	start     Cost
+	outermost bool
+}
+
+// NewProfiler returns a Profiler estimating cycles using the given cost table.
+func NewProfiler(costs *opconf.CostTable) *Profiler {
+	root := &callNode{
+		children: make(map[callSite]*callNode),
+		counts:   make(map[uint32]uint64),
+	}
+	return &Profiler{
+		costs:     costs,
+		root:      root,
+		node:      root,
+		instrs:    make(map[uint32]*Instr),
+		leaders:   make(map[uint32]bool),
+		calls:     make(map[*Method]uint64),
+		inclusive: make(map[*Method]*Cost),
+		active:    make(map[*Method]int),
+	}
+}
+
+// Before implements Hook
+func (p *Profiler) Before(m *Machine, in *Instr) {
+	p.frame = m.Frame()
+	p.depth = len(m.Frames())
+	if p.total.Instructions == 0 || p.branched || in.PC != p.next {
+		p.leaders[in.PC] = true
+	}
+}
+
+// After implements Hook
+func (p *Profiler) After(m *Machine, in *Instr) {
+	p.count(in)
+
+	p.next = in.PC + in.Size
+	p.branched = isControl(in.Op)
+
+	if frame := m.Frame(); frame != p.frame || frame.Method != p.frame.Method {
+		depth := len(m.Frames())
+		if depth <= p.depth {
+			p.leave()
+		}
+		if depth >= p.depth {
+			p.enter(frame.Method, in.PC)
+		}
+	}
+}
+
+// Failed implements FailHook, the failing instruction is counted as executed
+func (p *Profiler) Failed(m *Machine, in *Instr, err error) {
+	p.count(in)
+}
+
+// Counts a single execution of the instruction in the current call stack
+func (p *Profiler) count(in *Instr) {
+	if _, ok := p.instrs[in.PC]; !ok {
+		p.instrs[in.PC] = in
+	}
+	p.node.counts[in.PC]++
+	p.total.add(Cost{1, p.cost(in)})
+}
+
+// Estimated cycles of a single instruction
+func (p *Profiler) cost(in *Instr) uint64 {
+	cost := p.costs.Cost(in.Op.Name)
+	if in.Wide {
+		cost += p.costs.Cost(ijvmasm.OperationWide)
+	}
+	return cost
+}
+
+func (p *Profiler) enter(method *Method, site uint32) {
+	key := callSite{site, method}
+	child, ok := p.node.children[key]
+	if !ok {
+		child = &callNode{
+			parent:   p.node,
+			site:     site,
+			method:   method,
+			children: make(map[callSite]*callNode),
+			counts:   make(map[uint32]uint64),
+		}
+		p.node.children[key] = child
+	}
+	p.node = child
+
+	p.calls[method]++
+	p.entries = append(p.entries, &callEntry{
+		method:    method,
+		start:     p.total,
+		outermost: p.active[method] == 0,
+	})
+	p.active[method]++
+}
+
+func (p *Profiler) leave() {
+	if len(p.entries) == 0 {
+		return
+	}
+	entry := p.entries[len(p.entries)-1]
+	p.entries = p.entries[:len(p.entries)-1]
+	p.active[entry.method]--
+
+	// Recursive invocations are already covered by the outermost one
+	if entry.outermost {
+		if _, ok := p.inclusive[entry.method]; !ok {
+			p.inclusive[entry.method] = &Cost{}
+		}
+		p.inclusive[entry.method].add(p.total.sub(entry.start))
+	}
+	p.node = p.node.parent
+}
+
+// Returns true iff the operation can transfer control
+func isControl(op *opconf.Operation) bool {
+	for _, arg := range op.Args {
+		if arg == opconf.ArgLabel || arg == opconf.ArgMethod {
+			return true
+		}
+	}
+	return op.Name == "IRETURN"
+}
+
+// Total returns the cost of the whole execution.
+func (p *Profiler) Total() Cost {
+	return p.total
+}
+
+// Merged instruction counts of every call stack
+func (p *Profiler) counts() map[uint32]uint64 {
+	counts := make(map[uint32]uint64)
+	var walk func(n *callNode)
+	walk = func(n *callNode) {
+		for pc, c := range n.counts {
+			counts[pc] += c
+		}
+		for _, child := range n.children {
+			walk(child)
+		}
+	}
+	walk(p.root)
+	return counts
+}
+
+// Inclusive costs per method, including invocations that have not returned yet
+func (p *Profiler) inclusiveCosts() map[*Method]Cost {
+	costs := make(map[*Method]Cost)
+	for method, c := range p.inclusive {
+		costs[method] = *c
+	}
+	for _, entry := range p.entries {
+		if entry.outermost {
+			c := costs[entry.method]
+			c.add(p.total.sub(entry.start))
+			costs[entry.method] = c
+		}
+	}
+	return costs
+}
+
+type profileRow struct {
+	name string
+	Cost
+}
+
+// Sorts rows by descending instruction count, then by name
+func sortRows(rows []*profileRow) {
+	sort.SliceStable(rows, func(i, j int) bool {
+		if rows[i].Instructions != rows[j].Instructions {
+			return rows[i].Instructions > rows[j].Instructions
+		}
+		return rows[i].name < rows[j].name
+	})
+}
+
+// WriteReport writes a human readable profile of the execution of prog to out.
+func (p *Profiler) WriteReport(out io.Writer, prog *Program) error {
+	counts := p.counts()
+	pcs := make([]uint32, 0, len(counts))
+	for pc := range counts {
+		pcs = append(pcs, pc)
+	}
+	sort.Slice(pcs, func(i, j int) bool { return pcs[i] < pcs[j] })
+
+	w := tabwriter.NewWriter(out, 0, 8, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(w, "Executed instructions:\t%d\t\n", p.total.Instructions)
+	fmt.Fprintf(w, "Estimated cycles:\t%d\t\n", p.total.Cycles)
+
+	// Opcodes
+	ops := make(map[string]*profileRow)
+	for _, pc := range pcs {
+		in := p.instrs[pc]
+		name := in.Op.Name
+		if in.Wide {
+			name = ijvmasm.OperationWide + " " + name
+		}
+		if _, ok := ops[name]; !ok {
+			ops[name] = &profileRow{name: name}
+		}
+		ops[name].add(Cost{counts[pc], counts[pc] * p.cost(in)})
+	}
+	rows := make([]*profileRow, 0, len(ops))
+	for _, row := range ops {
+		rows = append(rows, row)
+	}
+	sortRows(rows)
+
+	fmt.Fprintf(w, "\nOpcodes\n")
+	fmt.Fprintf(w, "count\tcycles\t  operation\n")
+	for _, row := range rows {
+		fmt.Fprintf(w, "%d\t%d\t  %s\n", row.Instructions, row.Cycles, row.name)
+	}
+
+	// Methods
+	self := make(map[*Method]*Cost)
+	for _, pc := range pcs {
+		method := prog.MethodAt(pc)
+		if _, ok := self[method]; !ok {
+			self[method] = &Cost{}
+		}
+		self[method].add(Cost{counts[pc], counts[pc] * p.cost(p.instrs[pc])})
+	}
+	inclusive := p.inclusiveCosts()
+	calls := make(map[*Method]uint64)
+	for method, c := range p.calls {
+		calls[method] = c
+	}
+	if main := prog.MethodAt(0); main != nil {
+		inclusive[main] = p.total
+		calls[main]++
+	}
+
+	methods := make([]*Method, 0, len(self))
+	for method := range self {
+		methods = append(methods, method)
+	}
+	sort.Slice(methods, func(i, j int) bool {
+		if inclusive[methods[i]].Instructions != inclusive[methods[j]].Instructions {
+			return inclusive[methods[i]].Instructions > inclusive[methods[j]].Instructions
+		}
+		return methods[i].Addr < methods[j].Addr
+	})
+
+	fmt.Fprintf(w, "\nMethods\n")
+	fmt.Fprintf(w, "calls\tself\tself cycles\ttotal\ttotal cycles\t  method\n")
+	for _, method := range methods {
+		fmt.Fprintf(w, "%d\t%d\t%d\t%d\t%d\t  %s\n", calls[method],
+			self[method].Instructions, self[method].Cycles,
+			inclusive[method].Instructions, inclusive[method].Cycles, method.Name)
+	}
+
+	// Source lines
+	if prog.Debug != nil {
+		source := readSource(prog.Debug.File)
+		lines := make(map[uint32]*profileRow)
+		for _, pc := range pcs {
+			n := prog.Line(pc)
+			if _, ok := lines[n]; !ok {
+				lines[n] = &profileRow{name: fmt.Sprint(n)}
+			}
+			lines[n].add(Cost{counts[pc], counts[pc] * p.cost(p.instrs[pc])})
+		}
+		keys := make([]uint32, 0, len(lines))
+		for n := range lines {
+			keys = append(keys, n)
+		}
+		sort.Slice(keys, func(i, j int) bool {
+			if lines[keys[i]].Instructions != lines[keys[j]].Instructions {
+				return lines[keys[i]].Instructions > lines[keys[j]].Instructions
+			}
+			return keys[i] < keys[j]
+		})
+
+		fmt.Fprintf(w, "\nLines\n")
+		fmt.Fprintf(w, "line\tcount\tcycles\t  source\n")
+		for _, n := range keys {
+			text := ""
+			if int(n) <= len(source) && n > 0 {
+				text = source[n-1]
+			}
+			fmt.Fprintf(w, "%d\t%d\t%d\t  %s\n", n, lines[n].Instructions, lines[n].Cycles, text)
+		}
+	}
+
+	// Basic blocks
+	leaders := make([]uint32, 0, len(p.leaders))
+	for pc := range p.leaders {
+		leaders = append(leaders, pc)
+	}
+	sort.Slice(leaders, func(i, j int) bool { return leaders[i] < leaders[j] })
+
+	blocks := make([]*profileRow, 0, len(leaders))
+	for i, start := range leaders {
+		method := prog.MethodAt(start)
+		end := method.End
+		if i+1 < len(leaders) && leaders[i+1] < end {
+			end = leaders[i+1]
+		}
+
+		block := &profileRow{name: fmt.Sprintf("%s\t%d-%d", method.Name, start, end)}
+		if n := prog.Line(start); n > 0 {
+			block.name += fmt.Sprintf("\tline %d", n)
+		} else {
+			block.name += "\t"
+		}
+		for pc := start; pc < end; {
+			in, ok := p.instrs[pc]
+			if !ok {
+				break
+			}
+			block.add(Cost{counts[pc], counts[pc] * p.cost(in)})
+			pc += in.Size
+		}
+		// The amount of times a block was entered equals the count of its first instruction
+		block.Instructions = counts[start]
+		blocks = append(blocks, block)
+	}
+	sortRows(blocks)
+
+	fmt.Fprintf(w, "\nBasic blocks\n")
+	fmt.Fprintf(w, "count\tcycles\tmethod\tbytes\tsource\t\n")
+	for _, block := range blocks {
+		fmt.Fprintf(w, "%d\t%d\t%s\t\n", block.Instructions, block.Cycles, block.name)
+	}
+
+	return w.Flush()
+}
+
+// WritePprof writes a gzipped pprof profile of the execution of prog to out,
+// sampling both executed instructions and estimated cycles.
+func (p *Profiler) WritePprof(out io.Writer, prog *Program) error {
+	var samples []*pprofSampleData
+	var walk func(n *callNode, stack []uint32)
+	walk = func(n *callNode, stack []uint32) {
+		for pc, c := range n.counts {
+			samples = append(samples, &pprofSampleData{
+				Stack:  append([]uint32{pc}, stack...),
+				Values: []int64{int64(c), int64(c * p.cost(p.instrs[pc]))},
+			})
+		}
+		for _, child := range n.children {
+			walk(child, append([]uint32{child.site}, stack...))
+		}
+	}
+	walk(p.root, nil)
+
+	// Map iteration is random, keep the output deterministic
+	sort.Slice(samples, func(i, j int) bool {
+		a, b := samples[i].Stack, samples[j].Stack
+		for k := 0; k < len(a) && k < len(b); k++ {
+			if a[len(a)-1-k] != b[len(b)-1-k] {
+				return a[len(a)-1-k] < b[len(b)-1-k]
+			}
+		}
+		return len(a) < len(b)
+	})
+
+	return writePprof(out, prog, [][2]string{{"instructions", "count"}, {"cycles", "count"}}, samples)
+}
+
+// Reads the lines of the given source file, or nil if it cannot be read
+func readSource(path string) []string {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer file.Close()
+
+	var lines []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	return lines
+}
@@ -0,0 +1,245 @@
+package ijvmemu
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/BlackNovaTech/gojasm/opconf"
+)
+
+// A decoded field of a protocol buffer message
+type protoField struct {
+	num    int
+	varint uint64
+	data   []byte
+}
+
+// Reads a single varint, returning it and the remaining data
+func readVarint(data []byte) (uint64, []byte, error) {
+	var x uint64
+	for i, b := range data {
+		if i == 10 {
+			break
+		}
+		x |= uint64(b&0x7F) << (7 * uint(i))
+		if b < 0x80 {
+			return x, data[i+1:], nil
+		}
+	}
+	return 0, nil, errors.New("invalid varint")
+}
+
+// Decodes the fields of a message, supporting the wire types the encoder writes
+func readProto(data []byte) ([]protoField, error) {
+	var fields []protoField
+	for len(data) > 0 {
+		tag, rest, err := readVarint(data)
+		if err != nil {
+			return nil, err
+		}
+		f := protoField{num: int(tag >> 3)}
+		switch tag & 7 {
+		case 0:
+			f.varint, rest, err = readVarint(rest)
+		case 2:
+			var n uint64
+			if n, rest, err = readVarint(rest); err == nil {
+				if n > uint64(len(rest)) {
+					return nil, errors.New("truncated field")
+				}
+				f.data, rest = rest[:n], rest[n:]
+			}
+		default:
+			err = errors.New("unsupported wire type")
+		}
+		if err != nil {
+			return nil, err
+		}
+		fields = append(fields, f)
+		data = rest
+	}
+	return fields, nil
+}
+
+// Decodes a packed repeated field
+func readPacked(data []byte) ([]uint64, error) {
+	var xs []uint64
+	for len(data) > 0 {
+		x, rest, err := readVarint(data)
+		if err != nil {
+			return nil, err
+		}
+		xs = append(xs, x)
+		data = rest
+	}
+	return xs, nil
+}
+
+// The parts of a pprof profile checked by the tests
+type decodedProfile struct {
+	types   []string
+	samples []decodedSample
+}
+
+type decodedSample struct {
+	// Functions of the stack, innermost first
+	stack  []string
+	values []uint64
+}
+
+func decodePprof(t *testing.T, data []byte) *decodedProfile {
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := ioutil.ReadAll(gz)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fields, err := readProto(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sub := func(data []byte) map[int]protoField {
+		fs, err := readProto(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		byNum := make(map[int]protoField)
+		for _, f := range fs {
+			byNum[f.num] = f
+		}
+		return byNum
+	}
+
+	var strs []string
+	for _, f := range fields {
+		if f.num == pprofStringTable {
+			strs = append(strs, string(f.data))
+		}
+	}
+	str := func(idx uint64) string {
+		if idx >= uint64(len(strs)) {
+			t.Fatalf("string index %d out of range", idx)
+		}
+		return strs[idx]
+	}
+
+	p := &decodedProfile{}
+	names := make(map[uint64]string)
+	lineFunctions := make(map[uint64]uint64)
+	var samples [][2][]uint64
+	for _, f := range fields {
+		switch f.num {
+		case pprofSampleType:
+			vt := sub(f.data)
+			p.types = append(p.types, str(vt[pprofValueTypeType].varint)+"/"+str(vt[pprofValueTypeUnit].varint))
+		case pprofSample:
+			s := sub(f.data)
+			locs, err := readPacked(s[pprofSampleLocation].data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			values, err := readPacked(s[pprofSampleValue].data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			samples = append(samples, [2][]uint64{locs, values})
+		case pprofLocation:
+			l := sub(f.data)
+			line := sub(l[pprofLocationLine].data)
+			lineFunctions[l[pprofLocationID].varint] = line[pprofLineFunction].varint
+		case pprofFunction:
+			fn := sub(f.data)
+			names[fn[pprofFunctionID].varint] = str(fn[pprofFunctionName].varint)
+		}
+	}
+	for _, s := range samples {
+		var stack []string
+		for _, loc := range s[0] {
+			stack = append(stack, names[lineFunctions[loc]])
+		}
+		p.samples = append(p.samples, decodedSample{stack, s[1]})
+	}
+	return p
+}
+
+// Main of profile.jas costs 5 instructions and 4+4+23+3+1 cycles, inc 4
+// instructions and 6+4+4+9 cycles using the default costs.
+func TestWritePprof(t *testing.T) {
+	prog := loadTestProgram(t, "profile.jas")
+
+	profiler := NewProfiler(opconf.NewDefaultCostTable())
+	m := NewMachine(prog, strings.NewReader(""), ioutil.Discard)
+	m.AddHook(profiler)
+	if err := m.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if total := profiler.Total(); total != (Cost{9, 58}) {
+		t.Errorf("total cost %+v, want 9 instructions and 58 cycles", total)
+	}
+
+	var buf bytes.Buffer
+	if err := profiler.WritePprof(&buf, prog); err != nil {
+		t.Fatal(err)
+	}
+	p := decodePprof(t, buf.Bytes())
+
+	if want := []string{"instructions/count", "cycles/count"}; !reflect.DeepEqual(p.types, want) {
+		t.Errorf("sample types %q, want %q", p.types, want)
+	}
+
+	// Samples are attributed to the innermost function of their stack
+	costs := make(map[string]Cost)
+	var total Cost
+	for _, s := range p.samples {
+		if len(s.values) != 2 {
+			t.Fatalf("sample with %d values, want 2", len(s.values))
+		}
+		want := []string{"main"}
+		if s.stack[0] == "inc" {
+			want = []string{"inc", "main"}
+		}
+		if !reflect.DeepEqual(s.stack, want) {
+			t.Errorf("sample with stack %q, want %q", s.stack, want)
+		}
+		c := Cost{s.values[0], s.values[1]}
+		total.add(c)
+		fn := costs[s.stack[0]]
+		fn.add(c)
+		costs[s.stack[0]] = fn
+	}
+	if total != profiler.Total() {
+		t.Errorf("samples total %+v, want %+v", total, profiler.Total())
+	}
+	if want := map[string]Cost{"main": {5, 35}, "inc": {4, 23}}; !reflect.DeepEqual(costs, want) {
+		t.Errorf("costs per function %+v, want %+v", costs, want)
+	}
+}
+
+// The instruction failing the program is part of the profile.
+func TestProfileFailure(t *testing.T) {
+	prog := loadTestProgram(t, "fault.jas")
+	profiler := NewProfiler(opconf.NewDefaultCostTable())
+	m := NewMachine(prog, strings.NewReader(""), ioutil.Discard)
+	m.AddHook(profiler)
+	if err := m.Run(); !errors.Is(err, ErrErrInstruction) {
+		t.Fatalf("ran with %v, want %v", err, ErrErrInstruction)
+	}
+	if total := profiler.Total(); total != (Cost{4, 16}) {
+		t.Errorf("total cost %+v, want 4 instructions and 16 cycles", total)
+	}
+
+	var buf bytes.Buffer
+	if err := profiler.WriteReport(&buf, prog); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "1       1    ERR") {
+		t.Errorf("report does not count the ERR instruction:\n%s", buf.String())
+	}
+}
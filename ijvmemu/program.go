@@ -0,0 +1,258 @@
+package ijvmemu
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+
+	"github.com/BlackNovaTech/gojasm/ijvmasm"
+	"github.com/BlackNovaTech/gojasm/opconf"
+)
+
+// Program is a loaded IJVM program, ready to be executed by a Machine.
+type Program struct {
+	// Constants is the constant pool of the program
+	Constants []int32
+	// Text is the bytecode of the program
+	Text []byte
+	// Debug optionally maps the program back onto its JAS source
+	Debug *ijvmasm.DebugInfo
+
+	ops     *opconf.OpConfig
+	methods []*Method
+	lines   map[uint32]uint32
+
+	// Predecoded instruction stream, and the index of the instruction at every byte offset
+	code []*Instr
+	at   []int32
+}
+
+// Method is a single method of a loaded program.
+type Method struct {
+	Name string
+	// Addr is the byte offset of the method header, or 0 for main
+	Addr uint32
+	// Start is the byte offset of the first instruction
+	Start uint32
+	// End is the byte offset directly after the last instruction
+	End uint32
+	// NumParams is the amount of parameters, including the object reference
+	NumParams int
+	// NumLocals is the amount of local variables, excluding the parameters
+	NumLocals int
+	// Vars optionally holds the name of every local variable slot
+	Vars []string
+	// N is the source line the method was declared on, or 0 if unknown
+	N uint32
+}
+
+// VarName returns the name of the given local variable slot, or an empty
+// string if it is unknown.
+func (m *Method) VarName(idx int) string {
+	if idx < len(m.Vars) {
+		return m.Vars[idx]
+	}
+	return ""
+}
+
+// LoadBinary loads an IJVM binary, decoding its opcodes using the given operation configuration.
+func LoadBinary(r io.Reader, ops *opconf.OpConfig) (*Program, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := bytes.NewReader(data)
+	var magic uint32
+	if err := binary.Read(buf, binary.BigEndian, &magic); err != nil {
+		return nil, errors.New("binary: missing magic header")
+	}
+	if magic != ijvmasm.Magic {
+		return nil, fmt.Errorf("binary: invalid magic header 0x%08X", magic)
+	}
+
+	prog := &Program{ops: ops}
+
+	blocks := 0
+	for buf.Len() > 0 {
+		var origin, size uint32
+		if err := binary.Read(buf, binary.BigEndian, &origin); err != nil {
+			return nil, fmt.Errorf("binary: block %d: missing origin", blocks)
+		}
+		if err := binary.Read(buf, binary.BigEndian, &size); err != nil {
+			return nil, fmt.Errorf("binary: block %d: missing size", blocks)
+		}
+		if uint32(buf.Len()) < size {
+			return nil, fmt.Errorf("binary: block %d: truncated, expected %d bytes", blocks, size)
+		}
+		block := make([]byte, size)
+		buf.Read(block)
+
+		switch blocks {
+		case 0:
+			if size%4 != 0 {
+				return nil, errors.New("binary: constant pool size is not a multiple of 4")
+			}
+			prog.Constants = make([]int32, size/4)
+			for i := range prog.Constants {
+				prog.Constants[i] = int32(binary.BigEndian.Uint32(block[i*4:]))
+			}
+		case 1:
+			prog.Text = block
+		}
+		blocks++
+	}
+
+	if blocks < 2 {
+		return nil, errors.New("binary: missing constant pool or text block")
+	}
+
+	prog.index()
+	return prog, nil
+}
+
+// FromAssembler generates the binary of a successfully parsed Assembler and
+// loads it, keeping the debug information of the assembler.
+func FromAssembler(asm *ijvmasm.Assembler) (*Program, error) {
+	buf := new(bytes.Buffer)
+	if err := asm.Generate(buf); err != nil {
+		return nil, err
+	}
+
+	prog, err := LoadBinary(buf, asm.OpConfig())
+	if err != nil {
+		return nil, err
+	}
+
+	prog.Debug = asm.DebugInfo()
+	prog.index()
+	return prog, nil
+}
+
+// Builds the method and line lookup tables, and predecodes the instructions
+func (p *Program) index() {
+	p.methods = make([]*Method, 0)
+	p.lines = make(map[uint32]uint32)
+	defer p.predecode()
+
+	if p.Debug == nil {
+		p.methods = append(p.methods, &Method{
+			Name: "main",
+			End:  uint32(len(p.Text)),
+		})
+		return
+	}
+
+	for i, mi := range p.Debug.Methods {
+		m := &Method{
+			Name:      mi.Name,
+			Addr:      mi.B,
+			Start:     mi.B,
+			End:       mi.B + mi.Size,
+			NumParams: mi.NumParams,
+			NumLocals: len(mi.Vars) - mi.NumParams,
+			Vars:      mi.Vars,
+			N:         mi.N,
+		}
+		if i > 0 {
+			m.Start += 4
+		}
+		p.methods = append(p.methods, m)
+
+		for _, l := range mi.Lines {
+			p.lines[l.B] = l.N
+		}
+	}
+
+	sort.Slice(p.methods, func(i, j int) bool {
+		return p.methods[i].Addr < p.methods[j].Addr
+	})
+}
+
+// OpConfig returns the operation configuration used to decode the program.
+func (p *Program) OpConfig() *opconf.OpConfig {
+	return p.ops
+}
+
+// Hash returns the SHA-256 hash of the constant pool and text of the program.
+func (p *Program) Hash() [sha256.Size]byte {
+	h := sha256.New()
+	binary.Write(h, binary.BigEndian, p.Constants)
+	h.Write(p.Text)
+
+	var sum [sha256.Size]byte
+	copy(sum[:], h.Sum(nil))
+	return sum
+}
+
+// Methods returns every known method of the program, ordered by address.
+func (p *Program) Methods() []*Method {
+	return p.methods
+}
+
+// MethodAt returns the method containing the given byte offset.
+func (p *Program) MethodAt(pc uint32) *Method {
+	i := sort.Search(len(p.methods), func(i int) bool {
+		return p.methods[i].Addr > pc
+	})
+	if i == 0 {
+		return nil
+	}
+	return p.methods[i-1]
+}
+
+// MethodByName returns the method with the given name, or nil if it is unknown.
+func (p *Program) MethodByName(name string) *Method {
+	for _, m := range p.methods {
+		if m.Name == name {
+			return m
+		}
+	}
+	return nil
+}
+
+// Line returns the source line of the instruction at the given byte offset,
+// or 0 if it is unknown.
+func (p *Program) Line(pc uint32) uint32 {
+	return p.lines[pc]
+}
+
+// Method returns the method whose header is located at the given address,
+// registering it if the program carries no debug information for it.
+func (p *Program) Method(addr uint32) (*Method, error) {
+	if int(addr)+4 > len(p.Text) {
+		return nil, fmt.Errorf("method address %d out of bounds", addr)
+	}
+
+	i := sort.Search(len(p.methods), func(i int) bool {
+		return p.methods[i].Addr >= addr
+	})
+	if i < len(p.methods) && p.methods[i].Addr == addr {
+		return p.methods[i], nil
+	}
+
+	m := &Method{
+		Name:      fmt.Sprintf("method@%d", addr),
+		Addr:      addr,
+		Start:     addr + 4,
+		End:       uint32(len(p.Text)),
+		NumParams: int(binary.BigEndian.Uint16(p.Text[addr:])),
+		NumLocals: int(binary.BigEndian.Uint16(p.Text[addr+2:])),
+	}
+
+	p.methods = append(p.methods, nil)
+	copy(p.methods[i+1:], p.methods[i:])
+	p.methods[i] = m
+	if i > 0 && p.methods[i-1].End > addr {
+		p.methods[i-1].End = addr
+	}
+	if i+1 < len(p.methods) {
+		m.End = p.methods[i+1].Addr
+	}
+	return m, nil
+}
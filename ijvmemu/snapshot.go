@@ -0,0 +1,279 @@
+package ijvmemu
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+)
+
+const (
+	// SnapshotMagic is the magic header of snapshot files
+	SnapshotMagic = uint32(0x1DEAD5AF)
+	// SnapshotVersion is the version of the snapshot format written by Snapshot
+	SnapshotVersion = uint32(2)
+)
+
+var (
+	// ErrSnapshotProgram is returned when restoring a snapshot of a different program
+	ErrSnapshotProgram = errors.New("snapshot: taken of a different program")
+	// ErrSnapshotConnections is returned when taking a snapshot while connections are open
+	ErrSnapshotConnections = errors.New("snapshot: cannot save open network connections")
+)
+
+// Snapshot writes the complete state of the machine to w: the PC, call stack,
+// operand stack, heap, constant pool, and the amount of consumed input and
+// produced output. Limits, hooks and the network backend are not part of the
+// snapshot.
+//
+// Snapshots are taken between instructions, and cannot be taken while the
+// program has open network connections.
+func (m *Machine) Snapshot(w io.Writer) error {
+	if len(m.conns) > 0 {
+		return ErrSnapshotConnections
+	}
+
+	bw := bufio.NewWriter(w)
+	sw := &snapshotWriter{w: bw}
+	hash := m.prog.Hash()
+
+	sw.u32(SnapshotMagic)
+	sw.u32(SnapshotVersion)
+	sw.raw(hash[:])
+	sw.words(m.prog.Constants)
+
+	sw.u32(m.pc)
+	sw.u64(m.steps)
+	sw.bool(m.halted)
+	sw.u64(m.inBytes)
+	sw.u64(m.outBytes)
+	sw.u32(uint32(m.nextConn))
+
+	sw.words(m.stack)
+	sw.u32(uint32(len(m.frames)))
+	for _, f := range m.frames {
+		sw.u32(f.Method.Addr)
+		sw.u32(uint32(f.Base))
+		sw.u32(f.Return)
+		sw.words(f.Locals)
+	}
+
+	h := m.heap
+	sw.u64(h.Threshold)
+	sw.u32(uint32(h.next))
+	sw.u64(h.pending)
+	sw.stats(h.stats)
+
+	refs := make([]int32, 0, len(h.objects))
+	for ref := range h.objects {
+		refs = append(refs, ref)
+	}
+	sort.Slice(refs, func(i, j int) bool { return refs[i] < refs[j] })
+	sw.u32(uint32(len(refs)))
+	for _, ref := range refs {
+		arr := h.objects[ref]
+		sw.u32(uint32(ref))
+		sw.bool(arr.Refs)
+		sw.words(arr.Data)
+	}
+
+	if sw.err != nil {
+		return sw.err
+	}
+	return bw.Flush()
+}
+
+// RestoreMachine returns a Machine continuing the given program from the
+// snapshot read from r. The consumed part of the input is skipped, so in must
+// provide the same input the program was originally given. The output already
+// produced is not written to out again, but counts towards MaxOutputBytes.
+func RestoreMachine(prog *Program, r io.Reader, in io.Reader, out io.Writer) (m *Machine, err error) {
+	sr := &snapshotReader{r: bufio.NewReader(r)}
+
+	if magic := sr.u32(); sr.err == nil && magic != SnapshotMagic {
+		return nil, fmt.Errorf("snapshot: invalid magic header 0x%08X", magic)
+	}
+	if version := sr.u32(); sr.err == nil && version != SnapshotVersion {
+		return nil, fmt.Errorf("snapshot: unsupported version %d, expected %d", version, SnapshotVersion)
+	}
+	var hash [sha256.Size]byte
+	sr.raw(hash[:])
+	sr.words()
+	if sr.err != nil {
+		return nil, sr.err
+	}
+	if hash != prog.Hash() {
+		return nil, ErrSnapshotProgram
+	}
+
+	// Invalid snapshots may reference methods that don't exist
+	defer func() {
+		if r := recover(); r != nil {
+			x, ok := r.(error)
+			if !ok {
+				panic(r)
+			}
+			m, err = nil, fmt.Errorf("snapshot: %s", x)
+		}
+	}()
+
+	m = NewMachine(prog, in, out)
+	m.pc = sr.u32()
+	m.steps = sr.u64()
+	m.halted = sr.bool()
+	m.inBytes = sr.u64()
+	m.outBytes = sr.u64()
+	m.nextConn = int32(sr.u32())
+
+	m.stack = sr.words()
+	m.frames = make([]*Frame, sr.u32())
+	for i := range m.frames {
+		addr := sr.u32()
+		f := &Frame{
+			Method: prog.MethodAt(0),
+			Base:   int(sr.u32()),
+			Return: sr.u32(),
+			Locals: sr.words(),
+		}
+		if addr != 0 {
+			if f.Method, err = prog.Method(addr); err != nil {
+				panic(err)
+			}
+		}
+		if f.Base > len(m.stack) {
+			panic(fmt.Errorf("frame %d has base %d above the stack", i, f.Base))
+		}
+		m.frames[i] = f
+	}
+	if len(m.frames) == 0 {
+		return nil, errors.New("snapshot: missing frames")
+	}
+	m.frame = m.frames[len(m.frames)-1]
+
+	h := m.heap
+	h.Threshold = sr.u64()
+	h.next = int32(sr.u32())
+	h.pending = sr.u64()
+	h.stats = sr.stats()
+	for n := sr.u32(); n > 0 && sr.err == nil; n-- {
+		ref := int32(sr.u32())
+		h.objects[ref] = &Array{Refs: sr.bool(), Data: sr.words()}
+	}
+
+	if sr.err != nil {
+		return nil, sr.err
+	}
+	if int(m.pc) > len(prog.Text) {
+		return nil, fmt.Errorf("snapshot: pc %d out of bounds", m.pc)
+	}
+	if _, err := m.in.Discard(int(m.inBytes)); err != nil {
+		return nil, fmt.Errorf("snapshot: input is shorter than the %d bytes consumed", m.inBytes)
+	}
+	return m, nil
+}
+
+// Writes the big-endian encoding of snapshots, remembering the first error
+type snapshotWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (w *snapshotWriter) raw(data []byte) {
+	if w.err == nil {
+		_, w.err = w.w.Write(data)
+	}
+}
+
+func (w *snapshotWriter) u32(x uint32) {
+	var buf [4]byte
+	binary.BigEndian.PutUint32(buf[:], x)
+	w.raw(buf[:])
+}
+
+func (w *snapshotWriter) u64(x uint64) {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], x)
+	w.raw(buf[:])
+}
+
+func (w *snapshotWriter) bool(b bool) {
+	if b {
+		w.raw([]byte{1})
+	} else {
+		w.raw([]byte{0})
+	}
+}
+
+func (w *snapshotWriter) words(xs []int32) {
+	w.u32(uint32(len(xs)))
+	for _, x := range xs {
+		w.u32(uint32(x))
+	}
+}
+
+func (w *snapshotWriter) stats(s HeapStats) {
+	for _, x := range []uint64{s.Allocations, s.AllocatedWords, s.Collections, s.Freed,
+		s.FreedWords, s.Live, s.LiveWords, s.PeakWords} {
+		w.u64(x)
+	}
+}
+
+// Reads the big-endian encoding of snapshots, remembering the first error
+type snapshotReader struct {
+	r   io.Reader
+	err error
+}
+
+func (r *snapshotReader) raw(data []byte) {
+	if r.err != nil {
+		return
+	}
+	if _, err := io.ReadFull(r.r, data); err != nil {
+		r.err = errors.New("snapshot: truncated")
+	}
+}
+
+func (r *snapshotReader) u32() uint32 {
+	var buf [4]byte
+	r.raw(buf[:])
+	return binary.BigEndian.Uint32(buf[:])
+}
+
+func (r *snapshotReader) u64() uint64 {
+	var buf [8]byte
+	r.raw(buf[:])
+	return binary.BigEndian.Uint64(buf[:])
+}
+
+func (r *snapshotReader) bool() bool {
+	var buf [1]byte
+	r.raw(buf[:])
+	return buf[0] != 0
+}
+
+func (r *snapshotReader) words() []int32 {
+	n := r.u32()
+	xs := make([]int32, 0, minInt(int(n), 1<<16))
+	for i := uint32(0); i < n && r.err == nil; i++ {
+		xs = append(xs, int32(r.u32()))
+	}
+	return xs
+}
+
+func (r *snapshotReader) stats() (s HeapStats) {
+	for _, x := range []*uint64{&s.Allocations, &s.AllocatedWords, &s.Collections, &s.Freed,
+		&s.FreedWords, &s.Live, &s.LiveWords, &s.PeakWords} {
+		*x = r.u64()
+	}
+	return s
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
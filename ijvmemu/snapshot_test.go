@@ -0,0 +1,111 @@
+package ijvmemu
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+// Stores the input in an array, then prints it shifted by one using a method
+const snapshotSource = `.main
+.var
+arr
+i
+n
+.end-var
+BIPUSH 64
+NEWARRAY
+ISTORE arr
+read:
+IN
+DUP
+IFEQ print
+ILOAD n
+ILOAD arr
+IASTORE
+IINC n 1
+GOTO read
+print:
+POP
+loop:
+ILOAD i
+ILOAD n
+IF_ICMPEQ done
+BIPUSH 0
+ILOAD i
+ILOAD arr
+IALOAD
+INVOKEVIRTUAL shift
+OUT
+IINC i 1
+GOTO loop
+done:
+HALT
+.end-main
+
+.method shift(c)
+ILOAD c
+BIPUSH 1
+IADD
+IRETURN
+.end-method
+`
+
+const snapshotInput = "Hello, world!"
+
+// A program stopped by a limit and resumed from its snapshot produces the same
+// output as an uninterrupted run, without keeping that output in memory.
+func TestSnapshotResume(t *testing.T) {
+	prog := assembleExtended(t, snapshotSource)
+	full := new(strings.Builder)
+	if err := NewMachine(prog, strings.NewReader(snapshotInput), full).Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, steps := range []uint64{1, 50, 150, 200} {
+		first := new(strings.Builder)
+		m := NewMachine(prog, strings.NewReader(snapshotInput), first)
+		m.MaxSteps = steps
+		var lerr *LimitError
+		if err := m.Run(); !errors.As(err, &lerr) || lerr.Limit != LimitSteps {
+			t.Fatalf("expected the step limit to be exceeded, got %v", err)
+		}
+		var snap bytes.Buffer
+		if err := m.Snapshot(&snap); err != nil {
+			t.Fatal(err)
+		}
+
+		rest := new(strings.Builder)
+		r, err := RestoreMachine(prog, &snap, strings.NewReader(snapshotInput), rest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r.Steps() != steps || r.OutputBytes() != uint64(first.Len()) {
+			t.Errorf("restored %d steps and %d output bytes, want %d and %d", r.Steps(), r.OutputBytes(), steps, first.Len())
+		}
+		if err := r.Run(); err != nil {
+			t.Fatal(err)
+		}
+		if got := first.String() + rest.String(); got != full.String() {
+			t.Errorf("resumed after %d steps printing %q, want %q", steps, got, full.String())
+		}
+		if r.OutputBytes() != uint64(full.Len()) {
+			t.Errorf("%d output bytes counted, want %d", r.OutputBytes(), full.Len())
+		}
+	}
+}
+
+func TestSnapshotOtherProgram(t *testing.T) {
+	m := NewMachine(assembleExtended(t, snapshotSource), strings.NewReader(""), ioutil.Discard)
+	var snap bytes.Buffer
+	if err := m.Snapshot(&snap); err != nil {
+		t.Fatal(err)
+	}
+
+	other := assembleExtended(t, loopSource("NOP"))
+	if _, err := RestoreMachine(other, &snap, strings.NewReader(""), ioutil.Discard); err != ErrSnapshotProgram {
+		t.Errorf("restored with %v, want %v", err, ErrSnapshotProgram)
+	}
+}
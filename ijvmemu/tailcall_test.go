@@ -0,0 +1,158 @@
+package ijvmemu
+
+import (
+	"strings"
+	"testing"
+)
+
+// Counts down from 10000 using tail recursion, printing y if the accumulator
+// counted all the way up
+const tailRecursionSource = `.constant
+depth 10000
+.end-constant
+
+.main
+BIPUSH 0
+LDC_W depth
+BIPUSH 0
+INVOKEVIRTUAL count
+LDC_W depth
+ISUB
+IFEQ ok
+ERR
+ok:
+BIPUSH 'y'
+OUT
+HALT
+.end-main
+
+.method count(n, acc)
+ILOAD n
+IFEQ done
+BIPUSH 0
+ILOAD n
+BIPUSH 1
+ISUB
+ILOAD acc
+BIPUSH 1
+IADD
+%s count
+IRETURN
+done:
+ILOAD acc
+IRETURN
+.end-method
+`
+
+// Records the deepest call stack observed
+type depthHook struct {
+	max int
+}
+
+func (h *depthHook) Before(m *Machine, in *Instr) {}
+
+func (h *depthHook) After(m *Machine, in *Instr) {
+	if n := len(m.Frames()); n > h.max {
+		h.max = n
+	}
+}
+
+// Runs the program, returning its output and the deepest call stack
+func runDepth(t *testing.T, prog *Program) (string, int) {
+	t.Helper()
+	hook := &depthHook{}
+	out := new(strings.Builder)
+	m := NewMachine(prog, strings.NewReader(""), out)
+	m.AddHook(hook)
+	if err := m.Run(); err != nil {
+		t.Fatal(err)
+	}
+	return out.String(), hook.max
+}
+
+func TestTailCallReusesFrame(t *testing.T) {
+	tests := []struct {
+		invoke string
+		depth  int
+	}{
+		{"INVOKEVIRTUAL", 10002},
+		{"TAILCALL", 2},
+	}
+
+	for _, tt := range tests {
+		out, depth := runDepth(t, assembleExtended(t, strings.Replace(tailRecursionSource, "%s", tt.invoke, 1)))
+		if out != "y" || depth != tt.depth {
+			t.Errorf("%s: printed %q with %d frames, want y with %d frames", tt.invoke, out, depth, tt.depth)
+		}
+	}
+}
+
+// A tail call to a method with more locals than the current one grows the
+// reused frame, and one to a method with fewer locals zeroes the locals it
+// reuses. Prints 'a' + 3 twice.
+const tailLocalsSource = `.main
+BIPUSH 0
+BIPUSH 'a'
+INVOKEVIRTUAL small
+OUT
+BIPUSH 0
+BIPUSH 'a'
+INVOKEVIRTUAL large
+OUT
+HALT
+.end-main
+
+.method small(x)
+BIPUSH 0
+ILOAD x
+TAILCALL add3
+IRETURN
+.end-method
+
+.method large(x)
+.var
+a
+b
+c
+d
+e
+.end-var
+BIPUSH 9
+ISTORE c
+BIPUSH 0
+ILOAD x
+TAILCALL add3
+IRETURN
+.end-method
+
+.method add3(x)
+.var
+p
+q
+r
+.end-var
+ILOAD r
+ILOAD x
+IADD
+BIPUSH 1
+IADD
+ISTORE p
+ILOAD p
+BIPUSH 1
+IADD
+ISTORE q
+ILOAD q
+BIPUSH 1
+IADD
+ISTORE r
+ILOAD r
+IRETURN
+.end-method
+`
+
+func TestTailCallLocals(t *testing.T) {
+	out, depth := runDepth(t, assembleExtended(t, tailLocalsSource))
+	if out != "dd" || depth != 2 {
+		t.Errorf("printed %q with %d frames, want dd with 2 frames", out, depth)
+	}
+}
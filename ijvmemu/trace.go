@@ -0,0 +1,178 @@
+package ijvmemu
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/BlackNovaTech/gojasm/ijvmasm"
+)
+
+// TraceFormat selects the output format of a Tracer.
+type TraceFormat int
+
+const (
+	// TraceJSON writes one JSON object per executed instruction (JSON Lines)
+	TraceJSON TraceFormat = iota
+	// TraceText writes one compact line of text per executed instruction
+	TraceText
+)
+
+// ParseTraceFormat parses the name of a trace format.
+func ParseTraceFormat(name string) (TraceFormat, error) {
+	switch strings.ToLower(name) {
+	case "json", "jsonl":
+		return TraceJSON, nil
+	case "text", "txt":
+		return TraceText, nil
+	}
+	return 0, fmt.Errorf("unknown trace format `%s`", name)
+}
+
+// TraceRecord is a single traced instruction.
+type TraceRecord struct {
+	Step        uint64       `json:"step"`
+	Method      string       `json:"method"`
+	PC          uint32       `json:"pc"`
+	Line        uint32       `json:"line"`
+	Op          string       `json:"op"`
+	Wide        bool         `json:"wide,omitempty"`
+	Operands    []int32      `json:"operands"`
+	StackBefore []int32      `json:"stack_before"`
+	StackAfter  []int32      `json:"stack_after"`
+	Locals      []TraceLocal `json:"locals,omitempty"`
+	Error       string       `json:"error,omitempty"`
+}
+
+// TraceLocal is a single local variable write of a traced instruction.
+type TraceLocal struct {
+	Index int    `json:"index"`
+	Name  string `json:"name,omitempty"`
+	Value int32  `json:"value"`
+}
+
+// Tracer is a Hook writing a deterministic trace of every executed instruction.
+type Tracer struct {
+	// Method restricts the trace to instructions of the method with this name, if set
+	Method string
+	// Skip skips the first Skip executed instructions
+	Skip uint64
+
+	out    io.Writer
+	format TraceFormat
+	record *TraceRecord
+	method *Method
+	err    error
+}
+
+// NewTracer returns a Tracer writing to out in the given format.
+func NewTracer(out io.Writer, format TraceFormat) *Tracer {
+	return &Tracer{
+		out:    out,
+		format: format,
+	}
+}
+
+// Err returns the first error encountered writing the trace.
+func (t *Tracer) Err() error {
+	return t.err
+}
+
+// Before implements Hook
+func (t *Tracer) Before(m *Machine, in *Instr) {
+	t.record = nil
+	if t.err != nil || m.Steps() < t.Skip {
+		return
+	}
+
+	t.method = m.Frame().Method
+	if t.Method != "" && t.method.Name != t.Method {
+		return
+	}
+
+	t.record = &TraceRecord{
+		Method:      t.method.Name,
+		PC:          in.PC,
+		Line:        m.Program().Line(in.PC),
+		Op:          in.Op.Name,
+		Wide:        in.Wide,
+		Operands:    append([]int32{}, in.Operands...),
+		StackBefore: append([]int32{}, m.Stack()...),
+	}
+}
+
+// After implements Hook
+func (t *Tracer) After(m *Machine, in *Instr) {
+	if t.record == nil {
+		return
+	}
+
+	t.record.Step = m.Steps()
+	t.write(m)
+}
+
+// Failed implements FailHook, tracing the failing instruction along with its error
+func (t *Tracer) Failed(m *Machine, in *Instr, err error) {
+	if t.record == nil {
+		return
+	}
+
+	var rerr *RuntimeError
+	if errors.As(err, &rerr) {
+		err = rerr.Err
+	}
+	t.record.Step = m.Steps() + 1
+	t.record.Error = err.Error()
+	t.write(m)
+}
+
+// Completes the current record with the state after the instruction and writes it
+func (t *Tracer) write(m *Machine) {
+	rec := t.record
+	t.record = nil
+	rec.StackAfter = append([]int32{}, m.Stack()...)
+	for _, w := range m.LocalWrites() {
+		rec.Locals = append(rec.Locals, TraceLocal{
+			Index: w.Index,
+			Name:  t.method.VarName(w.Index),
+			Value: w.Value,
+		})
+	}
+
+	switch t.format {
+	case TraceJSON:
+		var data []byte
+		data, t.err = json.Marshal(rec)
+		if t.err == nil {
+			_, t.err = fmt.Fprintf(t.out, "%s\n", data)
+		}
+	case TraceText:
+		_, t.err = fmt.Fprintln(t.out, rec.String())
+	}
+}
+
+// String formats the record as a single compact line of text.
+func (rec *TraceRecord) String() string {
+	op := rec.Op
+	if rec.Wide {
+		op = ijvmasm.OperationWide + " " + op
+	}
+	s := fmt.Sprintf("%d %s:%d pc=%d %s", rec.Step, rec.Method, rec.Line, rec.PC, op)
+	for _, o := range rec.Operands {
+		s += fmt.Sprintf(" %d", o)
+	}
+	s += fmt.Sprintf(" %v -> %v", rec.StackBefore, rec.StackAfter)
+	for _, l := range rec.Locals {
+		if l.Name != "" {
+			s += fmt.Sprintf(" %s=%d", l.Name, l.Value)
+		} else {
+			s += fmt.Sprintf(" $%d=%d", l.Index, l.Value)
+		}
+	}
+	if rec.Error != "" {
+		s += " error: " + rec.Error
+	}
+	return s
+}
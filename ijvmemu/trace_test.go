@@ -0,0 +1,137 @@
+package ijvmemu
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Runs trace.jas with the given tracer attached, returning its output
+func runTraced(t *testing.T, format TraceFormat, configure func(*Tracer)) string {
+	t.Helper()
+	var out bytes.Buffer
+	tracer := NewTracer(&out, format)
+	if configure != nil {
+		configure(tracer)
+	}
+	m := NewMachine(loadTestProgram(t, "trace.jas"), strings.NewReader(""), ioutil.Discard)
+	m.AddHook(tracer)
+	if err := m.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if err := tracer.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return out.String()
+}
+
+// Decodes a JSON Lines trace
+func decodeTrace(t *testing.T, trace string) []TraceRecord {
+	t.Helper()
+	var records []TraceRecord
+	scanner := bufio.NewScanner(strings.NewReader(trace))
+	for scanner.Scan() {
+		var rec TraceRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			t.Fatalf("invalid trace line %q: %v", scanner.Text(), err)
+		}
+		records = append(records, rec)
+	}
+	return records
+}
+
+func TestTraceGolden(t *testing.T) {
+	tests := []struct {
+		format TraceFormat
+		golden string
+	}{
+		{TraceJSON, "trace.jsonl"},
+		{TraceText, "trace.txt"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.golden, func(t *testing.T) {
+			got := runTraced(t, tt.format, nil)
+			want, err := ioutil.ReadFile(filepath.Join("testdata", tt.golden))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != string(want) {
+				t.Errorf("traced\n%s\nwant\n%s", got, want)
+			}
+		})
+	}
+}
+
+func TestTraceLocalNames(t *testing.T) {
+	records := decodeTrace(t, runTraced(t, TraceJSON, nil))
+
+	names := map[string]bool{}
+	for _, rec := range records {
+		for _, l := range rec.Locals {
+			names[rec.Method+"."+l.Name] = true
+		}
+	}
+	want := map[string]bool{"main.n": true, "dec.x": true}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("wrote locals %v, want %v", names, want)
+	}
+}
+
+// The filters only drop records, every remaining record is traced exactly as
+// it is in the full trace.
+func TestTraceFilters(t *testing.T) {
+	all := decodeTrace(t, runTraced(t, TraceJSON, nil))
+
+	tests := []struct {
+		name   string
+		tracer func(*Tracer)
+		keep   func(TraceRecord) bool
+	}{
+		{"method", func(tr *Tracer) { tr.Method = "dec" }, func(r TraceRecord) bool { return r.Method == "dec" }},
+		{"skip", func(tr *Tracer) { tr.Skip = 10 }, func(r TraceRecord) bool { return r.Step > 10 }},
+		{"both", func(tr *Tracer) { tr.Method = "main"; tr.Skip = 10 }, func(r TraceRecord) bool {
+			return r.Method == "main" && r.Step > 10
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var want []TraceRecord
+			for _, rec := range all {
+				if tt.keep(rec) {
+					want = append(want, rec)
+				}
+			}
+			got := decodeTrace(t, runTraced(t, TraceJSON, tt.tracer))
+			if len(got) == 0 || len(got) == len(all) {
+				t.Fatalf("traced %d of %d records", len(got), len(all))
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("traced %+v, want %+v", got, want)
+			}
+		})
+	}
+}
+
+// The instruction failing the program is traced along with its error.
+func TestTraceFailure(t *testing.T) {
+	var out bytes.Buffer
+	m := NewMachine(loadTestProgram(t, "fault.jas"), strings.NewReader(""), ioutil.Discard)
+	m.AddHook(NewTracer(&out, TraceText))
+	if err := m.Run(); !errors.Is(err, ErrErrInstruction) {
+		t.Fatalf("ran with %v, want %v", err, ErrErrInstruction)
+	}
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	want := "4 main:8 pc=6 ERR [2] -> [2] error: ERR instruction executed"
+	if got := lines[len(lines)-1]; got != want {
+		t.Errorf("traced %q last, want %q", got, want)
+	}
+}
@@ -0,0 +1,484 @@
+// Package ijvmgo translates IJVM programs into standalone Go programs.
+package ijvmgo
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"go/token"
+	"io"
+	"strings"
+	"unicode"
+
+	"github.com/BlackNovaTech/gojasm/ijvmasm"
+	"github.com/BlackNovaTech/gojasm/ijvmemu"
+	"github.com/BlackNovaTech/gojasm/opconf"
+)
+
+// A method of the translated program
+type method struct {
+	*ijvmemu.Method
+	ident string
+	main  bool
+	code  []*ijvmemu.Instr
+	// Errors of the byte offsets execution continues at that failed to decode
+	faults map[uint32]error
+	// Go labels of the branch targets, by byte offset
+	labels map[uint32]string
+	// Size of the locals slice
+	size int
+}
+
+type translator struct {
+	prog    *ijvmemu.Program
+	methods []*method
+	byAddr  map[uint32]*method
+	idents  map[string]bool
+	buf     bytes.Buffer
+}
+
+// Translate writes a standalone Go program executing the given IJVM program to out.
+// Every IJVM method becomes a Go function, with its operand stack and local
+// variables as slices and its branches as gotos. IN and OUT use buffered stdin
+// and stdout. Only the operations of the default configuration, and TAILCALL,
+// can be translated.
+func Translate(prog *ijvmemu.Program, out io.Writer) error {
+	t := &translator{
+		prog:   prog,
+		byAddr: make(map[uint32]*method),
+		idents: make(map[string]bool),
+	}
+	if err := t.discover(); err != nil {
+		return err
+	}
+
+	source := "IJVM binary"
+	if prog.Debug != nil {
+		source = prog.Debug.File
+	}
+	fmt.Fprintf(&t.buf, prelude, source)
+	for _, m := range t.methods {
+		if err := t.method(m); err != nil {
+			return err
+		}
+	}
+
+	src, err := format.Source(t.buf.Bytes())
+	if err != nil {
+		return fmt.Errorf("formatting generated code: %s", err)
+	}
+	_, err = out.Write(src)
+	return err
+}
+
+// Finds every method reachable from main and decodes their instructions
+func (t *translator) discover() error {
+	methods, err := t.prog.Reachable()
+	if err != nil {
+		return err
+	}
+
+	for _, m := range methods {
+		code, faults := t.prog.Code(m)
+		tm := &method{
+			Method: m,
+			ident:  t.ident(m.Name),
+			main:   m.Addr == 0,
+			code:   code,
+			faults: faults,
+			labels: make(map[uint32]string),
+			size:   m.NumParams + m.NumLocals,
+		}
+		t.byAddr[m.Addr] = tm
+		t.methods = append(t.methods, tm)
+	}
+	return nil
+}
+
+// Returns a unique Go identifier for the function of the given method
+func (t *translator) ident(name string) string {
+	var sb strings.Builder
+	sb.WriteString("method")
+	upper := true
+	// Methods without debug information are named method@ADDR
+	for _, r := range strings.TrimPrefix(name, "method@") {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			upper = true
+			continue
+		}
+		if upper {
+			r = unicode.ToUpper(r)
+			upper = false
+		}
+		sb.WriteRune(r)
+	}
+
+	ident := sb.String()
+	for i := 2; t.idents[ident]; i++ {
+		ident = fmt.Sprintf("%s%d", sb.String(), i)
+	}
+	t.idents[ident] = true
+	return ident
+}
+
+// Returns the Go label of the given branch target within the method
+func (t *translator) label(m *method, pc uint32) string {
+	if label, ok := m.labels[pc]; ok {
+		return label
+	}
+
+	label := fmt.Sprintf("L%d", pc)
+	if t.prog.Debug != nil {
+		for _, mi := range t.prog.Debug.Methods {
+			for _, l := range mi.Labels {
+				if l.B == pc && token.IsIdentifier(l.Name) && l.Name != "_" {
+					label = l.Name
+				}
+			}
+		}
+	}
+	for _, other := range m.labels {
+		if other == label {
+			label = fmt.Sprintf("L%d", pc)
+		}
+	}
+	m.labels[pc] = label
+	return label
+}
+
+// Writes the function of a single method
+func (t *translator) method(m *method) error {
+	starts := make(map[uint32]bool)
+	for _, inst := range m.code {
+		starts[inst.PC] = true
+	}
+	halt := "panic(halt{})"
+	if m.main {
+		halt = "return"
+	}
+
+	// Translate the body first, so the labels and size of the locals are known
+	body := make([]string, len(m.code))
+	usesStack, usesLocals := false, false
+	for i, inst := range m.code {
+		stmt, err := t.instruction(m, inst, starts, halt)
+		if err != nil {
+			return fmt.Errorf("method %s: pc %d: %s", m.Name, inst.PC, err)
+		}
+		// Comment on the first line of the statement, e.g. after the opening brace of an if
+		first := strings.SplitN(stmt, "\n", 2)
+		first[0] += " // " + t.comment(m, inst)
+		body[i] = strings.Join(first, "\n")
+
+		// Make execution continuing with the next instruction explicit when
+		// it does not directly follow
+		next := inst.PC + inst.Size
+		switch {
+		case ijvmasm.Terminates(inst.Op.Name):
+		case i+1 < len(m.code) && m.code[i+1].PC == next:
+		case starts[next]:
+			body[i] += "\ngoto " + t.label(m, next)
+		case int(next) == len(t.prog.Text):
+			body[i] += "\n" + halt + " // end of program"
+		default:
+			body[i] += fmt.Sprintf("\npanic(errors.New(%q))", m.faults[next].Error())
+		}
+		usesStack = usesStack || strings.Contains(stmt, "s.")
+		usesLocals = usesLocals || strings.Contains(stmt, "lv[")
+	}
+
+	b := &t.buf
+	if m.main {
+		fmt.Fprintf(b, "\n// %s\nfunc %s() {\n", m.Name, m.ident)
+		// Declared variables may be left unused, which Go rejects
+		if usesLocals {
+			fmt.Fprintf(b, "lv := make([]int32, %d)\n", m.size)
+		}
+	} else {
+		fmt.Fprintf(b, "\n// %s(%s)\nfunc %s(lv []int32) int32 {\n", m.Name, t.params(m), m.ident)
+	}
+	if m.N > 0 {
+		fmt.Fprintf(b, "// Declared on line %d\n", m.N)
+	}
+	if usesStack {
+		fmt.Fprintf(b, "var s stack\n")
+	}
+
+	for i, inst := range m.code {
+		stmt := body[i]
+		if label, ok := m.labels[inst.PC]; ok {
+			// Labels must precede a statement
+			if strings.HasPrefix(stmt, " //") {
+				stmt = "{}" + stmt
+			}
+			fmt.Fprintf(b, "%s:\n", label)
+		}
+		fmt.Fprintf(b, "%s\n", stmt)
+	}
+
+	if len(m.code) == 0 {
+		fmt.Fprintf(b, "%s // end of program\n", halt)
+	}
+	fmt.Fprintf(b, "}\n")
+	return nil
+}
+
+// Returns the parameter names of a method, without the object reference
+func (t *translator) params(m *method) string {
+	var names []string
+	for i := 1; i < m.NumParams; i++ {
+		name := m.VarName(i)
+		if name == "" {
+			name = fmt.Sprintf("p%d", i)
+		}
+		names = append(names, name)
+	}
+	return strings.Join(names, ", ")
+}
+
+// Returns the JAS notation of the instruction, and its source line if known
+func (t *translator) comment(m *method, inst *ijvmemu.Instr) string {
+	s := inst.Op.Name
+	if inst.Wide {
+		s = ijvmasm.OperationWide + " " + s
+	}
+	for i, arg := range inst.Op.Args {
+		o := inst.Operands[i]
+		switch {
+		case arg == opconf.ArgVar && m.VarName(int(o)) != "":
+			s += " " + m.VarName(int(o))
+		case arg == opconf.ArgMethod:
+			if addr, ok := t.prog.Invoked(inst); ok && t.byAddr[addr] != nil {
+				s += " " + t.byAddr[addr].Name
+			} else {
+				s += fmt.Sprintf(" %d", o)
+			}
+		case arg == opconf.ArgLabel:
+			s += " " + t.label(m, uint32(o))
+		default:
+			s += fmt.Sprintf(" %d", o)
+		}
+	}
+	if line := t.prog.Line(inst.PC); line > 0 {
+		s = fmt.Sprintf("%d: %s", line, s)
+	}
+	return s
+}
+
+// Returns the Go statement executing a single instruction
+func (t *translator) instruction(m *method, inst *ijvmemu.Instr, starts map[uint32]bool, halt string) (string, error) {
+	args := inst.Operands
+
+	local := func() (string, error) {
+		idx := int(args[0])
+		if idx >= m.size {
+			if !m.main {
+				return "", fmt.Errorf("local variable %d out of range", idx)
+			}
+			// Main has no header declaring its amount of locals, so it grows as needed
+			m.size = idx + 1
+		}
+		return fmt.Sprintf("lv[%d]", idx), nil
+	}
+	target := func() (string, error) {
+		pc := uint32(args[0])
+		if !starts[pc] {
+			return "", fmt.Errorf("branch target %d is not an instruction of the method", pc)
+		}
+		return t.label(m, pc), nil
+	}
+	callee := func() (string, error) {
+		addr, ok := t.prog.Invoked(inst)
+		if !ok {
+			return "", fmt.Errorf("constant index %d out of range", args[0])
+		}
+		c := t.byAddr[addr]
+		return fmt.Sprintf("%s(s.args(%d, %d))", c.ident, c.NumParams, c.NumParams+c.NumLocals), nil
+	}
+
+	switch inst.Op.Name {
+	case "NOP":
+		return "", nil
+	case "BIPUSH":
+		return fmt.Sprintf("s.push(%d)", args[0]), nil
+	case "LDC_W":
+		if int(args[0]) >= len(t.prog.Constants) {
+			return "", fmt.Errorf("constant index %d out of range", args[0])
+		}
+		return fmt.Sprintf("s.push(%d)", t.prog.Constants[args[0]]), nil
+	case "DUP":
+		return "s.dup()", nil
+	case "POP":
+		return "s.pop()", nil
+	case "SWAP":
+		return "s.swap()", nil
+	case "IADD":
+		return "s.iadd()", nil
+	case "ISUB":
+		return "s.isub()", nil
+	case "IAND":
+		return "s.iand()", nil
+	case "IOR":
+		return "s.ior()", nil
+	case "GOTO":
+		label, err := target()
+		return "goto " + label, err
+	case "IFEQ", "IFLT", "IF_ICMPEQ":
+		label, err := target()
+		cond := map[string]string{
+			"IFEQ":      "s.pop() == 0",
+			"IFLT":      "s.pop() < 0",
+			"IF_ICMPEQ": "s.pop() == s.pop()",
+		}[inst.Op.Name]
+		return fmt.Sprintf("if %s {\ngoto %s\n}", cond, label), err
+	case "ILOAD":
+		lv, err := local()
+		return fmt.Sprintf("s.push(%s)", lv), err
+	case "ISTORE":
+		lv, err := local()
+		return fmt.Sprintf("%s = s.pop()", lv), err
+	case "IINC":
+		lv, err := local()
+		return fmt.Sprintf("%s += %d", lv, args[1]), err
+	case "INVOKEVIRTUAL":
+		call, err := callee()
+		return fmt.Sprintf("s.push(%s)", call), err
+	case ijvmasm.OperationTailCall:
+		call, err := callee()
+		if m.main {
+			// The invoked method takes over the frame of main, so returning from it halts
+			return fmt.Sprintf("%s\nreturn", call), err
+		}
+		return "return " + call, err
+	case "IRETURN":
+		if m.main {
+			return "return", nil
+		}
+		return "return s.pop()", nil
+	case "IN":
+		return "s.push(read())", nil
+	case "OUT":
+		return "write(s.pop())", nil
+	case "HALT":
+		return halt, nil
+	case "ERR":
+		return "panic(errERR)", nil
+	}
+	return "", fmt.Errorf("operation %s cannot be translated", inst.Op.Name)
+}
+
+// Runtime support of every translated program
+const prelude = `// Code generated by gojasm translate from %s. DO NOT EDIT.
+
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+var (
+	in  = bufio.NewReader(os.Stdin)
+	out = bufio.NewWriter(os.Stdout)
+
+	errUnderflow = errors.New("operand stack underflow")
+	errERR       = errors.New("ERR instruction executed")
+)
+
+func main() {
+	defer func() {
+		out.Flush()
+		if r := recover(); r != nil {
+			if _, ok := r.(halt); ok {
+				return
+			}
+			fmt.Fprintln(os.Stderr, "error:", r)
+			os.Exit(1)
+		}
+	}()
+	methodMain()
+}
+
+// halt unwinds the call stack when the program halts
+type halt struct{}
+
+// stack is the operand stack of a single method invocation
+type stack []int32
+
+func (s *stack) push(v int32) {
+	*s = append(*s, v)
+}
+
+func (s *stack) pop() int32 {
+	if len(*s) == 0 {
+		panic(errUnderflow)
+	}
+	v := (*s)[len(*s)-1]
+	*s = (*s)[:len(*s)-1]
+	return v
+}
+
+func (s *stack) dup() {
+	v := s.pop()
+	s.push(v)
+	s.push(v)
+}
+
+func (s *stack) swap() {
+	b, a := s.pop(), s.pop()
+	s.push(b)
+	s.push(a)
+}
+
+func (s *stack) iadd() {
+	b, a := s.pop(), s.pop()
+	s.push(a + b)
+}
+
+func (s *stack) isub() {
+	b, a := s.pop(), s.pop()
+	s.push(a - b)
+}
+
+func (s *stack) iand() {
+	b, a := s.pop(), s.pop()
+	s.push(a & b)
+}
+
+func (s *stack) ior() {
+	b, a := s.pop(), s.pop()
+	s.push(a | b)
+}
+
+// args pops the n parameters of an invocation into the locals of the invoked method
+func (s *stack) args(n, size int) []int32 {
+	if len(*s) < n {
+		panic(errUnderflow)
+	}
+	lv := make([]int32, size)
+	copy(lv, (*s)[len(*s)-n:])
+	*s = (*s)[:len(*s)-n]
+	return lv
+}
+
+// read reads a single byte of input, or 0 at the end of the input
+func read() int32 {
+	b, err := in.ReadByte()
+	if err == io.EOF {
+		return 0
+	}
+	if err != nil {
+		panic(err)
+	}
+	return int32(b)
+}
+
+func write(v int32) {
+	if err := out.WriteByte(byte(v)); err != nil {
+		panic(err)
+	}
+}
+`
@@ -0,0 +1,101 @@
+package ijvmgo
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/BlackNovaTech/gojasm/ijvmasm"
+	"github.com/BlackNovaTech/gojasm/ijvmemu"
+	"github.com/BlackNovaTech/gojasm/jastest"
+	"github.com/BlackNovaTech/gojasm/opconf"
+	"github.com/sirupsen/logrus"
+)
+
+func init() {
+	logrus.SetLevel(logrus.WarnLevel)
+}
+
+// Builds the translation of the given program into an executable
+func buildTranslation(t *testing.T, prog *ijvmemu.Program, dir string) string {
+	src := new(bytes.Buffer)
+	if err := Translate(prog, src); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "main.go"), src.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "go.mod"), []byte("module translated\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	bin := filepath.Join(dir, "translated")
+	cmd := exec.Command("go", "build", "-o", bin, ".")
+	cmd.Dir = dir
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("building translation failed: %s\n%s\n%s", err, out, src)
+	}
+	return bin
+}
+
+// Translated programs must produce the expected output of every golden test,
+// which must also be the output of the emulator
+func TestTranslateGolden(t *testing.T) {
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go toolchain not available")
+	}
+	if testing.Short() {
+		t.Skip("building translations is slow")
+	}
+
+	suites, err := jastest.Discover([]string{"testdata"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, suite := range suites {
+		asm := ijvmasm.NewAssembler(suite.Program, opconf.NewDefaultOpConfig())
+		if ok, err := asm.Parse(); !ok || err != nil {
+			t.Fatalf("%s: assembly failed: %v", suite.Program, err)
+		}
+		prog, err := ijvmemu.FromAssembler(asm)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		dir, err := ioutil.TempDir("", "ijvmgo")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+		bin := buildTranslation(t, prog, dir)
+
+		for _, c := range suite.Cases {
+			emulated := jastest.RunCase(prog, c, &jastest.Options{})
+			if !emulated.Passed {
+				t.Errorf("%s/%s: emulator failed: %v\n%s", suite.Name(), c.Name, emulated.Err, emulated.Diff)
+			}
+
+			input, err := os.Open(c.Input)
+			if err != nil {
+				t.Fatal(err)
+			}
+			cmd := exec.Command(bin)
+			cmd.Stdin = input
+			got, err := cmd.Output()
+			input.Close()
+			if err != nil {
+				t.Errorf("%s/%s: translation failed: %s", suite.Name(), c.Name, err)
+				continue
+			}
+
+			if !bytes.Equal(got, emulated.Output) {
+				t.Errorf("%s/%s: translation output differs from the emulator:\n%s",
+					suite.Name(), c.Name, jastest.Diff(string(emulated.Output), string(got)))
+			}
+		}
+	}
+}
@@ -0,0 +1,548 @@
+// Package ijvmsym symbolically executes IJVM programs. The bytes read by IN,
+// and the parameters of the explored method, are symbols, and every path
+// through the program is explored, deciding which directions of conditional
+// branches are feasible using a built-in bit-vector constraint solver. The
+// result is a concrete input reaching every branch direction, HALT, ERR and
+// runtime fault found.
+package ijvmsym
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/BlackNovaTech/gojasm/ijvmemu"
+)
+
+// ErrBudget is returned when the solver gives up on a query.
+var ErrBudget = errors.New("solver conflict budget exhausted")
+
+// Kind is the kind of event a Case reaches.
+type Kind int
+
+const (
+	// KindBranch is a direction of a conditional branch
+	KindBranch Kind = iota
+	// KindHalt is the end of the program, by HALT, IRETURN from main, or
+	// falling off the end of the program
+	KindHalt
+	// KindReturn is an IRETURN from the explored method
+	KindReturn
+	// KindErr is an ERR instruction
+	KindErr
+	// KindFault is a runtime error, such as an operand stack underflow
+	KindFault
+	// KindStopped is an operation that cannot be executed symbolically
+	KindStopped
+)
+
+var kindNames = map[Kind]string{
+	KindBranch:  "branch",
+	KindHalt:    "halt",
+	KindReturn:  "return",
+	KindErr:     "err",
+	KindFault:   "fault",
+	KindStopped: "stopped",
+}
+
+func (k Kind) String() string {
+	return kindNames[k]
+}
+
+// Case is a concrete input reaching an event of the program.
+type Case struct {
+	Kind Kind
+	// PC is the byte offset of the instruction, Op its operation
+	PC     uint32
+	Op     string
+	Method string
+	// Line is the source line of the instruction, or 0 if unknown
+	Line uint32
+	// Taken is set for branch cases jumping to the branch target, rather
+	// than continuing with the next instruction
+	Taken bool
+	// Input is fed to IN. Trailing zeros are left out, as IN reads zero at
+	// the end of the input.
+	Input []byte
+	// Args are the arguments of the explored method, if it is not main
+	Args []int32
+	// Err is the cause of fault and stopped cases
+	Err error
+}
+
+// Options select the method explored and bound the exploration. Paths
+// exceeding a bound are abandoned. Start out from DefaultOptions.
+type Options struct {
+	// Method is the name of the method explored, or empty for main
+	Method string
+	// MaxPaths is the amount of paths explored before giving up
+	MaxPaths int
+	// LoopBound is the amount of times a single path may fork at the same
+	// conditional branch. Paths forking more often are abandoned.
+	LoopBound int
+	// MaxSteps is the amount of instructions a single path may execute
+	MaxSteps int
+	// Conflicts is the budget of every solver query
+	Conflicts int
+}
+
+// DefaultOptions explores main within reasonable bounds.
+var DefaultOptions = Options{
+	MaxPaths:  10000,
+	LoopBound: 8,
+	MaxSteps:  100000,
+	Conflicts: DefaultConflicts,
+}
+
+// Report is the result of exploring a program.
+type Report struct {
+	// Cases holds an input for every event reached, ordered by byte offset
+	Cases []*Case
+	// Paths is the amount of paths explored
+	Paths int
+	// Bounded is the amount of paths abandoned for exceeding the loop bound
+	// or the step limit
+	Bounded int
+	// Undecided is the amount of branch directions the solver gave up on
+	Undecided int
+	// Complete is set iff every path was explored to its end, and no path was
+	// stopped by an operation that cannot be executed symbolically
+	Complete bool
+}
+
+// A method invocation on the symbolic call stack
+type frame struct {
+	method *ijvmemu.Method
+	locals []*Expr
+	base   int
+	ret    uint32
+}
+
+// A path being explored
+type state struct {
+	pc     uint32
+	stack  []*Expr
+	frames []*frame
+	path   Path
+	// Values of the symbols satisfying the path
+	model map[*Symbol]int32
+	// Amount of bytes read by IN
+	inputs int
+	steps  int
+	// Amount of times the path forked at every conditional branch
+	forks map[uint32]int
+}
+
+// Returns a copy of the state, sharing nothing that changes
+func (st *state) fork() *state {
+	c := *st
+	c.stack = append([]*Expr(nil), st.stack...)
+	c.frames = make([]*frame, len(st.frames))
+	for i, f := range st.frames {
+		cf := *f
+		cf.locals = append([]*Expr(nil), f.locals...)
+		c.frames[i] = &cf
+	}
+	c.forks = make(map[uint32]int, len(st.forks))
+	for pc, n := range st.forks {
+		c.forks[pc] = n
+	}
+	return &c
+}
+
+func (st *state) frame() *frame {
+	return st.frames[len(st.frames)-1]
+}
+
+func (st *state) push(e *Expr) {
+	st.stack = append(st.stack, e)
+}
+
+func (st *state) pop() *Expr {
+	if len(st.stack) <= st.frame().base {
+		panic(ijvmemu.ErrStackUnderflow)
+	}
+	e := st.stack[len(st.stack)-1]
+	st.stack = st.stack[:len(st.stack)-1]
+	return e
+}
+
+func (st *state) local(idx int) *Expr {
+	f := st.frame()
+	if idx >= len(f.locals) {
+		if len(st.frames) > 1 || f.method.Addr != 0 {
+			panic(fmt.Errorf("local variable %d out of range", idx))
+		}
+		// Main has no header declaring its amount of locals, so it grows on demand
+		return Const(0)
+	}
+	return f.locals[idx]
+}
+
+func (st *state) setLocal(idx int, e *Expr) {
+	f := st.frame()
+	if idx >= len(f.locals) {
+		if len(st.frames) > 1 || f.method.Addr != 0 {
+			panic(fmt.Errorf("local variable %d out of range", idx))
+		}
+		for len(f.locals) <= idx {
+			f.locals = append(f.locals, Const(0))
+		}
+	}
+	f.locals[idx] = e
+}
+
+// Identifies the event of a case, so every event is reported once
+type caseKey struct {
+	kind  Kind
+	pc    uint32
+	taken bool
+}
+
+type explorer struct {
+	prog   *ijvmemu.Program
+	opts   Options
+	solver *Solver
+	// Symbols of the bytes read by IN, shared by all paths, and of the parameters
+	inputs []*Symbol
+	params []*Symbol
+
+	code   map[uint32]*ijvmemu.Instr
+	work   []*state
+	seen   map[caseKey]bool
+	report *Report
+}
+
+// Explore symbolically executes the program from the start of main, or of
+// the method named by the options, with symbolic parameters. Paths are
+// explored depth-first, until every path ended or a bound is reached.
+// Operations on the heap and the network are not executed symbolically, and
+// stop the paths reaching them.
+func Explore(ctx context.Context, prog *ijvmemu.Program, opts Options) (*Report, error) {
+	ex := &explorer{
+		prog:   prog,
+		opts:   opts,
+		solver: NewSolver(),
+		code:   make(map[uint32]*ijvmemu.Instr),
+		seen:   make(map[caseKey]bool),
+		report: &Report{},
+	}
+	if opts.Conflicts > 0 {
+		ex.solver.Conflicts = opts.Conflicts
+	}
+
+	start := prog.MethodAt(0)
+	if opts.Method != "" {
+		start = prog.MethodByName(opts.Method)
+		if start == nil {
+			return nil, fmt.Errorf("unknown method `%s`", opts.Method)
+		}
+	}
+	f := &frame{method: start}
+	for i := 0; i < start.NumParams+start.NumLocals; i++ {
+		f.locals = append(f.locals, Const(0))
+	}
+	for i := 1; i < start.NumParams; i++ {
+		name := start.VarName(i)
+		if name == "" {
+			name = fmt.Sprintf("arg%d", i)
+		}
+		sym := &Symbol{Kind: SymbolParam, Index: i - 1, Name: name}
+		ex.params = append(ex.params, sym)
+		f.locals[i] = Sym(sym)
+	}
+	ex.work = append(ex.work, &state{
+		pc:     start.Start,
+		frames: []*frame{f},
+		model:  make(map[*Symbol]int32),
+		forks:  make(map[uint32]int),
+	})
+
+	for len(ex.work) > 0 {
+		if ex.report.Paths >= opts.MaxPaths {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		default:
+		}
+		st := ex.work[len(ex.work)-1]
+		ex.work = ex.work[:len(ex.work)-1]
+		ex.report.Paths++
+		ex.run(st)
+	}
+
+	r := ex.report
+	r.Complete = len(ex.work) == 0 && r.Bounded == 0 && r.Undecided == 0
+	for _, c := range r.Cases {
+		r.Complete = r.Complete && c.Kind != KindStopped
+	}
+	sort.SliceStable(r.Cases, func(i, j int) bool {
+		a, b := r.Cases[i], r.Cases[j]
+		if a.PC != b.PC {
+			return a.PC < b.PC
+		}
+		return a.Taken && !b.Taken
+	})
+	return r, nil
+}
+
+// Returns the symbol of the given byte of input
+func (ex *explorer) input(i int) *Symbol {
+	for len(ex.inputs) <= i {
+		n := len(ex.inputs)
+		ex.inputs = append(ex.inputs, &Symbol{Kind: SymbolInput, Index: n, Name: fmt.Sprintf("in%d", n)})
+	}
+	return ex.inputs[i]
+}
+
+// Records the event, if it was not reached before, with the input of the
+// given values of the symbols
+func (ex *explorer) record(st *state, model map[*Symbol]int32, kind Kind, inst *ijvmemu.Instr, taken bool, err error) {
+	key := caseKey{kind, st.pc, taken}
+	if inst != nil {
+		key.pc = inst.PC
+	}
+	if ex.seen[key] {
+		return
+	}
+	ex.seen[key] = true
+
+	c := &Case{
+		Kind:  kind,
+		PC:    key.pc,
+		Line:  ex.prog.Line(key.pc),
+		Taken: taken,
+		Err:   err,
+	}
+	if inst != nil {
+		c.Op = inst.Op.Name
+	}
+	if m := ex.prog.MethodAt(key.pc); m != nil {
+		c.Method = m.Name
+	}
+	for i := 0; i < st.inputs; i++ {
+		c.Input = append(c.Input, byte(model[ex.input(i)]))
+	}
+	for len(c.Input) > 0 && c.Input[len(c.Input)-1] == 0 {
+		c.Input = c.Input[:len(c.Input)-1]
+	}
+	for _, p := range ex.params {
+		c.Args = append(c.Args, model[p])
+	}
+	ex.report.Cases = append(ex.report.Cases, c)
+}
+
+// Fetches the decoded instruction at the given byte offset
+func (ex *explorer) fetch(pc uint32) (*ijvmemu.Instr, error) {
+	if inst, ok := ex.code[pc]; ok {
+		return inst, nil
+	}
+	inst, err := ijvmemu.Decode(ex.prog.Text, pc, ex.prog.OpConfig())
+	if err != nil {
+		return nil, err
+	}
+	ex.code[pc] = inst
+	return inst, nil
+}
+
+// Executes the path until it ends, queueing the paths it forks into
+func (ex *explorer) run(st *state) {
+	for {
+		if st.steps >= ex.opts.MaxSteps {
+			ex.report.Bounded++
+			return
+		}
+		// Falling off the end of the program terminates it
+		if int(st.pc) == len(ex.prog.Text) {
+			ex.record(st, st.model, KindHalt, nil, false, nil)
+			return
+		}
+		inst, err := ex.fetch(st.pc)
+		if err != nil {
+			ex.record(st, st.model, KindFault, nil, false, err)
+			return
+		}
+		st.steps++
+		if !ex.step(st, inst) {
+			return
+		}
+	}
+}
+
+// Executes a single instruction, reporting whether the path continues
+func (ex *explorer) step(st *state, inst *ijvmemu.Instr) (cont bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			err, ok := r.(error)
+			if !ok {
+				panic(r)
+			}
+			ex.record(st, st.model, KindFault, inst, false, err)
+			cont = false
+		}
+	}()
+
+	next := inst.PC + inst.Size
+	ops := inst.Operands
+	switch inst.Op.Name {
+	case "NOP", "GC":
+	case "BIPUSH":
+		st.push(Const(ops[0]))
+	case "LDC_W":
+		if int(ops[0]) >= len(ex.prog.Constants) {
+			panic(fmt.Errorf("constant index %d out of range", ops[0]))
+		}
+		st.push(Const(ex.prog.Constants[ops[0]]))
+	case "DUP":
+		v := st.pop()
+		st.push(v)
+		st.push(v)
+	case "POP":
+		st.pop()
+	case "SWAP":
+		b, a := st.pop(), st.pop()
+		st.push(b)
+		st.push(a)
+	case "IADD", "ISUB", "IAND", "IOR":
+		b, a := st.pop(), st.pop()
+		st.push(map[string]func(a, b *Expr) *Expr{
+			"IADD": Add, "ISUB": Sub, "IAND": And, "IOR": Or,
+		}[inst.Op.Name](a, b))
+	case "GOTO":
+		next = uint32(ops[0])
+	case "IFEQ":
+		return ex.branch(st, inst, Eq(st.pop(), Const(0)))
+	case "IFLT":
+		return ex.branch(st, inst, Neg(st.pop()))
+	case "IF_ICMPEQ":
+		b, a := st.pop(), st.pop()
+		return ex.branch(st, inst, Eq(a, b))
+	case "ILOAD":
+		st.push(st.local(int(ops[0])))
+	case "ISTORE":
+		st.setLocal(int(ops[0]), st.pop())
+	case "IINC":
+		st.setLocal(int(ops[0]), Add(st.local(int(ops[0])), Const(ops[1])))
+	case "INVOKEVIRTUAL", "TAILCALL":
+		method := ex.method(inst)
+		n := method.NumParams
+		f := st.frame()
+		if len(st.stack)-f.base < n {
+			panic(ijvmemu.ErrStackUnderflow)
+		}
+		locals := append([]*Expr(nil), st.stack[len(st.stack)-n:]...)
+		for i := 0; i < method.NumLocals; i++ {
+			locals = append(locals, Const(0))
+		}
+		if inst.Op.Name == "TAILCALL" {
+			st.stack = st.stack[:f.base]
+			f.method = method
+			f.locals = locals
+		} else {
+			st.stack = st.stack[:len(st.stack)-n]
+			st.frames = append(st.frames, &frame{
+				method: method,
+				locals: locals,
+				base:   len(st.stack),
+				ret:    next,
+			})
+		}
+		next = method.Start
+	case "IRETURN":
+		if len(st.frames) == 1 {
+			if ex.opts.Method == "" {
+				ex.record(st, st.model, KindHalt, inst, false, nil)
+				return false
+			}
+			st.pop()
+			ex.record(st, st.model, KindReturn, inst, false, nil)
+			return false
+		}
+		v := st.pop()
+		f := st.frame()
+		st.stack = st.stack[:f.base]
+		st.frames = st.frames[:len(st.frames)-1]
+		st.push(v)
+		next = f.ret
+	case "IN":
+		st.push(Sym(ex.input(st.inputs)))
+		st.inputs++
+	case "OUT":
+		st.pop()
+	case "HALT":
+		ex.record(st, st.model, KindHalt, inst, false, nil)
+		return false
+	case "ERR":
+		ex.record(st, st.model, KindErr, inst, false, nil)
+		return false
+	default:
+		ex.record(st, st.model, KindStopped, inst, false,
+			fmt.Errorf("operation %s is not executed symbolically", inst.Op.Name))
+		return false
+	}
+	st.pc = next
+	return true
+}
+
+// Returns the method invoked by the instruction
+func (ex *explorer) method(inst *ijvmemu.Instr) *ijvmemu.Method {
+	if int(inst.Operands[0]) >= len(ex.prog.Constants) {
+		panic(fmt.Errorf("constant index %d out of range", inst.Operands[0]))
+	}
+	method, err := ex.prog.Method(uint32(ex.prog.Constants[inst.Operands[0]]))
+	if err != nil {
+		panic(err)
+	}
+	return method
+}
+
+// Continues the path in every feasible direction of the conditional branch,
+// jumping if the condition holds. Reports whether the path itself continues.
+func (ex *explorer) branch(st *state, inst *ijvmemu.Instr, c Cond) bool {
+	target, next := uint32(inst.Operands[0]), inst.PC+inst.Size
+	goTo := func(st *state, holds bool) {
+		st.pc = next
+		if holds {
+			st.pc = target
+		}
+	}
+
+	if holds, ok := c.Constant(); ok {
+		ex.record(st, st.model, KindBranch, inst, holds, nil)
+		goTo(st, holds)
+		return true
+	}
+
+	// The values satisfying the path so far decide one direction, only the
+	// other one needs solving
+	holds := c.Eval(st.model)
+	other := st.path.With(c, !holds)
+	st.path = st.path.With(c, holds)
+	ex.record(st, st.model, KindBranch, inst, holds, nil)
+	goTo(st, holds)
+
+	sat, model, err := ex.solver.Check(other)
+	switch {
+	case err != nil:
+		ex.report.Undecided++
+		return true
+	case !sat:
+		return true
+	}
+	ex.record(st, model, KindBranch, inst, !holds, nil)
+
+	st.forks[inst.PC]++
+	if st.forks[inst.PC] > ex.opts.LoopBound {
+		ex.report.Bounded++
+		return false
+	}
+	forked := st.fork()
+	forked.path = other
+	forked.model = model
+	goTo(forked, !holds)
+	ex.work = append(ex.work, forked)
+	return true
+}
@@ -0,0 +1,284 @@
+package ijvmsym
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/BlackNovaTech/gojasm/ijvmemu"
+	"github.com/BlackNovaTech/gojasm/internal/testprog"
+	"github.com/BlackNovaTech/gojasm/opconf"
+)
+
+// Explores the program, and checks that the emulator reaches the event of
+// every case given its input
+func explore(t *testing.T, name, src string, opts Options) *Report {
+	return exploreConfig(t, opconf.NewDefaultOpConfig(), name, src, opts)
+}
+
+func exploreConfig(t *testing.T, cfg *opconf.OpConfig, name, src string, opts Options) *Report {
+	prog := testprog.Assemble(t, cfg, name, src)
+	report, err := Explore(context.Background(), prog, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range report.Cases {
+		cov := ijvmemu.NewCoverage()
+		m := ijvmemu.NewMachine(prog, strings.NewReader(string(c.Input)), ioutil.Discard)
+		m.MaxSteps = 1 << 20
+		m.AddHook(cov)
+		var err error
+		if opts.Method != "" {
+			_, err = m.Call(context.Background(), opts.Method, c.Args...)
+		} else {
+			err = m.Run()
+		}
+
+		var rerr *ijvmemu.RuntimeError
+		failedAt := errors.As(err, &rerr) && rerr.PC == c.PC
+		switch c.Kind {
+		case KindBranch:
+			b := cov.Branch(c.PC)
+			if b == nil || c.Taken && b.Taken == 0 || !c.Taken && b.NotTaken == 0 {
+				t.Errorf("%s: input %q args %v does not reach %s at pc %d, taken: %v", name, c.Input, c.Args, c.Op, c.PC, c.Taken)
+			}
+		case KindHalt, KindReturn:
+			if err != nil || cov.Count(c.PC) == 0 && c.Op != "" {
+				t.Errorf("%s: input %q args %v does not end at pc %d: %v", name, c.Input, c.Args, c.PC, err)
+			}
+		case KindErr:
+			if !failedAt || !errors.Is(err, ijvmemu.ErrErrInstruction) {
+				t.Errorf("%s: input %q args %v does not reach ERR at pc %d: %v", name, c.Input, c.Args, c.PC, err)
+			}
+		case KindFault:
+			if !failedAt {
+				t.Errorf("%s: input %q args %v does not fail at pc %d (%v): %v", name, c.Input, c.Args, c.PC, c.Err, err)
+			}
+		}
+	}
+	return report
+}
+
+// Returns the amount of cases of the given kind
+func count(r *Report, kind Kind) int {
+	n := 0
+	for _, c := range r.Cases {
+		if c.Kind == kind {
+			n++
+		}
+	}
+	return n
+}
+
+// Asserts that both directions of every conditional branch were reached
+func allBranches(t *testing.T, name string, r *Report, branches int) {
+	if n := count(r, KindBranch); n != 2*branches {
+		t.Errorf("%s: %d branch directions reached, want %d", name, n, 2*branches)
+	}
+}
+
+func TestExplorePassword(t *testing.T) {
+	r := explore(t, "password.jas", `
+.main
+IN
+BIPUSH 's'
+IF_ICMPEQ s
+HALT
+s:
+IN
+BIPUSH 'y'
+IF_ICMPEQ y
+HALT
+y:
+IN
+BIPUSH 'm'
+IF_ICMPEQ m
+HALT
+m:
+ERR
+.end-main`, DefaultOptions)
+	allBranches(t, "password.jas", r, 3)
+	if !r.Complete || r.Paths != 4 {
+		t.Errorf("explored %d paths, complete: %v", r.Paths, r.Complete)
+	}
+	for _, c := range r.Cases {
+		if c.Kind == KindErr && string(c.Input) != "sym" {
+			t.Errorf("ERR reached by %q", c.Input)
+		}
+	}
+	if count(r, KindErr) != 1 {
+		t.Error("ERR not reached")
+	}
+}
+
+func TestExploreArithmetic(t *testing.T) {
+	r := explore(t, "arithmetic.jas", `
+.main
+IN
+IN
+IADD
+DUP
+BIPUSH 100
+IF_ICMPEQ hundred
+BIPUSH 0x0f
+IAND
+BIPUSH 0x0f
+ISUB
+IFEQ low
+IN
+BIPUSH 0x40
+IOR
+BIPUSH 0x40
+ISUB
+IFLT never
+HALT
+low:
+HALT
+hundred:
+POP
+IN
+IN
+ISUB
+IFLT less
+HALT
+less:
+ERR
+never:
+ERR
+.end-main`, DefaultOptions)
+	// The OR with 0x40 never drops below 0x40
+	if n := count(r, KindBranch); n != 7 {
+		t.Errorf("%d branch directions reached, want 7", n)
+	}
+	if count(r, KindErr) != 1 || count(r, KindHalt) != 3 {
+		t.Errorf("reached %d ERR and %d HALT", count(r, KindErr), count(r, KindHalt))
+	}
+}
+
+func TestExploreLoop(t *testing.T) {
+	opts := DefaultOptions
+	opts.LoopBound = 4
+	r := explore(t, "loop.jas", `
+.main
+.var
+n
+.end-var
+loop:
+IN
+DUP
+IFEQ done
+BIPUSH '0'
+ISUB
+ILOAD n
+IADD
+ISTORE n
+GOTO loop
+done:
+POP
+ILOAD n
+BIPUSH 20
+IF_ICMPEQ twenty
+HALT
+twenty:
+ERR
+.end-main`, opts)
+	allBranches(t, "loop.jas", r, 2)
+	if r.Complete || r.Bounded == 0 {
+		t.Errorf("unbounded loop explored completely")
+	}
+	if count(r, KindErr) != 1 {
+		t.Error("ERR not reached")
+	}
+}
+
+func TestExploreFaults(t *testing.T) {
+	// The sample configuration adds the array operations
+	cfg := opconf.NewOpConfigFromPath("../ijvm.config")
+	r := exploreConfig(t, cfg, "faults.jas", `
+.constant
+objref 0
+.end-constant
+.main
+IN
+IFEQ zero
+IN
+BIPUSH 1
+IF_ICMPEQ one
+LDC_W objref
+IN
+INVOKEVIRTUAL f
+POP
+BIPUSH 0
+NEWARRAY
+HALT
+one:
+POP
+zero:
+HALT
+.end-main
+
+.method f(x)
+ILOAD x
+BIPUSH 7
+IF_ICMPEQ pop
+ILOAD x
+IRETURN
+pop:
+POP
+IRETURN
+.end-method`, DefaultOptions)
+	allBranches(t, "faults.jas", r, 3)
+	if count(r, KindFault) != 2 || count(r, KindStopped) != 1 {
+		t.Errorf("found %d faults and stopped at %d operations", count(r, KindFault), count(r, KindStopped))
+	}
+	if r.Complete {
+		t.Error("exploration stopped at NEWARRAY reported complete")
+	}
+}
+
+func TestExploreMethod(t *testing.T) {
+	opts := DefaultOptions
+	opts.Method = "clamp"
+	r := explore(t, "method.jas", `
+.main
+HALT
+.end-main
+
+.method clamp(x, lo, hi)
+ILOAD x
+ILOAD lo
+ISUB
+IFLT low
+ILOAD hi
+ILOAD x
+ISUB
+IFLT high
+ILOAD x
+IRETURN
+low:
+ILOAD lo
+IRETURN
+high:
+ILOAD hi
+IRETURN
+.end-method`, opts)
+	allBranches(t, "method.jas", r, 2)
+	if count(r, KindReturn) != 3 || !r.Complete {
+		t.Errorf("reached %d returns, complete: %v", count(r, KindReturn), r.Complete)
+	}
+}
+
+func TestExplorePrograms(t *testing.T) {
+	for _, p := range testprog.Examples(t) {
+		opts := DefaultOptions
+		opts.LoopBound = 3
+		opts.MaxSteps = 1 << 21
+		r := explore(t, p.Name, p.Source, opts)
+		if count(r, KindHalt) == 0 {
+			t.Errorf("%s: never halts", p.Name)
+		}
+	}
+}
@@ -0,0 +1,193 @@
+package ijvmsym
+
+import (
+	"fmt"
+)
+
+// SymbolKind tells where the value of a Symbol comes from.
+type SymbolKind int
+
+const (
+	// SymbolInput is a byte read by IN
+	SymbolInput SymbolKind = iota
+	// SymbolParam is a parameter of the explored method
+	SymbolParam
+)
+
+// Symbol is an unknown value the program depends on.
+type Symbol struct {
+	Kind SymbolKind
+	// Index is the position of the byte in the input, or of the parameter
+	// after the object reference
+	Index int
+	// Name is used when printing expressions
+	Name string
+}
+
+// Bits returns the amount of low bits the symbol may set. Bytes read by IN
+// are zero extended.
+func (s *Symbol) Bits() int {
+	if s.Kind == SymbolInput {
+		return 8
+	}
+	return 32
+}
+
+type exprOp int
+
+const (
+	opConst exprOp = iota
+	opSymbol
+	opAdd
+	opSub
+	opAnd
+	opOr
+)
+
+var exprOpNames = map[exprOp]string{
+	opAdd: "+",
+	opSub: "-",
+	opAnd: "&",
+	opOr:  "|",
+}
+
+// Expr is a symbolic 32-bit integer, built from constants and symbols using
+// the arithmetic IJVM supports. Expressions are immutable, and compared by identity.
+type Expr struct {
+	op    exprOp
+	value int32
+	sym   *Symbol
+	a, b  *Expr
+}
+
+// Const returns the expression of a constant.
+func Const(v int32) *Expr {
+	return &Expr{op: opConst, value: v}
+}
+
+// Sym returns the expression of a symbol.
+func Sym(s *Symbol) *Expr {
+	return &Expr{op: opSymbol, sym: s}
+}
+
+// Returns the expression of a binary operation, evaluating it if both operands are constant
+func binary(op exprOp, a, b *Expr) *Expr {
+	if av, ok := a.Constant(); ok {
+		if bv, ok := b.Constant(); ok {
+			return Const(evalOp(op, av, bv))
+		}
+	}
+	if bv, ok := b.Constant(); ok {
+		switch {
+		case bv == 0 && (op == opAdd || op == opSub || op == opOr):
+			return a
+		case bv == 0 && op == opAnd:
+			return b
+		case bv == -1 && op == opAnd:
+			return a
+		}
+	}
+	if av, ok := a.Constant(); ok {
+		switch {
+		case av == 0 && (op == opAdd || op == opOr):
+			return b
+		case av == 0 && op == opAnd:
+			return a
+		case av == -1 && op == opAnd:
+			return b
+		}
+	}
+	return &Expr{op: op, a: a, b: b}
+}
+
+// Add returns a + b.
+func Add(a, b *Expr) *Expr { return binary(opAdd, a, b) }
+
+// Sub returns a - b.
+func Sub(a, b *Expr) *Expr { return binary(opSub, a, b) }
+
+// And returns a & b.
+func And(a, b *Expr) *Expr { return binary(opAnd, a, b) }
+
+// Or returns a | b.
+func Or(a, b *Expr) *Expr { return binary(opOr, a, b) }
+
+func evalOp(op exprOp, a, b int32) int32 {
+	switch op {
+	case opAdd:
+		return a + b
+	case opSub:
+		return a - b
+	case opAnd:
+		return a & b
+	case opOr:
+		return a | b
+	}
+	panic(fmt.Sprintf("not a binary operation: %d", op))
+}
+
+// Constant returns the value of the expression, if it is constant.
+func (e *Expr) Constant() (int32, bool) {
+	return e.value, e.op == opConst
+}
+
+// Eval evaluates the expression, given the values of its symbols.
+func (e *Expr) Eval(values map[*Symbol]int32) int32 {
+	switch e.op {
+	case opConst:
+		return e.value
+	case opSymbol:
+		return values[e.sym]
+	}
+	return evalOp(e.op, e.a.Eval(values), e.b.Eval(values))
+}
+
+func (e *Expr) String() string {
+	switch e.op {
+	case opConst:
+		return fmt.Sprint(e.value)
+	case opSymbol:
+		return e.sym.Name
+	}
+	return fmt.Sprintf("(%s %s %s)", e.a, exprOpNames[e.op], e.b)
+}
+
+// Cond is a condition a conditional branch depends on.
+type Cond struct {
+	// Either a == b, or a < 0 if b is nil
+	a, b *Expr
+}
+
+// Eq returns the condition a == b.
+func Eq(a, b *Expr) Cond { return Cond{a, b} }
+
+// Neg returns the condition a < 0.
+func Neg(a *Expr) Cond { return Cond{a, nil} }
+
+// Constant returns whether the condition holds, if it does not depend on any symbol.
+func (c Cond) Constant() (holds, ok bool) {
+	a, ok := c.a.Constant()
+	if !ok {
+		return false, false
+	}
+	if c.b == nil {
+		return a < 0, true
+	}
+	b, ok := c.b.Constant()
+	return a == b, ok
+}
+
+// Eval evaluates the condition, given the values of its symbols.
+func (c Cond) Eval(values map[*Symbol]int32) bool {
+	if c.b == nil {
+		return c.a.Eval(values) < 0
+	}
+	return c.a.Eval(values) == c.b.Eval(values)
+}
+
+func (c Cond) String() string {
+	if c.b == nil {
+		return fmt.Sprintf("%s < 0", c.a)
+	}
+	return fmt.Sprintf("%s == %s", c.a, c.b)
+}
@@ -0,0 +1,342 @@
+package ijvmsym
+
+// A literal of the SAT solver, variable v is 2v and its negation 2v+1
+type lit int
+
+func (l lit) neg() lit { return l ^ 1 }
+func (l lit) v() int   { return int(l >> 1) }
+
+// Literals of the constant variable, which is always true
+const (
+	litTrue  lit = 0
+	litFalse lit = 1
+)
+
+type clause struct {
+	lits []lit
+}
+
+// Result of a satisfiability check
+type satResult int
+
+const (
+	satUnsat satResult = iota
+	satSat
+	// The conflict budget ran out before a result was found
+	satUnknown
+)
+
+// A conflict driven clause learning SAT solver with two watched literals,
+// solving under assumptions so a single solver serves many related queries.
+// Learnt clauses follow from the clauses added, and are kept between queries.
+type sat struct {
+	clauses []*clause
+	watches [][]*clause
+
+	// Per variable: the assigned value (0 unassigned, 1 true, -1 false), the
+	// decision level it was assigned at, and the clause implying it
+	assign []int8
+	level  []int
+	reason []*clause
+	// Saved phases, and activities deciding which variable to branch on
+	phase    []bool
+	activity []float64
+	inc      float64
+
+	trail    []lit
+	trailLim []int
+	qhead    int
+	seen     []bool
+
+	// Set once the clauses themselves are unsatisfiable
+	unsat bool
+	// Values of the variables of the last satisfying assignment
+	model []bool
+}
+
+func newSat() *sat {
+	s := &sat{inc: 1}
+	s.newVar()
+	s.addClause(litTrue)
+	return s
+}
+
+// Returns the positive literal of a new variable
+func (s *sat) newVar() lit {
+	v := len(s.assign)
+	s.assign = append(s.assign, 0)
+	s.level = append(s.level, 0)
+	s.reason = append(s.reason, nil)
+	s.phase = append(s.phase, false)
+	s.activity = append(s.activity, 0)
+	s.seen = append(s.seen, false)
+	s.watches = append(s.watches, nil, nil)
+	return lit(2 * v)
+}
+
+// Returns 1 if the literal is true, -1 if false, 0 if unassigned
+func (s *sat) value(l lit) int8 {
+	a := s.assign[l.v()]
+	if l&1 != 0 {
+		return -a
+	}
+	return a
+}
+
+func (s *sat) decisionLevel() int {
+	return len(s.trailLim)
+}
+
+// Adds a clause. Must only be called between queries.
+func (s *sat) addClause(lits ...lit) {
+	if s.unsat {
+		return
+	}
+	var c []lit
+	for _, l := range lits {
+		switch s.value(l) {
+		case 1:
+			return
+		case 0:
+			dup := false
+			for _, o := range c {
+				if o == l.neg() {
+					return
+				}
+				dup = dup || o == l
+			}
+			if !dup {
+				c = append(c, l)
+			}
+		}
+	}
+	switch len(c) {
+	case 0:
+		s.unsat = true
+	case 1:
+		s.enqueue(c[0], nil)
+		if s.propagate() != nil {
+			s.unsat = true
+		}
+	default:
+		s.attach(&clause{lits: c})
+	}
+}
+
+func (s *sat) attach(c *clause) {
+	s.clauses = append(s.clauses, c)
+	s.watches[c.lits[0].neg()] = append(s.watches[c.lits[0].neg()], c)
+	s.watches[c.lits[1].neg()] = append(s.watches[c.lits[1].neg()], c)
+}
+
+func (s *sat) enqueue(l lit, reason *clause) {
+	v := l.v()
+	if l&1 != 0 {
+		s.assign[v] = -1
+	} else {
+		s.assign[v] = 1
+	}
+	s.level[v] = s.decisionLevel()
+	s.reason[v] = reason
+	s.trail = append(s.trail, l)
+}
+
+// Propagates the assignments on the trail, returning a conflicting clause if any.
+// Clauses are watched by the negations of their first two literals.
+func (s *sat) propagate() *clause {
+	for s.qhead < len(s.trail) {
+		p := s.trail[s.qhead]
+		s.qhead++
+		watching := s.watches[p]
+		s.watches[p] = watching[:0]
+		for i := 0; i < len(watching); i++ {
+			c := watching[i]
+			if c.lits[0] == p.neg() {
+				c.lits[0], c.lits[1] = c.lits[1], c.lits[0]
+			}
+			if s.value(c.lits[0]) == 1 {
+				s.watches[p] = append(s.watches[p], c)
+				continue
+			}
+			moved := false
+			for k := 2; k < len(c.lits); k++ {
+				if s.value(c.lits[k]) != -1 {
+					c.lits[1], c.lits[k] = c.lits[k], c.lits[1]
+					s.watches[c.lits[1].neg()] = append(s.watches[c.lits[1].neg()], c)
+					moved = true
+					break
+				}
+			}
+			if moved {
+				continue
+			}
+			s.watches[p] = append(s.watches[p], c)
+			if s.value(c.lits[0]) == -1 {
+				s.watches[p] = append(s.watches[p], watching[i+1:]...)
+				s.qhead = len(s.trail)
+				return c
+			}
+			s.enqueue(c.lits[0], c)
+		}
+	}
+	return nil
+}
+
+// Derives the first unique implication point clause of the conflict, returning
+// it with its asserting literal first, and the level to backtrack to
+func (s *sat) analyze(confl *clause) ([]lit, int) {
+	learnt := []lit{0}
+	pathC := 0
+	p := lit(-1)
+	index := len(s.trail) - 1
+	for {
+		for _, q := range confl.lits {
+			if q == p {
+				continue
+			}
+			v := q.v()
+			if s.seen[v] || s.level[v] == 0 {
+				continue
+			}
+			s.seen[v] = true
+			s.bump(v)
+			if s.level[v] == s.decisionLevel() {
+				pathC++
+			} else {
+				learnt = append(learnt, q)
+			}
+		}
+		for !s.seen[s.trail[index].v()] {
+			index--
+		}
+		p = s.trail[index]
+		index--
+		confl = s.reason[p.v()]
+		s.seen[p.v()] = false
+		pathC--
+		if pathC == 0 {
+			break
+		}
+	}
+	learnt[0] = p.neg()
+
+	back := 0
+	for i := 1; i < len(learnt); i++ {
+		s.seen[learnt[i].v()] = false
+		if l := s.level[learnt[i].v()]; l > back {
+			back = l
+			learnt[1], learnt[i] = learnt[i], learnt[1]
+		}
+	}
+	return learnt, back
+}
+
+func (s *sat) bump(v int) {
+	s.activity[v] += s.inc
+	if s.activity[v] > 1e100 {
+		for i := range s.activity {
+			s.activity[i] *= 1e-100
+		}
+		s.inc *= 1e-100
+	}
+}
+
+func (s *sat) cancelUntil(level int) {
+	if s.decisionLevel() <= level {
+		return
+	}
+	for i := len(s.trail) - 1; i >= s.trailLim[level]; i-- {
+		v := s.trail[i].v()
+		s.phase[v] = s.assign[v] == 1
+		s.assign[v] = 0
+		s.reason[v] = nil
+	}
+	s.trail = s.trail[:s.trailLim[level]]
+	s.trailLim = s.trailLim[:level]
+	s.qhead = len(s.trail)
+}
+
+// Returns the unassigned variable of the highest activity among the given ones, or -1
+func (s *sat) pick(vars []int) int {
+	best := -1
+	for _, v := range vars {
+		if s.assign[v] == 0 && (best < 0 || s.activity[v] > s.activity[best]) {
+			best = v
+		}
+	}
+	return best
+}
+
+// Solves the clauses under the assumed literals, giving up after the given
+// amount of conflicts. Only the given variables are decided on, the others
+// must be implied by them, or be free to take any value consistent with them.
+// The satisfying assignment found is kept in model, leaving the variables
+// never assigned false.
+func (s *sat) solve(assumptions []lit, vars []int, budget int) satResult {
+	if s.unsat {
+		return satUnsat
+	}
+	defer s.cancelUntil(0)
+
+	conflicts := 0
+	restart := 100
+	for {
+		if confl := s.propagate(); confl != nil {
+			conflicts++
+			if s.decisionLevel() == 0 {
+				s.unsat = true
+				return satUnsat
+			}
+			if conflicts > budget {
+				return satUnknown
+			}
+			learnt, back := s.analyze(confl)
+			s.cancelUntil(back)
+			if len(learnt) == 1 {
+				s.enqueue(learnt[0], nil)
+			} else {
+				c := &clause{lits: learnt}
+				s.attach(c)
+				s.enqueue(learnt[0], c)
+			}
+			s.inc *= 1.05
+			continue
+		}
+
+		if conflicts >= restart {
+			restart += restart / 2
+			s.cancelUntil(0)
+			continue
+		}
+
+		if level := s.decisionLevel(); level < len(assumptions) {
+			p := assumptions[level]
+			switch s.value(p) {
+			case -1:
+				return satUnsat
+			case 1:
+				// Keep one decision level per assumption
+				s.trailLim = append(s.trailLim, len(s.trail))
+			default:
+				s.trailLim = append(s.trailLim, len(s.trail))
+				s.enqueue(p, nil)
+			}
+			continue
+		}
+
+		v := s.pick(vars)
+		if v < 0 {
+			s.model = make([]bool, len(s.assign))
+			for i, a := range s.assign {
+				s.model[i] = a == 1
+			}
+			return satSat
+		}
+		s.trailLim = append(s.trailLim, len(s.trail))
+		l := lit(2 * v)
+		if !s.phase[v] {
+			l = l.neg()
+		}
+		s.enqueue(l, nil)
+	}
+}
@@ -0,0 +1,263 @@
+package ijvmsym
+
+// Key of a gate, for sharing equal gates
+type gate struct {
+	op   byte
+	a, b lit
+}
+
+// Solver decides the satisfiability of paths by bit-blasting their conditions
+// into a SAT problem. Expressions and conditions are translated once, into
+// literals shared by the following queries, until the problem grows too large
+// and is started over.
+type Solver struct {
+	// Conflicts is the budget of every query, after which it gives up
+	Conflicts int
+
+	sat     *sat
+	bits    map[*Expr][]lit
+	symbols map[*Symbol][]lit
+	conds   map[Cond]lit
+	gates   map[gate]lit
+	// Inputs of every gate, by variable
+	inputs map[int][2]lit
+}
+
+// DefaultConflicts is the default conflict budget of a single query.
+const DefaultConflicts = 100000
+
+// Amount of variables after which the SAT problem is started over, as
+// propagating the gates of old queries slows down new ones
+const maxVars = 1 << 14
+
+// NewSolver returns an empty Solver.
+func NewSolver() *Solver {
+	s := &Solver{Conflicts: DefaultConflicts}
+	s.reset()
+	return s
+}
+
+func (s *Solver) reset() {
+	s.sat = newSat()
+	s.bits = make(map[*Expr][]lit)
+	s.symbols = make(map[*Symbol][]lit)
+	s.conds = make(map[Cond]lit)
+	s.gates = make(map[gate]lit)
+	s.inputs = make(map[int][2]lit)
+}
+
+func (s *Solver) and(a, b lit) lit {
+	switch {
+	case a == litFalse || b == litFalse || a == b.neg():
+		return litFalse
+	case a == litTrue:
+		return b
+	case b == litTrue || a == b:
+		return a
+	}
+	if a > b {
+		a, b = b, a
+	}
+	key := gate{'&', a, b}
+	if g, ok := s.gates[key]; ok {
+		return g
+	}
+	g := s.sat.newVar()
+	s.sat.addClause(g.neg(), a)
+	s.sat.addClause(g.neg(), b)
+	s.sat.addClause(g, a.neg(), b.neg())
+	s.gates[key] = g
+	s.inputs[g.v()] = [2]lit{a, b}
+	return g
+}
+
+func (s *Solver) or(a, b lit) lit {
+	return s.and(a.neg(), b.neg()).neg()
+}
+
+func (s *Solver) xor(a, b lit) lit {
+	switch {
+	case a == litFalse:
+		return b
+	case b == litFalse:
+		return a
+	case a == litTrue:
+		return b.neg()
+	case b == litTrue:
+		return a.neg()
+	case a == b:
+		return litFalse
+	case a == b.neg():
+		return litTrue
+	}
+	if a > b {
+		a, b = b, a
+	}
+	key := gate{'^', a, b}
+	if g, ok := s.gates[key]; ok {
+		return g
+	}
+	g := s.sat.newVar()
+	s.sat.addClause(g.neg(), a, b)
+	s.sat.addClause(g.neg(), a.neg(), b.neg())
+	s.sat.addClause(g, a.neg(), b)
+	s.sat.addClause(g, a, b.neg())
+	s.gates[key] = g
+	s.inputs[g.v()] = [2]lit{a, b}
+	return g
+}
+
+// Returns the sum bits of the ripple carry adder of a and b
+func (s *Solver) add(a, b []lit, carry lit) []lit {
+	sum := make([]lit, 32)
+	for i := range sum {
+		x := s.xor(a[i], b[i])
+		sum[i] = s.xor(x, carry)
+		carry = s.or(s.and(a[i], b[i]), s.and(x, carry))
+	}
+	return sum
+}
+
+// Returns the literals of the bits of the expression, least significant first
+func (s *Solver) blast(e *Expr) []lit {
+	if bits, ok := s.bits[e]; ok {
+		return bits
+	}
+	bits := make([]lit, 32)
+	switch e.op {
+	case opConst:
+		for i := range bits {
+			bits[i] = litFalse
+			if e.value>>uint(i)&1 != 0 {
+				bits[i] = litTrue
+			}
+		}
+	case opSymbol:
+		bits = s.symbol(e.sym)
+	case opAdd:
+		bits = s.add(s.blast(e.a), s.blast(e.b), litFalse)
+	case opSub:
+		b := s.blast(e.b)
+		inv := make([]lit, 32)
+		for i := range inv {
+			inv[i] = b[i].neg()
+		}
+		bits = s.add(s.blast(e.a), inv, litTrue)
+	case opAnd, opOr:
+		a, b := s.blast(e.a), s.blast(e.b)
+		for i := range bits {
+			if e.op == opAnd {
+				bits[i] = s.and(a[i], b[i])
+			} else {
+				bits[i] = s.or(a[i], b[i])
+			}
+		}
+	}
+	s.bits[e] = bits
+	return bits
+}
+
+// Returns the literals of the bits of the symbol, allocating them if new
+func (s *Solver) symbol(sym *Symbol) []lit {
+	if bits, ok := s.symbols[sym]; ok {
+		return bits
+	}
+	bits := make([]lit, 32)
+	for i := range bits {
+		bits[i] = litFalse
+		if i < sym.Bits() {
+			bits[i] = s.sat.newVar()
+		}
+	}
+	s.symbols[sym] = bits
+	return bits
+}
+
+// Returns the literal holding iff the condition holds
+func (s *Solver) cond(c Cond) lit {
+	if l, ok := s.conds[c]; ok {
+		return l
+	}
+	a := s.blast(c.a)
+	l := a[31]
+	if c.b != nil {
+		b := s.blast(c.b)
+		l = litTrue
+		for i := range a {
+			l = s.and(l, s.xor(a[i], b[i]).neg())
+		}
+	}
+	s.conds[c] = l
+	return l
+}
+
+// Returns the variables the given literals depend on. Gates outside of the
+// cone of the path can always be given the value of their inputs, so the
+// search is restricted to the cone.
+func (s *Solver) cone(lits []lit) []int {
+	var vars []int
+	seen := make(map[int]bool)
+	work := append([]lit(nil), lits...)
+	for len(work) > 0 {
+		v := work[len(work)-1].v()
+		work = work[:len(work)-1]
+		if seen[v] {
+			continue
+		}
+		seen[v] = true
+		vars = append(vars, v)
+		if in, ok := s.inputs[v]; ok {
+			work = append(work, in[0], in[1])
+		}
+	}
+	return vars
+}
+
+// Path is a conjunction of conditions, which either hold or not.
+type Path struct {
+	conds []Cond
+	holds []bool
+}
+
+// With returns the path extended with the condition, or its negation if holds is false.
+func (p Path) With(c Cond, holds bool) Path {
+	n := len(p.conds)
+	return Path{
+		conds: append(p.conds[:n:n], c),
+		holds: append(p.holds[:n:n], holds),
+	}
+}
+
+// Check reports whether the path is satisfiable. Returns the values of the
+// symbols known to the solver, if it is. Fails if the conflict budget runs
+// out before the satisfiability is decided.
+func (s *Solver) Check(p Path) (bool, map[*Symbol]int32, error) {
+	if len(s.sat.assign) > maxVars {
+		s.reset()
+	}
+	lits := make([]lit, len(p.conds))
+	for i, c := range p.conds {
+		lits[i] = s.cond(c)
+		if !p.holds[i] {
+			lits[i] = lits[i].neg()
+		}
+	}
+
+	switch s.sat.solve(lits, s.cone(lits), s.Conflicts) {
+	case satUnsat:
+		return false, nil, nil
+	case satUnknown:
+		return false, nil, ErrBudget
+	}
+	values := make(map[*Symbol]int32, len(s.symbols))
+	for sym, bits := range s.symbols {
+		var v int32
+		for i, b := range bits {
+			if b.v() < len(s.sat.model) && s.sat.model[b.v()] != (b&1 != 0) {
+				v |= 1 << uint(i)
+			}
+		}
+		values[sym] = v
+	}
+	return true, values, nil
+}
@@ -0,0 +1,99 @@
+package ijvmsym
+
+import (
+	"math/rand"
+	"testing"
+)
+
+// Builds a random expression over the given symbols
+func randomExpr(r *rand.Rand, syms []*Symbol, depth int) *Expr {
+	if depth == 0 || r.Intn(4) == 0 {
+		if r.Intn(3) == 0 {
+			return Const(int32(r.Uint32()) >> uint(r.Intn(32)))
+		}
+		return Sym(syms[r.Intn(len(syms))])
+	}
+	a, b := randomExpr(r, syms, depth-1), randomExpr(r, syms, depth-1)
+	return []func(a, b *Expr) *Expr{Add, Sub, And, Or}[r.Intn(4)](a, b)
+}
+
+// Every model found must satisfy the path, and paths satisfied by known values must be found satisfiable
+func TestSolverRandom(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	syms := []*Symbol{
+		{Kind: SymbolInput, Index: 0, Name: "in0"},
+		{Kind: SymbolInput, Index: 1, Name: "in1"},
+		{Kind: SymbolParam, Index: 0, Name: "x"},
+		{Kind: SymbolParam, Index: 1, Name: "y"},
+	}
+	s := NewSolver()
+	for i := 0; i < 300; i++ {
+		known := make(map[*Symbol]int32)
+		for _, sym := range syms {
+			known[sym] = int32(r.Uint32())
+			if sym.Kind == SymbolInput {
+				known[sym] &= 0xFF
+			}
+		}
+
+		var conds []Cond
+		var holds []bool
+		path := Path{}
+		for j := 0; j < 1+r.Intn(4); j++ {
+			a := randomExpr(r, syms, 4)
+			c := Neg(a)
+			if r.Intn(2) == 0 {
+				c = Eq(a, Const(a.Eval(known)))
+			}
+			conds = append(conds, c)
+			holds = append(holds, c.Eval(known))
+			path = path.With(c, holds[j])
+		}
+
+		sat, model, err := s.Check(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !sat {
+			t.Fatalf("%v: unsatisfiable, satisfied by %v", conds, known)
+		}
+		for j, c := range conds {
+			if c.Eval(model) != holds[j] {
+				t.Fatalf("%v: model %v does not satisfy %s == %v", conds, model, c, holds[j])
+			}
+		}
+		for _, sym := range syms {
+			if sym.Kind == SymbolInput && model[sym]&^0xFF != 0 {
+				t.Fatalf("input %s out of byte range: %d", sym.Name, model[sym])
+			}
+		}
+	}
+}
+
+func TestSolverUnsatisfiable(t *testing.T) {
+	in := Sym(&Symbol{Kind: SymbolInput, Name: "in0"})
+	x := Sym(&Symbol{Kind: SymbolParam, Name: "x"})
+	s := NewSolver()
+	for _, path := range [][]Cond{
+		// Sums of a value with itself are even
+		{Eq(Add(x, x), Const(1))},
+		// Inputs are bytes
+		{Neg(in)},
+		{Eq(in, Const(256))},
+		{Eq(And(in, Const(0xF0)), Const(0x0F))},
+		{Eq(Sub(x, Const(1)), Const(5)), Eq(Or(x, Const(1)), Const(6))},
+		{Neg(x), Eq(And(x, Const(0x7FFFFFFF)), x)},
+	} {
+		p := Path{}
+		for _, c := range path {
+			p = p.With(c, true)
+		}
+		sat, _, err := s.Check(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sat {
+			t.Errorf("%v: satisfiable", path)
+		}
+	}
+}
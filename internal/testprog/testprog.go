@@ -0,0 +1,123 @@
+// Package testprog assembles the programs used by the tests of the other
+// packages, and provides the example programs every pass is checked against.
+package testprog
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/BlackNovaTech/gojasm/ijvmasm"
+	"github.com/BlackNovaTech/gojasm/ijvmemu"
+	"github.com/BlackNovaTech/gojasm/opconf"
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+)
+
+// Collects the entries logged by the tests
+var hook = test.NewGlobal()
+
+// MaxSteps is the amount of instructions Run executes before giving up.
+const MaxSteps = 1 << 20
+
+// ExampleInput is the input the example programs are run on.
+const ExampleInput = "Hello, world!\n"
+
+// Example programs, relative to the root of the repository
+var examples = []string{
+	"ijvmemu/testdata/loop.jas",
+	"ijvmemu/testdata/recursion.jas",
+	"ijvmemu/testdata/print.jas",
+	"ijvmgo/testdata/countdown.jas",
+	"ijvmgo/testdata/caesar.jas",
+}
+
+func init() {
+	// Tests only report the warnings and errors of the assembler
+	logrus.SetLevel(logrus.WarnLevel)
+}
+
+// Example is an example program of the repository.
+type Example struct {
+	// Name is the file name of the program
+	Name   string
+	Source string
+}
+
+// Examples reads the example programs of the repository.
+func Examples(tb testing.TB) []Example {
+	_, file, _, _ := runtime.Caller(0)
+	root := filepath.Join(filepath.Dir(file), "..", "..")
+
+	var progs []Example
+	for _, name := range examples {
+		src, err := ioutil.ReadFile(filepath.Join(root, name))
+		if err != nil {
+			tb.Fatal(err)
+		}
+		progs = append(progs, Example{filepath.Base(name), string(src)})
+	}
+	return progs
+}
+
+// Assemble assembles the JAS source using the given configuration, or the
+// default one if nil, once the options are applied to the assembler. The test
+// fails if the program does not assemble.
+func Assemble(tb testing.TB, ops *opconf.OpConfig, name, src string, options ...func(*ijvmasm.Assembler)) *ijvmemu.Program {
+	if ops == nil {
+		ops = opconf.NewDefaultOpConfig()
+	}
+	asm := ijvmasm.NewAssemblerFromReader(strings.NewReader(src), name, ops)
+	for _, option := range options {
+		option(asm)
+	}
+	if ok, err := asm.Parse(); !ok || err != nil {
+		tb.Fatalf("%s: assembly failed: %v", name, err)
+	}
+	prog, err := ijvmemu.FromAssembler(asm)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return prog
+}
+
+// Run runs the program on the input for at most MaxSteps instructions,
+// returning its output, the amount of executed instructions and the error it
+// stopped with.
+func Run(prog *ijvmemu.Program, input string) (string, uint64, error) {
+	out := new(strings.Builder)
+	m := ijvmemu.NewMachine(prog, strings.NewReader(input), out)
+	m.MaxSteps = MaxSteps
+	err := m.Run()
+	return out.String(), m.Steps(), err
+}
+
+// Logged calls f, returning the messages it logged at warning level or above
+// instead of printing them.
+func Logged(f func()) []string {
+	return LoggedAt(logrus.WarnLevel, f)
+}
+
+// LoggedAt calls f with the log level lowered to the given one, returning the
+// messages it logged at that level or above instead of printing them.
+func LoggedAt(level logrus.Level, f func()) []string {
+	hook.Reset()
+	defer hook.Reset()
+	out := logrus.StandardLogger().Out
+	logrus.SetOutput(ioutil.Discard)
+	defer logrus.SetOutput(out)
+	prev := logrus.GetLevel()
+	logrus.SetLevel(level)
+	defer logrus.SetLevel(prev)
+	f()
+
+	var msgs []string
+	for _, e := range hook.AllEntries() {
+		if e.Level <= level {
+			msgs = append(msgs, e.Message)
+		}
+	}
+	return msgs
+}
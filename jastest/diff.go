@@ -0,0 +1,107 @@
+package jastest
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Maximum amount of line pairs compared when computing a full diff
+const maxDiffCells = 1 << 22
+
+// Diff returns a line based diff between the expected and actual output,
+// prefixing removed lines with `-` and added lines with `+`.
+// Only differing lines and a single line of context are included.
+func Diff(expected, actual string) string {
+	a := strings.Split(expected, "\n")
+	b := strings.Split(actual, "\n")
+
+	if len(a)*len(b) > maxDiffCells {
+		return firstDifference(a, b)
+	}
+
+	// Longest common subsequence of lines
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	type diffLine struct {
+		prefix byte
+		text   string
+	}
+	var lines []diffLine
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			lines = append(lines, diffLine{' ', a[i]})
+			i++
+			j++
+		case j < len(b) && (i == len(a) || lcs[i][j+1] > lcs[i+1][j]):
+			lines = append(lines, diffLine{'+', b[j]})
+			j++
+		default:
+			lines = append(lines, diffLine{'-', a[i]})
+			i++
+		}
+	}
+
+	var sb strings.Builder
+	skipped := false
+	for k, l := range lines {
+		changed := l.prefix != ' '
+		near := (k > 0 && lines[k-1].prefix != ' ') || (k+1 < len(lines) && lines[k+1].prefix != ' ')
+		if !changed && !near {
+			if !skipped {
+				sb.WriteString("  ...\n")
+			}
+			skipped = true
+			continue
+		}
+		skipped = false
+		fmt.Fprintf(&sb, "%c %q\n", l.prefix, l.text)
+	}
+	return sb.String()
+}
+
+// Describes the first differing line, for outputs too large to diff
+func firstDifference(a, b []string) string {
+	for i := 0; i < len(a) || i < len(b); i++ {
+		var x, y string
+		if i < len(a) {
+			x = a[i]
+		}
+		if i < len(b) {
+			y = b[i]
+		}
+		if i >= len(a) || i >= len(b) || x != y {
+			return fmt.Sprintf("first difference on line %d:\n- %q\n+ %q\n", i+1, x, y)
+		}
+	}
+	return ""
+}
+
+// Normalize normalizes the whitespace of the given output: every run of
+// whitespace within a line becomes a single space, lines are trimmed, and
+// trailing empty lines are removed.
+func Normalize(s string) string {
+	lines := strings.Split(strings.Replace(s, "\r\n", "\n", -1), "\n")
+	for i, l := range lines {
+		lines[i] = strings.Join(strings.Fields(l), " ")
+	}
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return strings.Join(lines, "\n")
+}
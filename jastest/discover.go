@@ -0,0 +1,160 @@
+package jastest
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Extensions of the files making up a test suite
+const (
+	ExtProgram  = ".jas"
+	ExtInput    = ".in"
+	ExtManifest = ".tests"
+)
+
+// Extensions accepted for expected output files, in order of preference
+var expectedExts = []string{".out", ".expected"}
+
+// Case is a single golden input/output test case of a program.
+type Case struct {
+	Name string
+	// Input is the path of the file fed to IN, or empty for no input
+	Input string
+	// Expected is the path of the file holding the expected output
+	Expected string
+}
+
+// Suite is the set of test cases of a single JAS program.
+type Suite struct {
+	// Program is the path of the JAS file under test
+	Program string
+	Cases   []*Case
+	Units   []*UnitTest
+}
+
+// Name returns the name of the suite, which is the name of the program without extension.
+func (s *Suite) Name() string {
+	return strings.TrimSuffix(filepath.Base(s.Program), ExtProgram)
+}
+
+// Discover finds the test suites of the given paths. Each path is either a JAS
+// program or a directory, which is searched (non-recursively) for programs.
+// Programs without any test cases or unit tests are skipped.
+func Discover(paths []string) ([]*Suite, error) {
+	var programs []string
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			programs = append(programs, path)
+			continue
+		}
+
+		matches, err := filepath.Glob(filepath.Join(path, "*"+ExtProgram))
+		if err != nil {
+			return nil, err
+		}
+		programs = append(programs, matches...)
+	}
+
+	suites := make([]*Suite, 0, len(programs))
+	for _, program := range programs {
+		suite, err := DiscoverProgram(program)
+		if err != nil {
+			return nil, err
+		}
+		if len(suite.Cases) > 0 || len(suite.Units) > 0 {
+			suites = append(suites, suite)
+		}
+	}
+	return suites, nil
+}
+
+// DiscoverProgram finds the test cases and unit tests of a single JAS program.
+// If a manifest `foo.tests` exists next to `foo.jas` its cases are used,
+// otherwise every `foo.NAME.in` with a matching `foo.NAME.out` (or
+// `foo.NAME.expected`) is a test case named NAME.
+func DiscoverProgram(program string) (*Suite, error) {
+	units, err := ReadUnitTests(program)
+	if err != nil {
+		return nil, err
+	}
+
+	suite := &Suite{Program: program, Units: units}
+	base := strings.TrimSuffix(program, ExtProgram)
+
+	manifest := base + ExtManifest
+	if _, err := os.Stat(manifest); err == nil {
+		cases, err := readManifest(manifest)
+		if err != nil {
+			return nil, err
+		}
+		suite.Cases = cases
+		return suite, nil
+	}
+
+	inputs, err := filepath.Glob(base + ".*" + ExtInput)
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(inputs)
+
+	for _, input := range inputs {
+		name := strings.TrimSuffix(strings.TrimPrefix(input, base+"."), ExtInput)
+		for _, ext := range expectedExts {
+			expected := base + "." + name + ext
+			if _, err := os.Stat(expected); err == nil {
+				suite.Cases = append(suite.Cases, &Case{
+					Name:     name,
+					Input:    input,
+					Expected: expected,
+				})
+				break
+			}
+		}
+	}
+	return suite, nil
+}
+
+// Reads a manifest. Every line follows the pattern `name input expected`,
+// where the paths are relative to the manifest. Use `-` for no input.
+func readManifest(path string) ([]*Case, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	dir := filepath.Dir(path)
+	resolve := func(p string) string {
+		if filepath.IsAbs(p) {
+			return p
+		}
+		return filepath.Join(dir, p)
+	}
+
+	var cases []*Case
+	scanner := bufio.NewScanner(file)
+	for n := 1; scanner.Scan(); n++ {
+		tokens := strings.Fields(strings.SplitN(scanner.Text(), "//", 2)[0])
+		if len(tokens) == 0 {
+			continue
+		}
+		if len(tokens) != 3 {
+			return nil, fmt.Errorf("%s:%d > expected name, input and expected output", path, n)
+		}
+
+		c := &Case{Name: tokens[0], Expected: resolve(tokens[2])}
+		if tokens[1] != "-" {
+			c.Input = resolve(tokens[1])
+		}
+		cases = append(cases, c)
+	}
+	return cases, scanner.Err()
+}
@@ -0,0 +1,111 @@
+package jastest
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+)
+
+// JUnit XML report structure, as understood by common CI systems
+type junitTestSuites struct {
+	XMLName  xml.Name          `xml:"testsuites"`
+	Tests    int               `xml:"tests,attr"`
+	Failures int               `xml:"failures,attr"`
+	Errors   int               `xml:"errors,attr"`
+	Time     string            `xml:"time,attr"`
+	Suites   []*junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name     string           `xml:"name,attr"`
+	Tests    int              `xml:"tests,attr"`
+	Failures int              `xml:"failures,attr"`
+	Errors   int              `xml:"errors,attr"`
+	Time     string           `xml:"time,attr"`
+	Cases    []*junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitMessage `xml:"failure,omitempty"`
+	Error     *junitMessage `xml:"error,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type junitMessage struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr,omitempty"`
+	Body    string `xml:",chardata"`
+}
+
+// WriteJUnit writes the given results to out as a JUnit XML report.
+// Cases with wrong output or return values are reported as failures, cases that failed
+// to execute (or whose program failed to assemble) as errors. Errors caused by exceeding
+// a limit have the type `limit: NAME`.
+func WriteJUnit(out io.Writer, results []*SuiteResult) error {
+	report := &junitTestSuites{}
+	var total float64
+
+	for _, sr := range results {
+		suite := &junitTestSuite{
+			Name: sr.Suite.Name(),
+			Time: fmt.Sprintf("%.3f", sr.Duration.Seconds()),
+		}
+		total += sr.Duration.Seconds()
+
+		if sr.Err != nil {
+			suite.Cases = append(suite.Cases, &junitTestCase{
+				Name:      "assemble",
+				ClassName: sr.Suite.Name(),
+				Time:      "0.000",
+				Error:     &junitMessage{Message: "assembly failed", Body: sr.Err.Error()},
+			})
+			suite.Errors++
+		}
+
+		for _, r := range sr.Results {
+			tc := &junitTestCase{
+				Name:      r.Name,
+				ClassName: sr.Suite.Name(),
+				Time:      fmt.Sprintf("%.3f", r.Duration.Seconds()),
+			}
+			if r.Err != nil {
+				tc.Error = &junitMessage{Message: r.Err.Error(), Body: r.Diff}
+				if r.Limit != 0 {
+					tc.Error.Type = "limit: " + r.Limit.String()
+				}
+				tc.SystemOut = string(r.Output)
+				suite.Errors++
+			} else if !r.Passed {
+				message := "output mismatch"
+				if r.Unit != nil {
+					message = "return value mismatch"
+				}
+				tc.Failure = &junitMessage{Message: message, Body: r.Diff}
+				tc.SystemOut = string(r.Output)
+				suite.Failures++
+			}
+			suite.Cases = append(suite.Cases, tc)
+		}
+
+		suite.Tests = len(suite.Cases)
+		report.Tests += suite.Tests
+		report.Failures += suite.Failures
+		report.Errors += suite.Errors
+		report.Suites = append(report.Suites, suite)
+	}
+	report.Time = fmt.Sprintf("%.3f", total)
+
+	if _, err := io.WriteString(out, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(out)
+	enc.Indent("", "  ")
+	if err := enc.Encode(report); err != nil {
+		return err
+	}
+	_, err := io.WriteString(out, "\n")
+	return err
+}
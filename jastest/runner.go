@@ -0,0 +1,155 @@
+package jastest
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/BlackNovaTech/gojasm/ijvmemu"
+)
+
+// Options configures how test cases are executed and compared.
+type Options struct {
+	// Limits bounds the resources used per case
+	ijvmemu.Limits
+	// Timeout limits the wall-clock time spent per case, if non-zero
+	Timeout time.Duration
+	// Normalize compares outputs using Normalize instead of exactly
+	Normalize bool
+	// Hooks are registered on the machine of every case
+	Hooks []ijvmemu.Hook
+	// Net provides the connections of the NET* operations, if set
+	Net ijvmemu.NetBackend
+}
+
+// Result is the outcome of a single test case or unit test.
+type Result struct {
+	Name string
+	// Case is the executed golden test case, if any
+	Case *Case
+	// Unit is the executed unit test, if any
+	Unit   *UnitTest
+	Passed bool
+	// Err is set if the program failed or exceeded a limit
+	Err error
+	// Limit is set if the program exceeded a limit
+	Limit ijvmemu.Limit
+	// Diff describes the difference between expected and actual output on failure
+	Diff     string
+	Output   []byte
+	Steps    uint64
+	Duration time.Duration
+}
+
+// SuiteResult is the outcome of all test cases of a single suite.
+type SuiteResult struct {
+	Suite *Suite
+	// Err is set if the program could not be assembled, in which case no cases ran
+	Err      error
+	Results  []*Result
+	Duration time.Duration
+}
+
+// Failed returns the amount of failed cases, counting an assembly failure as a single failure.
+func (sr *SuiteResult) Failed() int {
+	if sr.Err != nil {
+		return 1
+	}
+	failed := 0
+	for _, r := range sr.Results {
+		if !r.Passed {
+			failed++
+		}
+	}
+	return failed
+}
+
+// Total returns the amount of cases, counting an assembly failure as a single case.
+func (sr *SuiteResult) Total() int {
+	if sr.Err != nil {
+		return 1
+	}
+	return len(sr.Results)
+}
+
+// RunSuite executes every case and unit test of the suite against the given program.
+func RunSuite(prog *ijvmemu.Program, suite *Suite, opts *Options) *SuiteResult {
+	start := time.Now()
+	sr := &SuiteResult{Suite: suite}
+	for _, c := range suite.Cases {
+		sr.Results = append(sr.Results, RunCase(prog, c, opts))
+	}
+	for _, u := range suite.Units {
+		sr.Results = append(sr.Results, RunUnitTest(prog, suite.Program, u, opts))
+	}
+	sr.Duration = time.Since(start)
+	return sr
+}
+
+// RunCase executes a single case against the given program and compares its output.
+func RunCase(prog *ijvmemu.Program, c *Case, opts *Options) *Result {
+	res := &Result{Name: c.Name, Case: c}
+
+	expected, err := ioutil.ReadFile(c.Expected)
+	if err != nil {
+		res.Err = err
+		return res
+	}
+
+	var in io.Reader = bytes.NewReader(nil)
+	if c.Input != "" {
+		inf, err := os.Open(c.Input)
+		if err != nil {
+			res.Err = err
+			return res
+		}
+		defer inf.Close()
+		in = inf
+	}
+
+	ctx := context.Background()
+	if opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.Timeout)
+		defer cancel()
+	}
+
+	out := new(bytes.Buffer)
+	machine := ijvmemu.NewMachine(prog, in, out)
+	machine.Limits = opts.Limits
+	machine.Net = opts.Net
+	defer machine.Close()
+	for _, h := range opts.Hooks {
+		machine.AddHook(h)
+	}
+
+	start := time.Now()
+	res.Err = machine.RunContext(ctx)
+	res.Limit = limitOf(res.Err)
+	res.Duration = time.Since(start)
+	res.Steps = machine.Steps()
+	res.Output = out.Bytes()
+
+	want, got := string(expected), out.String()
+	if opts.Normalize {
+		want, got = Normalize(want), Normalize(got)
+	}
+	if want != got {
+		res.Diff = Diff(want, got)
+	}
+	res.Passed = res.Err == nil && want == got
+	return res
+}
+
+// Returns the limit that caused err, if any
+func limitOf(err error) ijvmemu.Limit {
+	var lerr *ijvmemu.LimitError
+	if errors.As(err, &lerr) {
+		return lerr.Limit
+	}
+	return 0
+}
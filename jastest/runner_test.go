@@ -0,0 +1,170 @@
+package jastest
+
+import (
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/BlackNovaTech/gojasm/ijvmasm"
+	"github.com/BlackNovaTech/gojasm/ijvmemu"
+	"github.com/BlackNovaTech/gojasm/opconf"
+)
+
+// Echoes its input shifted by one, failing on a `!`
+const shiftSource = `.main
+loop:
+IN
+DUP
+IFEQ done
+DUP
+BIPUSH '!'
+IF_ICMPEQ fail
+BIPUSH 1
+IADD
+OUT
+GOTO loop
+fail:
+ERR
+done:
+HALT
+.end-main
+`
+
+// Writes the given files to a temporary directory, returning its path
+func writeFiles(t *testing.T, files map[string]string) string {
+	dir := t.TempDir()
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+// Returns the names of the cases of a suite
+func caseNames(suite *Suite) []string {
+	var names []string
+	for _, c := range suite.Cases {
+		names = append(names, c.Name)
+	}
+	return names
+}
+
+func TestDiscover(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"shift.jas":            shiftSource,
+		"shift.abc.in":         "abc",
+		"shift.abc.out":        "bcd",
+		"shift.empty.in":       "",
+		"shift.empty.expected": "",
+		"shift.orphan.in":      "x",
+		"untested.jas":         shiftSource,
+		"manifest.jas":         shiftSource,
+		"manifest.tests":       "// name input expected\nfirst - shift.abc.out\nsecond shift.abc.in shift.abc.out\n",
+	})
+
+	suites, err := Discover([]string{dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(suites) != 2 {
+		t.Fatalf("discovered %d suites, want 2", len(suites))
+	}
+
+	manifest, shift := suites[0], suites[1]
+	if got := strings.Join(caseNames(shift), " "); shift.Name() != "shift" || got != "abc empty" {
+		t.Errorf("suite %s has cases %q, want shift with abc and empty", shift.Name(), got)
+	}
+	if c := shift.Cases[1]; c.Expected != filepath.Join(dir, "shift.empty.expected") {
+		t.Errorf("case empty expects %s", c.Expected)
+	}
+
+	if got := strings.Join(caseNames(manifest), " "); manifest.Name() != "manifest" || got != "first second" {
+		t.Errorf("suite %s has cases %q, want manifest with first and second", manifest.Name(), got)
+	}
+	first, second := manifest.Cases[0], manifest.Cases[1]
+	if first.Input != "" || second.Input != filepath.Join(dir, "shift.abc.in") {
+		t.Errorf("manifest cases read %q and %q", first.Input, second.Input)
+	}
+}
+
+func TestDiscoverInvalidManifest(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"shift.jas":   shiftSource,
+		"shift.tests": "first -\n",
+	})
+
+	_, err := DiscoverProgram(filepath.Join(dir, "shift.jas"))
+	want := filepath.Join(dir, "shift.tests") + ":1 > expected name, input and expected output"
+	if err == nil || err.Error() != want {
+		t.Errorf("discovered with error %v, want %s", err, want)
+	}
+}
+
+func TestRunSuite(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"shift.jas":       shiftSource,
+		"shift.pass.in":   "abc",
+		"shift.pass.out":  "bcd",
+		"shift.diff.in":   "abc",
+		"shift.diff.out":  "bce",
+		"shift.err.in":    "a!",
+		"shift.err.out":   "b",
+		"shift.empty.in":  "",
+		"shift.empty.out": "",
+	})
+
+	suite, err := DiscoverProgram(filepath.Join(dir, "shift.jas"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	asm := ijvmasm.NewAssembler(suite.Program, opconf.NewDefaultOpConfig())
+	if ok, err := asm.Parse(); !ok || err != nil {
+		t.Fatalf("assembly failed: %v", err)
+	}
+	prog, err := ijvmemu.FromAssembler(asm)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sr := RunSuite(prog, suite, &Options{Limits: ijvmemu.Limits{MaxSteps: 1000}})
+	if failed := sr.Failed(); failed != 2 {
+		t.Errorf("%d cases failed, want 2", failed)
+	}
+
+	results := make(map[string]*Result)
+	for _, r := range sr.Results {
+		results[r.Case.Name] = r
+	}
+	if r := results["pass"]; !r.Passed || r.Err != nil || r.Diff != "" || string(r.Output) != "bcd" {
+		t.Errorf("case pass: passed %v with error %v and output %q", r.Passed, r.Err, r.Output)
+	}
+	if r := results["diff"]; r.Passed || r.Err != nil || r.Diff == "" {
+		t.Errorf("case diff: passed %v with error %v and diff %q", r.Passed, r.Err, r.Diff)
+	}
+	if r := results["err"]; r.Passed || r.Err == nil || r.Diff != "" {
+		t.Errorf("case err: passed %v with error %v and diff %q", r.Passed, r.Err, r.Diff)
+	}
+	if r := results["empty"]; !r.Passed || r.Steps == 0 {
+		t.Errorf("case empty: passed %v after %d steps", r.Passed, r.Steps)
+	}
+}
+
+// A suite failing to assemble counts as a single failed case, so totals never
+// fall below the amount of failures
+func TestSuiteResultCounts(t *testing.T) {
+	for _, c := range []struct {
+		sr            *SuiteResult
+		failed, total int
+	}{
+		{&SuiteResult{Err: errors.New("assembly failed")}, 1, 1},
+		{&SuiteResult{Results: []*Result{{Passed: true}, {Passed: false}, {Passed: true}}}, 1, 3},
+		{&SuiteResult{}, 0, 0},
+	} {
+		if failed, total := c.sr.Failed(), c.sr.Total(); failed != c.failed || total != c.total {
+			t.Errorf("%d of %d cases failed, want %d of %d", failed, total, c.failed, c.total)
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package jastest
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/BlackNovaTech/gojasm/ijvmasm"
+	"github.com/BlackNovaTech/gojasm/ijvmemu"
+	"github.com/BlackNovaTech/gojasm/parsers"
+)
+
+// UnitPrefix marks a comment as a unit test annotation, e.g. `// test: mul(6, 7) == 42`
+const UnitPrefix = "test:"
+
+// UnitTest asserts the value returned by a single method for the given arguments.
+type UnitTest struct {
+	// Text is the assertion as written in the source
+	Text string
+	// N is the source line of the annotation
+	N        uint32
+	Method   string
+	Args     []int32
+	Expected int32
+}
+
+// ReadUnitTests reads the unit test annotations of the given JAS program.
+// Annotations are either comments starting with `test:`, or lines of a
+// `.test` block, and follow the pattern `method(args...) == expected`.
+func ReadUnitTests(program string) ([]*UnitTest, error) {
+	file, err := os.Open(program)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var tests []*UnitTest
+	inBlock := false
+	scanner := bufio.NewScanner(file)
+	for n := uint32(1); scanner.Scan(); n++ {
+		parts := strings.SplitN(scanner.Text(), "//", 2)
+		code := strings.TrimSpace(parts[0])
+
+		var text string
+		switch {
+		case code == ijvmasm.JASTestStart:
+			inBlock = true
+		case code == ijvmasm.JASTestEnd:
+			inBlock = false
+		case inBlock:
+			text = code
+		case len(parts) == 2 && strings.HasPrefix(strings.TrimSpace(parts[1]), UnitPrefix):
+			text = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(parts[1]), UnitPrefix))
+		}
+		if text == "" {
+			continue
+		}
+
+		test, err := parseUnitTest(text)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d > %s", program, n, err)
+		}
+		test.N = n
+		tests = append(tests, test)
+	}
+	return tests, scanner.Err()
+}
+
+// Parses a single `method(args...) == expected` assertion
+func parseUnitTest(text string) (*UnitTest, error) {
+	idx := strings.Index(text, "==")
+	if idx < 0 {
+		return nil, fmt.Errorf("test: missing `==` in `%s`", text)
+	}
+
+	call, expected := strings.TrimSpace(text[:idx]), text[idx+2:]
+	open := strings.IndexRune(call, '(')
+	if open < 0 || !strings.HasSuffix(call, ")") {
+		return nil, fmt.Errorf("test: invalid method call `%s`", call)
+	}
+
+	test := &UnitTest{
+		Text:   text,
+		Method: strings.TrimSpace(call[:open]),
+	}
+
+	args := strings.TrimSpace(call[open+1 : len(call)-1])
+	if args != "" {
+		for _, arg := range strings.Split(args, ",") {
+			v, err := parseValue(strings.TrimSpace(arg))
+			if err != nil {
+				return nil, fmt.Errorf("test: %s", err)
+			}
+			test.Args = append(test.Args, v)
+		}
+	}
+
+	v, err := parseValue(strings.TrimSpace(expected))
+	if err != nil {
+		return nil, fmt.Errorf("test: %s", err)
+	}
+	test.Expected = v
+	return test, nil
+}
+
+// Parses a number or character literal
+func parseValue(s string) (int32, error) {
+	if strings.HasPrefix(s, "'") {
+		c, err := parsers.ParseChar(s)
+		return int32(c), err
+	}
+	return parsers.ParseInt32(s)
+}
+
+// RunUnitTest invokes the method under test in a fresh machine and checks its return value.
+func RunUnitTest(prog *ijvmemu.Program, program string, test *UnitTest, opts *Options) *Result {
+	res := &Result{
+		Name: fmt.Sprintf("%s:%d", test.Method, test.N),
+		Unit: test,
+	}
+
+	ctx := context.Background()
+	if opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.Timeout)
+		defer cancel()
+	}
+
+	out := new(strings.Builder)
+	machine := ijvmemu.NewMachine(prog, strings.NewReader(""), out)
+	machine.Limits = opts.Limits
+	machine.Net = opts.Net
+	defer machine.Close()
+	for _, h := range opts.Hooks {
+		machine.AddHook(h)
+	}
+
+	start := time.Now()
+	got, err := machine.Call(ctx, test.Method, test.Args...)
+	res.Duration = time.Since(start)
+	res.Steps = machine.Steps()
+	res.Output = []byte(out.String())
+
+	location := fmt.Sprintf("%s:%d", program, test.N)
+	if method := prog.MethodByName(test.Method); method != nil {
+		location += fmt.Sprintf(", method %s declared on line %d", method.Name, method.N)
+	}
+
+	if err != nil {
+		res.Limit = limitOf(err)
+		res.Err = fmt.Errorf("%s: %s (%s)", test.Text, err, location)
+		return res
+	}
+	if got != test.Expected {
+		res.Diff = fmt.Sprintf("%s: %s returned %d, expected %d (%s)", test.Text, test.Method, got, test.Expected, location)
+		return res
+	}
+	res.Passed = true
+	return res
+}
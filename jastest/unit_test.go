@@ -0,0 +1,118 @@
+package jastest
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/BlackNovaTech/gojasm/internal/testprog"
+)
+
+// Writes the source to a file in a temporary directory, returning its path
+func writeProgram(t *testing.T, src string) string {
+	path := filepath.Join(t.TempDir(), "unit.jas")
+	if err := ioutil.WriteFile(path, []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+const unitSource = `// test: add(1, 2) == 3
+.main
+HALT
+.end-main
+
+.method add(a, b) // test: add(-5, 3) == -2
+ILOAD a
+ILOAD b
+IADD
+IRETURN
+.end-method
+
+.test
+add(0x7fffffff, 1) == -2147483648
+add('a', 1) == 'b'
+// Comments and empty lines are skipped
+
+.end-test
+`
+
+func TestReadUnitTests(t *testing.T) {
+	tests, err := ReadUnitTests(writeProgram(t, unitSource))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []*UnitTest{
+		{"add(1, 2) == 3", 1, "add", []int32{1, 2}, 3},
+		{"add(-5, 3) == -2", 6, "add", []int32{-5, 3}, -2},
+		{"add(0x7fffffff, 1) == -2147483648", 14, "add", []int32{0x7fffffff, 1}, -2147483648},
+		{"add('a', 1) == 'b'", 15, "add", []int32{'a', 1}, 'b'},
+	}
+	if !reflect.DeepEqual(tests, want) {
+		for _, test := range tests {
+			t.Logf("%+v", test)
+		}
+		t.Errorf("read %d tests, want %d", len(tests), len(want))
+	}
+}
+
+func TestReadUnitTestsErrors(t *testing.T) {
+	for _, c := range []struct {
+		src string
+		err string
+	}{
+		{"// test: add(1, 2)\n", "1 > test: missing `==` in `add(1, 2)`"},
+		{"// test: add 1 == 2\n", "1 > test: invalid method call `add 1`"},
+		{"// test: add(1, 2 == 3\n", "1 > test: invalid method call `add(1, 2`"},
+		{".test\nadd(1, x) == 2\n.end-test\n", "2 > test: invalid value: `x`"},
+		{".test\nadd(1, 2) == 99999999999\n.end-test\n", "2 > test: value out of range: `99999999999`"},
+		{".test\n.end-test\n// test: add(1, 2) = 3\n", "3 > test: missing `==` in `add(1, 2) = 3`"},
+	} {
+		path := writeProgram(t, c.src)
+		_, err := ReadUnitTests(path)
+		if want := path + ":" + c.err; err == nil || err.Error() != want {
+			t.Errorf("%q: expected error %q, got %v", c.src, want, err)
+		}
+	}
+}
+
+func TestRunUnitTest(t *testing.T) {
+	path := writeProgram(t, unitSource)
+	prog := testprog.Assemble(t, nil, path, unitSource)
+	tests, err := ReadUnitTests(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := &Options{}
+	for _, test := range tests {
+		if res := RunUnitTest(prog, path, test, opts); !res.Passed {
+			t.Errorf("%s failed: %v %s", test.Text, res.Err, res.Diff)
+		}
+	}
+
+	for _, c := range []struct {
+		test *UnitTest
+		diff string
+		err  string
+	}{
+		{&UnitTest{"add(1, 1) == 3", 20, "add", []int32{1, 1}, 3},
+			"add(1, 1) == 3: add returned 2, expected 3 (" + path + ":20, method add declared on line 6)", ""},
+		{&UnitTest{"nope(1) == 1", 21, "nope", []int32{1}, 1},
+			"", "nope(1) == 1: unknown method `nope` (" + path + ":21)"},
+		{&UnitTest{"add(1) == 1", 22, "add", []int32{1}, 1},
+			"", "add(1) == 1: method `add` takes 2 arguments, got 1 (" + path + ":22, method add declared on line 6)"},
+	} {
+		res := RunUnitTest(prog, path, c.test, opts)
+		if res.Passed || res.Diff != c.diff {
+			t.Errorf("%s: passed %v with difference %q, want %q", c.test.Text, res.Passed, res.Diff, c.diff)
+		}
+		err := ""
+		if res.Err != nil {
+			err = res.Err.Error()
+		}
+		if err != c.err {
+			t.Errorf("%s: failed with %q, want %q", c.test.Text, err, c.err)
+		}
+	}
+}
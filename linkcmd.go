@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/BlackNovaTech/gojasm/ijvmasm"
+	"github.com/BlackNovaTech/gojasm/stdlib"
+	"github.com/sirupsen/logrus"
+	flag "github.com/spf13/pflag"
+)
+
+var (
+	flagLinkOutput  string
+	flagLinkSymbols bool
+)
+
+// Links relocatable objects into a single program
+func linkCommand(args []string) {
+	fs := flag.NewFlagSet("link", flag.ExitOnError)
+	commonFlags(fs)
+	fs.StringVarP(&flagLinkOutput, "output", "o", "out.ijvm", "specify output file.")
+	fs.BoolVarP(&flagLinkSymbols, "symbols", "s", false, "generate symbol blocks")
+
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s link [flags] objects or inputfiles...\n", os.Args[0])
+		fs.PrintDefaults()
+		os.Exit(0)
+	}
+
+	parseFlags(fs, args)
+	if fs.NArg() == 0 {
+		logrus.Fatal("Please specify the objects to link")
+	}
+
+	var objs []*ijvmasm.Object
+	for _, input := range fs.Args() {
+		objs = append(objs, loadObject(input))
+	}
+	linked, err := ijvmasm.Link(objs, loadLibraries())
+	if err != nil {
+		logrus.WithError(err).Fatal("Linking failed")
+	}
+
+	var out io.Writer = os.Stdout
+	if flagLinkOutput != "-" {
+		file, err := os.Create(flagLinkOutput)
+		if err != nil {
+			logrus.WithError(err).Fatal("Could not open output file")
+		}
+		defer file.Close()
+		out = file
+	}
+
+	if err := linked.Generate(out); err != nil {
+		logrus.WithError(err).Error("Error generating bytecode")
+	}
+	if flagLinkSymbols {
+		logrus.Info("Generating Symbols...")
+		if err := linked.GenerateDebugSymbols(out); err != nil {
+			logrus.WithError(err).Error("Error generating symbols")
+		}
+	}
+}
+
+// Reads the given object file, or assembles the given source file into an object
+func loadObject(input string) *ijvmasm.Object {
+	if strings.HasSuffix(input, ".ijo") {
+		file, err := os.Open(input)
+		if err != nil {
+			logrus.WithError(err).Fatal("Could not open file")
+		}
+		defer file.Close()
+
+		obj, err := ijvmasm.ReadObject(file)
+		if err != nil {
+			logrus.WithError(err).Fatalf("Could not read object %s", input)
+		}
+		return obj
+	}
+
+	flagObject = true
+	obj, err := assemble(input).Object()
+	if err != nil {
+		logrus.WithError(err).Fatalf("Could not assemble %s into an object", input)
+	}
+	return obj
+}
+
+// Reads the library archives given using --library, followed by the standard
+// library unless disabled
+func loadLibraries() []*ijvmasm.Archive {
+	libs := readArchives(flagLibraries)
+	if flagNoStdlib {
+		return libs
+	}
+
+	lib, err := stdlib.Archive(loadConfig())
+	if err != nil {
+		logrus.WithError(err).Warn("Standard library unavailable, disable it using --nostdlib")
+		return libs
+	}
+	return append(libs, lib)
+}
+
+// Reads the given library archives
+func readArchives(inputs []string) []*ijvmasm.Archive {
+	var libs []*ijvmasm.Archive
+	for _, input := range inputs {
+		file, err := os.Open(input)
+		if err != nil {
+			logrus.WithError(err).Fatal("Could not open library")
+		}
+
+		lib, err := ijvmasm.ReadArchive(file)
+		file.Close()
+		if err != nil {
+			logrus.WithError(err).Fatalf("Could not read library %s", input)
+		}
+		libs = append(libs, lib)
+	}
+	return libs
+}
@@ -3,24 +3,40 @@ package main
 import (
 	"io"
 	"os"
+	"path"
+	"strings"
 
 	"fmt"
 
 	"github.com/BlackNovaTech/gojasm/ijvmasm"
+	"github.com/BlackNovaTech/gojasm/ijvmc"
+	"github.com/BlackNovaTech/gojasm/ijvmclass"
 	"github.com/BlackNovaTech/gojasm/opconf"
 	"github.com/sirupsen/logrus"
 	flag "github.com/spf13/pflag"
 )
 
 var (
-	flagInfo     bool
-	flagDebug    bool
-	flagConfig   string
-	flagOutput   string
-	flagForce    bool
-	flagAutoWide bool
-	flagSymbols  bool
-	flagVersion  bool
+	flagInfo        bool
+	flagDebug       bool
+	flagConfig      string
+	flagOutput      string
+	flagForce       bool
+	flagAutoWide    bool
+	flagTailCall    bool
+	flagSymbols     bool
+	flagObject      bool
+	flagLibraries   []string
+	flagNoStdlib    bool
+	flagInline      bool
+	flagInlineSize  int
+	flagInlineDepth int
+	flagFold        bool
+	flagRanges      bool
+	flagDeadMethods bool
+	flagLocals      bool
+	flagReorder     bool
+	flagVersion     bool
 )
 
 // Linker Variables
@@ -29,23 +45,59 @@ var (
 	BuildDate = "<dev>"
 )
 
+// Subcommands, invoked as `gojasm <command> [args...]`
+var commands = map[string]func(args []string){
+	"run":       runCommand,
+	"translate": translateCommand,
+	"test":      testCommand,
+	"link":      linkCommand,
+	"ar":        arCommand,
+	"symex":     symexCommand,
+}
+
 func init() {
-	flag.BoolVarP(&flagInfo, "info", "i", false, "enable info message logging")
-	flag.BoolVarP(&flagDebug, "debug", "d", false, "enable debug message logging")
-	flag.StringVarP(&flagConfig, "config", "c", "", "specify custom ijvm configuration file")
+	commonFlags(flag.CommandLine)
 	flag.StringVarP(&flagOutput, "output", "o", "out.ijvm", "specify output file.")
-	flag.BoolVarP(&flagForce, "force", "f", false, "ignore most error messages and just yolo through")
-	flag.BoolVarP(&flagAutoWide, "widen", "w", false, "automatically add WIDE operations when required")
 	flag.BoolVarP(&flagSymbols, "symbols", "s", false, "generate symbol blocks")
+	flag.BoolVar(&flagObject, "object", false, "assemble into a relocatable object file (.ijo) for gojasm link")
 	flag.BoolVarP(&flagVersion, "version", "v", false, "output version information")
 
 	flag.Usage = func() {
-		fmt.Fprintf(os.Stderr, "Usage: %s inputfile\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Usage: %s inputfile (.jas, .ic or .class)\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s run inputfile\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s test [inputfiles or directories]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s translate --go inputfile\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s link [objects or inputfiles]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s ar [objects or inputfiles]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s symex inputfile\n", os.Args[0])
 		flag.PrintDefaults()
 		os.Exit(0)
 	}
+}
 
-	flag.Parse()
+// Registers the flags shared by every command
+func commonFlags(fs *flag.FlagSet) {
+	fs.BoolVarP(&flagInfo, "info", "i", false, "enable info message logging")
+	fs.BoolVarP(&flagDebug, "debug", "d", false, "enable debug message logging")
+	fs.StringVarP(&flagConfig, "config", "c", "", "specify custom ijvm configuration file")
+	fs.BoolVarP(&flagForce, "force", "f", false, "ignore most error messages and just yolo through")
+	fs.BoolVarP(&flagAutoWide, "widen", "w", false, "automatically add WIDE operations when required")
+	fs.BoolVar(&flagTailCall, "tailcalls", false, "rewrite INVOKEVIRTUAL followed by IRETURN into TAILCALL when available")
+	fs.BoolVar(&flagInline, "inline", false, "inline small non-recursive methods at their call sites")
+	fs.IntVar(&flagInlineSize, "inline-size", 16, "largest size in bytes of the code of methods inlined")
+	fs.IntVar(&flagInlineDepth, "inline-depth", 2, "levels of nested invocations inlined")
+	fs.BoolVar(&flagFold, "fold-constants", false, "evaluate arithmetic and branches on constants, removing unreachable code")
+	fs.BoolVar(&flagLocals, "allocate-locals", false, "share the slots of local variables that are never live at the same time")
+	fs.BoolVar(&flagDeadMethods, "dead-methods", false, "remove methods unreachable from main")
+	fs.BoolVar(&flagReorder, "reorder-methods", false, "place methods in the order they are first invoked from main")
+	fs.BoolVar(&flagRanges, "check-ranges", false, "warn about values out of range, overflowing loop counters and constant branch conditions")
+	fs.StringSliceVarP(&flagLibraries, "library", "L", nil, "link methods from the given library archives (.ija) when required")
+	fs.BoolVar(&flagNoStdlib, "nostdlib", false, "do not link methods from the standard library")
+}
+
+// Parses the given arguments and applies the shared flags
+func parseFlags(fs *flag.FlagSet, args []string) {
+	fs.Parse(args)
 
 	if flagVersion {
 		printVersion()
@@ -62,32 +114,27 @@ func init() {
 }
 
 func main() {
+	if len(os.Args) > 1 {
+		if cmd, ok := commands[os.Args[1]]; ok {
+			cmd(os.Args[2:])
+			return
+		}
+	}
+
+	parseFlags(flag.CommandLine, os.Args[1:])
+
 	args := flag.Args()
 	if len(args) == 0 {
 		logrus.Fatal("Please specify a file to compile")
 	}
 
-	var config *opconf.OpConfig
-	if flagConfig == "" {
-		config = opconf.NewDefaultOpConfig()
-	} else {
-		config = opconf.NewOpConfigFromPath(flagConfig)
-	}
-
 	input := args[0]
 	output := flagOutput
-
-	asm := ijvmasm.NewAssembler(input, config)
-	asm.AutoWide = flagAutoWide
-	ok, err := asm.Parse()
-
-	if err != nil && !flagForce {
-		logrus.WithError(err).Fatal("Assembly prematurely aborted:")
+	if flagObject && !flag.CommandLine.Changed("output") {
+		output = strings.TrimSuffix(input, path.Ext(input)) + ".ijo"
 	}
 
-	if !ok && !flagForce {
-		logrus.Fatalln("Assembly failed")
-	}
+	asm := assemble(input)
 
 	var out io.Writer = os.Stdout
 	if output != "-" {
@@ -99,18 +146,92 @@ func main() {
 		out = outf
 	}
 
-	if err = asm.Generate(out); err != nil {
+	if flagObject {
+		obj, err := asm.Object()
+		if err != nil {
+			logrus.WithError(err).Fatal("Error generating object")
+		}
+		if err := ijvmasm.WriteObject(obj, out); err != nil {
+			logrus.WithError(err).Error("Error writing object")
+		}
+		return
+	}
+
+	if err := asm.Generate(out); err != nil {
 		logrus.WithError(err).Error("Error generating bytecode")
 	}
 
 	if flagSymbols {
 		logrus.Info("Generating Symbols...")
-		if err = asm.GenerateDebugSymbols(out); err != nil {
+		if err := asm.GenerateDebugSymbols(out); err != nil {
 			logrus.WithError(err).Error("Error generating symbols")
 		}
 	}
 }
 
+// Loads the operation configuration selected by the flags
+func loadConfig() *opconf.OpConfig {
+	if flagConfig == "" {
+		return opconf.NewDefaultOpConfig()
+	}
+	return opconf.NewOpConfigFromPath(flagConfig)
+}
+
+// Parses the given JAS file, compiles the given IJVM C file, or imports the
+// given Java class file, aborting on failure unless forced. The program is
+// assembled into a relocatable object if requested.
+func assemble(input string) *ijvmasm.Assembler {
+	if strings.HasSuffix(input, ".ic") {
+		asm := ijvmasm.NewBuilder(input, loadConfig())
+		configure(asm)
+		asm.Relocatable = flagObject
+		if err := ijvmc.Compile(asm, input); err != nil && !flagForce {
+			logrus.WithError(err).Fatal("Compilation failed")
+		}
+		return asm
+	}
+	if strings.HasSuffix(input, ".class") {
+		asm := ijvmasm.NewBuilder(input, loadConfig())
+		configure(asm)
+		asm.Relocatable = flagObject
+		if err := ijvmclass.Import(asm, input); err != nil && !flagForce {
+			logrus.WithError(err).Fatal("Import failed")
+		}
+		return asm
+	}
+
+	asm := ijvmasm.NewAssembler(input, loadConfig())
+	asm.AutoWide = flagAutoWide
+	configure(asm)
+	asm.Relocatable = flagObject
+	ok, err := asm.Parse()
+
+	if err != nil && !flagForce {
+		logrus.WithError(err).Fatal("Assembly prematurely aborted:")
+	}
+
+	if !ok && !flagForce {
+		logrus.Fatalln("Assembly failed")
+	}
+
+	return asm
+}
+
+// Applies the shared flags to the assembler
+func configure(asm *ijvmasm.Assembler) {
+	asm.TailCalls = flagTailCall
+	if flagInline {
+		asm.InlineSize = flagInlineSize
+		asm.InlineDepth = flagInlineDepth
+	}
+	asm.FoldConstants = flagFold
+	asm.AllocateLocals = flagLocals
+	asm.DeadMethods = flagDeadMethods
+	asm.ReorderMethods = flagReorder
+	asm.CheckRanges = flagRanges
+	asm.Libraries = loadLibraries()
+}
+
 func printVersion() {
 	fmt.Printf("gojasm version %s\n", Version)
 	fmt.Printf("Built at: %s\n", BuildDate)
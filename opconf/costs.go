@@ -0,0 +1,136 @@
+package opconf
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/BlackNovaTech/gojasm/parsers"
+	"github.com/sirupsen/logrus"
+)
+
+// Default microinstruction costs, derived from the Mic-1 microprogram.
+// Every cost includes the Main1 cycle fetching the next opcode.
+// Conditional branches are counted as not taken, a taken branch costs
+// 3 cycles more. WIDE is the extra cost of a widened ILOAD or ISTORE,
+// including its own Main1 cycle.
+const defaultCosts = `BIPUSH          4
+DUP             3
+GOTO            7
+IADD            4
+IAND            4
+IFEQ            8
+IFLT            8
+IF_ICMPEQ       10
+IINC            7
+ILOAD           6
+INVOKEVIRTUAL   23
+IOR             4
+IRETURN         9
+ISTORE          7
+ISUB            4
+LDC_W           8
+NOP             2
+POP             4
+SWAP            7
+WIDE            4
+HALT            1
+ERR             1
+OUT             3
+IN              3`
+
+// DefaultCost is the cost of every operation missing from a CostTable
+const DefaultCost = 1
+
+// CostTable maps operations onto their estimated cost in clock cycles
+type CostTable struct {
+	fileName string
+	scanner  *bufio.Scanner
+	line     uint32
+	costs    map[string]uint64
+	failed   bool
+}
+
+// NewCostTableFromPath generates a CostTable from the given file
+func NewCostTableFromPath(filepath string) *CostTable {
+	file, err := os.Open(filepath)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	defer file.Close()
+
+	return NewCostTable(file, path.Base(filepath))
+}
+
+// NewDefaultCostTable generates a CostTable from the default Mic-1 costs
+func NewDefaultCostTable() *CostTable {
+	return NewCostTable(strings.NewReader(defaultCosts), "default")
+}
+
+// NewCostTable generates a CostTable from the given source, optionally with the given name.
+// Every line follows the pattern `name cycles`.
+func NewCostTable(read io.Reader, name string) *CostTable {
+	table := &CostTable{
+		scanner:  bufio.NewScanner(read),
+		fileName: name,
+		costs:    make(map[string]uint64),
+	}
+
+	table.parse()
+	if table.failed {
+		logrus.Fatal("Cost table parse failed")
+	}
+
+	return table
+}
+
+// Cost retrieves the cost of the operation corresponding to the given name
+func (tbl *CostTable) Cost(opname string) uint64 {
+	if cost, ok := tbl.costs[opname]; ok {
+		return cost
+	}
+	return DefaultCost
+}
+
+// Parses a cost table
+func (tbl *CostTable) parse() {
+	for tbl.scanner.Scan() {
+		tbl.line++
+		tokens := strings.Fields(strings.SplitN(tbl.scanner.Text(), "//", 2)[0])
+
+		if len(tokens) == 0 {
+			continue
+		}
+
+		if len(tokens) != 2 {
+			tbl.Errorf("Expected operation name and cost")
+			continue
+		}
+
+		cost, err := parsers.ParseUint32(tokens[1])
+		if err != nil {
+			tbl.Errorf("cost: %s", err.Error())
+			continue
+		}
+
+		opname := strings.ToUpper(tokens[0])
+		if _, ok := tbl.costs[opname]; ok {
+			tbl.Errorf("Duplicate operation `%s`", opname)
+			continue
+		}
+
+		tbl.costs[opname] = uint64(cost)
+		logrus.Debugf("Cost registered: %s -> %d", opname, cost)
+	}
+}
+
+// Errorf sets the failed flag of the cost table, and then logs an error
+// prepended with the filename and line number
+func (tbl *CostTable) Errorf(format string, args ...interface{}) {
+	tbl.failed = true
+	vars := append([]interface{}{tbl.fileName, tbl.line}, args...)
+	logrus.Error(fmt.Sprintf("%s:%d > "+format, vars...))
+}
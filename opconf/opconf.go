@@ -34,6 +34,7 @@ type OpConfig struct {
 	scanner    *bufio.Scanner
 	line       uint32
 	operations map[string]*Operation
+	opcodes    map[uint8]*Operation
 	failed     bool
 }
 
@@ -74,6 +75,7 @@ func NewOpConfig(read io.Reader, name string) *OpConfig {
 		scanner:    scanner,
 		fileName:   name,
 		operations: make(map[string]*Operation),
+		opcodes:    make(map[uint8]*Operation),
 	}
 
 	config.parse()
@@ -92,9 +94,16 @@ func (cfg *OpConfig) GetOp(opname string) *Operation {
 	return nil
 }
 
+// GetOpByCode retrieves the operation corresponding to the given opcode
+func (cfg *OpConfig) GetOpByCode(opcode uint8) *Operation {
+	if op, ok := cfg.opcodes[opcode]; ok {
+		return op
+	}
+	return nil
+}
+
 // Parses a configuration file
 func (cfg *OpConfig) parse() {
-	ophash := make(map[uint8]bool)
 	for tokens := cfg.next(); tokens != nil; tokens = cfg.next() {
 		logrus.Debug(cfg.Sprintf(strings.Join(tokens, " ")))
 
@@ -113,7 +122,7 @@ func (cfg *OpConfig) parse() {
 			continue
 		}
 
-		if _, ok := ophash[opcode]; ok {
+		if _, ok := cfg.opcodes[opcode]; ok {
 			cfg.Errorf("Duplicate opcode `%2X`", opcode)
 			continue
 		}
@@ -140,7 +149,7 @@ func (cfg *OpConfig) parse() {
 			Args:   args,
 		}
 
-		ophash[opcode] = true
+		cfg.opcodes[opcode] = op
 		cfg.operations[opname] = op
 		logrus.Debugf("Operation registered: %2X -> %s (%d)", op.Opcode, op.Name, len(op.Args))
 	}
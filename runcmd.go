@@ -0,0 +1,305 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/BlackNovaTech/gojasm/ijvmemu"
+	"github.com/BlackNovaTech/gojasm/opconf"
+	"github.com/sirupsen/logrus"
+	flag "github.com/spf13/pflag"
+)
+
+var (
+	flagInput       string
+	flagTrace       string
+	flagTraceFormat string
+	flagTraceMethod string
+	flagTraceAfter  uint64
+	flagProfile     string
+	flagPprof       string
+	flagCosts       string
+	flagCoverage    string
+	flagLCOV        string
+	flagSummary     bool
+	flagGCThreshold uint64
+	flagNetTimeout  time.Duration
+	flagSnapshot    string
+	flagResume      string
+
+	flagMaxSteps  uint64
+	flagMaxStack  uint64
+	flagMaxFrames uint64
+	flagMaxHeap   uint64
+	flagMaxOutput uint64
+	flagTimeout   time.Duration
+)
+
+// Assembles and executes a program in the built-in emulator
+func runCommand(args []string) {
+	fs := flag.NewFlagSet("run", flag.ExitOnError)
+	commonFlags(fs)
+	fs.StringVar(&flagInput, "input", "-", "read program input from file")
+	fs.StringVarP(&flagTrace, "trace", "t", "", "write an execution trace to file")
+	fs.StringVar(&flagTraceFormat, "trace-format", "json", "trace format, either json (JSON Lines) or text")
+	fs.StringVar(&flagTraceMethod, "trace-method", "", "only trace instructions of the given method")
+	fs.Uint64Var(&flagTraceAfter, "trace-after", 0, "only trace after the given amount of executed instructions")
+	fs.StringVarP(&flagProfile, "profile", "p", "", "write a human readable execution profile to file (- for stderr)")
+	fs.StringVar(&flagPprof, "pprof", "", "write a pprof compatible execution profile to file")
+	fs.StringVar(&flagCosts, "costs", "", "specify custom cycle cost table for profiling")
+	fs.StringVar(&flagCoverage, "coverage", "", "write coverage annotated source to file (- for stderr)")
+	fs.StringVar(&flagLCOV, "lcov", "", "write coverage to file as LCOV tracefile")
+	fs.BoolVarP(&flagSummary, "summary", "S", false, "print a run summary with heap statistics to stderr")
+	fs.DurationVar(&flagNetTimeout, "net-timeout", ijvmemu.DefaultNetTimeout, "time NET* operations wait before giving up")
+	fs.Uint64Var(&flagGCThreshold, "gc-threshold", 0, "collect garbage after allocating the given amount of words (0 for only on GC)")
+	limitFlags(fs, 0, 0)
+	fs.StringVar(&flagSnapshot, "snapshot", "", "save the machine state to file when stopped by --max-steps or --timeout")
+	fs.StringVar(&flagResume, "resume", "", "continue execution from a snapshot file")
+
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s run [flags] inputfile\n", os.Args[0])
+		fs.PrintDefaults()
+		os.Exit(0)
+	}
+
+	parseFlags(fs, args)
+	if fs.NArg() == 0 {
+		logrus.Fatal("Please specify a file to run")
+	}
+
+	if err := runProgram(loadProgram(fs.Arg(0))); err != nil {
+		logrus.WithError(err).Error("Program failed")
+		os.Exit(1)
+	}
+}
+
+// Registers the flags limiting the resources of executed programs, using the given defaults
+func limitFlags(fs *flag.FlagSet, steps uint64, timeout time.Duration) {
+	fs.Uint64Var(&flagMaxSteps, "max-steps", steps, "maximum amount of executed instructions (0 for no limit)")
+	fs.Uint64Var(&flagMaxStack, "max-stack", 0, "maximum operand stack depth in words (0 for no limit)")
+	fs.Uint64Var(&flagMaxFrames, "max-frames", 0, "maximum amount of method frames (0 for no limit)")
+	fs.Uint64Var(&flagMaxHeap, "max-heap", 0, "maximum amount of live heap words (0 for no limit)")
+	fs.Uint64Var(&flagMaxOutput, "max-output", 0, "maximum amount of output bytes (0 for no limit)")
+	fs.DurationVar(&flagTimeout, "timeout", timeout, "maximum execution time (0 for no limit)")
+}
+
+// Returns the limits selected by the flags
+func limits() ijvmemu.Limits {
+	return ijvmemu.Limits{
+		MaxSteps:       flagMaxSteps,
+		MaxStackDepth:  flagMaxStack,
+		MaxFrames:      flagMaxFrames,
+		MaxHeapWords:   flagMaxHeap,
+		MaxOutputBytes: flagMaxOutput,
+	}
+}
+
+// Executes the program with the I/O and tracing selected by the flags
+func runProgram(prog *ijvmemu.Program) error {
+	var in io.Reader = os.Stdin
+	if flagInput != "-" {
+		inf, err := os.Open(flagInput)
+		if err != nil {
+			logrus.WithError(err).Fatal("Could not open input file")
+		}
+		defer inf.Close()
+		in = inf
+	}
+
+	machine := ijvmemu.NewMachine(prog, in, os.Stdout)
+	if flagResume != "" {
+		machine = resumeMachine(prog, in)
+	}
+	machine.Limits = limits()
+	if flagResume != "" {
+		// Limits apply to what is executed after resuming
+		if machine.Limits.MaxSteps > 0 {
+			machine.Limits.MaxSteps += machine.Steps()
+		}
+		if machine.Limits.MaxOutputBytes > 0 {
+			machine.Limits.MaxOutputBytes += machine.OutputBytes()
+		}
+	}
+	if flagGCThreshold > 0 {
+		machine.Heap().Threshold = flagGCThreshold
+	}
+	machine.Net = ijvmemu.TCPBackend{}
+	machine.NetTimeout = flagNetTimeout
+	defer machine.Close()
+
+	if flagTrace != "" {
+		format, err := ijvmemu.ParseTraceFormat(flagTraceFormat)
+		if err != nil {
+			logrus.WithError(err).Fatal("Invalid trace format")
+		}
+
+		tracef, err := os.Create(flagTrace)
+		if err != nil {
+			logrus.WithError(err).Fatal("Could not open trace file")
+		}
+		defer tracef.Close()
+		tracew := bufio.NewWriter(tracef)
+		defer tracew.Flush()
+
+		tracer := ijvmemu.NewTracer(tracew, format)
+		tracer.Method = flagTraceMethod
+		tracer.Skip = flagTraceAfter
+		machine.AddHook(tracer)
+		defer func() {
+			if err := tracer.Err(); err != nil {
+				logrus.WithError(err).Error("Error writing trace")
+			}
+		}()
+	}
+
+	var profiler *ijvmemu.Profiler
+	if flagProfile != "" || flagPprof != "" {
+		costs := opconf.NewDefaultCostTable()
+		if flagCosts != "" {
+			costs = opconf.NewCostTableFromPath(flagCosts)
+		}
+		profiler = ijvmemu.NewProfiler(costs)
+		machine.AddHook(profiler)
+	}
+
+	var coverage *ijvmemu.Coverage
+	if flagCoverage != "" || flagLCOV != "" {
+		coverage = ijvmemu.NewCoverage()
+		machine.AddHook(coverage)
+	}
+
+	ctx := context.Background()
+	if flagTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, flagTimeout)
+		defer cancel()
+	}
+
+	err := machine.RunContext(ctx)
+	logrus.Infof("Executed %d instructions", machine.Steps())
+	if _, ok := err.(*ijvmemu.LimitError); ok && flagSnapshot != "" {
+		writeFile(flagSnapshot, machine.Snapshot)
+		logrus.Infof("Saved snapshot to %s", flagSnapshot)
+	}
+	if flagSummary {
+		printSummary(machine)
+	}
+
+	if profiler != nil {
+		writeProfiles(profiler, prog)
+	}
+	if coverage != nil {
+		writeCoverage(coverage, prog)
+	}
+	return err
+}
+
+// Restores the machine saved in the snapshot selected by the flags
+func resumeMachine(prog *ijvmemu.Program, in io.Reader) *ijvmemu.Machine {
+	file, err := os.Open(flagResume)
+	if err != nil {
+		logrus.WithError(err).Fatal("Could not open snapshot")
+	}
+	defer file.Close()
+
+	machine, err := ijvmemu.RestoreMachine(prog, file, in, os.Stdout)
+	if err != nil {
+		logrus.WithError(err).Fatal("Could not restore snapshot")
+	}
+	logrus.Infof("Resuming after %d instructions", machine.Steps())
+	return machine
+}
+
+// Prints a summary of the execution to stderr
+func printSummary(machine *ijvmemu.Machine) {
+	stats := machine.Heap().Stats()
+	fmt.Fprintf(os.Stderr, "Executed instructions: %d\n", machine.Steps())
+	fmt.Fprintf(os.Stderr, "Heap allocations:      %d (%d words)\n", stats.Allocations, stats.AllocatedWords)
+	fmt.Fprintf(os.Stderr, "Heap collections:      %d, freed %d (%d words)\n", stats.Collections, stats.Freed, stats.FreedWords)
+	fmt.Fprintf(os.Stderr, "Heap live:             %d (%d words), peak %d words\n", stats.Live, stats.LiveWords, stats.PeakWords)
+}
+
+// Writes the profiles selected by the flags
+func writeProfiles(profiler *ijvmemu.Profiler, prog *ijvmemu.Program) {
+	if flagProfile == "-" {
+		if err := profiler.WriteReport(os.Stderr, prog); err != nil {
+			logrus.WithError(err).Error("Error writing profile")
+		}
+	} else if flagProfile != "" {
+		writeFile(flagProfile, func(w io.Writer) error {
+			return profiler.WriteReport(w, prog)
+		})
+	}
+
+	if flagPprof != "" {
+		writeFile(flagPprof, func(w io.Writer) error {
+			return profiler.WritePprof(w, prog)
+		})
+	}
+}
+
+// Writes the coverage reports selected by the flags
+func writeCoverage(coverage *ijvmemu.Coverage, prog *ijvmemu.Program) {
+	if flagCoverage == "-" {
+		if err := coverage.WriteAnnotated(os.Stderr, prog); err != nil {
+			logrus.WithError(err).Error("Error writing coverage")
+		}
+	} else if flagCoverage != "" {
+		writeFile(flagCoverage, func(w io.Writer) error {
+			return coverage.WriteAnnotated(w, prog)
+		})
+	}
+
+	if flagLCOV != "" {
+		writeFile(flagLCOV, func(w io.Writer) error {
+			return coverage.WriteLCOV(w, prog)
+		})
+	}
+}
+
+// Creates the given file and writes to it using write, logging any errors
+func writeFile(path string, write func(w io.Writer) error) {
+	file, err := os.Create(path)
+	if err != nil {
+		logrus.WithError(err).Errorf("Could not open %s", path)
+		return
+	}
+	defer file.Close()
+
+	w := bufio.NewWriter(file)
+	if err := write(w); err != nil {
+		logrus.WithError(err).Errorf("Error writing %s", path)
+		return
+	}
+	if err := w.Flush(); err != nil {
+		logrus.WithError(err).Errorf("Error writing %s", path)
+	}
+}
+
+// Loads an IJVM binary, or assembles a JAS file, for execution
+func loadProgram(input string) *ijvmemu.Program {
+	if strings.HasSuffix(input, ".ijvm") {
+		file, err := os.Open(input)
+		if err != nil {
+			logrus.WithError(err).Fatal("Could not open file")
+		}
+		defer file.Close()
+
+		prog, err := ijvmemu.LoadBinary(file, loadConfig())
+		if err != nil {
+			logrus.WithError(err).Fatal("Could not load binary")
+		}
+		return prog
+	}
+
+	prog, err := ijvmemu.FromAssembler(assemble(input))
+	if err != nil {
+		logrus.WithError(err).Fatal("Error generating bytecode")
+	}
+	return prog
+}
@@ -0,0 +1,55 @@
+// Package stdlib holds the standard library of gojasm: JAS routines for
+// multiplication, division, remainders, absolute values, and writing and
+// reading decimal numbers, which programs use by invoking them by name.
+// The routines are embedded in the binary, and assembled into a library
+// archive, so only the routines a program invokes end up in its binary.
+package stdlib
+
+import (
+	"embed"
+	"fmt"
+	"io/fs"
+	"path"
+
+	"github.com/BlackNovaTech/gojasm/ijvmasm"
+	"github.com/BlackNovaTech/gojasm/opconf"
+)
+
+//go:embed *.jas
+var sources embed.FS
+
+// Routines returns the names of the source files of the routines.
+func Routines() []string {
+	names, err := fs.Glob(sources, "*.jas")
+	if err != nil {
+		panic(err)
+	}
+	return names
+}
+
+// Archive assembles the routines using the given operation configuration,
+// returning the library archive holding them.
+func Archive(ops *opconf.OpConfig) (*ijvmasm.Archive, error) {
+	var objs []*ijvmasm.Object
+	for _, name := range Routines() {
+		file, err := sources.Open(name)
+		if err != nil {
+			return nil, err
+		}
+
+		asm := ijvmasm.NewAssemblerFromReader(file, path.Join("stdlib", name), ops)
+		asm.Relocatable = true
+		ok, err := asm.Parse()
+		file.Close()
+		if err != nil || !ok {
+			return nil, fmt.Errorf("stdlib: assembling %s failed", name)
+		}
+
+		obj, err := asm.Object()
+		if err != nil {
+			return nil, err
+		}
+		objs = append(objs, obj)
+	}
+	return ijvmasm.NewArchive(objs)
+}
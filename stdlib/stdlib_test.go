@@ -0,0 +1,178 @@
+package stdlib
+
+import (
+	"context"
+	"math"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/BlackNovaTech/gojasm/ijvmasm"
+	"github.com/BlackNovaTech/gojasm/ijvmemu"
+	"github.com/BlackNovaTech/gojasm/internal/testprog"
+	"github.com/BlackNovaTech/gojasm/opconf"
+)
+
+// Values around the edges of every routine
+var values = []int32{
+	0, 1, -1, 2, -2, 3, 7, -7, 9, 10, -10, 11, 99, 100, 127, -128, 1000, 12345, -54321,
+	65535, 65536, 1 << 30, -1 << 30, 999999999, 1000000000, -1000000000,
+	math.MaxInt32, math.MaxInt32 - 1, math.MinInt32, math.MinInt32 + 1,
+}
+
+// Assembles a program invoking the given routines against the standard library
+func loadProgram(t *testing.T, routines ...string) *ijvmemu.Program {
+	ops := opconf.NewDefaultOpConfig()
+	lib, err := Archive(ops)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	src := new(strings.Builder)
+	src.WriteString(".main\n")
+	for _, r := range routines {
+		src.WriteString("BIPUSH 0\nINVOKEVIRTUAL " + r + "\n")
+	}
+	src.WriteString("HALT\n.end-main\n")
+
+	return testprog.Assemble(t, ops, "test.jas", src.String(), func(asm *ijvmasm.Assembler) {
+		asm.Libraries = []*ijvmasm.Archive{lib}
+	})
+}
+
+// Calls the routine on a fresh machine, returning its result and output
+func call(t *testing.T, prog *ijvmemu.Program, input, routine string, args ...int32) (int32, string, error) {
+	out := new(strings.Builder)
+	m := ijvmemu.NewMachine(prog, strings.NewReader(input), out)
+	m.MaxSteps = 1 << 20
+	got, err := m.Call(context.Background(), routine, args...)
+	return got, out.String(), err
+}
+
+func TestMul(t *testing.T) {
+	prog := loadProgram(t, "mul")
+	for _, a := range values {
+		for _, b := range values {
+			got, _, err := call(t, prog, "", "mul", a, b)
+			if err != nil {
+				t.Fatalf("mul(%d, %d): %v", a, b, err)
+			}
+			if got != a*b {
+				t.Errorf("mul(%d, %d) = %d, expected %d", a, b, got, a*b)
+			}
+		}
+	}
+}
+
+func TestDivMod(t *testing.T) {
+	prog := loadProgram(t, "div", "mod")
+	for _, a := range values {
+		for _, b := range values {
+			if b == 0 {
+				continue
+			}
+			got, _, err := call(t, prog, "", "div", a, b)
+			if err != nil {
+				t.Fatalf("div(%d, %d): %v", a, b, err)
+			}
+			if got != a/b {
+				t.Errorf("div(%d, %d) = %d, expected %d", a, b, got, a/b)
+			}
+
+			got, _, err = call(t, prog, "", "mod", a, b)
+			if err != nil {
+				t.Fatalf("mod(%d, %d): %v", a, b, err)
+			}
+			if got != a%b {
+				t.Errorf("mod(%d, %d) = %d, expected %d", a, b, got, a%b)
+			}
+		}
+	}
+
+	for _, routine := range []string{"div", "mod"} {
+		if _, _, err := call(t, prog, "", routine, 1, 0); err == nil {
+			t.Errorf("%s(1, 0) did not fail", routine)
+		}
+	}
+}
+
+func TestAbs(t *testing.T) {
+	prog := loadProgram(t, "abs")
+	for _, x := range values {
+		expected := x
+		if x < 0 {
+			expected = -x
+		}
+		got, _, err := call(t, prog, "", "abs", x)
+		if err != nil {
+			t.Fatalf("abs(%d): %v", x, err)
+		}
+		if got != expected {
+			t.Errorf("abs(%d) = %d, expected %d", x, got, expected)
+		}
+	}
+}
+
+func TestPrintInt(t *testing.T) {
+	prog := loadProgram(t, "printint")
+	for _, n := range values {
+		got, out, err := call(t, prog, "", "printint", n)
+		if err != nil {
+			t.Fatalf("printint(%d): %v", n, err)
+		}
+		if expected := strconv.Itoa(int(n)); out != expected || got != n {
+			t.Errorf("printint(%d) wrote %q and returned %d, expected %q", n, out, got, expected)
+		}
+	}
+}
+
+func TestReadInt(t *testing.T) {
+	prog := loadProgram(t, "readint")
+	for _, n := range values {
+		input := strconv.Itoa(int(n)) + "\n"
+		got, _, err := call(t, prog, input, "readint")
+		if err != nil {
+			t.Fatalf("readint(%q): %v", input, err)
+		}
+		if got != n {
+			t.Errorf("readint(%q) = %d, expected %d", input, got, n)
+		}
+	}
+
+	cases := []struct {
+		input    string
+		expected int32
+	}{
+		{"", 0},
+		{"x", 0},
+		{"-", 0},
+		{"  \t\r\n42 13", 42},
+		{"-007,", -7},
+		{"2147483648", math.MinInt32},
+		{"4294967297", 1},
+	}
+	for _, c := range cases {
+		got, _, err := call(t, prog, c.input, "readint")
+		if err != nil {
+			t.Fatalf("readint(%q): %v", c.input, err)
+		}
+		if got != c.expected {
+			t.Errorf("readint(%q) = %d, expected %d", c.input, got, c.expected)
+		}
+	}
+}
+
+// Only the routines invoked, and those they invoke in turn, are included
+func TestOnlyInvokedRoutines(t *testing.T) {
+	prog := loadProgram(t, "div")
+	for _, name := range []string{"div", "divmod"} {
+		if prog.MethodByName(name) == nil {
+			t.Errorf("%s was not included", name)
+		}
+	}
+	for _, name := range []string{"mul", "mod", "abs", "printint", "readint"} {
+		if prog.MethodByName(name) != nil {
+			t.Errorf("%s was included without being invoked", name)
+		}
+	}
+}
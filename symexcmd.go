@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/BlackNovaTech/gojasm/ijvmemu"
+	"github.com/BlackNovaTech/gojasm/ijvmsym"
+	"github.com/sirupsen/logrus"
+	flag "github.com/spf13/pflag"
+)
+
+var (
+	flagSymMethod    string
+	flagSymMaxPaths  int
+	flagSymLoopBound int
+	flagSymPathSteps int
+	flagSymConflicts int
+	flagSymWrite     bool
+)
+
+// Symbolically executes a program, generating inputs reaching its branches, halts and errors
+func symexCommand(args []string) {
+	fs := flag.NewFlagSet("symex", flag.ExitOnError)
+	commonFlags(fs)
+	defaults := ijvmsym.DefaultOptions
+	fs.StringVar(&flagSymMethod, "method", "", "explore the given method with symbolic arguments instead of main")
+	fs.IntVar(&flagSymMaxPaths, "max-paths", defaults.MaxPaths, "maximum amount of explored paths")
+	fs.IntVar(&flagSymLoopBound, "loop-bound", defaults.LoopBound, "maximum amount of times a path forks at the same branch")
+	fs.IntVar(&flagSymPathSteps, "path-steps", defaults.MaxSteps, "maximum amount of instructions executed by a single path")
+	fs.IntVar(&flagSymConflicts, "conflicts", defaults.Conflicts, "conflict budget of every solver query")
+	fs.BoolVar(&flagSymWrite, "write", false, "write every generated input as a golden test case next to the program")
+
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s symex [flags] inputfile\n", os.Args[0])
+		fs.PrintDefaults()
+		os.Exit(0)
+	}
+
+	parseFlags(fs, args)
+	if fs.NArg() == 0 {
+		logrus.Fatal("Please specify a file to explore")
+	}
+	if flagSymWrite && flagSymMethod != "" {
+		logrus.Fatal("Test cases can only be written when exploring main")
+	}
+
+	input := fs.Arg(0)
+	prog := loadProgram(input)
+	report, err := ijvmsym.Explore(context.Background(), prog, ijvmsym.Options{
+		Method:    flagSymMethod,
+		MaxPaths:  flagSymMaxPaths,
+		LoopBound: flagSymLoopBound,
+		MaxSteps:  flagSymPathSteps,
+		Conflicts: flagSymConflicts,
+	})
+	if err != nil {
+		logrus.WithError(err).Fatal("Symbolic execution failed")
+	}
+
+	for _, c := range report.Cases {
+		fmt.Println(formatCase(c))
+	}
+	fmt.Printf("%d cases, %d paths explored", len(report.Cases), report.Paths)
+	if report.Bounded > 0 {
+		fmt.Printf(", %d paths bounded", report.Bounded)
+	}
+	if report.Undecided > 0 {
+		fmt.Printf(", %d branches undecided", report.Undecided)
+	}
+	if report.Complete {
+		fmt.Println(", complete")
+	} else {
+		fmt.Println(", incomplete")
+	}
+
+	if flagSymWrite {
+		writeCases(prog, strings.TrimSuffix(strings.TrimSuffix(input, ".jas"), ".ijvm"), report)
+	}
+}
+
+// Formats a case as its location, event and input
+func formatCase(c *ijvmsym.Case) string {
+	loc := fmt.Sprintf("%s (pc %d)", c.Method, c.PC)
+	if c.Line > 0 {
+		loc = fmt.Sprintf("%s:%d (pc %d)", c.Method, c.Line, c.PC)
+	}
+
+	event := strings.ToUpper(c.Kind.String())
+	switch c.Kind {
+	case ijvmsym.KindBranch:
+		event = c.Op + " not taken"
+		if c.Taken {
+			event = c.Op + " taken"
+		}
+	case ijvmsym.KindFault, ijvmsym.KindStopped:
+		event = fmt.Sprintf("%s: %v", event, c.Err)
+	}
+
+	line := fmt.Sprintf("%-24s %-28s input %q", loc, event, c.Input)
+	if c.Args != nil {
+		line += fmt.Sprintf(" args %v", c.Args)
+	}
+	return line
+}
+
+// Writes the distinct inputs of the cases as base.symN.in, along with the
+// output of the emulator as base.symN.out, so gojasm test picks them up.
+// Golden tests expect programs to halt, so inputs reaching ERR or a runtime
+// error are left out.
+func writeCases(prog *ijvmemu.Program, base string, report *ijvmsym.Report) {
+	done := make(map[string]bool)
+	n := 0
+	for _, c := range report.Cases {
+		if done[string(c.Input)] {
+			continue
+		}
+		done[string(c.Input)] = true
+
+		var out bytes.Buffer
+		m := ijvmemu.NewMachine(prog, bytes.NewReader(c.Input), &out)
+		m.Limits.MaxSteps = 100000000
+		if err := m.Run(); err != nil {
+			logrus.WithError(err).Infof("Not writing input %q", c.Input)
+			continue
+		}
+		n++
+
+		name := fmt.Sprintf("%s.sym%d", base, n)
+		if err := ioutil.WriteFile(name+".in", c.Input, 0644); err != nil {
+			logrus.WithError(err).Fatal("Could not write test input")
+		}
+		if err := ioutil.WriteFile(name+".out", out.Bytes(), 0644); err != nil {
+			logrus.WithError(err).Fatal("Could not write test output")
+		}
+	}
+	logrus.Infof("Wrote %d test cases", n)
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/BlackNovaTech/gojasm/ijvmasm"
+	"github.com/BlackNovaTech/gojasm/ijvmemu"
+	"github.com/BlackNovaTech/gojasm/jastest"
+	"github.com/sirupsen/logrus"
+	flag "github.com/spf13/pflag"
+)
+
+var (
+	flagNormalize bool
+	flagJUnit     string
+	flagVerbose   bool
+)
+
+// Runs the golden input/output tests of JAS programs
+func testCommand(args []string) {
+	fs := flag.NewFlagSet("test", flag.ExitOnError)
+	commonFlags(fs)
+	limitFlags(fs, 100000000, 10*time.Second)
+	fs.BoolVarP(&flagNormalize, "normalize", "n", false, "normalize whitespace before comparing outputs")
+	fs.StringVar(&flagJUnit, "junit", "", "write a JUnit XML report to file")
+	fs.StringVar(&flagCoverage, "coverage", "", "write coverage annotated sources to file (- for stderr)")
+	fs.StringVar(&flagLCOV, "lcov", "", "write coverage to file as LCOV tracefile")
+	fs.BoolVarP(&flagVerbose, "verbose", "V", false, "also report passing cases")
+
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s test [flags] [programs or directories...]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Every foo.NAME.in with a matching foo.NAME.out next to foo.jas is a test case.\n")
+		fmt.Fprintf(os.Stderr, "Alternatively foo.tests lists the cases of foo.jas, one `name input expected` per line.\n")
+		fmt.Fprintf(os.Stderr, "Methods are unit tested using `// test: method(args...) == expected` annotations.\n")
+		fs.PrintDefaults()
+		os.Exit(0)
+	}
+
+	parseFlags(fs, args)
+	paths := fs.Args()
+	if len(paths) == 0 {
+		paths = []string{"."}
+	}
+
+	suites, err := jastest.Discover(paths)
+	if err != nil {
+		logrus.WithError(err).Fatal("Could not discover tests")
+	}
+	if len(suites) == 0 {
+		logrus.Fatal("No tests found")
+	}
+
+	var results []*jastest.SuiteResult
+	var coverages []*programCoverage
+	failed, total := 0, 0
+	for _, suite := range suites {
+		opts := &jastest.Options{
+			Limits:    limits(),
+			Timeout:   flagTimeout,
+			Normalize: flagNormalize,
+			Net:       ijvmemu.TCPBackend{},
+		}
+
+		var sr *jastest.SuiteResult
+		prog, err := tryAssemble(suite.Program)
+		if err != nil {
+			sr = &jastest.SuiteResult{Suite: suite, Err: err}
+		} else {
+			if flagCoverage != "" || flagLCOV != "" {
+				coverage := ijvmemu.NewCoverage()
+				opts.Hooks = append(opts.Hooks, coverage)
+				coverages = append(coverages, &programCoverage{prog, coverage})
+			}
+			sr = jastest.RunSuite(prog, suite, opts)
+		}
+
+		reportSuite(sr)
+		results = append(results, sr)
+		failed += sr.Failed()
+		total += sr.Total()
+	}
+
+	if flagJUnit != "" {
+		writeFile(flagJUnit, func(w io.Writer) error {
+			return jastest.WriteJUnit(w, results)
+		})
+	}
+	writeTestCoverage(coverages)
+
+	if failed > 0 {
+		fmt.Printf("FAIL: %d of %d cases failed\n", failed, total)
+		os.Exit(1)
+	}
+	fmt.Printf("PASS: %d cases\n", total)
+}
+
+// Prints the outcome of every case of the suite
+func reportSuite(sr *jastest.SuiteResult) {
+	name := sr.Suite.Name()
+	if sr.Err != nil {
+		fmt.Printf("FAIL %s: %s\n", name, sr.Err)
+		return
+	}
+
+	for _, r := range sr.Results {
+		if r.Passed {
+			if flagVerbose {
+				fmt.Printf("ok   %s/%s (%d steps, %s)\n", name, r.Name, r.Steps, r.Duration)
+			}
+			continue
+		}
+
+		fmt.Printf("FAIL %s/%s (%d steps, %s)\n", name, r.Name, r.Steps, r.Duration)
+		if r.Limit != 0 {
+			fmt.Printf("    stopped by %s limit: %s\n", r.Limit, r.Err)
+		} else if r.Err != nil {
+			fmt.Printf("    error: %s\n", r.Err)
+		}
+		if r.Unit != nil && r.Diff != "" {
+			fmt.Printf("    %s\n", r.Diff)
+		} else if r.Diff != "" {
+			fmt.Printf("    output differs (- expected, + actual):\n")
+			for _, line := range strings.Split(strings.TrimSuffix(r.Diff, "\n"), "\n") {
+				fmt.Printf("    %s\n", line)
+			}
+		}
+	}
+}
+
+// Coverage collected for a single program
+type programCoverage struct {
+	prog     *ijvmemu.Program
+	coverage *ijvmemu.Coverage
+}
+
+// Writes the coverage of every tested program, as selected by the flags
+func writeTestCoverage(coverages []*programCoverage) {
+	annotate := func(w io.Writer) error {
+		for _, pc := range coverages {
+			fmt.Fprintf(w, "%s\n", pc.prog.Debug.File)
+			if err := pc.coverage.WriteAnnotated(w, pc.prog); err != nil {
+				return err
+			}
+			fmt.Fprintln(w)
+		}
+		return nil
+	}
+
+	if flagCoverage == "-" {
+		w := bufio.NewWriter(os.Stderr)
+		if err := annotate(w); err != nil {
+			logrus.WithError(err).Error("Error writing coverage")
+		}
+		w.Flush()
+	} else if flagCoverage != "" {
+		writeFile(flagCoverage, annotate)
+	}
+
+	if flagLCOV != "" {
+		writeFile(flagLCOV, func(w io.Writer) error {
+			for _, pc := range coverages {
+				if err := pc.coverage.WriteLCOV(w, pc.prog); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}
+}
+
+// Assembles the given JAS file, reporting failures instead of aborting
+func tryAssemble(input string) (*ijvmemu.Program, error) {
+	asm := ijvmasm.NewAssembler(input, loadConfig())
+	asm.AutoWide = flagAutoWide
+	configure(asm)
+	ok, err := asm.Parse()
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, errors.New("assembly failed")
+	}
+	return ijvmemu.FromAssembler(asm)
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/BlackNovaTech/gojasm/ijvmclass"
+	"github.com/BlackNovaTech/gojasm/ijvmgo"
+	"github.com/sirupsen/logrus"
+	flag "github.com/spf13/pflag"
+)
+
+var (
+	flagTranslateGo     bool
+	flagTranslateClass  bool
+	flagTranslateName   string
+	flagTranslateOutput string
+)
+
+// Translates a program into source code of another language
+func translateCommand(args []string) {
+	fs := flag.NewFlagSet("translate", flag.ExitOnError)
+	commonFlags(fs)
+	fs.BoolVar(&flagTranslateGo, "go", false, "translate into a standalone Go program")
+	fs.BoolVar(&flagTranslateClass, "class", false, "translate into a Java class file")
+	fs.StringVar(&flagTranslateName, "class-name", "", "specify the name of the class (defaults to the output file name)")
+	fs.StringVarP(&flagTranslateOutput, "output", "o", "", "specify output file (- for stdout, defaults to the input with the target's extension)")
+
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s translate --go|--class [flags] inputfile\n", os.Args[0])
+		fs.PrintDefaults()
+		os.Exit(0)
+	}
+
+	parseFlags(fs, args)
+	if fs.NArg() == 0 {
+		logrus.Fatal("Please specify a file to translate")
+	}
+	if flagTranslateGo == flagTranslateClass {
+		logrus.Fatal("Please specify a single translation target, e.g. --go")
+	}
+
+	input := fs.Arg(0)
+	prog := loadProgram(input)
+
+	base := strings.TrimSuffix(strings.TrimSuffix(input, ".jas"), ".ijvm")
+	output := flagTranslateOutput
+	translate := func(w io.Writer) error {
+		return ijvmgo.Translate(prog, w)
+	}
+
+	if flagTranslateClass {
+		if output == "" {
+			output = base + ".class"
+		}
+		name := flagTranslateName
+		if name == "" {
+			// The JVM expects classes in files named after them
+			name = strings.TrimSuffix(filepath.Base(output), ".class")
+			if output == "-" {
+				name = filepath.Base(base)
+			}
+		}
+		translate = func(w io.Writer) error {
+			return ijvmclass.Emit(prog, name, w)
+		}
+	} else if output == "" {
+		output = base + ".go"
+	}
+	if output == "-" {
+		if err := translate(os.Stdout); err != nil {
+			logrus.WithError(err).Fatal("Translation failed")
+		}
+		return
+	}
+
+	file, err := os.Create(output)
+	if err != nil {
+		logrus.WithError(err).Fatal("Could not open output file")
+	}
+	defer file.Close()
+	if err := translate(file); err != nil {
+		file.Close()
+		os.Remove(output)
+		logrus.WithError(err).Fatal("Translation failed")
+	}
+	logrus.Infof("Translated %s into %s", input, output)
+}